@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"E-book-service/internal/domain"
 	"E-book-service/internal/handler"
 	"E-book-service/internal/middleware"
 	"E-book-service/internal/repository"
 	"E-book-service/internal/service"
+	"E-book-service/internal/storage"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/joho/godotenv"
@@ -43,27 +48,100 @@ func main() {
 		)
 	}
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// TranslateError превращает нарушения уникальности в gorm.ErrDuplicatedKey.
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatalf("Failed to connect to DB: %v", err)
 	}
 
-	// Автомиграция
-	if err := db.AutoMigrate(&domain.User{}, &domain.Author{}, &domain.Book{}, &domain.Review{}, &domain.Shelf{}); err != nil {
-		log.Fatalf("failed to migrate database: %v", err)
+	// Схемой управляет `server migrate ...`; на отставшей схеме сервер не стартует
+	migrator, err := repository.NewMigrator(db)
+	if err != nil {
+		log.Fatalf("failed to load migrations: %v", err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(migrator, os.Args[2:])
+		return
+	}
+	if err := migrator.Check(); err != nil {
+		log.Fatalf("refusing to start: %v", err)
 	}
 
 	rdb := redis.NewClient(&redis.Options{
 		Addr: os.Getenv("REDIS_ADDR"),
 	})
 
+	filesDir := os.Getenv("FILES_DIR")
+	if filesDir == "" {
+		filesDir = "./data/files"
+	}
+	files, err := storage.NewLocalStore(filesDir)
+	if err != nil {
+		log.Fatalf("failed to init file storage: %v", err)
+	}
+
+	// Модерация отзывов: порог жалоб и запрещённые слова через запятую
+	moderation := service.ModerationConfig{}
+	if v := os.Getenv("REVIEW_REPORT_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("invalid REVIEW_REPORT_THRESHOLD: %q", v)
+		}
+		moderation.ReportThreshold = n
+	}
+	if v := os.Getenv("REVIEW_BLOCKLIST"); v != "" {
+		moderation.Blocklist = strings.Split(v, ",")
+	}
+
+	// Дедлайн на обработку запроса, по истечении — 504; 0 отключает
+	requestTimeout := 30 * time.Second
+	if v := os.Getenv("REQUEST_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("invalid REQUEST_TIMEOUT: %q", v)
+		}
+		requestTimeout = d
+	}
+
 	jwtSecret := os.Getenv("JWT_SECRET")
 	repo := repository.NewRepository(db)
-	svc := service.NewService(repo, jwtSecret)
+	denylist := repository.NewDenylist(rdb)
+	svc := service.NewService(repo, jwtSecret,
+		service.WithDenylist(denylist),
+		service.WithCache(repository.NewCache(rdb)),
+		service.WithFileStore(files),
+		service.WithModeration(moderation),
+	)
+
+	// Служебные команды выполняются один раз и выходят: `server recompute-ratings`
+	// пересчитывает оценки, `server split-chapters` разбивает на главы книги,
+	// сохранённые до появления глав. При старте сервера их не запускаем, чтобы
+	// реплики не делали одну и ту же работу наперегонки.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "recompute-ratings":
+			n, err := svc.RecomputeRatings(context.Background())
+			if err != nil {
+				log.Fatalf("failed to recompute ratings: %v", err)
+			}
+			log.Printf("recomputed ratings for %d books", n)
+		case "split-chapters":
+			n, err := svc.BackfillChapters(context.Background())
+			if err != nil {
+				log.Fatalf("failed to split books into chapters: %v", err)
+			}
+			log.Printf("split %d books into chapters", n)
+		default:
+			log.Fatalf("unknown command %q", os.Args[1])
+		}
+		return
+	}
+
 	h := handler.NewHandler(svc)
 
 	e := echo.New()
 	e.Use(echoMW.Recover())
+	e.Use(middleware.Timeout(requestTimeout))
 	e.Use(middleware.RateLimiter(rdb))
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
 
@@ -71,43 +149,111 @@ func main() {
 	e.GET("/health", h.Health)
 	e.POST("/register", h.Register)
 	e.POST("/login", h.Login)
+	e.POST("/token/refresh", h.Refresh)
+	e.GET("/shared/collections/:slug", h.GetSharedCollection)
 
 	// Routes (PROTECTED)
 
 	a := e.Group("/api/v1")
-	a.Use(middleware.JWTMiddleware(jwtSecret))
+	a.Use(middleware.JWTMiddleware(jwtSecret, denylist))
+
+	// Изменять каталог могут только редакторы и администраторы
+	editor := middleware.RequireRole(domain.RoleEditor, domain.RoleAdmin)
 
 	{
+		a.POST("/logout", h.Logout)
+		a.POST("/logout/all", h.LogoutAll)
+
 		a.GET("/me", h.GetMe)
 		a.PUT("/me", h.UpdateProfile)
+		a.PATCH("/me", h.PatchProfile)
 		a.GET("/profile", h.GetMe)
 
 		// Books
 		a.GET("/books", h.ListBooks)
-		a.POST("/books", h.CreateBook)
+		a.POST("/books", h.CreateBook, editor)
 		a.GET("/books/:id", h.GetBook)
-		a.PUT("/books/:id", h.UpdateBook)
-		a.DELETE("/books/:id", h.DeleteBook)
+		a.PUT("/books/:id", h.UpdateBook, editor)
+		a.PATCH("/books/:id", h.PatchBook, editor)
+		a.DELETE("/books/:id", h.DeleteBook, editor)
 		a.GET("/books/:id/content", h.GetBookContent)
+		a.GET("/books/:id/chapters", h.ListChapters)
+		a.GET("/books/:id/chapters/:n", h.GetChapter)
+		a.POST("/books/:id/files", h.UploadBookFile, editor)
+		a.GET("/books/:id/files", h.ListBookFiles)
+		a.GET("/books/:id/files/:file_id", h.DownloadBookFile)
+		a.GET("/books/:id/cover", h.GetBookCover)
+		a.GET("/search", h.SearchBooks)
+		a.GET("/autocomplete", h.Autocomplete)
 
 		// Authors
 		a.GET("/authors", h.ListAuthors)
-		a.POST("/authors", h.CreateAuthor)
+		a.POST("/authors", h.CreateAuthor, editor)
 		a.GET("/authors/:id", h.GetAuthor)
-		a.PUT("/authors/:id", h.UpdateAuthor)
-		a.DELETE("/authors/:id", h.DeleteAuthor)
+		a.PUT("/authors/:id", h.UpdateAuthor, editor)
+		a.PATCH("/authors/:id", h.PatchAuthor, editor)
+		a.DELETE("/authors/:id", h.DeleteAuthor, editor)
 		a.GET("/authors/:id/books", h.GetAuthorBooks)
 
 		// Reviews
 		a.GET("/books/:id/reviews", h.ListReviews)
 		a.POST("/books/:id/reviews", h.AddReview)
+		a.PUT("/reviews/:id", h.UpdateReview)
 		a.DELETE("/reviews/:id", h.DeleteReview)
+		a.PUT("/reviews/:id/vote", h.VoteReview)
+		a.DELETE("/reviews/:id/vote", h.UnvoteReview)
+		a.POST("/reviews/:id/report", h.ReportReview)
+
+		// Comments
+		a.GET("/reviews/:id/comments", h.ListComments)
+		a.POST("/reviews/:id/comments", h.AddComment)
+		a.PUT("/comments/:id", h.EditComment)
+		a.DELETE("/comments/:id", h.DeleteComment)
+
+		// Notifications
+		a.GET("/notifications", h.ListNotifications)
+		a.POST("/notifications/:id/read", h.ReadNotification)
+
+		// Collections
+		a.GET("/collections", h.ListCollections)
+		a.POST("/collections", h.CreateCollection)
+		a.GET("/collections/:id", h.GetCollection)
+		a.PUT("/collections/:id", h.UpdateCollection)
+		a.DELETE("/collections/:id", h.DeleteCollection)
+		a.POST("/collections/:id/books", h.AddCollectionBook)
+		a.DELETE("/collections/:id/books/:book_id", h.RemoveCollectionBook)
+		a.PUT("/collections/:id/order", h.ReorderCollection)
+		a.GET("/users/:id/collections", h.ListUserCollections)
+
+		// Annotations
+		a.GET("/books/:id/annotations", h.ListBookAnnotations)
+		a.POST("/books/:id/annotations", h.CreateAnnotation)
+		a.GET("/annotations", h.ListAnnotations)
+		a.GET("/annotations/export", h.ExportAnnotations)
+		a.GET("/annotations/:id", h.GetAnnotation)
+		a.PUT("/annotations/:id", h.UpdateAnnotation)
+		a.DELETE("/annotations/:id", h.DeleteAnnotation)
 
 		// Shelf
 		a.GET("/shelf", h.GetShelf)
 		a.POST("/shelf/:id", h.AddToShelf)
 		a.DELETE("/shelf/:id", h.RemoveFromShelf)
 		a.PUT("/shelf/:id", h.AddToShelf)
+		a.PUT("/shelf/:id/progress", h.UpdateProgress)
+		a.POST("/shelf/:id/sync", h.SyncPosition)
+	}
+
+	mod := a.Group("/moderation", middleware.RequireRole(domain.RoleModerator, domain.RoleAdmin))
+	{
+		mod.GET("/reviews", h.ModerationQueue)
+		mod.POST("/reviews/:id/approve", h.ApproveReview)
+		mod.POST("/reviews/:id/reject", h.RejectReview)
+		mod.GET("/reviews/:id/actions", h.ModerationActions)
+	}
+
+	adm := a.Group("/admin", middleware.RequireRole(domain.RoleAdmin))
+	{
+		adm.PUT("/users/:id/role", h.SetUserRole)
 	}
 
 	port := os.Getenv("PORT")
@@ -117,3 +263,48 @@ func main() {
 	log.Printf("Server starting on %s", port)
 	e.Logger.Fatal(e.Start(port))
 }
+
+// runMigrate выполняет `server migrate up|down|status|to N`.
+func runMigrate(m *repository.Migrator, args []string) {
+	if len(args) == 0 {
+		log.Fatal("usage: migrate up|down|status|to N")
+	}
+	var (
+		n   int
+		err error
+	)
+	switch args[0] {
+	case "up":
+		n, err = m.Up()
+	case "down":
+		n, err = m.Down()
+	case "to":
+		if len(args) < 2 {
+			log.Fatal("usage: migrate to N")
+		}
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			log.Fatalf("invalid version %q", args[1])
+		}
+		n, err = m.To(version)
+	case "status":
+		list, err := m.Status()
+		if err != nil {
+			log.Fatalf("failed to read migration status: %v", err)
+		}
+		for _, st := range list {
+			applied := "pending"
+			if st.AppliedAt != nil {
+				applied = "applied " + st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-32s %s\n", st.Version, st.Name, applied)
+		}
+		return
+	default:
+		log.Fatalf("unknown migrate command %q", args[0])
+	}
+	if err != nil {
+		log.Fatalf("migration failed after %d steps: %v", n, err)
+	}
+	log.Printf("ran %d migrations", n)
+}
@@ -0,0 +1,124 @@
+package domain
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("invalid sort field")
+)
+
+// ListQuery — общие параметры постраничной выдачи.
+// Sort — имя поля, "-" в начале означает сортировку по убыванию.
+type ListQuery struct {
+	Limit  int
+	Cursor string
+	Sort   string
+}
+
+// PageSize приводит Limit к допустимому диапазону.
+func (q ListQuery) PageSize() int {
+	switch {
+	case q.Limit <= 0:
+		return DefaultPageSize
+	case q.Limit > MaxPageSize:
+		return MaxPageSize
+	}
+	return q.Limit
+}
+
+type BookFilter struct {
+	ListQuery
+	AuthorID    uint
+	MinRating   float64
+	TitlePrefix string
+}
+
+type AuthorFilter struct {
+	ListQuery
+	NamePrefix string
+}
+
+// AnnotationFilter — выборка аннотаций пользователя. Query ищет подстроку
+// в выделенном тексте и в тексте заметки.
+type AnnotationFilter struct {
+	ListQuery
+	BookID uint
+	Kind   string
+	Query  string
+}
+
+// Page — конверт для списков: элементы, общее число и курсор следующей страницы.
+// NextCursor пуст, если страница последняя.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int64  `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Курсор непрозрачен для клиента: он просто передаёт его обратно как есть.
+const cursorPrefix = "o:"
+
+func EncodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + strconv.Itoa(offset)))
+}
+
+func DecodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(raw), cursorPrefix) {
+		return 0, ErrInvalidCursor
+	}
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(raw), cursorPrefix))
+	if err != nil || offset < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return offset, nil
+}
+
+const (
+	SearchLangRU = "ru"
+	SearchLangEN = "en"
+)
+
+// SearchQuery — параметры полнотекстового поиска по книгам.
+// Lang выбирает текстовую конфигурацию PostgreSQL: "ru" или "en".
+type SearchQuery struct {
+	ListQuery
+	Text string
+	Lang string
+}
+
+// SearchHit — книга из результатов поиска. Snippet содержит фрагменты текста,
+// где совпадения обёрнуты в <mark>…</mark>.
+type SearchHit struct {
+	BookID     uint    `json:"book_id"`
+	Title      string  `json:"title"`
+	AuthorID   uint    `json:"author_id"`
+	AuthorName string  `json:"author_name"`
+	Rank       float64 `json:"rank"`
+	Snippet    string  `json:"snippet"`
+}
+
+// Suggestion — вариант автодополнения; Score — триграммная похожесть от 0 до 1.
+type Suggestion struct {
+	ID    uint    `json:"id"`
+	Label string  `json:"label"`
+	Score float64 `json:"score"`
+}
+
+type Autocomplete struct {
+	Books   []Suggestion `json:"books"`
+	Authors []Suggestion `json:"authors"`
+}
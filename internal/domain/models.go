@@ -1,25 +1,61 @@
 package domain
 
 import (
+	"encoding/json"
+	"errors"
 	"gorm.io/gorm"
 	"time"
 )
 
+const (
+	RoleReader    = "reader"
+	RoleEditor    = "editor"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// ValidRole сообщает, является ли строка известной ролью пользователя.
+func ValidRole(role string) bool {
+	switch role {
+	case RoleReader, RoleEditor, RoleModerator, RoleAdmin:
+		return true
+	}
+	return false
+}
+
 type User struct {
 	ID        uint           `gorm:"primaryKey" json:"id"`
 	Email     string         `gorm:"unique;not null" json:"email"`
 	Password  string         `json:"-"`
 	Name      string         `json:"name"`
+	Role      string         `gorm:"type:varchar(16);not null;default:reader" json:"role"`
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+// RefreshToken хранит только SHA-256 хэш токена, сам токен знает лишь клиент.
+// Все токены, полученные ротацией от одного логина, образуют семейство FamilyID.
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	FamilyID  string     `gorm:"type:varchar(64);index;not null" json:"family_id"`
+	TokenHash string     `gorm:"type:char(64);uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// ErrVersionMismatch — книгу или автора изменили после того, как клиент их прочитал.
+var ErrVersionMismatch = errors.New("the resource has been modified, fetch it again and retry")
+
 type Author struct {
-	ID    uint   `gorm:"primaryKey" json:"id"`
-	Name  string `gorm:"not null" json:"name"`
-	Bio   string `gorm:"type:text" json:"bio"`
-	Books []Book `gorm:"foreignKey:AuthorID" json:"books,omitempty"`
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	Name      string         `gorm:"not null" json:"name"`
+	Bio       string         `gorm:"type:text" json:"bio"`
+	Books     []Book         `gorm:"foreignKey:AuthorID" json:"books,omitempty"`
+	Version   int64          `gorm:"not null;default:1" json:"version"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 type Book struct {
@@ -27,23 +63,407 @@ type Book struct {
 	Title       string   `gorm:"not null" json:"title"`
 	Description string   `gorm:"type:text" json:"description"`
 	Content     string   `gorm:"type:text" json:"content"`
+	Language    string   `gorm:"type:varchar(16)" json:"language"`
+	ISBN        string   `gorm:"column:isbn;type:varchar(13);index" json:"isbn"`
+	CoverKey    string   `json:"-"` // ключ обложки в FileStore, пусто — обложки нет
 	AuthorID    uint     `json:"author_id"`
 	Author      *Author  `gorm:"foreignKey:AuthorID" json:"author,omitempty"`
 	Reviews     []Review `gorm:"foreignKey:BookID" json:"reviews,omitempty"`
+
+	// Сводка оценок пересчитывается репозиторием при каждом изменении отзывов;
+	// из запросов клиента эти поля не сохраняются.
+	RatingAvg       float64         `gorm:"not null;default:0" json:"rating_avg"`
+	RatingCount     int             `gorm:"not null;default:0" json:"rating_count"`
+	RatingHistogram RatingHistogram `gorm:"embedded;embeddedPrefix:rating_" json:"rating_histogram"`
+
+	// Version растёт при каждом изменении полей книги и служит её ETag;
+	// пересчёт сводки оценок версию не меняет.
+	Version int64 `gorm:"not null;default:1" json:"version"`
+
+	// Удалённая книга пропадает из каталога, но остаётся на полках читателей.
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// RatingHistogram — сколько раз книге поставили каждую из оценок 1–5.
+type RatingHistogram struct {
+	One   int `gorm:"column:1;not null;default:0" json:"1"`
+	Two   int `gorm:"column:2;not null;default:0" json:"2"`
+	Three int `gorm:"column:3;not null;default:0" json:"3"`
+	Four  int `gorm:"column:4;not null;default:0" json:"4"`
+	Five  int `gorm:"column:5;not null;default:0" json:"5"`
+}
+
+// MergePatch — тело PATCH по RFC 7386: поле со значением заменяет текущее,
+// null сбрасывает его, а отсутствующие поля не меняются.
+type MergePatch map[string]json.RawMessage
+
+// Политики удаления книги или автора, на которых ссылаются другие записи.
+const (
+	DeleteRestrict = "restrict" // отказать и перечислить зависимые записи
+	DeleteCascade  = "cascade"  // удалить вместе с книгами автора, убрав книги из подборок
+	DeleteReassign = "reassign" // передать книги другому автору; только для авторов
+)
+
+// DeleteOptions — политика удаления, выбранная клиентом. ReassignTo нужен только для DeleteReassign.
+// Version — версия из If-Match, 0 — любая.
+type DeleteOptions struct {
+	Policy     string
+	ReassignTo uint
+	Version    int64
+}
+
+type BookRef struct {
+	ID    uint   `json:"id"`
+	Title string `json:"title"`
+}
+
+// Dependents — записи, из-за которых политика restrict не даёт удалить книгу или автора.
+type Dependents struct {
+	Books        []BookRef `json:"books,omitempty"`
+	Reviews      int64     `json:"reviews,omitempty"`
+	ShelfEntries int64     `json:"shelf_entries,omitempty"`
+	Collections  int64     `json:"collections,omitempty"`
+	Annotations  int64     `json:"annotations,omitempty"`
+}
+
+func (d Dependents) Empty() bool {
+	return len(d.Books) == 0 && d.Reviews == 0 && d.ShelfEntries == 0 && d.Collections == 0 && d.Annotations == 0
+}
+
+// Chapter — глава книги, полученная разбиением Content по заголовкам.
+// Ordinal начинается с 1. В оглавлении Body не отдаётся.
+type Chapter struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	BookID    uint   `gorm:"not null;uniqueIndex:idx_chapters_book_ordinal" json:"book_id"`
+	Ordinal   int    `gorm:"not null;uniqueIndex:idx_chapters_book_ordinal" json:"ordinal"`
+	Title     string `json:"title"`
+	Body      string `gorm:"type:text" json:"body,omitempty"`
+	WordCount int    `json:"word_count"`
+}
+
+const (
+	FormatEPUB = "epub"
+	FormatPDF  = "pdf"
+	FormatFB2  = "fb2"
+)
+
+// FormatContentTypes — MIME-типы, с которыми файлы отдаются на скачивание.
+var FormatContentTypes = map[string]string{
+	FormatEPUB: "application/epub+zip",
+	FormatPDF:  "application/pdf",
+	FormatFB2:  "application/x-fictionbook+xml",
+}
+
+// BookFile — загруженный файл книги. Само содержимое лежит в FileStore под StorageKey.
+type BookFile struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	BookID     uint      `gorm:"index;not null" json:"book_id"`
+	Format     string    `gorm:"type:varchar(8);not null" json:"format"`
+	FileName   string    `json:"file_name"`
+	Size       int64     `json:"size"`
+	Checksum   string    `gorm:"type:char(64);not null" json:"checksum"` // SHA-256, hex
+	StorageKey string    `gorm:"not null" json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+const (
+	VisibilityPrivate  = "private"
+	VisibilityUnlisted = "unlisted" // доступна по ссылке, но не показывается в списках
+	VisibilityPublic   = "public"
+)
+
+func ValidVisibility(v string) bool {
+	switch v {
+	case VisibilityPrivate, VisibilityUnlisted, VisibilityPublic:
+		return true
+	}
+	return false
+}
+
+// Collection — именованная подборка книг пользователя. Slug — случайный
+// идентификатор для ссылки, по которой подборку видят другие.
+type Collection struct {
+	ID          uint             `gorm:"primaryKey" json:"id"`
+	UserID      uint             `gorm:"index;not null" json:"user_id"`
+	Name        string           `gorm:"not null" json:"name"`
+	Description string           `gorm:"type:text" json:"description"`
+	Visibility  string           `gorm:"type:varchar(16);not null;default:private" json:"visibility"`
+	Slug        string           `gorm:"type:varchar(32);uniqueIndex;not null" json:"slug"`
+	Books       []CollectionBook `gorm:"foreignKey:CollectionID" json:"books,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+// CollectionBook — книга в подборке. Position задаёт порядок, начиная с 1.
+type CollectionBook struct {
+	CollectionID uint      `gorm:"primaryKey" json:"-"`
+	BookID       uint      `gorm:"primaryKey" json:"book_id"`
+	Book         *Book     `gorm:"foreignKey:BookID" json:"book,omitempty"`
+	Position     int       `gorm:"not null" json:"position"`
+	AddedAt      time.Time `json:"added_at"`
+}
+
+const (
+	AnnotationBookmark  = "bookmark"
+	AnnotationHighlight = "highlight"
+	AnnotationNote      = "note"
+)
+
+func ValidAnnotationKind(kind string) bool {
+	switch kind {
+	case AnnotationBookmark, AnnotationHighlight, AnnotationNote:
+		return true
+	}
+	return false
+}
+
+// Anchor — точка в тексте книги: номер главы и смещение в символах от начала главы.
+type Anchor struct {
+	Chapter int `gorm:"not null;default:0" json:"chapter"`
+	Offset  int `gorm:"not null;default:0" json:"offset"`
+}
+
+// Before сообщает, что a стоит в тексте раньше b.
+func (a Anchor) Before(b Anchor) bool {
+	if a.Chapter != b.Chapter {
+		return a.Chapter < b.Chapter
+	}
+	return a.Offset < b.Offset
+}
+
+// Annotation — закладка, выделение или заметка читателя. У закладки End совпадает со Start.
+type Annotation struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"index:idx_annotations_user_book;not null" json:"user_id"`
+	BookID       uint      `gorm:"index:idx_annotations_user_book;not null" json:"book_id"`
+	Book         *Book     `gorm:"foreignKey:BookID" json:"-"`
+	Kind         string    `gorm:"type:varchar(16);not null" json:"kind"`
+	Start        Anchor    `gorm:"embedded;embeddedPrefix:start_" json:"start"`
+	End          Anchor    `gorm:"embedded;embeddedPrefix:end_" json:"end"`
+	SelectedText string    `gorm:"type:text" json:"selected_text"`
+	Note         string    `gorm:"type:text" json:"note"`
+	Color        string    `gorm:"type:varchar(16)" json:"color"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 
+const (
+	MinRating = 1
+	MaxRating = 5
+)
+
+// Статусы модерации отзыва. В списках и в сводке оценок участвуют только опубликованные.
+const (
+	ReviewPublished = "published"
+	ReviewPending   = "pending"
+	ReviewRejected  = "rejected"
+)
+
+// ErrReviewStatusChanged — статус отзыва изменили, пока решение о нём ещё готовилось.
+var ErrReviewStatusChanged = errors.New("review status has already been changed")
+
+// Review — отзыв пользователя о книге, не больше одного на книгу.
+// Edited становится true после первой правки текста или оценки.
+// ModeratedAt — время последнего решения модератора: жалобы, поданные
+// раньше, на отзыв больше не влияют.
 type Review struct {
-	ID      uint   `gorm:"primaryKey" json:"id"`
-	BookID  uint   `json:"book_id"`
-	UserID  uint   `json:"user_id"`
-	Rating  int    `json:"rating"`
-	Comment string `json:"comment"`
+	ID          uint           `gorm:"primaryKey" json:"id"`
+	BookID      uint           `gorm:"uniqueIndex:idx_reviews_book_user;not null" json:"book_id"`
+	UserID      uint           `gorm:"uniqueIndex:idx_reviews_book_user;not null" json:"user_id"`
+	Rating      int            `gorm:"not null" json:"rating"`
+	Comment     string         `json:"comment"`
+	Edited      bool           `gorm:"not null;default:false" json:"edited"`
+	Status      string         `gorm:"type:varchar(16);not null;default:published;index" json:"status"`
+	ModeratedAt *time.Time     `json:"moderated_at,omitempty"`
+	Reports     []ReviewReport `gorm:"foreignKey:ReviewID" json:"reports,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+
+	// Счётчики голосов пересчитываются репозиторием при каждом голосовании.
+	HelpfulCount    int `gorm:"not null;default:0" json:"helpful_count"`
+	NotHelpfulCount int `gorm:"not null;default:0" json:"not_helpful_count"`
 }
 
-type Shelf struct {
+// ReviewVote — оценка отзыва читателем: полезен или нет. Один голос от пользователя,
+// повторное голосование меняет его.
+type ReviewVote struct {
+	ReviewID  uint      `gorm:"primaryKey" json:"review_id"`
 	UserID    uint      `gorm:"primaryKey" json:"user_id"`
-	BookID    uint      `gorm:"primaryKey" json:"book_id"`
-	Book      Book      `gorm:"foreignKey:BookID"`
-	Status    string    `json:"status"` // "reading", "completed"
+	Helpful   bool      `gorm:"not null" json:"helpful"`
+	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
+
+// ReviewComment — комментарий к отзыву. ParentID указывает на комментарий,
+// на который это ответ; у комментариев верхнего уровня он пуст, Depth = 0.
+// Удалённые комментарии с ответами остаются в ветке заглушками: Deleted = true, Body пуст.
+type ReviewComment struct {
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	ReviewID  uint           `gorm:"index;not null" json:"review_id"`
+	UserID    uint           `gorm:"not null" json:"user_id"`
+	ParentID  *uint          `gorm:"index" json:"parent_id,omitempty"`
+	Depth     int            `gorm:"not null;default:0" json:"depth"`
+	Body      string         `gorm:"type:text;not null" json:"body"`
+	EditedAt  *time.Time     `json:"edited_at,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Deleted        bool             `gorm:"-" json:"deleted,omitempty"`
+	HasMoreReplies bool             `gorm:"-" json:"has_more_replies,omitempty"`
+	Replies        []*ReviewComment `gorm:"-" json:"replies,omitempty"`
+}
+
+const (
+	NotificationReviewComment = "review_comment" // прокомментировали ваш отзыв
+	NotificationCommentReply  = "comment_reply"  // ответили на ваш комментарий
+)
+
+// Notification — уведомление пользователя о событии, которое сделал ActorID.
+type Notification struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	Kind      string     `gorm:"type:varchar(32);not null" json:"kind"`
+	ActorID   uint       `json:"actor_id"`
+	ReviewID  uint       `json:"review_id,omitempty"`
+	CommentID uint       `json:"comment_id,omitempty"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// ReviewReport — жалоба пользователя на отзыв, одна от пользователя на отзыв.
+type ReviewReport struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	ReviewID  uint      `gorm:"uniqueIndex:idx_review_reports_review_user;not null" json:"review_id"`
+	UserID    uint      `gorm:"uniqueIndex:idx_review_reports_review_user;not null" json:"user_id"`
+	Reason    string    `gorm:"type:text;not null" json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Действия в журнале модерации. flag ставит система, approve и reject — модератор.
+const (
+	ModerationFlag    = "flag"
+	ModerationApprove = "approve"
+	ModerationReject  = "reject"
+)
+
+// ModerationAction — запись журнала модерации. ModeratorID пуст,
+// если отзыв скрыт автоматически.
+type ModerationAction struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	ReviewID    uint      `gorm:"index;not null" json:"review_id"`
+	ModeratorID *uint     `json:"moderator_id,omitempty"`
+	Action      string    `gorm:"type:varchar(16);not null" json:"action"`
+	FromStatus  string    `gorm:"type:varchar(16);not null" json:"from_status"`
+	ToStatus    string    `gorm:"type:varchar(16);not null" json:"to_status"`
+	Reason      string    `gorm:"type:text" json:"reason"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+const (
+	ShelfStatusWantToRead = "want_to_read"
+	ShelfStatusReading    = "reading"
+	ShelfStatusPaused     = "paused"
+	ShelfStatusCompleted  = "completed"
+	ShelfStatusAbandoned  = "abandoned"
+)
+
+var ShelfStatuses = []string{ShelfStatusWantToRead, ShelfStatusReading, ShelfStatusPaused, ShelfStatusCompleted, ShelfStatusAbandoned}
+
+// ShelfTransitions — куда можно перейти из каждого статуса. Новую запись
+// (пустой статус) можно создать в любом статусе.
+var ShelfTransitions = map[string][]string{
+	"":                    ShelfStatuses,
+	ShelfStatusWantToRead: {ShelfStatusReading, ShelfStatusCompleted, ShelfStatusAbandoned},
+	ShelfStatusReading:    {ShelfStatusPaused, ShelfStatusCompleted, ShelfStatusAbandoned},
+	ShelfStatusPaused:     {ShelfStatusReading, ShelfStatusCompleted, ShelfStatusAbandoned},
+	ShelfStatusCompleted:  {ShelfStatusReading},
+	ShelfStatusAbandoned:  {ShelfStatusWantToRead, ShelfStatusReading, ShelfStatusCompleted},
+}
+
+func ValidShelfStatus(status string) bool {
+	for _, s := range ShelfStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// CanMoveShelfStatus сообщает, допустим ли переход. Повторная установка того же статуса разрешена.
+func CanMoveShelfStatus(from, to string) bool {
+	if from == to {
+		return true
+	}
+	for _, s := range ShelfTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadingPosition — место, где читатель остановился: номер главы,
+// смещение в символах от начала главы и процент прочитанного по всей книге.
+type ReadingPosition struct {
+	Chapter int     `gorm:"not null;default:0" json:"chapter"`
+	Offset  int     `gorm:"not null;default:0" json:"offset"`
+	Percent float64 `gorm:"not null;default:0" json:"percent"`
+}
+
+// Before сообщает, что позиция p находится раньше q. Сравниваем главу и
+// смещение, а процент — только если они совпадают.
+func (p ReadingPosition) Before(q ReadingPosition) bool {
+	if p.Chapter != q.Chapter {
+		return p.Chapter < q.Chapter
+	}
+	if p.Offset != q.Offset {
+		return p.Offset < q.Offset
+	}
+	return p.Percent < q.Percent
+}
+
+type Shelf struct {
+	UserID     uint             `gorm:"primaryKey" json:"user_id"`
+	BookID     uint             `gorm:"primaryKey" json:"book_id"`
+	Book       Book             `gorm:"foreignKey:BookID"`
+	Status     string           `json:"status"` // одно из ShelfStatuses
+	Progress   ReadingPosition  `gorm:"embedded;embeddedPrefix:progress_" json:"progress"`
+	Devices    []DevicePosition `gorm:"foreignKey:UserID,BookID;references:UserID,BookID" json:"devices,omitempty"`
+	StartedAt  *time.Time       `json:"started_at,omitempty"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+}
+
+// PositionUpdate — позиция, присланная устройством на синхронизацию.
+// JumpBack разрешает откатить общую позицию назад (читатель сам вернулся к началу).
+type PositionUpdate struct {
+	DeviceID   string          `json:"device_id"`
+	Position   ReadingPosition `json:"position"`
+	ClientTime time.Time       `json:"client_time"`
+	JumpBack   bool            `json:"jump_back"`
+}
+
+// DevicePosition — последняя позиция, присланная конкретным устройством.
+type DevicePosition struct {
+	UserID     uint            `gorm:"primaryKey" json:"-"`
+	BookID     uint            `gorm:"primaryKey" json:"-"`
+	DeviceID   string          `gorm:"primaryKey;type:varchar(64)" json:"device_id"`
+	Position   ReadingPosition `gorm:"embedded;embeddedPrefix:progress_" json:"position"`
+	ClientTime time.Time       `json:"client_time"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// ReadingEvent — запись истории синхронизаций. Applied показывает,
+// стала ли присланная позиция общей позицией на полке.
+type ReadingEvent struct {
+	ID         uint            `gorm:"primaryKey" json:"id"`
+	UserID     uint            `gorm:"index:idx_reading_events_user_book;not null" json:"user_id"`
+	BookID     uint            `gorm:"index:idx_reading_events_user_book;not null" json:"book_id"`
+	DeviceID   string          `gorm:"type:varchar(64);not null" json:"device_id"`
+	Position   ReadingPosition `gorm:"embedded;embeddedPrefix:progress_" json:"position"`
+	ClientTime time.Time       `json:"client_time"`
+	JumpBack   bool            `json:"jump_back"`
+	Applied    bool            `json:"applied"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
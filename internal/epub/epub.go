@@ -0,0 +1,271 @@
+// Package epub читает метаданные из OPF-пакета EPUB-файла.
+package epub
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+var ErrInvalid = errors.New("epub: invalid package")
+
+const (
+	containerPath = "META-INF/container.xml"
+	// Ограничения защищают от zip-бомб: метаданные и обложка не бывают большими.
+	maxXMLSize   = 1 << 20
+	maxCoverSize = 10 << 20
+)
+
+type Metadata struct {
+	Title       string
+	Description string
+	Author      string
+	Language    string
+	ISBN        string
+	Cover       *Cover
+}
+
+type Cover struct {
+	Name      string // имя файла внутри архива
+	MediaType string
+	Data      []byte
+}
+
+type container struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type opfPackage struct {
+	Metadata struct {
+		Titles       []string `xml:"title"`
+		Descriptions []string `xml:"description"`
+		Languages    []string `xml:"language"`
+		Creators     []struct {
+			Value string `xml:",chardata"`
+			Role  string `xml:"role,attr"`
+		} `xml:"creator"`
+		Identifiers []struct {
+			Value  string `xml:",chardata"`
+			Scheme string `xml:"scheme,attr"`
+		} `xml:"identifier"`
+		Meta []struct {
+			Name    string `xml:"name,attr"`
+			Content string `xml:"content,attr"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Manifest []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+}
+
+// Parse разбирает EPUB: находит OPF через META-INF/container.xml и
+// извлекает из него основные метаданные и обложку.
+func Parse(r io.ReaderAt, size int64) (*Metadata, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var c container
+	if err := decodeXML(files, containerPath, &c); err != nil {
+		return nil, err
+	}
+	opfPath := ""
+	for _, rf := range c.Rootfiles {
+		if rf.MediaType == "" || rf.MediaType == "application/oebps-package+xml" {
+			opfPath = rf.FullPath
+			break
+		}
+	}
+	if opfPath == "" {
+		return nil, fmt.Errorf("%w: no rootfile in %s", ErrInvalid, containerPath)
+	}
+
+	var p opfPackage
+	if err := decodeXML(files, opfPath, &p); err != nil {
+		return nil, err
+	}
+
+	md := p.Metadata
+	m := &Metadata{
+		Title:       first(md.Titles),
+		Description: stripTags(first(md.Descriptions)),
+		Language:    first(md.Languages),
+	}
+	for _, cr := range md.Creators {
+		// Без роли считаем создателя автором; иллюстраторов и редакторов пропускаем.
+		if name := clean(cr.Value); name != "" && (cr.Role == "" || cr.Role == "aut") {
+			m.Author = name
+			break
+		}
+	}
+	for _, id := range md.Identifiers {
+		v := clean(id.Value)
+		if strings.EqualFold(id.Scheme, "isbn") || strings.HasPrefix(strings.ToLower(v), "urn:isbn:") || strings.HasPrefix(strings.ToLower(v), "isbn") {
+			if isbn := normalizeISBN(v); isbn != "" {
+				m.ISBN = isbn
+				break
+			}
+		}
+	}
+
+	// EPUB 3 помечает обложку свойством cover-image, EPUB 2 — элементом <meta name="cover">.
+	coverID := ""
+	for _, meta := range md.Meta {
+		if meta.Name == "cover" {
+			coverID = meta.Content
+		}
+	}
+	for _, item := range p.Manifest {
+		if !strings.HasPrefix(item.MediaType, "image/") {
+			continue
+		}
+		if !hasProperty(item.Properties, "cover-image") && (coverID == "" || item.ID != coverID) {
+			continue
+		}
+		href, err := url.PathUnescape(item.Href)
+		if err != nil {
+			continue
+		}
+		name := path.Join(path.Dir(opfPath), href)
+		data, err := readFile(files, name, maxCoverSize)
+		if err != nil {
+			continue
+		}
+		m.Cover = &Cover{Name: path.Base(name), MediaType: item.MediaType, Data: data}
+		break
+	}
+	return m, nil
+}
+
+func decodeXML(files map[string]*zip.File, name string, v interface{}) error {
+	data, err := readFile(files, name, maxXMLSize)
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalid, name, err)
+	}
+	return nil
+}
+
+func readFile(files map[string]*zip.File, name string, limit int64) ([]byte, error) {
+	f, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing %s", ErrInvalid, name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: %s is too large", ErrInvalid, name)
+	}
+	return data, nil
+}
+
+func first(values []string) string {
+	for _, v := range values {
+		if v = clean(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// clean схлопывает пробельные символы, которыми OPF-файлы обычно отформатированы.
+func clean(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func hasProperty(props, want string) bool {
+	for _, p := range strings.Fields(props) {
+		if p == want {
+			return true
+		}
+	}
+	return false
+}
+
+// stripTags убирает HTML-разметку, которую издатели часто кладут в dc:description.
+func stripTags(s string) string {
+	var b strings.Builder
+	inTag := false
+	for _, r := range s {
+		switch {
+		case r == '<':
+			inTag = true
+			b.WriteRune(' ')
+		case r == '>':
+			inTag = false
+		case !inTag:
+			b.WriteRune(r)
+		}
+	}
+	return clean(b.String())
+}
+
+// normalizeISBN оставляет только цифры (и X в ISBN-10) и проверяет контрольную сумму.
+// Для невалидного значения возвращает пустую строку.
+func normalizeISBN(s string) string {
+	var digits []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= '0' && c <= '9':
+			digits = append(digits, c)
+		case (c == 'X' || c == 'x') && len(digits) == 9:
+			digits = append(digits, 'X')
+		}
+	}
+	switch len(digits) {
+	case 10:
+		sum := 0
+		for i, c := range digits {
+			v := int(c - '0')
+			if c == 'X' {
+				v = 10
+			}
+			sum += v * (10 - i)
+		}
+		if sum%11 == 0 {
+			return string(digits)
+		}
+	case 13:
+		sum := 0
+		for i, c := range digits {
+			if c == 'X' {
+				return ""
+			}
+			v := int(c - '0')
+			if i%2 == 1 {
+				v *= 3
+			}
+			sum += v
+		}
+		if sum%10 == 0 {
+			return string(digits)
+		}
+	}
+	return ""
+}
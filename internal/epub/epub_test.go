@@ -0,0 +1,110 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildEPUB(t *testing.T, files map[string]string) *bytes.Reader {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, body := range files {
+		f, err := w.Create(name)
+		assert.NoError(t, err)
+		_, err = f.Write([]byte(body))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+	return bytes.NewReader(buf.Bytes())
+}
+
+const containerXML = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`
+
+func TestParse(t *testing.T) {
+	t.Run("EPUB3", func(t *testing.T) {
+		r := buildEPUB(t, map[string]string{
+			containerPath: containerXML,
+			"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>
+      Война и мир
+    </dc:title>
+    <dc:creator>Лев Толстой</dc:creator>
+    <dc:language>ru</dc:language>
+    <dc:identifier>urn:uuid:0b2f</dc:identifier>
+    <dc:identifier>urn:isbn:978-5-17-090335-1</dc:identifier>
+    <dc:description>&lt;p&gt;Роман-эпопея&lt;/p&gt;</dc:description>
+  </metadata>
+  <manifest>
+    <item id="c" href="images/cover%20art.jpg" media-type="image/jpeg" properties="cover-image"/>
+  </manifest>
+</package>`,
+			"OEBPS/images/cover art.jpg": "JPEG",
+		})
+		m, err := Parse(r, r.Size())
+		assert.NoError(t, err)
+		assert.Equal(t, "Война и мир", m.Title)
+		assert.Equal(t, "Лев Толстой", m.Author)
+		assert.Equal(t, "ru", m.Language)
+		assert.Equal(t, "9785170903351", m.ISBN)
+		assert.Equal(t, "Роман-эпопея", m.Description)
+		if !assert.NotNil(t, m.Cover) {
+			return
+		}
+		assert.Equal(t, "cover art.jpg", m.Cover.Name)
+		assert.Equal(t, "image/jpeg", m.Cover.MediaType)
+		assert.Equal(t, []byte("JPEG"), m.Cover.Data)
+	})
+
+	t.Run("EPUB2", func(t *testing.T) {
+		r := buildEPUB(t, map[string]string{
+			containerPath: containerXML,
+			"OEBPS/content.opf": `<package xmlns="http://www.idpf.org/2007/opf" xmlns:opf="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Dune</dc:title>
+    <dc:creator opf:role="ill">John Schoenherr</dc:creator>
+    <dc:creator opf:role="aut">Frank Herbert</dc:creator>
+    <dc:identifier opf:scheme="ISBN">0-441-17271-7</dc:identifier>
+    <meta name="cover" content="cov"/>
+  </metadata>
+  <manifest>
+    <item id="cov" href="cover.png" media-type="image/png"/>
+  </manifest>
+</package>`,
+			"OEBPS/cover.png": "PNG",
+		})
+		m, err := Parse(r, r.Size())
+		assert.NoError(t, err)
+		assert.Equal(t, "Frank Herbert", m.Author)
+		assert.Equal(t, "0441172717", m.ISBN)
+		if !assert.NotNil(t, m.Cover) {
+			return
+		}
+		assert.Equal(t, "image/png", m.Cover.MediaType)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		r := buildEPUB(t, map[string]string{"mimetype": "application/epub+zip"})
+		_, err := Parse(r, r.Size())
+		assert.ErrorIs(t, err, ErrInvalid)
+
+		_, err = Parse(bytes.NewReader([]byte("not a zip")), 9)
+		assert.ErrorIs(t, err, ErrInvalid)
+	})
+}
+
+func TestNormalizeISBN(t *testing.T) {
+	assert.Equal(t, "080442957X", normalizeISBN("ISBN 0-8044-2957-X"))
+	assert.Equal(t, "9780306406157", normalizeISBN("978-0-306-40615-7"))
+	assert.Empty(t, normalizeISBN("978-0-306-40615-8"))
+	assert.Empty(t, normalizeISBN("12345"))
+}
@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"E-book-service/internal/domain"
+	"E-book-service/internal/service"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+var exportContentTypes = map[string]string{
+	service.ExportMarkdown: "text/markdown; charset=utf-8",
+	service.ExportJSON:     echo.MIMEApplicationJSONCharsetUTF8,
+}
+
+func annotationError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidAnnotationKind), errors.Is(err, service.ErrInvalidAnchor),
+		errors.Is(err, service.ErrEmptyAnnotation), errors.Is(err, service.ErrAnnotationTooLong),
+		errors.Is(err, service.ErrInvalidColor), errors.Is(err, service.ErrInvalidExportFormat):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Annotation or book not found"})
+	default:
+		return listError(c, err)
+	}
+}
+
+// parseAnnotationFilter дополняет параметры списка фильтрами по аннотациям.
+func parseAnnotationFilter(c echo.Context) (domain.AnnotationFilter, error) {
+	lq, err := parseListQuery(c)
+	if err != nil {
+		return domain.AnnotationFilter{}, err
+	}
+	f := domain.AnnotationFilter{ListQuery: lq, Kind: c.QueryParam("kind"), Query: c.QueryParam("q")}
+	if v := c.QueryParam("book_id"); v != "" {
+		bID, err := strconv.ParseUint(v, 10, 0)
+		if err != nil {
+			return f, errors.New("invalid book_id")
+		}
+		f.BookID = uint(bID)
+	}
+	return f, nil
+}
+
+// @Summary Мои аннотации к книге
+// @Description По умолчанию — в порядке текста.
+// @Tags Annotations
+// @Security ApiKeyAuth
+// @Produce json
+// @Param id path int true "ID книги"
+// @Param kind query string false "bookmark, highlight или note"
+// @Param q query string false "Подстрока в выделенном тексте или заметке"
+// @Param limit query int false "Размер страницы (по умолчанию 20, максимум 100)"
+// @Param cursor query string false "Курсор из next_cursor предыдущей страницы"
+// @Param sort query string false "position, created или id; -created — по убыванию"
+// @Success 200 {object} domain.Page[domain.Annotation]
+// @Router /books/{id}/annotations [get]
+func (h *Handler) ListBookAnnotations(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	f, err := parseAnnotationFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	f.BookID = id
+	page, err := h.svc.GetAnnotations(c.Request().Context(), getUID(c), f)
+	if err != nil {
+		return annotationError(c, err)
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// @Summary Поиск по моим аннотациям
+// @Description По умолчанию — от новых к старым.
+// @Tags Annotations
+// @Security ApiKeyAuth
+// @Produce json
+// @Param q query string false "Подстрока в выделенном тексте или заметке"
+// @Param kind query string false "bookmark, highlight или note"
+// @Param book_id query int false "Только аннотации к книге"
+// @Param limit query int false "Размер страницы (по умолчанию 20, максимум 100)"
+// @Param cursor query string false "Курсор из next_cursor предыдущей страницы"
+// @Param sort query string false "created, position или id; -created — по убыванию"
+// @Success 200 {object} domain.Page[domain.Annotation]
+// @Router /annotations [get]
+func (h *Handler) ListAnnotations(c echo.Context) error {
+	f, err := parseAnnotationFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	page, err := h.svc.GetAnnotations(c.Request().Context(), getUID(c), f)
+	if err != nil {
+		return annotationError(c, err)
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// @Summary Добавить аннотацию
+// @Description kind: bookmark (только start), highlight (нужен selected_text) или note (нужен note).
+// @Description color: yellow, green, blue, pink, purple, orange или #rrggbb.
+// @Tags Annotations
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID книги"
+// @Param annotation body domain.Annotation true "Аннотация"
+// @Success 201 {object} domain.Annotation
+// @Router /books/{id}/annotations [post]
+func (h *Handler) CreateAnnotation(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	var a domain.Annotation
+	if err := c.Bind(&a); err != nil {
+		return err
+	}
+	if err := h.svc.CreateAnnotation(c.Request().Context(), getUID(c), id, &a); err != nil {
+		return annotationError(c, err)
+	}
+	return c.JSON(http.StatusCreated, a)
+}
+
+// @Summary Аннотация
+// @Tags Annotations
+// @Security ApiKeyAuth
+// @Produce json
+// @Param id path int true "ID аннотации"
+// @Success 200 {object} domain.Annotation
+// @Router /annotations/{id} [get]
+func (h *Handler) GetAnnotation(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	a, err := h.svc.GetAnnotation(c.Request().Context(), getUID(c), id)
+	if err != nil {
+		return annotationError(c, err)
+	}
+	return c.JSON(http.StatusOK, a)
+}
+
+// @Summary Изменить аннотацию
+// @Tags Annotations
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID аннотации"
+// @Param annotation body domain.Annotation true "Аннотация"
+// @Success 200 {object} domain.Annotation
+// @Router /annotations/{id} [put]
+func (h *Handler) UpdateAnnotation(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	var a domain.Annotation
+	if err := c.Bind(&a); err != nil {
+		return err
+	}
+	a.ID = id
+	if err := h.svc.UpdateAnnotation(c.Request().Context(), getUID(c), &a); err != nil {
+		return annotationError(c, err)
+	}
+	return c.JSON(http.StatusOK, a)
+}
+
+// @Summary Удалить аннотацию
+// @Tags Annotations
+// @Security ApiKeyAuth
+// @Param id path int true "ID аннотации"
+// @Success 204 "No Content"
+// @Router /annotations/{id} [delete]
+func (h *Handler) DeleteAnnotation(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	if err := h.svc.DeleteAnnotation(c.Request().Context(), getUID(c), id); err != nil {
+		return annotationError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Summary Экспорт аннотаций
+// @Description Все аннотации пользователя или только одной книги, файлом Markdown или JSON.
+// @Tags Annotations
+// @Security ApiKeyAuth
+// @Produce text/markdown,json
+// @Param format query string false "md (по умолчанию) или json"
+// @Param book_id query int false "Только аннотации к книге"
+// @Success 200 {file} file
+// @Router /annotations/export [get]
+func (h *Handler) ExportAnnotations(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = service.ExportMarkdown
+	}
+	var bookID uint
+	if v := c.QueryParam("book_id"); v != "" {
+		bID, err := strconv.ParseUint(v, 10, 0)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid book_id"})
+		}
+		bookID = uint(bID)
+	}
+	data, err := h.svc.ExportAnnotations(c.Request().Context(), getUID(c), bookID, format)
+	if err != nil {
+		return annotationError(c, err)
+	}
+	name := "annotations." + format
+	if bookID != 0 {
+		name = "annotations-book-" + strconv.FormatUint(uint64(bookID), 10) + "." + format
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	return c.Blob(http.StatusOK, exportContentTypes[format], data)
+}
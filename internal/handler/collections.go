@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"E-book-service/internal/domain"
+	"E-book-service/internal/service"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type CollectionBookRequest struct {
+	BookID uint `json:"book_id"`
+}
+
+type CollectionOrderRequest struct {
+	BookIDs []uint `json:"book_ids"`
+}
+
+func collectionError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidCollectionName), errors.Is(err, service.ErrInvalidVisibility), errors.Is(err, service.ErrInvalidOrder):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, service.ErrBookInCollection):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Collection or book not found"})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
+
+// parseID разбирает числовой параметр пути.
+func parseID(c echo.Context, name string) (uint, bool) {
+	id, err := strconv.Atoi(c.Param(name))
+	if err != nil || id < 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// @Summary Мои подборки
+// @Tags Collections
+// @Security ApiKeyAuth
+// @Produce json
+// @Success 200 {array} domain.Collection
+// @Router /collections [get]
+func (h *Handler) ListCollections(c echo.Context) error {
+	list, err := h.svc.GetCollections(c.Request().Context(), getUID(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+// @Summary Публичные подборки пользователя
+// @Tags Collections
+// @Security ApiKeyAuth
+// @Produce json
+// @Param id path int true "ID пользователя"
+// @Success 200 {array} domain.Collection
+// @Router /users/{id}/collections [get]
+func (h *Handler) ListUserCollections(c echo.Context) error {
+	uID, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	list, err := h.svc.GetUserCollections(c.Request().Context(), uID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+// @Summary Создать подборку
+// @Description visibility: private (по умолчанию), unlisted — только по ссылке, public — видна всем.
+// @Tags Collections
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param collection body domain.Collection true "Имя, описание и видимость"
+// @Success 201 {object} domain.Collection
+// @Router /collections [post]
+func (h *Handler) CreateCollection(c echo.Context) error {
+	var col domain.Collection
+	if err := c.Bind(&col); err != nil {
+		return err
+	}
+	if err := h.svc.CreateCollection(c.Request().Context(), getUID(c), &col); err != nil {
+		return collectionError(c, err)
+	}
+	return c.JSON(http.StatusCreated, col)
+}
+
+// @Summary Подборка
+// @Description Своя подборка или чужая публичная, вместе с книгами по порядку.
+// @Tags Collections
+// @Security ApiKeyAuth
+// @Produce json
+// @Param id path int true "ID подборки"
+// @Success 200 {object} domain.Collection
+// @Router /collections/{id} [get]
+func (h *Handler) GetCollection(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	col, err := h.svc.GetCollection(c.Request().Context(), getUID(c), id)
+	if err != nil {
+		return collectionError(c, err)
+	}
+	return c.JSON(http.StatusOK, col)
+}
+
+// @Summary Подборка по ссылке
+// @Description Доступна без авторизации для публичных и unlisted подборок.
+// @Tags Collections
+// @Produce json
+// @Param slug path string true "Slug из ссылки"
+// @Success 200 {object} domain.Collection
+// @Router /shared/collections/{slug} [get]
+func (h *Handler) GetSharedCollection(c echo.Context) error {
+	col, err := h.svc.GetSharedCollection(c.Request().Context(), c.Param("slug"))
+	if err != nil {
+		return collectionError(c, err)
+	}
+	return c.JSON(http.StatusOK, col)
+}
+
+// @Summary Изменить подборку
+// @Tags Collections
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID подборки"
+// @Param collection body domain.Collection true "Имя, описание и видимость"
+// @Success 200 {object} domain.Collection
+// @Router /collections/{id} [put]
+func (h *Handler) UpdateCollection(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	var col domain.Collection
+	if err := c.Bind(&col); err != nil {
+		return err
+	}
+	col.ID = id
+	if err := h.svc.UpdateCollection(c.Request().Context(), getUID(c), &col); err != nil {
+		return collectionError(c, err)
+	}
+	return c.JSON(http.StatusOK, col)
+}
+
+// @Summary Удалить подборку
+// @Tags Collections
+// @Security ApiKeyAuth
+// @Param id path int true "ID подборки"
+// @Success 204 "No Content"
+// @Router /collections/{id} [delete]
+func (h *Handler) DeleteCollection(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	if err := h.svc.DeleteCollection(c.Request().Context(), getUID(c), id); err != nil {
+		return collectionError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Summary Добавить книгу в подборку
+// @Description Книга добавляется в конец. Повторное добавление — 409.
+// @Tags Collections
+// @Security ApiKeyAuth
+// @Accept json
+// @Param id path int true "ID подборки"
+// @Param body body CollectionBookRequest true "Книга"
+// @Success 204 "No Content"
+// @Router /collections/{id}/books [post]
+func (h *Handler) AddCollectionBook(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	var r CollectionBookRequest
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+	if err := h.svc.AddBookToCollection(c.Request().Context(), getUID(c), id, r.BookID); err != nil {
+		return collectionError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Summary Убрать книгу из подборки
+// @Tags Collections
+// @Security ApiKeyAuth
+// @Param id path int true "ID подборки"
+// @Param book_id path int true "ID книги"
+// @Success 204 "No Content"
+// @Router /collections/{id}/books/{book_id} [delete]
+func (h *Handler) RemoveCollectionBook(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	bookID, ok2 := parseID(c, "book_id")
+	if !ok || !ok2 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	if err := h.svc.RemoveBookFromCollection(c.Request().Context(), getUID(c), id, bookID); err != nil {
+		return collectionError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Summary Изменить порядок книг
+// @Description book_ids должен перечислять каждую книгу подборки ровно один раз.
+// @Tags Collections
+// @Security ApiKeyAuth
+// @Accept json
+// @Param id path int true "ID подборки"
+// @Param body body CollectionOrderRequest true "Новый порядок"
+// @Success 204 "No Content"
+// @Router /collections/{id}/order [put]
+func (h *Handler) ReorderCollection(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	var r CollectionOrderRequest
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+	if err := h.svc.ReorderCollection(c.Request().Context(), getUID(c), id, r.BookIDs); err != nil {
+		return collectionError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"E-book-service/internal/domain"
+	"E-book-service/internal/service"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type CommentRequest struct {
+	Body     string `json:"body"`
+	ParentID *uint  `json:"parent_id,omitempty"`
+}
+
+func commentError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidComment), errors.Is(err, service.ErrInvalidParent), errors.Is(err, service.ErrThreadTooDeep):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, service.ErrEditWindowClosed):
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Review or comment not found"})
+	default:
+		return listError(c, err)
+	}
+}
+
+// @Summary Комментарии к отзыву
+// @Description Дерево комментариев. depth — сколько уровней вернуть (по умолчанию 3);
+// @Description у обрезанных веток has_more_replies = true, их можно догрузить через parent_id.
+// @Tags Comments
+// @Security ApiKeyAuth
+// @Produce json
+// @Param id path int true "ID отзыва"
+// @Param depth query int false "Глубина дерева"
+// @Param parent_id query int false "Вернуть только ответы на этот комментарий"
+// @Success 200 {array} domain.ReviewComment
+// @Router /reviews/{id}/comments [get]
+func (h *Handler) ListComments(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	depth := 0
+	if v := c.QueryParam("depth"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 1 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "depth must be a positive integer"})
+		}
+		depth = d
+	}
+	var parentID *uint
+	if v := c.QueryParam("parent_id"); v != "" {
+		p, err := strconv.ParseUint(v, 10, 0)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid parent_id"})
+		}
+		pid := uint(p)
+		parentID = &pid
+	}
+	thread, err := h.svc.GetCommentThread(c.Request().Context(), id, parentID, depth)
+	if err != nil {
+		return commentError(c, err)
+	}
+	return c.JSON(http.StatusOK, thread)
+}
+
+// @Summary Прокомментировать отзыв
+// @Description С parent_id комментарий становится ответом. Автор отзыва и автор
+// @Description родительского комментария получают уведомления.
+// @Tags Comments
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID отзыва"
+// @Param body body CommentRequest true "Текст и родительский комментарий"
+// @Success 201 {object} domain.ReviewComment
+// @Router /reviews/{id}/comments [post]
+func (h *Handler) AddComment(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	var r CommentRequest
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+	cm := domain.ReviewComment{Body: r.Body, ParentID: r.ParentID}
+	if err := h.svc.AddComment(c.Request().Context(), getUID(c), id, &cm); err != nil {
+		return commentError(c, err)
+	}
+	return c.JSON(http.StatusCreated, cm)
+}
+
+// @Summary Изменить комментарий
+// @Description Только свой и только в первые 15 минут после публикации.
+// @Tags Comments
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID комментария"
+// @Param body body CommentRequest true "Новый текст"
+// @Success 200 {object} domain.ReviewComment
+// @Router /comments/{id} [put]
+func (h *Handler) EditComment(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	var r CommentRequest
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+	cm := domain.ReviewComment{ID: id, Body: r.Body}
+	if err := h.svc.EditComment(c.Request().Context(), getUID(c), &cm); err != nil {
+		return commentError(c, err)
+	}
+	return c.JSON(http.StatusOK, cm)
+}
+
+// @Summary Удалить комментарий
+// @Description Ответы на удалённый комментарий остаются в ветке.
+// @Tags Comments
+// @Security ApiKeyAuth
+// @Param id path int true "ID комментария"
+// @Success 204 "No Content"
+// @Router /comments/{id} [delete]
+func (h *Handler) DeleteComment(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	if err := h.svc.DeleteComment(c.Request().Context(), getUID(c), id); err != nil {
+		return commentError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Summary Мои уведомления
+// @Tags Notifications
+// @Security ApiKeyAuth
+// @Produce json
+// @Param unread query bool false "Только непрочитанные"
+// @Param limit query int false "Размер страницы (по умолчанию 20, максимум 100)"
+// @Param cursor query string false "Курсор из next_cursor предыдущей страницы"
+// @Success 200 {object} domain.Page[domain.Notification]
+// @Router /notifications [get]
+func (h *Handler) ListNotifications(c echo.Context) error {
+	lq, err := parseListQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	unread, _ := strconv.ParseBool(c.QueryParam("unread"))
+	page, err := h.svc.GetNotifications(c.Request().Context(), getUID(c), unread, lq)
+	if err != nil {
+		return listError(c, err)
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// @Summary Отметить уведомление прочитанным
+// @Tags Notifications
+// @Security ApiKeyAuth
+// @Param id path int true "ID уведомления"
+// @Success 204 "No Content"
+// @Router /notifications/{id}/read [post]
+func (h *Handler) ReadNotification(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	if err := h.svc.MarkNotificationRead(c.Request().Context(), getUID(c), id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Notification not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"E-book-service/internal/domain"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	headerETag        = "ETag"
+	headerIfMatch     = "If-Match"
+	headerIfNoneMatch = "If-None-Match"
+)
+
+var errIfMatchRequired = errors.New("If-Match header with the ETag from GET is required")
+
+// etag — ETag книги или автора: "версия-хэш", где хэш считается по телу ответа.
+// Сводка оценок и вложенный автор меняются без новой версии, поэтому
+// If-None-Match сверяет весь тег, а If-Match — только версию.
+func etag(version int64, body interface{}) string {
+	data, _ := json.Marshal(body)
+	sum := sha256.Sum256(data)
+	return `"` + strconv.FormatInt(version, 10) + "-" + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// notModified ставит ETag и сообщает, есть ли такой ответ у клиента.
+// If-None-Match сравнивается слабо: префикс W/ не учитывается.
+func notModified(c echo.Context, version int64, body interface{}) bool {
+	tag := etag(version, body)
+	c.Response().Header().Set(headerETag, tag)
+	for _, t := range strings.Split(c.Request().Header.Get(headerIfNoneMatch), ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch читает версию, которую клиент видел перед изменением: часть тега до
+// дефиса, хэш не сверяется. "*" даёт 0 — подойдёт любая версия. Без заголовка —
+// 428; тег, который не может совпасть ни с одной версией (слабый, не число,
+// список), — сразу 412.
+func ifMatch(c echo.Context) (int64, int, error) {
+	h := strings.TrimSpace(c.Request().Header.Get(headerIfMatch))
+	switch {
+	case h == "":
+		return 0, http.StatusPreconditionRequired, errIfMatchRequired
+	case h == "*":
+		return 0, 0, nil
+	}
+	tag := strings.TrimSuffix(strings.TrimPrefix(h, `"`), `"`)
+	if i := strings.IndexByte(tag, '-'); i >= 0 {
+		tag = tag[:i]
+	}
+	v, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || v <= 0 || !strings.HasPrefix(h, `"`) || !strings.HasSuffix(h, `"`) {
+		return 0, http.StatusPreconditionFailed, domain.ErrVersionMismatch
+	}
+	return v, 0, nil
+}
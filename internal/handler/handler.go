@@ -3,10 +3,16 @@ package handler
 import (
 	"E-book-service/internal/domain"
 	"E-book-service/internal/service"
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
+	"path"
 	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
 type RegisterRequest struct {
@@ -20,6 +26,18 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
 type ShelfStatusRequest struct {
 	Status string `json:"status"`
 }
@@ -32,6 +50,51 @@ func NewHandler(s service.ServiceInterface) *Handler {
 	return &Handler{svc: s}
 }
 
+// parseListQuery читает общие параметры списка: limit, cursor и sort.
+func parseListQuery(c echo.Context) (domain.ListQuery, error) {
+	q := domain.ListQuery{Cursor: c.QueryParam("cursor"), Sort: c.QueryParam("sort")}
+	if v := c.QueryParam("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return q, errors.New("invalid limit")
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+// parseBookFilter дополняет параметры списка фильтрами по книгам.
+func parseBookFilter(c echo.Context) (domain.BookFilter, error) {
+	lq, err := parseListQuery(c)
+	if err != nil {
+		return domain.BookFilter{}, err
+	}
+	f := domain.BookFilter{ListQuery: lq, TitlePrefix: c.QueryParam("title_prefix")}
+	if v := c.QueryParam("author_id"); v != "" {
+		aID, err := strconv.ParseUint(v, 10, 0)
+		if err != nil {
+			return f, errors.New("invalid author_id")
+		}
+		f.AuthorID = uint(aID)
+	}
+	if v := c.QueryParam("min_rating"); v != "" {
+		rating, err := strconv.ParseFloat(v, 64)
+		if err != nil || rating < 0 {
+			return f, errors.New("invalid min_rating")
+		}
+		f.MinRating = rating
+	}
+	return f, nil
+}
+
+// listError отвечает 400 на неверный курсор или поле сортировки и 500 на остальное.
+func listError(c echo.Context, err error) error {
+	if errors.Is(err, domain.ErrInvalidCursor) || errors.Is(err, domain.ErrInvalidSort) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
 func getUID(c echo.Context) uint {
 	val := c.Get("user_id")
 	if val == nil {
@@ -67,7 +130,7 @@ func (h *Handler) Register(c echo.Context) error {
 	if err := c.Bind(&r); err != nil {
 		return err
 	}
-	if err := h.svc.Register(r.Email, r.Password, r.Name); err != nil {
+	if err := h.svc.Register(c.Request().Context(), r.Email, r.Password, r.Name); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 	return c.NoContent(http.StatusCreated)
@@ -79,7 +142,7 @@ func (h *Handler) Register(c echo.Context) error {
 // @Accept json
 // @Produce json
 // @Param body body LoginRequest true "Данные логина"
-// @Success 200 {object} map[string]string "token"
+// @Success 200 {object} service.TokenPair
 // @Router /login [post]
 func (h *Handler) Login(c echo.Context) error {
 	var r struct {
@@ -89,11 +152,39 @@ func (h *Handler) Login(c echo.Context) error {
 	if err := c.Bind(&r); err != nil {
 		return err
 	}
-	token, err := h.svc.Login(r.Email, r.Password)
+	tokens, err := h.svc.Login(c.Request().Context(), r.Email, r.Password)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
 	}
-	return c.JSON(http.StatusOK, map[string]string{"token": token})
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// Refresh godoc
+// @Summary Обновить пару токенов
+// @Description Refresh-токен одноразовый: в ответе приходит новый, старый перестаёт действовать.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param body body RefreshRequest true "Refresh-токен"
+// @Success 200 {object} service.TokenPair
+// @Router /token/refresh [post]
+func (h *Handler) Refresh(c echo.Context) error {
+	var r RefreshRequest
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+	if r.RefreshToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "refresh_token is required"})
+	}
+	tokens, err := h.svc.Refresh(c.Request().Context(), r.RefreshToken)
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, tokens)
+	case errors.Is(err, service.ErrInvalidRefreshToken), errors.Is(err, service.ErrRefreshTokenReused):
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
 }
 
 // --- PROTECTED ---
@@ -105,7 +196,7 @@ func (h *Handler) Login(c echo.Context) error {
 // @Success 200 {object} domain.User
 // @Router /me [get]
 func (h *Handler) GetMe(c echo.Context) error {
-	u, err := h.svc.GetProfile(getUID(c))
+	u, err := h.svc.GetProfile(c.Request().Context(), getUID(c))
 	if err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
 	}
@@ -113,6 +204,7 @@ func (h *Handler) GetMe(c echo.Context) error {
 }
 
 // @Summary Обновить профиль
+// @Description Заменяет имя и email. Роль и пароль так не меняются. Отдаёт сохранённый профиль.
 // @Tags Profile
 // @Security ApiKeyAuth
 // @Accept json
@@ -125,21 +217,104 @@ func (h *Handler) UpdateProfile(c echo.Context) error {
 		return err
 	}
 	u.ID = getUID(c)
-	if err := h.svc.UpdateProfile(&u); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	if err := h.svc.UpdateProfile(c.Request().Context(), &u); err != nil {
+		// Это не merge patch: неверное поле отдаём так же, как ошибку Bind
+		var pe *service.PatchError
+		if errors.As(err, &pe) {
+			return echo.NewHTTPError(http.StatusBadRequest, pe.Error())
+		}
+		return updateError(c, err)
 	}
 	return c.JSON(http.StatusOK, u)
 }
 
-// @Summary Список всех книг
+// Logout godoc
+// @Summary Выйти с текущего устройства
+// @Description Отзывает текущий access-токен. Если передан refresh-токен, он тоже перестаёт действовать.
+// @Tags Auth
+// @Security ApiKeyAuth
+// @Accept json
+// @Param body body LogoutRequest false "Refresh-токен этого устройства"
+// @Success 204 "No Content"
+// @Router /logout [post]
+func (h *Handler) Logout(c echo.Context) error {
+	var r LogoutRequest
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+	jti, _ := c.Get("token_id").(string)
+	exp, _ := c.Get("token_exp").(time.Time)
+	if err := h.svc.Logout(c.Request().Context(), getUID(c), jti, exp, r.RefreshToken); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// LogoutAll godoc
+// @Summary Выйти на всех устройствах
+// @Tags Auth
+// @Security ApiKeyAuth
+// @Success 204 "No Content"
+// @Router /logout/all [post]
+func (h *Handler) LogoutAll(c echo.Context) error {
+	if err := h.svc.LogoutAll(c.Request().Context(), getUID(c)); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// SetUserRole godoc
+// @Summary Изменить роль пользователя
+// @Tags Admin
+// @Security ApiKeyAuth
+// @Param id path int true "ID пользователя"
+// @Accept json
+// @Param body body RoleRequest true "Новая роль (reader, editor, moderator, admin)"
+// @Success 204 "No Content"
+// @Router /admin/users/{id}/role [put]
+func (h *Handler) SetUserRole(c echo.Context) error {
+	idParam := c.Param("id")
+	idInt, err := strconv.Atoi(idParam)
+	if err != nil || idInt < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	var r RoleRequest
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+	err = h.svc.SetUserRole(c.Request().Context(), getUID(c), uint(idInt), r.Role)
+	switch {
+	case err == nil:
+		return c.NoContent(http.StatusNoContent)
+	case errors.Is(err, service.ErrInvalidRole), errors.Is(err, service.ErrSelfRoleChange):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
+
+// @Summary Список книг
+// @Description Без текста книги: content приходит пустым, полный текст — в GET /books/{id}.
 // @Tags Books
 // @Produce json
-// @Success 200 {array} domain.Book
+// @Param limit query int false "Размер страницы (по умолчанию 20, максимум 100)"
+// @Param cursor query string false "Курсор из next_cursor предыдущей страницы"
+// @Param sort query string false "id, title, rating (средняя оценка) или rating_count; -rating — по убыванию"
+// @Param author_id query int false "Только книги автора"
+// @Param min_rating query number false "Минимальная средняя оценка"
+// @Param title_prefix query string false "Начало названия"
+// @Success 200 {object} domain.Page[domain.Book]
 // @Router /books [get]
 func (h *Handler) ListBooks(c echo.Context) error {
-	books, err := h.svc.GetAllBooks()
+	f, err := parseBookFilter(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	books, err := h.svc.GetAllBooks(c.Request().Context(), f)
+	if err != nil {
+		return listError(c, err)
 	}
 	return c.JSON(http.StatusOK, books)
 }
@@ -156,17 +331,20 @@ func (h *Handler) CreateBook(c echo.Context) error {
 	if err := c.Bind(&b); err != nil {
 		return err
 	}
-	if err := h.svc.CreateBook(&b); err != nil {
+	if err := h.svc.CreateBook(c.Request().Context(), &b); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 	return c.JSON(http.StatusCreated, b)
 }
 
 // @Summary Получить книгу по ID
+// @Description Отдаёт ETag из версии книги и хэша ответа; при совпадении с If-None-Match — 304 без тела.
 // @Tags Books
 // @Param id path int true "ID книги"
+// @Param If-None-Match header string false "ETag из прошлого ответа"
 // @Produce json
 // @Success 200 {object} domain.Book
+// @Success 304 "Not Modified"
 // @Router /books/{id} [get]
 func (h *Handler) GetBook(c echo.Context) error {
 	idParam := c.Param("id")
@@ -175,17 +353,22 @@ func (h *Handler) GetBook(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
 	}
 	id := uint(idInt)
-	b, err := h.svc.GetBook(uint(id))
+	b, err := h.svc.GetBook(c.Request().Context(), uint(id))
 	if err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
 	}
+	if notModified(c, b.Version, b) {
+		return c.NoContent(http.StatusNotModified)
+	}
 	return c.JSON(http.StatusOK, b)
 }
 
 // @Summary Обновить книгу
+// @Description Нужен If-Match с ETag из GET: без него — 428, если книгу успели изменить — 412.
 // @Tags Books
 // @Security ApiKeyAuth
 // @Param id path int true "ID книги"
+// @Param If-Match header string true "ETag книги"
 // @Accept json
 // @Param book body domain.Book true "Новые данные"
 // @Success 200 {object} domain.Book
@@ -197,21 +380,61 @@ func (h *Handler) UpdateBook(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
 	}
 	id := uint(idInt)
+	version, code, err := ifMatch(c)
+	if err != nil {
+		return c.JSON(code, map[string]string{"error": err.Error()})
+	}
 	var b domain.Book
 	if err := c.Bind(&b); err != nil {
 		return err
 	}
-	b.ID = uint(id)
-	if err := h.svc.UpdateBook(&b); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	b.ID, b.Version = uint(id), version
+	if err := h.svc.UpdateBook(c.Request().Context(), &b); err != nil {
+		return updateError(c, err)
 	}
+	c.Response().Header().Set(headerETag, etag(b.Version, b))
 	return c.JSON(http.StatusOK, b)
 }
 
+// parseDeleteOptions читает политику удаления из параметров policy и reassign_to.
+func parseDeleteOptions(c echo.Context) (domain.DeleteOptions, bool) {
+	opts := domain.DeleteOptions{Policy: c.QueryParam("policy")}
+	if v := c.QueryParam("reassign_to"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			return opts, false
+		}
+		opts.ReassignTo = uint(id)
+	}
+	return opts, true
+}
+
+// deleteError отвечает на отказ в удалении; при restrict клиент получает список зависимых записей.
+func deleteError(c echo.Context, err error) error {
+	var depsErr *service.DependentsError
+	switch {
+	case errors.As(err, &depsErr):
+		return c.JSON(http.StatusConflict, map[string]interface{}{"error": err.Error(), "dependents": depsErr.Dependents})
+	case errors.Is(err, service.ErrInvalidDeletePolicy), errors.Is(err, service.ErrInvalidReassign):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, domain.ErrVersionMismatch):
+		return c.JSON(http.StatusPreconditionFailed, map[string]string{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Not found"})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
+
 // @Summary Удалить книгу
+// @Description Книга удаляется мягко: пропадает из каталога, поиска и подборок, но остаётся на полках читателей вместе с отзывами и аннотациями.
+// @Description policy=restrict (по умолчанию) — 409 с зависимыми записями в поле dependents, если они есть; policy=cascade — удалить всё равно.
+// @Description Нужен If-Match с ETag из GET: без него — 428, если книгу успели изменить — 412.
 // @Tags Books
 // @Security ApiKeyAuth
 // @Param id path int true "ID книги"
+// @Param If-Match header string true "ETag книги"
+// @Param policy query string false "restrict или cascade"
 // @Success 204 "No Content"
 // @Router /books/{id} [delete]
 func (h *Handler) DeleteBook(c echo.Context) error {
@@ -221,8 +444,13 @@ func (h *Handler) DeleteBook(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
 	}
 	id := uint(idInt)
-	if err := h.svc.DeleteBook(uint(id)); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	version, code, err := ifMatch(c)
+	if err != nil {
+		return c.JSON(code, map[string]string{"error": err.Error()})
+	}
+	opts := domain.DeleteOptions{Policy: c.QueryParam("policy"), Version: version}
+	if err := h.svc.DeleteBook(c.Request().Context(), id, opts); err != nil {
+		return deleteError(c, err)
 	}
 	return c.NoContent(http.StatusNoContent)
 }
@@ -240,22 +468,272 @@ func (h *Handler) GetBookContent(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
 	}
 	id := uint(idInt)
-	b, err := h.svc.GetBook(uint(id))
+	b, err := h.svc.GetBook(c.Request().Context(), uint(id))
 	if err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
 	}
 	return c.JSON(http.StatusOK, map[string]string{"content": b.Content})
 }
 
+// @Summary Оглавление книги
+// @Description Список глав без текста, по порядку.
+// @Tags Books
+// @Security ApiKeyAuth
+// @Produce json
+// @Param id path int true "ID книги"
+// @Success 200 {array} domain.Chapter
+// @Router /books/{id}/chapters [get]
+func (h *Handler) ListChapters(c echo.Context) error {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil || idInt < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	chapters, err := h.svc.GetChapters(c.Request().Context(), uint(idInt))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, chapters)
+}
+
+// @Summary Глава книги
+// @Tags Books
+// @Security ApiKeyAuth
+// @Produce json
+// @Param id path int true "ID книги"
+// @Param n path int true "Номер главы, начиная с 1"
+// @Success 200 {object} domain.Chapter
+// @Router /books/{id}/chapters/{n} [get]
+func (h *Handler) GetChapter(c echo.Context) error {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil || idInt < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	n, err := strconv.Atoi(c.Param("n"))
+	if err != nil || n < 1 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid chapter number"})
+	}
+	ch, err := h.svc.GetChapter(c.Request().Context(), uint(idInt), n)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Chapter not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, ch)
+}
+
+// SearchBooks godoc
+// @Summary Полнотекстовый поиск книг
+// @Description Ищет по названию, описанию, тексту и автору. Поддерживает синтаксис websearch: "точная фраза", -исключить, or.
+// @Tags Books
+// @Produce json
+// @Param q query string true "Поисковый запрос"
+// @Param lang query string false "Конфигурация текста: ru (по умолчанию) или en"
+// @Param limit query int false "Размер страницы (по умолчанию 20, максимум 100)"
+// @Param cursor query string false "Курсор из next_cursor предыдущей страницы"
+// @Success 200 {object} domain.Page[domain.SearchHit]
+// @Router /search [get]
+func (h *Handler) SearchBooks(c echo.Context) error {
+	lq, err := parseListQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	hits, err := h.svc.SearchBooks(c.Request().Context(), domain.SearchQuery{ListQuery: lq, Text: c.QueryParam("q"), Lang: c.QueryParam("lang")})
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, hits)
+	case errors.Is(err, service.ErrEmptySearchQuery), errors.Is(err, service.ErrUnsupportedLanguage):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	default:
+		return listError(c, err)
+	}
+}
+
+// Autocomplete godoc
+// @Summary Автодополнение по книгам и авторам
+// @Description Нечёткий поиск по триграммам: терпим к опечаткам и смешению кириллицы с латиницей.
+// @Tags Books
+// @Produce json
+// @Param q query string true "Начало названия или имени"
+// @Param limit query int false "Сколько подсказок каждого вида (по умолчанию 5, максимум 20)"
+// @Success 200 {object} domain.Autocomplete
+// @Router /autocomplete [get]
+func (h *Handler) Autocomplete(c echo.Context) error {
+	limit := 0
+	if v := c.QueryParam("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid limit"})
+		}
+		limit = l
+	}
+	res, err := h.svc.Autocomplete(c.Request().Context(), c.QueryParam("q"), limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// maxUploadBytes ограничивает размер тела запроса при загрузке файла книги.
+const maxUploadBytes = 200 << 20
+
+// UploadBookFile godoc
+// @Summary Загрузить файл книги
+// @Description Принимает EPUB, PDF или FB2 в поле file. Формат определяется по содержимому.
+// @Description Из EPUB извлекаются метаданные: пустые поля книги заполняются, расхождения возвращаются в metadata.conflicts.
+// @Tags Files
+// @Security ApiKeyAuth
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path int true "ID книги"
+// @Param file formData file true "Файл книги"
+// @Success 201 {object} service.UploadResult
+// @Router /books/{id}/files [post]
+func (h *Handler) UploadBookFile(c echo.Context) error {
+	idParam := c.Param("id")
+	idInt, err := strconv.Atoi(idParam)
+	if err != nil || idInt < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxUploadBytes)
+	fh, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "File is too large"})
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Multipart field 'file' is required"})
+	}
+	src, err := fh.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	defer src.Close()
+
+	res, err := h.svc.UploadBookFile(c.Request().Context(), uint(idInt), fh.Filename, src)
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusCreated, res)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
+	case errors.Is(err, service.ErrUnsupportedFormat):
+		return c.JSON(http.StatusUnsupportedMediaType, map[string]string{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidEPUB):
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	case errors.Is(err, domain.ErrVersionMismatch):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
+
+// @Summary Файлы книги
+// @Tags Files
+// @Security ApiKeyAuth
+// @Produce json
+// @Param id path int true "ID книги"
+// @Success 200 {array} domain.BookFile
+// @Router /books/{id}/files [get]
+func (h *Handler) ListBookFiles(c echo.Context) error {
+	idParam := c.Param("id")
+	idInt, err := strconv.Atoi(idParam)
+	if err != nil || idInt < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	files, err := h.svc.GetBookFiles(c.Request().Context(), uint(idInt))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, files)
+}
+
+// DownloadBookFile godoc
+// @Summary Скачать файл книги
+// @Description Поддерживает Range-запросы, так что читалка может докачивать файл частями.
+// @Tags Files
+// @Security ApiKeyAuth
+// @Produce application/epub+zip,application/pdf,application/x-fictionbook+xml
+// @Param id path int true "ID книги"
+// @Param file_id path int true "ID файла"
+// @Success 200 {file} file
+// @Success 206 {file} file
+// @Router /books/{id}/files/{file_id} [get]
+func (h *Handler) DownloadBookFile(c echo.Context) error {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil || idInt < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	fileID, err := strconv.Atoi(c.Param("file_id"))
+	if err != nil || fileID < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	f, content, err := h.svc.OpenBookFile(c.Request().Context(), uint(idInt), uint(fileID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "File not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	defer content.Close()
+
+	name := f.FileName
+	if name == "" {
+		name = fmt.Sprintf("book-%d.%s", f.BookID, f.Format)
+	}
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, domain.FormatContentTypes[f.Format])
+	res.Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	res.Header().Set("ETag", `"`+f.Checksum+`"`)
+	// ServeContent сам разбирает Range, If-Range и If-None-Match.
+	http.ServeContent(res, c.Request(), name, f.CreatedAt, content)
+	return nil
+}
+
+// @Summary Обложка книги
+// @Tags Files
+// @Security ApiKeyAuth
+// @Produce image/jpeg,image/png
+// @Param id path int true "ID книги"
+// @Success 200 {file} file
+// @Router /books/{id}/cover [get]
+func (h *Handler) GetBookCover(c echo.Context) error {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil || idInt < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	book, content, err := h.svc.OpenBookCover(c.Request().Context(), uint(idInt))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Cover not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	defer content.Close()
+	// Тип определяется по расширению ключа, которое выбирается по media-type из OPF.
+	http.ServeContent(c.Response(), c.Request(), path.Base(book.CoverKey), time.Time{}, content)
+	return nil
+}
+
 // @Summary Список авторов
 // @Tags Authors
 // @Produce json
-// @Success 200 {array} domain.Author
+// @Param limit query int false "Размер страницы (по умолчанию 20, максимум 100)"
+// @Param cursor query string false "Курсор из next_cursor предыдущей страницы"
+// @Param sort query string false "id или name; -name — по убыванию"
+// @Param name_prefix query string false "Начало имени"
+// @Success 200 {object} domain.Page[domain.Author]
 // @Router /authors [get]
 func (h *Handler) ListAuthors(c echo.Context) error {
-	authors, err := h.svc.GetAllAuthors()
+	lq, err := parseListQuery(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	authors, err := h.svc.GetAllAuthors(c.Request().Context(), domain.AuthorFilter{ListQuery: lq, NamePrefix: c.QueryParam("name_prefix")})
+	if err != nil {
+		return listError(c, err)
 	}
 	return c.JSON(http.StatusOK, authors)
 }
@@ -272,17 +750,20 @@ func (h *Handler) CreateAuthor(c echo.Context) error {
 	if err := c.Bind(&a); err != nil {
 		return err
 	}
-	if err := h.svc.CreateAuthor(&a); err != nil {
+	if err := h.svc.CreateAuthor(c.Request().Context(), &a); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 	return c.JSON(http.StatusCreated, a)
 }
 
 // @Summary Инфо об авторе
+// @Description Отдаёт ETag из версии автора и хэша ответа; при совпадении с If-None-Match — 304 без тела.
 // @Tags Authors
 // @Param id path int true "ID автора"
+// @Param If-None-Match header string false "ETag из прошлого ответа"
 // @Produce json
 // @Success 200 {object} domain.Author
+// @Success 304 "Not Modified"
 // @Router /authors/{id} [get]
 func (h *Handler) GetAuthor(c echo.Context) error {
 	idParam := c.Param("id")
@@ -291,17 +772,22 @@ func (h *Handler) GetAuthor(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
 	}
 	id := uint(idInt)
-	a, err := h.svc.GetAuthor(uint(id))
+	a, err := h.svc.GetAuthor(c.Request().Context(), uint(id))
 	if err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "Author not found"})
 	}
+	if notModified(c, a.Version, a) {
+		return c.NoContent(http.StatusNotModified)
+	}
 	return c.JSON(http.StatusOK, a)
 }
 
 // @Summary Обновить автора
+// @Description Нужен If-Match с ETag из GET: без него — 428, если автора успели изменить — 412.
 // @Tags Authors
 // @Security ApiKeyAuth
 // @Param id path int true "ID автора"
+// @Param If-Match header string true "ETag автора"
 // @Accept json
 // @Param author body domain.Author true "Данные"
 // @Success 200 {object} domain.Author
@@ -313,21 +799,32 @@ func (h *Handler) UpdateAuthor(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
 	}
 	id := uint(idInt)
+	version, code, err := ifMatch(c)
+	if err != nil {
+		return c.JSON(code, map[string]string{"error": err.Error()})
+	}
 	var a domain.Author
 	if err := c.Bind(&a); err != nil {
 		return err
 	}
-	a.ID = uint(id)
-	if err := h.svc.UpdateAuthor(&a); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	a.ID, a.Version = uint(id), version
+	if err := h.svc.UpdateAuthor(c.Request().Context(), &a); err != nil {
+		return updateError(c, err)
 	}
+	c.Response().Header().Set(headerETag, etag(a.Version, a))
 	return c.JSON(http.StatusOK, a)
 }
 
 // @Summary Удалить автора
+// @Description Автор и его книги удаляются мягко. policy=restrict (по умолчанию) — 409 со списком книг автора в поле dependents, если они есть;
+// @Description policy=cascade — удалить вместе с книгами; policy=reassign — передать книги автору reassign_to.
+// @Description Нужен If-Match с ETag из GET: без него — 428, если автора успели изменить — 412.
 // @Tags Authors
 // @Security ApiKeyAuth
 // @Param id path int true "ID автора"
+// @Param If-Match header string true "ETag автора"
+// @Param policy query string false "restrict, cascade или reassign"
+// @Param reassign_to query int false "ID автора, которому перейдут книги при reassign"
 // @Success 204 "No Content"
 // @Router /authors/{id} [delete]
 func (h *Handler) DeleteAuthor(c echo.Context) error {
@@ -337,8 +834,17 @@ func (h *Handler) DeleteAuthor(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
 	}
 	id := uint(idInt)
-	if err := h.svc.DeleteAuthor(uint(id)); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	version, code, err := ifMatch(c)
+	if err != nil {
+		return c.JSON(code, map[string]string{"error": err.Error()})
+	}
+	opts, ok := parseDeleteOptions(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid reassign_to"})
+	}
+	opts.Version = version
+	if err := h.svc.DeleteAuthor(c.Request().Context(), id, opts); err != nil {
+		return deleteError(c, err)
 	}
 	return c.NoContent(http.StatusNoContent)
 }
@@ -346,8 +852,13 @@ func (h *Handler) DeleteAuthor(c echo.Context) error {
 // @Summary Книги автора
 // @Tags Authors
 // @Param id path int true "ID автора"
+// @Param limit query int false "Размер страницы (по умолчанию 20, максимум 100)"
+// @Param cursor query string false "Курсор из next_cursor предыдущей страницы"
+// @Param sort query string false "id, title, rating (средняя оценка) или rating_count; -rating — по убыванию"
+// @Param min_rating query number false "Минимальная средняя оценка"
+// @Param title_prefix query string false "Начало названия"
 // @Produce json
-// @Success 200 {array} domain.Book
+// @Success 200 {object} domain.Page[domain.Book]
 // @Router /authors/{id}/books [get]
 func (h *Handler) GetAuthorBooks(c echo.Context) error {
 	idParam := c.Param("id")
@@ -356,18 +867,26 @@ func (h *Handler) GetAuthorBooks(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
 	}
 	id := uint(idInt)
-	books, err := h.svc.GetBooksByAuthor(uint(id))
+	f, err := parseBookFilter(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	books, err := h.svc.GetBooksByAuthor(c.Request().Context(), uint(id), f)
+	if err != nil {
+		return listError(c, err)
 	}
 	return c.JSON(http.StatusOK, books)
 }
 
 // @Summary Список отзывов к книге
+// @Description Только опубликованные отзывы: ожидающие модерации и отклонённые скрыты.
 // @Tags Reviews
 // @Param id path int true "ID книги"
+// @Param limit query int false "Размер страницы (по умолчанию 20, максимум 100)"
+// @Param cursor query string false "Курсор из next_cursor предыдущей страницы"
+// @Param sort query string false "helpful (по умолчанию), newest, rating_desc или rating_asc"
 // @Produce json
-// @Success 200 {array} domain.Review
+// @Success 200 {object} domain.Page[domain.Review]
 // @Router /books/{id}/reviews [get]
 func (h *Handler) ListReviews(c echo.Context) error {
 	idParam := c.Param("id")
@@ -376,14 +895,20 @@ func (h *Handler) ListReviews(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
 	}
 	id := uint(idInt)
-	reviews, err := h.svc.GetReviews(uint(id))
+	lq, err := parseListQuery(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	reviews, err := h.svc.GetReviews(c.Request().Context(), uint(id), lq)
+	if err != nil {
+		return listError(c, err)
 	}
 	return c.JSON(http.StatusOK, reviews)
 }
 
 // @Summary Добавить отзыв
+// @Description Оценка от 1 до 5. Один отзыв на книгу: повторный — 409, правка — через PUT /reviews/{id}.
+// @Description Отзыв с запрещённым словом сохраняется со статусом pending и ждёт модерации.
 // @Tags Reviews
 // @Security ApiKeyAuth
 // @Param id path int true "ID книги"
@@ -404,12 +929,99 @@ func (h *Handler) AddReview(c echo.Context) error {
 	}
 	r.BookID = uint(id)
 	r.UserID = getUID(c)
-	if err := h.svc.AddReview(&r); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	if err := h.svc.AddReview(c.Request().Context(), &r); err != nil {
+		return reviewError(c, err)
 	}
 	return c.JSON(http.StatusCreated, r)
 }
 
+// @Summary Изменить свой отзыв
+// @Description Меняет оценку и текст; отзыв помечается как отредактированный.
+// @Tags Reviews
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID отзыва"
+// @Param review body domain.Review true "Оценка 1–5 и текст"
+// @Success 200 {object} domain.Review
+// @Router /reviews/{id} [put]
+func (h *Handler) UpdateReview(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	var r domain.Review
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+	r.ID = id
+	if err := h.svc.UpdateReview(c.Request().Context(), getUID(c), &r); err != nil {
+		return reviewError(c, err)
+	}
+	return c.JSON(http.StatusOK, r)
+}
+
+type VoteRequest struct {
+	Helpful bool `json:"helpful"`
+}
+
+// @Summary Оценить полезность отзыва
+// @Description Один голос от пользователя, повторный заменяет прежний. За свой отзыв голосовать нельзя.
+// @Tags Reviews
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID отзыва"
+// @Param body body VoteRequest true "helpful: true — полезен, false — нет"
+// @Success 200 {object} domain.Review
+// @Router /reviews/{id}/vote [put]
+func (h *Handler) VoteReview(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	var r VoteRequest
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+	re, err := h.svc.VoteReview(c.Request().Context(), getUID(c), id, r.Helpful)
+	if err != nil {
+		return reviewError(c, err)
+	}
+	return c.JSON(http.StatusOK, re)
+}
+
+// @Summary Снять голос с отзыва
+// @Tags Reviews
+// @Security ApiKeyAuth
+// @Produce json
+// @Param id path int true "ID отзыва"
+// @Success 200 {object} domain.Review
+// @Router /reviews/{id}/vote [delete]
+func (h *Handler) UnvoteReview(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	re, err := h.svc.UnvoteReview(c.Request().Context(), getUID(c), id)
+	if err != nil {
+		return reviewError(c, err)
+	}
+	return c.JSON(http.StatusOK, re)
+}
+
+// reviewError: повторный отзыв — 409, нет книги или отзыва — 404, остальное — 400.
+func reviewError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrReviewExists):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Book or review not found"})
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+}
+
 // @Summary Удалить отзыв
 // @Tags Reviews
 // @Security ApiKeyAuth
@@ -423,7 +1035,7 @@ func (h *Handler) DeleteReview(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
 	}
 	id := uint(idInt)
-	if err := h.svc.DeleteReview(uint(id), getUID(c)); err != nil {
+	if err := h.svc.DeleteReview(c.Request().Context(), uint(id), getUID(c)); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 	return c.NoContent(http.StatusNoContent)
@@ -436,7 +1048,7 @@ func (h *Handler) DeleteReview(c echo.Context) error {
 // @Success 200 {array} domain.Book
 // @Router /shelf [get]
 func (h *Handler) GetShelf(c echo.Context) error {
-	shelf, err := h.svc.GetShelf(getUID(c))
+	shelf, err := h.svc.GetShelf(c.Request().Context(), getUID(c))
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
@@ -445,6 +1057,8 @@ func (h *Handler) GetShelf(c echo.Context) error {
 
 // AddToShelf godoc
 // @Summary Добавить на полку
+// @Description Статус: want_to_read, reading, paused, completed или abandoned.
+// @Description Неизвестный статус или недопустимый переход — 422 со списком allowed.
 // @Tags Shelf
 // @Security ApiKeyAuth
 // @Param id path int true "Book ID"
@@ -464,10 +1078,85 @@ func (h *Handler) AddToShelf(c echo.Context) error {
 	if err := c.Bind(&r); err != nil {
 		return err
 	}
-	if err := h.svc.SetShelfStatus(getUID(c), uint(id), r.Status); err != nil {
+	err = h.svc.SetShelfStatus(c.Request().Context(), getUID(c), uint(id), r.Status)
+	var statusErr *service.StatusError
+	switch {
+	case err == nil:
+		return c.NoContent(http.StatusOK)
+	case errors.As(err, &statusErr):
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{"error": err.Error(), "allowed": statusErr.Allowed})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
+
+// UpdateProgress godoc
+// @Summary Сохранить позицию чтения
+// @Description Книга, которой нет на полке, добавляется со статусом reading. При 100% статус становится completed.
+// @Tags Shelf
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID книги"
+// @Param body body domain.ReadingPosition true "Позиция"
+// @Success 200 {object} domain.Shelf
+// @Router /shelf/{id}/progress [put]
+func (h *Handler) UpdateProgress(c echo.Context) error {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil || idInt < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	var pos domain.ReadingPosition
+	if err := c.Bind(&pos); err != nil {
+		return err
+	}
+	entry, err := h.svc.UpdateProgress(c.Request().Context(), getUID(c), uint(idInt), pos)
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, entry)
+	case errors.Is(err, service.ErrInvalidProgress):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
+
+// SyncPosition godoc
+// @Summary Синхронизировать позицию между устройствами
+// @Description Побеждает самая дальняя позиция; jump_back=true разрешает вернуться назад.
+// @Description Если позиция не принята (applied=false), клиент должен перейти на position из ответа.
+// @Tags Shelf
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID книги"
+// @Param body body domain.PositionUpdate true "Позиция устройства"
+// @Success 200 {object} service.SyncResult
+// @Router /shelf/{id}/sync [post]
+func (h *Handler) SyncPosition(c echo.Context) error {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil || idInt < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	var u domain.PositionUpdate
+	if err := c.Bind(&u); err != nil {
+		return err
+	}
+	res, err := h.svc.SyncPosition(c.Request().Context(), getUID(c), uint(idInt), u)
+	switch {
+	case err == nil:
+		return c.JSON(http.StatusOK, res)
+	case errors.Is(err, service.ErrInvalidProgress), errors.Is(err, service.ErrInvalidDevice):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Book not found"})
+	default:
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
-	return c.NoContent(http.StatusOK)
 }
 
 // @Summary Удалить с полки
@@ -483,7 +1172,7 @@ func (h *Handler) RemoveFromShelf(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
 	}
 	id := uint(idInt)
-	if err := h.svc.RemoveFromShelf(getUID(c), uint(id)); err != nil {
+	if err := h.svc.RemoveFromShelf(c.Request().Context(), getUID(c), uint(id)); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 	return c.NoContent(http.StatusNoContent)
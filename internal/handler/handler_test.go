@@ -2,17 +2,24 @@ package handler
 
 import (
 	"E-book-service/internal/domain"
+	"E-book-service/internal/service"
+	"E-book-service/internal/storage"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
 )
 
 // --- MOCK SERVICE ---
@@ -21,67 +28,328 @@ type MockService struct {
 	mock.Mock
 }
 
-func (m *MockService) Register(email, pass, name string) error {
+func (m *MockService) Register(ctx context.Context, email, pass, name string) error {
 	return m.Called(email, pass, name).Error(0)
 }
-func (m *MockService) Login(email, pass string) (string, error) {
+func (m *MockService) Login(ctx context.Context, email, pass string) (*service.TokenPair, error) {
 	args := m.Called(email, pass)
-	return args.String(0), args.Error(1)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.TokenPair), args.Error(1)
+}
+func (m *MockService) Refresh(ctx context.Context, refreshToken string) (*service.TokenPair, error) {
+	args := m.Called(refreshToken)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.TokenPair), args.Error(1)
+}
+func (m *MockService) Logout(ctx context.Context, uID uint, jti string, exp time.Time, refreshToken string) error {
+	return m.Called(uID, jti, exp, refreshToken).Error(0)
 }
-func (m *MockService) GetProfile(id uint) (*domain.User, error) {
+func (m *MockService) LogoutAll(ctx context.Context, uID uint) error { return m.Called(uID).Error(0) }
+func (m *MockService) GetProfile(ctx context.Context, id uint) (*domain.User, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.User), args.Error(1)
 }
-func (m *MockService) UpdateProfile(u *domain.User) error { return m.Called(u).Error(0) }
-func (m *MockService) CreateBook(b *domain.Book) error    { return m.Called(b).Error(0) }
-func (m *MockService) GetAllBooks() ([]domain.Book, error) {
-	args := m.Called()
-	return args.Get(0).([]domain.Book), args.Error(1)
+func (m *MockService) UpdateProfile(ctx context.Context, u *domain.User) error {
+	return m.Called(u).Error(0)
+}
+func (m *MockService) PatchProfile(ctx context.Context, uID uint, p domain.MergePatch) (*domain.User, error) {
+	args := m.Called(uID, p)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.User), args.Error(1)
+}
+func (m *MockService) SetUserRole(ctx context.Context, actorID, id uint, role string) error {
+	return m.Called(actorID, id, role).Error(0)
+}
+func (m *MockService) CreateBook(ctx context.Context, b *domain.Book) error {
+	return m.Called(b).Error(0)
 }
-func (m *MockService) GetBook(id uint) (*domain.Book, error) {
+func (m *MockService) GetAllBooks(ctx context.Context, f domain.BookFilter) (domain.Page[domain.Book], error) {
+	args := m.Called(f)
+	return args.Get(0).(domain.Page[domain.Book]), args.Error(1)
+}
+func (m *MockService) GetBook(ctx context.Context, id uint) (*domain.Book, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.Book), args.Error(1)
 }
-func (m *MockService) UpdateBook(b *domain.Book) error { return m.Called(b).Error(0) }
-func (m *MockService) DeleteBook(id uint) error        { return m.Called(id).Error(0) }
-func (m *MockService) GetBooksByAuthor(aID uint) ([]domain.Book, error) {
-	args := m.Called(aID)
-	return args.Get(0).([]domain.Book), args.Error(1)
+func (m *MockService) SearchBooks(ctx context.Context, q domain.SearchQuery) (domain.Page[domain.SearchHit], error) {
+	args := m.Called(q)
+	return args.Get(0).(domain.Page[domain.SearchHit]), args.Error(1)
 }
-func (m *MockService) CreateAuthor(a *domain.Author) error { return m.Called(a).Error(0) }
-func (m *MockService) GetAllAuthors() ([]domain.Author, error) {
-	args := m.Called()
-	return args.Get(0).([]domain.Author), args.Error(1)
+func (m *MockService) Autocomplete(ctx context.Context, q string, limit int) (domain.Autocomplete, error) {
+	args := m.Called(q, limit)
+	return args.Get(0).(domain.Autocomplete), args.Error(1)
+}
+func (m *MockService) UpdateBook(ctx context.Context, b *domain.Book) error {
+	return m.Called(b).Error(0)
+}
+func (m *MockService) PatchBook(ctx context.Context, id uint, version int64, p domain.MergePatch) (*domain.Book, error) {
+	args := m.Called(id, version, p)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Book), args.Error(1)
 }
-func (m *MockService) GetAuthor(id uint) (*domain.Author, error) {
+func (m *MockService) DeleteBook(ctx context.Context, id uint, opts domain.DeleteOptions) error {
+	return m.Called(id, opts).Error(0)
+}
+func (m *MockService) GetBooksByAuthor(ctx context.Context, aID uint, f domain.BookFilter) (domain.Page[domain.Book], error) {
+	args := m.Called(aID, f)
+	return args.Get(0).(domain.Page[domain.Book]), args.Error(1)
+}
+func (m *MockService) CreateAuthor(ctx context.Context, a *domain.Author) error {
+	return m.Called(a).Error(0)
+}
+func (m *MockService) GetAllAuthors(ctx context.Context, f domain.AuthorFilter) (domain.Page[domain.Author], error) {
+	args := m.Called(f)
+	return args.Get(0).(domain.Page[domain.Author]), args.Error(1)
+}
+func (m *MockService) GetAuthor(ctx context.Context, id uint) (*domain.Author, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.Author), args.Error(1)
 }
-func (m *MockService) UpdateAuthor(a *domain.Author) error { return m.Called(a).Error(0) }
-func (m *MockService) DeleteAuthor(id uint) error          { return m.Called(id).Error(0) }
-func (m *MockService) AddReview(re *domain.Review) error   { return m.Called(re).Error(0) }
-func (m *MockService) GetReviews(bID uint) ([]domain.Review, error) {
-	args := m.Called(bID)
-	return args.Get(0).([]domain.Review), args.Error(1)
+func (m *MockService) UpdateAuthor(ctx context.Context, a *domain.Author) error {
+	return m.Called(a).Error(0)
+}
+func (m *MockService) PatchAuthor(ctx context.Context, id uint, version int64, p domain.MergePatch) (*domain.Author, error) {
+	args := m.Called(id, version, p)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Author), args.Error(1)
+}
+func (m *MockService) DeleteAuthor(ctx context.Context, id uint, opts domain.DeleteOptions) error {
+	return m.Called(id, opts).Error(0)
+}
+func (m *MockService) AddReview(ctx context.Context, re *domain.Review) error {
+	return m.Called(re).Error(0)
+}
+func (m *MockService) GetReviews(ctx context.Context, bID uint, q domain.ListQuery) (domain.Page[domain.Review], error) {
+	args := m.Called(bID, q)
+	return args.Get(0).(domain.Page[domain.Review]), args.Error(1)
+}
+func (m *MockService) UpdateReview(ctx context.Context, uID uint, re *domain.Review) error {
+	return m.Called(uID, re).Error(0)
+}
+func (m *MockService) DeleteReview(ctx context.Context, id, uID uint) error {
+	return m.Called(id, uID).Error(0)
+}
+func (m *MockService) RecomputeRatings(ctx context.Context) (int64, error) {
+	args := m.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
+func (m *MockService) VoteReview(ctx context.Context, uID, reviewID uint, helpful bool) (*domain.Review, error) {
+	args := m.Called(uID, reviewID, helpful)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Review), args.Error(1)
+}
+func (m *MockService) UnvoteReview(ctx context.Context, uID, reviewID uint) (*domain.Review, error) {
+	args := m.Called(uID, reviewID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Review), args.Error(1)
+}
+func (m *MockService) ReportReview(ctx context.Context, uID, reviewID uint, reason string) error {
+	return m.Called(uID, reviewID, reason).Error(0)
+}
+func (m *MockService) GetModerationQueue(ctx context.Context, q domain.ListQuery) (domain.Page[domain.Review], error) {
+	args := m.Called(q)
+	return args.Get(0).(domain.Page[domain.Review]), args.Error(1)
+}
+func (m *MockService) ModerateReview(ctx context.Context, moderatorID, reviewID uint, action, reason string) (*domain.Review, error) {
+	args := m.Called(moderatorID, reviewID, action, reason)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Review), args.Error(1)
+}
+func (m *MockService) GetModerationActions(ctx context.Context, reviewID uint) ([]domain.ModerationAction, error) {
+	args := m.Called(reviewID)
+	return args.Get(0).([]domain.ModerationAction), args.Error(1)
+}
+func (m *MockService) GetCommentThread(ctx context.Context, reviewID uint, parentID *uint, depth int) ([]*domain.ReviewComment, error) {
+	args := m.Called(reviewID, parentID, depth)
+	return args.Get(0).([]*domain.ReviewComment), args.Error(1)
 }
-func (m *MockService) DeleteReview(id, uID uint) error { return m.Called(id, uID).Error(0) }
-func (m *MockService) SetShelfStatus(uID, bID uint, status string) error {
+func (m *MockService) AddComment(ctx context.Context, uID, reviewID uint, c *domain.ReviewComment) error {
+	return m.Called(uID, reviewID, c).Error(0)
+}
+func (m *MockService) EditComment(ctx context.Context, uID uint, c *domain.ReviewComment) error {
+	return m.Called(uID, c).Error(0)
+}
+func (m *MockService) DeleteComment(ctx context.Context, uID, id uint) error {
+	return m.Called(uID, id).Error(0)
+}
+func (m *MockService) GetNotifications(ctx context.Context, uID uint, unreadOnly bool, q domain.ListQuery) (domain.Page[domain.Notification], error) {
+	args := m.Called(uID, unreadOnly, q)
+	return args.Get(0).(domain.Page[domain.Notification]), args.Error(1)
+}
+func (m *MockService) MarkNotificationRead(ctx context.Context, uID, id uint) error {
+	return m.Called(uID, id).Error(0)
+}
+func (m *MockService) SetShelfStatus(ctx context.Context, uID, bID uint, status string) error {
 	return m.Called(uID, bID, status).Error(0)
 }
-func (m *MockService) GetShelf(uID uint) ([]domain.Shelf, error) {
+func (m *MockService) GetShelf(ctx context.Context, uID uint) ([]domain.Shelf, error) {
 	args := m.Called(uID)
 	return args.Get(0).([]domain.Shelf), args.Error(1)
 }
-func (m *MockService) RemoveFromShelf(uID, bID uint) error { return m.Called(uID, bID).Error(0) }
+func (m *MockService) RemoveFromShelf(ctx context.Context, uID, bID uint) error {
+	return m.Called(uID, bID).Error(0)
+}
+func (m *MockService) CreateCollection(ctx context.Context, uID uint, c *domain.Collection) error {
+	return m.Called(uID, c).Error(0)
+}
+func (m *MockService) GetCollections(ctx context.Context, uID uint) ([]domain.Collection, error) {
+	args := m.Called(uID)
+	return args.Get(0).([]domain.Collection), args.Error(1)
+}
+func (m *MockService) GetUserCollections(ctx context.Context, uID uint) ([]domain.Collection, error) {
+	args := m.Called(uID)
+	return args.Get(0).([]domain.Collection), args.Error(1)
+}
+func (m *MockService) GetCollection(ctx context.Context, uID, id uint) (*domain.Collection, error) {
+	args := m.Called(uID, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Collection), args.Error(1)
+}
+func (m *MockService) GetSharedCollection(ctx context.Context, slug string) (*domain.Collection, error) {
+	args := m.Called(slug)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Collection), args.Error(1)
+}
+func (m *MockService) UpdateCollection(ctx context.Context, uID uint, c *domain.Collection) error {
+	return m.Called(uID, c).Error(0)
+}
+func (m *MockService) DeleteCollection(ctx context.Context, uID, id uint) error {
+	return m.Called(uID, id).Error(0)
+}
+func (m *MockService) AddBookToCollection(ctx context.Context, uID, id, bookID uint) error {
+	return m.Called(uID, id, bookID).Error(0)
+}
+func (m *MockService) RemoveBookFromCollection(ctx context.Context, uID, id, bookID uint) error {
+	return m.Called(uID, id, bookID).Error(0)
+}
+func (m *MockService) ReorderCollection(ctx context.Context, uID, id uint, bookIDs []uint) error {
+	return m.Called(uID, id, bookIDs).Error(0)
+}
+func (m *MockService) CreateAnnotation(ctx context.Context, uID, bookID uint, a *domain.Annotation) error {
+	return m.Called(uID, bookID, a).Error(0)
+}
+func (m *MockService) GetAnnotations(ctx context.Context, uID uint, f domain.AnnotationFilter) (domain.Page[domain.Annotation], error) {
+	args := m.Called(uID, f)
+	return args.Get(0).(domain.Page[domain.Annotation]), args.Error(1)
+}
+func (m *MockService) GetAnnotation(ctx context.Context, uID, id uint) (*domain.Annotation, error) {
+	args := m.Called(uID, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Annotation), args.Error(1)
+}
+func (m *MockService) UpdateAnnotation(ctx context.Context, uID uint, a *domain.Annotation) error {
+	return m.Called(uID, a).Error(0)
+}
+func (m *MockService) DeleteAnnotation(ctx context.Context, uID, id uint) error {
+	return m.Called(uID, id).Error(0)
+}
+func (m *MockService) ExportAnnotations(ctx context.Context, uID, bookID uint, format string) ([]byte, error) {
+	args := m.Called(uID, bookID, format)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]byte), args.Error(1)
+}
+func (m *MockService) SyncPosition(ctx context.Context, uID, bID uint, u domain.PositionUpdate) (*service.SyncResult, error) {
+	args := m.Called(uID, bID, u)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.SyncResult), args.Error(1)
+}
+func (m *MockService) UpdateProgress(ctx context.Context, uID, bID uint, pos domain.ReadingPosition) (*domain.Shelf, error) {
+	args := m.Called(uID, bID, pos)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Shelf), args.Error(1)
+}
+func (m *MockService) GetChapters(ctx context.Context, bookID uint) ([]domain.Chapter, error) {
+	args := m.Called(bookID)
+	return args.Get(0).([]domain.Chapter), args.Error(1)
+}
+func (m *MockService) GetChapter(ctx context.Context, bookID uint, ordinal int) (*domain.Chapter, error) {
+	args := m.Called(bookID, ordinal)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Chapter), args.Error(1)
+}
+func (m *MockService) BackfillChapters(ctx context.Context) (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
+func (m *MockService) UploadBookFile(ctx context.Context, bookID uint, name string, r io.Reader) (*service.UploadResult, error) {
+	args := m.Called(bookID, name, r)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.UploadResult), args.Error(1)
+}
+func (m *MockService) GetBookFiles(ctx context.Context, bookID uint) ([]domain.BookFile, error) {
+	args := m.Called(bookID)
+	return args.Get(0).([]domain.BookFile), args.Error(1)
+}
+func (m *MockService) OpenBookFile(ctx context.Context, bookID, id uint) (*domain.BookFile, storage.File, error) {
+	args := m.Called(bookID, id)
+	if args.Get(0) == nil {
+		return nil, nil, args.Error(2)
+	}
+	return args.Get(0).(*domain.BookFile), args.Get(1).(storage.File), args.Error(2)
+}
+func (m *MockService) OpenBookCover(ctx context.Context, bookID uint) (*domain.Book, storage.File, error) {
+	args := m.Called(bookID)
+	if args.Get(0) == nil {
+		return nil, nil, args.Error(2)
+	}
+	return args.Get(0).(*domain.Book), args.Get(1).(storage.File), args.Error(2)
+}
+
+// nopFile позволяет отдать bytes.Reader как storage.File.
+type nopFile struct{ *bytes.Reader }
+
+func (nopFile) Close() error { return nil }
+
+func multipartBody(t *testing.T, field, name string, content []byte) (*bytes.Buffer, string) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	fw, err := w.CreateFormFile(field, name)
+	assert.NoError(t, err)
+	fw.Write(content)
+	assert.NoError(t, w.Close())
+	return &buf, w.FormDataContentType()
+}
 
 // --- TESTS ---
 
@@ -122,9 +390,41 @@ func TestHandler_All(t *testing.T) {
 		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
-		ms.On("Login", "e", "p").Return("token", nil).Once()
+		ms.On("Login", "e", "p").Return(&service.TokenPair{AccessToken: "token", RefreshToken: "refresh"}, nil).Once()
 		assert.NoError(t, h.Login(c))
 		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"refresh_token":"refresh"`)
+	})
+
+	t.Run("Auth_Refresh_Success", func(t *testing.T) {
+		body, _ := json.Marshal(RefreshRequest{RefreshToken: "r1"})
+		req := httptest.NewRequest(http.MethodPost, "/token/refresh", bytes.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		ms.On("Refresh", "r1").Return(&service.TokenPair{AccessToken: "a2", RefreshToken: "r2"}, nil).Once()
+		assert.NoError(t, h.Refresh(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Auth_Refresh_Reused", func(t *testing.T) {
+		body, _ := json.Marshal(RefreshRequest{RefreshToken: "r1"})
+		req := httptest.NewRequest(http.MethodPost, "/token/refresh", bytes.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		ms.On("Refresh", "r1").Return(nil, service.ErrRefreshTokenReused).Once()
+		assert.NoError(t, h.Refresh(c))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("Auth_Refresh_Missing", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/token/refresh", strings.NewReader("{}"))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		assert.NoError(t, h.Refresh(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
 	})
 
 	t.Run("Auth_Login_BindErr", func(t *testing.T) {
@@ -134,6 +434,31 @@ func TestHandler_All(t *testing.T) {
 		assert.Error(t, h.Login(c))
 	})
 
+	t.Run("Auth_Logout", func(t *testing.T) {
+		exp := time.Now().Add(time.Minute)
+		body, _ := json.Marshal(LogoutRequest{RefreshToken: "r1"})
+		req := httptest.NewRequest(http.MethodPost, "/logout", bytes.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.Set("user_id", uint(1))
+		c.Set("token_id", "jti")
+		c.Set("token_exp", exp)
+		ms.On("Logout", uint(1), "jti", exp, "r1").Return(nil).Once()
+		assert.NoError(t, h.Logout(c))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	})
+
+	t.Run("Auth_LogoutAll_Err", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/logout/all", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.Set("user_id", uint(1))
+		ms.On("LogoutAll", uint(1)).Return(errors.New("redis down")).Once()
+		assert.NoError(t, h.LogoutAll(c))
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
 	t.Run("Profile_GetMe_NotFound", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/me", nil)
 		rec := httptest.NewRecorder()
@@ -165,6 +490,33 @@ func TestHandler_All(t *testing.T) {
 		assert.Equal(t, http.StatusInternalServerError, rec.Code)
 	})
 
+	t.Run("Profile_Update_EmailTaken", func(t *testing.T) {
+		body, _ := json.Marshal(domain.User{Name: "N", Email: "taken@b.c"})
+		req := httptest.NewRequest(http.MethodPut, "/me", bytes.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.Set("user_id", uint(1))
+		ms.On("UpdateProfile", mock.Anything).Return(service.ErrEmailTaken).Once()
+		assert.NoError(t, h.UpdateProfile(c))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+
+	t.Run("Profile_Update_Invalid", func(t *testing.T) {
+		body, _ := json.Marshal(domain.User{Name: "N"})
+		req := httptest.NewRequest(http.MethodPut, "/me", bytes.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		c := e.NewContext(req, httptest.NewRecorder())
+		c.Set("user_id", uint(1))
+		ms.On("UpdateProfile", mock.Anything).Return(&service.PatchError{Field: "email", Reason: "must not be empty"}).Once()
+		err := h.UpdateProfile(c)
+		var he *echo.HTTPError
+		if assert.ErrorAs(t, err, &he) {
+			assert.Equal(t, http.StatusBadRequest, he.Code)
+			assert.Equal(t, "email: must not be empty", he.Message)
+		}
+	})
+
 	t.Run("Profile_Update_BindErr", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPut, "/me", strings.NewReader("?"))
 		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
@@ -172,15 +524,95 @@ func TestHandler_All(t *testing.T) {
 		assert.Error(t, h.UpdateProfile(c))
 	})
 
+	t.Run("Admin_SetRole", func(t *testing.T) {
+		body, _ := json.Marshal(RoleRequest{Role: "editor"})
+		req := httptest.NewRequest(http.MethodPut, "/admin/users/2/role", bytes.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("2")
+		c.Set("user_id", uint(1))
+		ms.On("SetUserRole", uint(1), uint(2), "editor").Return(nil).Once()
+		assert.NoError(t, h.SetUserRole(c))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	})
+
+	t.Run("Admin_SetRole_Invalid", func(t *testing.T) {
+		body, _ := json.Marshal(RoleRequest{Role: "root"})
+		req := httptest.NewRequest(http.MethodPut, "/admin/users/2/role", bytes.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("2")
+		c.Set("user_id", uint(1))
+		ms.On("SetUserRole", uint(1), uint(2), "root").Return(service.ErrInvalidRole).Once()
+		assert.NoError(t, h.SetUserRole(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Admin_SetRole_NotFound", func(t *testing.T) {
+		body, _ := json.Marshal(RoleRequest{Role: "admin"})
+		req := httptest.NewRequest(http.MethodPut, "/admin/users/9/role", bytes.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("9")
+		c.Set("user_id", uint(1))
+		ms.On("SetUserRole", uint(1), uint(9), "admin").Return(gorm.ErrRecordNotFound).Once()
+		assert.NoError(t, h.SetUserRole(c))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
 	t.Run("Books_List_Err", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/books", nil)
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
-		ms.On("GetAllBooks").Return([]domain.Book{}, errors.New("err")).Once()
+		ms.On("GetAllBooks", domain.BookFilter{}).Return(domain.Page[domain.Book]{}, errors.New("err")).Once()
 		assert.NoError(t, h.ListBooks(c))
 		assert.Equal(t, http.StatusInternalServerError, rec.Code)
 	})
 
+	t.Run("Books_List_Filters", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/books?limit=5&cursor=abc&sort=-rating&author_id=3&min_rating=4.5&title_prefix=War", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		want := domain.BookFilter{
+			ListQuery:   domain.ListQuery{Limit: 5, Cursor: "abc", Sort: "-rating"},
+			AuthorID:    3,
+			MinRating:   4.5,
+			TitlePrefix: "War",
+		}
+		page := domain.Page[domain.Book]{Items: []domain.Book{{ID: 1}}, Total: 7, NextCursor: "next"}
+		ms.On("GetAllBooks", want).Return(page, nil).Once()
+		assert.NoError(t, h.ListBooks(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"total":7`)
+		assert.Contains(t, rec.Body.String(), `"next_cursor":"next"`)
+	})
+
+	t.Run("Books_List_BadParams", func(t *testing.T) {
+		for _, query := range []string{"limit=0", "limit=x", "author_id=-1", "min_rating=bad"} {
+			req := httptest.NewRequest(http.MethodGet, "/books?"+query, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			assert.NoError(t, h.ListBooks(c))
+			assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+		}
+	})
+
+	t.Run("Books_List_InvalidSort", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/books?sort=price", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		f := domain.BookFilter{ListQuery: domain.ListQuery{Sort: "price"}}
+		ms.On("GetAllBooks", f).Return(domain.Page[domain.Book]{}, domain.ErrInvalidSort).Once()
+		assert.NoError(t, h.ListBooks(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
 	t.Run("Books_Create_SvcErr", func(t *testing.T) {
 		body, _ := json.Marshal(domain.Book{Title: "T"})
 		req := httptest.NewRequest(http.MethodPost, "/books", bytes.NewReader(body))
@@ -214,6 +646,7 @@ func TestHandler_All(t *testing.T) {
 		body, _ := json.Marshal(domain.Book{Title: "U"})
 		req := httptest.NewRequest(http.MethodPut, "/books/1", bytes.NewReader(body))
 		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("If-Match", `"2"`)
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
 		c.SetParamNames("id")
@@ -232,15 +665,42 @@ func TestHandler_All(t *testing.T) {
 
 	t.Run("Books_Delete_SvcErr", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodDelete, "/books/1", nil)
+		req.Header.Set("If-Match", "*")
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
 		c.SetParamNames("id")
 		c.SetParamValues("1")
-		ms.On("DeleteBook", uint(1)).Return(errors.New("err")).Once()
+		ms.On("DeleteBook", uint(1), domain.DeleteOptions{}).Return(errors.New("err")).Once()
 		assert.NoError(t, h.DeleteBook(c))
 		assert.Equal(t, http.StatusInternalServerError, rec.Code)
 	})
 
+	t.Run("Books_Delete_Restricted", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/books/1?policy=restrict", nil)
+		req.Header.Set("If-Match", `"4"`)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		depsErr := &service.DependentsError{Dependents: domain.Dependents{ShelfEntries: 3}}
+		ms.On("DeleteBook", uint(1), domain.DeleteOptions{Policy: "restrict", Version: 4}).Return(depsErr).Once()
+		assert.NoError(t, h.DeleteBook(c))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"dependents":{"shelf_entries":3}`)
+	})
+
+	t.Run("Books_Delete_Cascade", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/books/1?policy=cascade", nil)
+		req.Header.Set("If-Match", `"4"`)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		ms.On("DeleteBook", uint(1), domain.DeleteOptions{Policy: "cascade", Version: 4}).Return(nil).Once()
+		assert.NoError(t, h.DeleteBook(c))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	})
+
 	t.Run("Books_GetContent_NotFound", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/books/1/content", nil)
 		rec := httptest.NewRecorder()
@@ -252,58 +712,296 @@ func TestHandler_All(t *testing.T) {
 		assert.Equal(t, http.StatusNotFound, rec.Code)
 	})
 
-	t.Run("Authors_List_Err", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/authors", nil)
+	t.Run("Chapters_List", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/books/1/chapters", nil)
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
-		ms.On("GetAllAuthors").Return([]domain.Author{}, errors.New("err")).Once()
-		assert.NoError(t, h.ListAuthors(c))
-		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		ms.On("GetChapters", uint(1)).Return([]domain.Chapter{{BookID: 1, Ordinal: 1, Title: "Начало", WordCount: 3}}, nil).Once()
+		assert.NoError(t, h.ListChapters(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"title":"Начало"`)
+		assert.NotContains(t, rec.Body.String(), `"body"`)
 	})
 
-	t.Run("Authors_Create_SvcErr", func(t *testing.T) {
-		body, _ := json.Marshal(domain.Author{Name: "A"})
-		req := httptest.NewRequest(http.MethodPost, "/authors", bytes.NewReader(body))
-		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	t.Run("Chapters_Get", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/books/1/chapters/2", nil)
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
-		ms.On("CreateAuthor", mock.Anything).Return(errors.New("err")).Once()
-		assert.NoError(t, h.CreateAuthor(c))
-		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		c.SetParamNames("id", "n")
+		c.SetParamValues("1", "2")
+		ms.On("GetChapter", uint(1), 2).Return(&domain.Chapter{Ordinal: 2, Body: "text"}, nil).Once()
+		assert.NoError(t, h.GetChapter(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"body":"text"`)
 	})
 
-	t.Run("Authors_Create_BindErr", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodPost, "/authors", strings.NewReader("?"))
-		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
-		c := e.NewContext(req, httptest.NewRecorder())
-		assert.Error(t, h.CreateAuthor(c))
+	t.Run("Chapters_Get_NotFound", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/books/1/chapters/99", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id", "n")
+		c.SetParamValues("1", "99")
+		ms.On("GetChapter", uint(1), 99).Return(nil, gorm.ErrRecordNotFound).Once()
+		assert.NoError(t, h.GetChapter(c))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
 	})
 
-	t.Run("Authors_Get_NotFound", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/authors/1", nil)
+	t.Run("Chapters_Get_BadNumber", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/books/1/chapters/0", nil)
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
-		c.SetParamNames("id")
-		c.SetParamValues("1")
-		ms.On("GetAuthor", uint(1)).Return(nil, errors.New("err")).Once()
-		assert.NoError(t, h.GetAuthor(c))
-		assert.Equal(t, http.StatusNotFound, rec.Code)
+		c.SetParamNames("id", "n")
+		c.SetParamValues("1", "0")
+		assert.NoError(t, h.GetChapter(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
 	})
 
-	t.Run("Authors_Update_SvcErr", func(t *testing.T) {
-		body, _ := json.Marshal(domain.Author{Name: "U"})
-		req := httptest.NewRequest(http.MethodPut, "/authors/1", bytes.NewReader(body))
-		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	t.Run("Files_Upload", func(t *testing.T) {
+		body, ct := multipartBody(t, "file", "war.pdf", []byte("%PDF-1.4"))
+		req := httptest.NewRequest(http.MethodPost, "/books/1/files", body)
+		req.Header.Set(echo.HeaderContentType, ct)
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
 		c.SetParamNames("id")
 		c.SetParamValues("1")
-		ms.On("UpdateAuthor", mock.Anything).Return(errors.New("err")).Once()
-		assert.NoError(t, h.UpdateAuthor(c))
-		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		ms.On("UploadBookFile", uint(1), "war.pdf", mock.Anything).
+			Return(&service.UploadResult{File: &domain.BookFile{ID: 3, BookID: 1, Format: domain.FormatPDF}}, nil).Once()
+		assert.NoError(t, h.UploadBookFile(c))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"format":"pdf"`)
+		assert.NotContains(t, rec.Body.String(), `"metadata"`)
 	})
 
-	t.Run("Authors_Update_BindErr", func(t *testing.T) {
+	t.Run("Files_Upload_EPUBMetadata", func(t *testing.T) {
+		body, ct := multipartBody(t, "file", "war.epub", []byte("PK"))
+		req := httptest.NewRequest(http.MethodPost, "/books/1/files", body)
+		req.Header.Set(echo.HeaderContentType, ct)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		res := &service.UploadResult{
+			File: &domain.BookFile{ID: 4, Format: domain.FormatEPUB},
+			Metadata: &service.MetadataReport{
+				Applied:   []string{"isbn"},
+				Conflicts: []service.MetadataConflict{{Field: "title", Current: "Война", Suggested: "Война и мир"}},
+			},
+		}
+		ms.On("UploadBookFile", uint(1), "war.epub", mock.Anything).Return(res, nil).Once()
+		assert.NoError(t, h.UploadBookFile(c))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"applied":["isbn"]`)
+		assert.Contains(t, rec.Body.String(), `"suggested":"Война и мир"`)
+	})
+
+	t.Run("Files_Upload_InvalidEPUB", func(t *testing.T) {
+		body, ct := multipartBody(t, "file", "broken.epub", []byte("PK"))
+		req := httptest.NewRequest(http.MethodPost, "/books/1/files", body)
+		req.Header.Set(echo.HeaderContentType, ct)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		ms.On("UploadBookFile", uint(1), "broken.epub", mock.Anything).Return(nil, service.ErrInvalidEPUB).Once()
+		assert.NoError(t, h.UploadBookFile(c))
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	})
+
+	t.Run("Files_Upload_BookChanged", func(t *testing.T) {
+		body, ct := multipartBody(t, "file", "war.epub", []byte("PK"))
+		req := httptest.NewRequest(http.MethodPost, "/books/1/files", body)
+		req.Header.Set(echo.HeaderContentType, ct)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		ms.On("UploadBookFile", uint(1), "war.epub", mock.Anything).Return(nil, domain.ErrVersionMismatch).Once()
+		assert.NoError(t, h.UploadBookFile(c))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+
+	t.Run("Files_Upload_Unsupported", func(t *testing.T) {
+		body, ct := multipartBody(t, "file", "notes.txt", []byte("hello"))
+		req := httptest.NewRequest(http.MethodPost, "/books/1/files", body)
+		req.Header.Set(echo.HeaderContentType, ct)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		ms.On("UploadBookFile", uint(1), "notes.txt", mock.Anything).Return(nil, service.ErrUnsupportedFormat).Once()
+		assert.NoError(t, h.UploadBookFile(c))
+		assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+	})
+
+	t.Run("Files_Upload_NoFile", func(t *testing.T) {
+		body, ct := multipartBody(t, "other", "x.pdf", []byte("%PDF-"))
+		req := httptest.NewRequest(http.MethodPost, "/books/1/files", body)
+		req.Header.Set(echo.HeaderContentType, ct)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		assert.NoError(t, h.UploadBookFile(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Files_List", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/books/1/files", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		ms.On("GetBookFiles", uint(1)).Return([]domain.BookFile{{ID: 3}}, nil).Once()
+		assert.NoError(t, h.ListBookFiles(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Files_Download_Range", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/books/1/files/3", nil)
+		req.Header.Set("Range", "bytes=2-4")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id", "file_id")
+		c.SetParamValues("1", "3")
+		f := &domain.BookFile{ID: 3, BookID: 1, Format: domain.FormatEPUB, FileName: "war.epub", Checksum: "abc"}
+		ms.On("OpenBookFile", uint(1), uint(3)).Return(f, nopFile{bytes.NewReader([]byte("0123456789"))}, nil).Once()
+		assert.NoError(t, h.DownloadBookFile(c))
+		assert.Equal(t, http.StatusPartialContent, rec.Code)
+		assert.Equal(t, "234", rec.Body.String())
+		assert.Equal(t, "application/epub+zip", rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, `"abc"`, rec.Header().Get("ETag"))
+		assert.Contains(t, rec.Header().Get(echo.HeaderContentDisposition), `filename=war.epub`)
+	})
+
+	t.Run("Files_Download_NotFound", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/books/1/files/9", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id", "file_id")
+		c.SetParamValues("1", "9")
+		ms.On("OpenBookFile", uint(1), uint(9)).Return(nil, nil, gorm.ErrRecordNotFound).Once()
+		assert.NoError(t, h.DownloadBookFile(c))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Files_Cover", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/books/1/cover", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		book := &domain.Book{ID: 1, CoverKey: "books/1/cover.png"}
+		ms.On("OpenBookCover", uint(1)).Return(book, nopFile{bytes.NewReader([]byte("PNG"))}, nil).Once()
+		assert.NoError(t, h.GetBookCover(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "image/png", rec.Header().Get(echo.HeaderContentType))
+	})
+
+	t.Run("Files_Cover_NotFound", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/books/2/cover", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("2")
+		ms.On("OpenBookCover", uint(2)).Return(nil, nil, gorm.ErrRecordNotFound).Once()
+		assert.NoError(t, h.GetBookCover(c))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Books_Search", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/search?q=%D0%BC%D0%B8%D1%80&lang=ru&limit=10", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		q := domain.SearchQuery{ListQuery: domain.ListQuery{Limit: 10}, Text: "мир", Lang: "ru"}
+		hits := domain.Page[domain.SearchHit]{Items: []domain.SearchHit{{BookID: 1, Snippet: "<mark>мир</mark>"}}, Total: 1}
+		ms.On("SearchBooks", q).Return(hits, nil).Once()
+		assert.NoError(t, h.SearchBooks(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "snippet")
+	})
+
+	t.Run("Books_Search_Empty", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/search", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		ms.On("SearchBooks", domain.SearchQuery{}).Return(domain.Page[domain.SearchHit]{}, service.ErrEmptySearchQuery).Once()
+		assert.NoError(t, h.SearchBooks(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Books_Autocomplete", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/autocomplete?q=tolst&limit=3", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		ms.On("Autocomplete", "tolst", 3).Return(domain.Autocomplete{Authors: []domain.Suggestion{{ID: 1, Label: "Лев Толстой"}}}, nil).Once()
+		assert.NoError(t, h.Autocomplete(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Лев Толстой")
+	})
+
+	t.Run("Books_Autocomplete_BadLimit", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/autocomplete?q=tolst&limit=-1", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		assert.NoError(t, h.Autocomplete(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Authors_List_Err", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/authors", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		ms.On("GetAllAuthors", domain.AuthorFilter{}).Return(domain.Page[domain.Author]{}, errors.New("err")).Once()
+		assert.NoError(t, h.ListAuthors(c))
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("Authors_Create_SvcErr", func(t *testing.T) {
+		body, _ := json.Marshal(domain.Author{Name: "A"})
+		req := httptest.NewRequest(http.MethodPost, "/authors", bytes.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		ms.On("CreateAuthor", mock.Anything).Return(errors.New("err")).Once()
+		assert.NoError(t, h.CreateAuthor(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Authors_Create_BindErr", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/authors", strings.NewReader("?"))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		c := e.NewContext(req, httptest.NewRecorder())
+		assert.Error(t, h.CreateAuthor(c))
+	})
+
+	t.Run("Authors_Get_NotFound", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/authors/1", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		ms.On("GetAuthor", uint(1)).Return(nil, errors.New("err")).Once()
+		assert.NoError(t, h.GetAuthor(c))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Authors_Update_SvcErr", func(t *testing.T) {
+		body, _ := json.Marshal(domain.Author{Name: "U"})
+		req := httptest.NewRequest(http.MethodPut, "/authors/1", bytes.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("If-Match", `"2"`)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		ms.On("UpdateAuthor", mock.Anything).Return(errors.New("err")).Once()
+		assert.NoError(t, h.UpdateAuthor(c))
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("Authors_Update_BindErr", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPut, "/authors/1", strings.NewReader("?"))
 		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		c := e.NewContext(req, httptest.NewRecorder())
@@ -312,22 +1010,58 @@ func TestHandler_All(t *testing.T) {
 
 	t.Run("Authors_Delete_SvcErr", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodDelete, "/authors/1", nil)
+		req.Header.Set("If-Match", "*")
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
 		c.SetParamNames("id")
 		c.SetParamValues("1")
-		ms.On("DeleteAuthor", uint(1)).Return(errors.New("err")).Once()
+		ms.On("DeleteAuthor", uint(1), domain.DeleteOptions{}).Return(errors.New("err")).Once()
 		assert.NoError(t, h.DeleteAuthor(c))
 		assert.Equal(t, http.StatusInternalServerError, rec.Code)
 	})
 
+	t.Run("Authors_Delete_Policies", func(t *testing.T) {
+		cases := []struct {
+			query string
+			opts  domain.DeleteOptions
+			err   error
+			code  int
+		}{
+			{"?policy=reassign&reassign_to=2", domain.DeleteOptions{Policy: "reassign", ReassignTo: 2}, nil, http.StatusNoContent},
+			{"?policy=reassign&reassign_to=1", domain.DeleteOptions{Policy: "reassign", ReassignTo: 1}, service.ErrInvalidReassign, http.StatusBadRequest},
+			{"?policy=purge", domain.DeleteOptions{Policy: "purge"}, service.ErrInvalidDeletePolicy, http.StatusBadRequest},
+			{"", domain.DeleteOptions{}, &service.DependentsError{Dependents: domain.Dependents{Books: []domain.BookRef{{ID: 3}}}}, http.StatusConflict},
+			{"?policy=cascade", domain.DeleteOptions{Policy: "cascade"}, gorm.ErrRecordNotFound, http.StatusNotFound},
+		}
+		for _, tc := range cases {
+			req := httptest.NewRequest(http.MethodDelete, "/authors/1"+tc.query, nil)
+			req.Header.Set("If-Match", "*")
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues("1")
+			ms.On("DeleteAuthor", uint(1), tc.opts).Return(tc.err).Once()
+			assert.NoError(t, h.DeleteAuthor(c))
+			assert.Equal(t, tc.code, rec.Code, tc.query)
+		}
+
+		req := httptest.NewRequest(http.MethodDelete, "/authors/1?policy=reassign&reassign_to=x", nil)
+		req.Header.Set("If-Match", "*")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		assert.NoError(t, h.DeleteAuthor(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
 	t.Run("Authors_GetBooks_Err", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/authors/1/books", nil)
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
 		c.SetParamNames("id")
 		c.SetParamValues("1")
-		ms.On("GetBooksByAuthor", uint(1)).Return([]domain.Book{}, errors.New("err")).Once()
+		ms.On("GetBooksByAuthor", uint(1), domain.BookFilter{}).Return(domain.Page[domain.Book]{}, errors.New("err")).Once()
 		assert.NoError(t, h.GetAuthorBooks(c))
 		assert.Equal(t, http.StatusInternalServerError, rec.Code)
 	})
@@ -338,11 +1072,22 @@ func TestHandler_All(t *testing.T) {
 		c := e.NewContext(req, rec)
 		c.SetParamNames("id")
 		c.SetParamValues("1")
-		ms.On("GetReviews", uint(1)).Return([]domain.Review{}, errors.New("err")).Once()
+		ms.On("GetReviews", uint(1), domain.ListQuery{}).Return(domain.Page[domain.Review]{}, errors.New("err")).Once()
 		assert.NoError(t, h.ListReviews(c))
 		assert.Equal(t, http.StatusInternalServerError, rec.Code)
 	})
 
+	t.Run("Reviews_List_InvalidCursor", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/books/1/reviews?cursor=zzz", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		ms.On("GetReviews", uint(1), domain.ListQuery{Cursor: "zzz"}).Return(domain.Page[domain.Review]{}, domain.ErrInvalidCursor).Once()
+		assert.NoError(t, h.ListReviews(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
 	t.Run("Reviews_Add_SvcErr", func(t *testing.T) {
 		body, _ := json.Marshal(domain.Review{Comment: "C"})
 		req := httptest.NewRequest(http.MethodPost, "/reviews/1", bytes.NewReader(body))
@@ -357,6 +1102,75 @@ func TestHandler_All(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, rec.Code)
 	})
 
+	t.Run("Reviews_Add_Duplicate", func(t *testing.T) {
+		body, _ := json.Marshal(domain.Review{Rating: 5})
+		req := httptest.NewRequest(http.MethodPost, "/books/1/reviews", bytes.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		c.Set("user_id", uint(1))
+		ms.On("AddReview", mock.Anything).Return(service.ErrReviewExists).Once()
+		assert.NoError(t, h.AddReview(c))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+
+	t.Run("Reviews_Update", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/reviews/7", strings.NewReader(`{"rating":4,"comment":"Better"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("7")
+		c.Set("user_id", uint(1))
+		ms.On("UpdateReview", uint(1), &domain.Review{ID: 7, Rating: 4, Comment: "Better"}).
+			Run(func(args mock.Arguments) { args.Get(1).(*domain.Review).Edited = true }).
+			Return(nil).Once()
+		assert.NoError(t, h.UpdateReview(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"edited":true`)
+	})
+
+	t.Run("Reviews_Update_NotFound", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/reviews/7", strings.NewReader(`{"rating":4}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("7")
+		c.Set("user_id", uint(2))
+		ms.On("UpdateReview", uint(2), mock.Anything).Return(gorm.ErrRecordNotFound).Once()
+		assert.NoError(t, h.UpdateReview(c))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Reviews_Vote", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/reviews/7/vote", strings.NewReader(`{"helpful":true}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("7")
+		c.Set("user_id", uint(2))
+		ms.On("VoteReview", uint(2), uint(7), true).Return(&domain.Review{ID: 7, HelpfulCount: 3}, nil).Once()
+		assert.NoError(t, h.VoteReview(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"helpful_count":3`)
+	})
+
+	t.Run("Reviews_Unvote_Own", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/reviews/7/vote", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("7")
+		c.Set("user_id", uint(1))
+		ms.On("UnvoteReview", uint(1), uint(7)).Return(nil, service.ErrOwnReviewVote).Once()
+		assert.NoError(t, h.UnvoteReview(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
 	t.Run("Reviews_Add_BindErr", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPost, "/reviews/1", strings.NewReader("?"))
 		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
@@ -407,6 +1221,22 @@ func TestHandler_All(t *testing.T) {
 		assert.Equal(t, http.StatusInternalServerError, rec.Code)
 	})
 
+	t.Run("Shelf_Add_InvalidStatus", func(t *testing.T) {
+		body, _ := json.Marshal(ShelfStatusRequest{Status: "readng"})
+		req := httptest.NewRequest(http.MethodPost, "/shelf/1", bytes.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		c.Set("user_id", uint(1))
+		err := &service.StatusError{Err: service.ErrInvalidShelfStatus, Allowed: domain.ShelfStatuses}
+		ms.On("SetShelfStatus", uint(1), uint(1), "readng").Return(err).Once()
+		assert.NoError(t, h.AddToShelf(c))
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"allowed":["want_to_read","reading","paused","completed","abandoned"]`)
+	})
+
 	t.Run("Shelf_Remove_SvcErr", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodDelete, "/shelf/1", nil)
 		rec := httptest.NewRecorder()
@@ -418,4 +1248,573 @@ func TestHandler_All(t *testing.T) {
 		assert.NoError(t, h.RemoveFromShelf(c))
 		assert.Equal(t, http.StatusInternalServerError, rec.Code)
 	})
+
+	t.Run("Shelf_Progress", func(t *testing.T) {
+		body, _ := json.Marshal(domain.ReadingPosition{Chapter: 4, Offset: 120, Percent: 100})
+		req := httptest.NewRequest(http.MethodPut, "/shelf/1/progress", bytes.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		c.Set("user_id", uint(1))
+		pos := domain.ReadingPosition{Chapter: 4, Offset: 120, Percent: 100}
+		ms.On("UpdateProgress", uint(1), uint(1), pos).
+			Return(&domain.Shelf{UserID: 1, BookID: 1, Status: domain.ShelfStatusCompleted, Progress: pos}, nil).Once()
+		assert.NoError(t, h.UpdateProgress(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"status":"completed"`)
+		assert.Contains(t, rec.Body.String(), `"progress":{"chapter":4,"offset":120,"percent":100}`)
+	})
+
+	t.Run("Shelf_Sync", func(t *testing.T) {
+		body := `{"device_id":"kindle","position":{"chapter":2,"offset":10,"percent":20},"client_time":"2026-06-01T10:00:00Z"}`
+		req := httptest.NewRequest(http.MethodPost, "/shelf/1/sync", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		c.Set("user_id", uint(1))
+		u := domain.PositionUpdate{
+			DeviceID:   "kindle",
+			Position:   domain.ReadingPosition{Chapter: 2, Offset: 10, Percent: 20},
+			ClientTime: time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC),
+		}
+		res := &service.SyncResult{Applied: false, Status: "reading", Position: domain.ReadingPosition{Chapter: 5}}
+		ms.On("SyncPosition", uint(1), uint(1), u).Return(res, nil).Once()
+		assert.NoError(t, h.SyncPosition(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"applied":false`)
+	})
+
+	t.Run("Shelf_Sync_NoDevice", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/shelf/1/sync", strings.NewReader(`{}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		c.Set("user_id", uint(1))
+		ms.On("SyncPosition", uint(1), uint(1), domain.PositionUpdate{}).Return(nil, service.ErrInvalidDevice).Once()
+		assert.NoError(t, h.SyncPosition(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Shelf_Progress_Invalid", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/shelf/1/progress", strings.NewReader(`{"percent":150}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		c.Set("user_id", uint(1))
+		ms.On("UpdateProgress", uint(1), uint(1), domain.ReadingPosition{Percent: 150}).Return(nil, service.ErrInvalidProgress).Once()
+		assert.NoError(t, h.UpdateProgress(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestHandler_Collections(t *testing.T) {
+	e := echo.New()
+	ms := new(MockService)
+	h := NewHandler(ms)
+
+	newCtx := func(method, target, body string, params ...string) (echo.Context, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		var names, values []string
+		for i := 0; i+1 < len(params); i += 2 {
+			names, values = append(names, params[i]), append(values, params[i+1])
+		}
+		c.SetParamNames(names...)
+		c.SetParamValues(values...)
+		c.Set("user_id", uint(1))
+		return c, rec
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		c, rec := newCtx(http.MethodPost, "/collections", `{"name":"Summer 2026","visibility":"public"}`)
+		ms.On("CreateCollection", uint(1), mock.AnythingOfType("*domain.Collection")).
+			Run(func(args mock.Arguments) { args.Get(1).(*domain.Collection).Slug = "s1" }).
+			Return(nil).Once()
+		assert.NoError(t, h.CreateCollection(c))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"slug":"s1"`)
+	})
+
+	t.Run("Create_Invalid", func(t *testing.T) {
+		c, rec := newCtx(http.MethodPost, "/collections", `{"name":"A","visibility":"friends"}`)
+		ms.On("CreateCollection", uint(1), mock.Anything).Return(service.ErrInvalidVisibility).Once()
+		assert.NoError(t, h.CreateCollection(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Get_NotFound", func(t *testing.T) {
+		c, rec := newCtx(http.MethodGet, "/collections/5", "", "id", "5")
+		ms.On("GetCollection", uint(1), uint(5)).Return(nil, gorm.ErrRecordNotFound).Once()
+		assert.NoError(t, h.GetCollection(c))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Shared", func(t *testing.T) {
+		c, rec := newCtx(http.MethodGet, "/shared/collections/abc", "", "slug", "abc")
+		ms.On("GetSharedCollection", "abc").Return(&domain.Collection{ID: 5, Name: "Shared"}, nil).Once()
+		assert.NoError(t, h.GetSharedCollection(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		c, rec := newCtx(http.MethodPut, "/collections/5", `{"name":"Renamed"}`, "id", "5")
+		ms.On("UpdateCollection", uint(1), &domain.Collection{ID: 5, Name: "Renamed"}).Return(nil).Once()
+		assert.NoError(t, h.UpdateCollection(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		c, rec := newCtx(http.MethodDelete, "/collections/5", "", "id", "5")
+		ms.On("DeleteCollection", uint(1), uint(5)).Return(nil).Once()
+		assert.NoError(t, h.DeleteCollection(c))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	})
+
+	t.Run("AddBook_Duplicate", func(t *testing.T) {
+		c, rec := newCtx(http.MethodPost, "/collections/5/books", `{"book_id":10}`, "id", "5")
+		ms.On("AddBookToCollection", uint(1), uint(5), uint(10)).Return(service.ErrBookInCollection).Once()
+		assert.NoError(t, h.AddCollectionBook(c))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+
+	t.Run("RemoveBook", func(t *testing.T) {
+		c, rec := newCtx(http.MethodDelete, "/collections/5/books/10", "", "id", "5", "book_id", "10")
+		ms.On("RemoveBookFromCollection", uint(1), uint(5), uint(10)).Return(nil).Once()
+		assert.NoError(t, h.RemoveCollectionBook(c))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	})
+
+	t.Run("Reorder_Invalid", func(t *testing.T) {
+		c, rec := newCtx(http.MethodPut, "/collections/5/order", `{"book_ids":[11]}`, "id", "5")
+		ms.On("ReorderCollection", uint(1), uint(5), []uint{11}).Return(service.ErrInvalidOrder).Once()
+		assert.NoError(t, h.ReorderCollection(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("ListUser", func(t *testing.T) {
+		c, rec := newCtx(http.MethodGet, "/users/2/collections", "", "id", "2")
+		ms.On("GetUserCollections", uint(2)).Return([]domain.Collection{{ID: 7}}, nil).Once()
+		assert.NoError(t, h.ListUserCollections(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+	ms.AssertExpectations(t)
+}
+
+func TestHandler_Annotations(t *testing.T) {
+	e := echo.New()
+	ms := new(MockService)
+	h := NewHandler(ms)
+
+	newCtx := func(method, target, body string, params ...string) (echo.Context, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		var names, values []string
+		for i := 0; i+1 < len(params); i += 2 {
+			names, values = append(names, params[i]), append(values, params[i+1])
+		}
+		c.SetParamNames(names...)
+		c.SetParamValues(values...)
+		c.Set("user_id", uint(1))
+		return c, rec
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		c, rec := newCtx(http.MethodPost, "/books/10/annotations",
+			`{"kind":"highlight","start":{"chapter":1,"offset":5},"end":{"chapter":1,"offset":20},"selected_text":"текст"}`, "id", "10")
+		ms.On("CreateAnnotation", uint(1), uint(10), mock.MatchedBy(func(a *domain.Annotation) bool {
+			return a.Kind == domain.AnnotationHighlight && a.End.Offset == 20
+		})).Run(func(args mock.Arguments) { args.Get(2).(*domain.Annotation).ID = 3 }).Return(nil).Once()
+		assert.NoError(t, h.CreateAnnotation(c))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"id":3`)
+	})
+
+	t.Run("Create_Invalid", func(t *testing.T) {
+		c, rec := newCtx(http.MethodPost, "/books/10/annotations", `{"kind":"underline"}`, "id", "10")
+		ms.On("CreateAnnotation", uint(1), uint(10), mock.Anything).Return(service.ErrInvalidAnnotationKind).Once()
+		assert.NoError(t, h.CreateAnnotation(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("ListBook", func(t *testing.T) {
+		c, rec := newCtx(http.MethodGet, "/books/10/annotations?kind=note", "", "id", "10")
+		ms.On("GetAnnotations", uint(1), domain.AnnotationFilter{BookID: 10, Kind: "note"}).
+			Return(domain.Page[domain.Annotation]{Items: []domain.Annotation{{ID: 3}}, Total: 1}, nil).Once()
+		assert.NoError(t, h.ListBookAnnotations(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		c, rec := newCtx(http.MethodGet, "/annotations?q=семьи&book_id=10&sort=-created", "")
+		ms.On("GetAnnotations", uint(1), domain.AnnotationFilter{ListQuery: domain.ListQuery{Sort: "-created"}, BookID: 10, Query: "семьи"}).
+			Return(domain.Page[domain.Annotation]{Items: []domain.Annotation{}}, nil).Once()
+		assert.NoError(t, h.ListAnnotations(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		c, rec = newCtx(http.MethodGet, "/annotations?book_id=x", "")
+		assert.NoError(t, h.ListAnnotations(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Get_NotFound", func(t *testing.T) {
+		c, rec := newCtx(http.MethodGet, "/annotations/3", "", "id", "3")
+		ms.On("GetAnnotation", uint(1), uint(3)).Return(nil, gorm.ErrRecordNotFound).Once()
+		assert.NoError(t, h.GetAnnotation(c))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		c, rec := newCtx(http.MethodPut, "/annotations/3", `{"kind":"bookmark","color":"blue"}`, "id", "3")
+		ms.On("UpdateAnnotation", uint(1), &domain.Annotation{ID: 3, Kind: "bookmark", Color: "blue"}).Return(nil).Once()
+		assert.NoError(t, h.UpdateAnnotation(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		c, rec := newCtx(http.MethodDelete, "/annotations/3", "", "id", "3")
+		ms.On("DeleteAnnotation", uint(1), uint(3)).Return(nil).Once()
+		assert.NoError(t, h.DeleteAnnotation(c))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		c, rec := newCtx(http.MethodGet, "/annotations/export?book_id=10", "")
+		ms.On("ExportAnnotations", uint(1), uint(10), "md").Return([]byte("# Annotations\n"), nil).Once()
+		assert.NoError(t, h.ExportAnnotations(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "text/markdown; charset=utf-8", rec.Header().Get(echo.HeaderContentType))
+		assert.Contains(t, rec.Header().Get(echo.HeaderContentDisposition), `filename=annotations-book-10.md`)
+		assert.Equal(t, "# Annotations\n", rec.Body.String())
+
+		c, rec = newCtx(http.MethodGet, "/annotations/export?format=pdf", "")
+		ms.On("ExportAnnotations", uint(1), uint(0), "pdf").Return(nil, service.ErrInvalidExportFormat).Once()
+		assert.NoError(t, h.ExportAnnotations(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+	ms.AssertExpectations(t)
+}
+
+func TestHandler_Moderation(t *testing.T) {
+	e := echo.New()
+	ms := new(MockService)
+	h := NewHandler(ms)
+
+	newCtx := func(method, target, body string, params ...string) (echo.Context, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		var names, values []string
+		for i := 0; i+1 < len(params); i += 2 {
+			names, values = append(names, params[i]), append(values, params[i+1])
+		}
+		c.SetParamNames(names...)
+		c.SetParamValues(values...)
+		c.Set("user_id", uint(1))
+		return c, rec
+	}
+
+	t.Run("Report", func(t *testing.T) {
+		c, rec := newCtx(http.MethodPost, "/reviews/7/report", `{"reason":"Spam"}`, "id", "7")
+		ms.On("ReportReview", uint(1), uint(7), "Spam").Return(nil).Once()
+		assert.NoError(t, h.ReportReview(c))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		c, rec = newCtx(http.MethodPost, "/reviews/7/report", `{"reason":"Spam"}`, "id", "7")
+		ms.On("ReportReview", uint(1), uint(7), "Spam").Return(service.ErrAlreadyReported).Once()
+		assert.NoError(t, h.ReportReview(c))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+
+		c, rec = newCtx(http.MethodPost, "/reviews/7/report", `{}`, "id", "7")
+		ms.On("ReportReview", uint(1), uint(7), "").Return(service.ErrInvalidReportReason).Once()
+		assert.NoError(t, h.ReportReview(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Queue", func(t *testing.T) {
+		c, rec := newCtx(http.MethodGet, "/moderation/reviews?limit=5", "")
+		ms.On("GetModerationQueue", domain.ListQuery{Limit: 5}).
+			Return(domain.Page[domain.Review]{Items: []domain.Review{{ID: 7, Status: domain.ReviewPending}}, Total: 1}, nil).Once()
+		assert.NoError(t, h.ModerationQueue(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"status":"pending"`)
+	})
+
+	t.Run("Approve", func(t *testing.T) {
+		c, rec := newCtx(http.MethodPost, "/moderation/reviews/7/approve", `{"reason":"ok"}`, "id", "7")
+		ms.On("ModerateReview", uint(1), uint(7), domain.ModerationApprove, "ok").
+			Return(&domain.Review{ID: 7, Status: domain.ReviewPublished}, nil).Once()
+		assert.NoError(t, h.ApproveReview(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Reject_NotPending", func(t *testing.T) {
+		c, rec := newCtx(http.MethodPost, "/moderation/reviews/7/reject", `{}`, "id", "7")
+		ms.On("ModerateReview", uint(1), uint(7), domain.ModerationReject, "").Return(nil, service.ErrNotPending).Once()
+		assert.NoError(t, h.RejectReview(c))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+
+	t.Run("Actions", func(t *testing.T) {
+		c, rec := newCtx(http.MethodGet, "/moderation/reviews/7/actions", "", "id", "7")
+		ms.On("GetModerationActions", uint(7)).Return([]domain.ModerationAction{}, gorm.ErrRecordNotFound).Once()
+		assert.NoError(t, h.ModerationActions(c))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+	ms.AssertExpectations(t)
+}
+
+func TestHandler_Comments(t *testing.T) {
+	e := echo.New()
+	ms := new(MockService)
+	h := NewHandler(ms)
+
+	newCtx := func(method, target, body string, params ...string) (echo.Context, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		var names, values []string
+		for i := 0; i+1 < len(params); i += 2 {
+			names, values = append(names, params[i]), append(values, params[i+1])
+		}
+		c.SetParamNames(names...)
+		c.SetParamValues(values...)
+		c.Set("user_id", uint(1))
+		return c, rec
+	}
+
+	t.Run("List", func(t *testing.T) {
+		c, rec := newCtx(http.MethodGet, "/reviews/7/comments?depth=2&parent_id=3", "", "id", "7")
+		parent := uint(3)
+		ms.On("GetCommentThread", uint(7), &parent, 2).
+			Return([]*domain.ReviewComment{{ID: 4, Body: "hi", HasMoreReplies: true}}, nil).Once()
+		assert.NoError(t, h.ListComments(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"has_more_replies":true`)
+
+		c, rec = newCtx(http.MethodGet, "/reviews/7/comments?depth=0", "", "id", "7")
+		assert.NoError(t, h.ListComments(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		c, rec = newCtx(http.MethodGet, "/reviews/8/comments", "", "id", "8")
+		ms.On("GetCommentThread", uint(8), (*uint)(nil), 0).Return([]*domain.ReviewComment{}, gorm.ErrRecordNotFound).Once()
+		assert.NoError(t, h.ListComments(c))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Add", func(t *testing.T) {
+		c, rec := newCtx(http.MethodPost, "/reviews/7/comments", `{"body":"hi","parent_id":3}`, "id", "7")
+		ms.On("AddComment", uint(1), uint(7), mock.MatchedBy(func(cm *domain.ReviewComment) bool {
+			return cm.Body == "hi" && cm.ParentID != nil && *cm.ParentID == 3
+		})).Return(nil).Once()
+		assert.NoError(t, h.AddComment(c))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+
+		c, rec = newCtx(http.MethodPost, "/reviews/7/comments", `{"body":"hi","parent_id":30}`, "id", "7")
+		ms.On("AddComment", uint(1), uint(7), mock.Anything).Return(service.ErrThreadTooDeep).Once()
+		assert.NoError(t, h.AddComment(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Edit", func(t *testing.T) {
+		c, rec := newCtx(http.MethodPut, "/comments/4", `{"body":"fixed"}`, "id", "4")
+		ms.On("EditComment", uint(1), &domain.ReviewComment{ID: 4, Body: "fixed"}).Return(nil).Once()
+		assert.NoError(t, h.EditComment(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		c, rec = newCtx(http.MethodPut, "/comments/4", `{"body":"late"}`, "id", "4")
+		ms.On("EditComment", uint(1), &domain.ReviewComment{ID: 4, Body: "late"}).Return(service.ErrEditWindowClosed).Once()
+		assert.NoError(t, h.EditComment(c))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		c, rec := newCtx(http.MethodDelete, "/comments/4", "", "id", "4")
+		ms.On("DeleteComment", uint(1), uint(4)).Return(nil).Once()
+		assert.NoError(t, h.DeleteComment(c))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		c, rec = newCtx(http.MethodDelete, "/comments/5", "", "id", "5")
+		ms.On("DeleteComment", uint(1), uint(5)).Return(gorm.ErrRecordNotFound).Once()
+		assert.NoError(t, h.DeleteComment(c))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Notifications", func(t *testing.T) {
+		c, rec := newCtx(http.MethodGet, "/notifications?unread=true&limit=5", "")
+		ms.On("GetNotifications", uint(1), true, domain.ListQuery{Limit: 5}).
+			Return(domain.Page[domain.Notification]{Items: []domain.Notification{{ID: 2, Kind: domain.NotificationCommentReply}}, Total: 1}, nil).Once()
+		assert.NoError(t, h.ListNotifications(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"kind":"comment_reply"`)
+
+		c, rec = newCtx(http.MethodPost, "/notifications/2/read", "", "id", "2")
+		ms.On("MarkNotificationRead", uint(1), uint(2)).Return(nil).Once()
+		assert.NoError(t, h.ReadNotification(c))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		c, rec = newCtx(http.MethodPost, "/notifications/3/read", "", "id", "3")
+		ms.On("MarkNotificationRead", uint(1), uint(3)).Return(gorm.ErrRecordNotFound).Once()
+		assert.NoError(t, h.ReadNotification(c))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+	ms.AssertExpectations(t)
+}
+
+func TestHandler_Patch(t *testing.T) {
+	e := echo.New()
+	ms := new(MockService)
+	h := NewHandler(ms)
+
+	newCtx := func(target, contentType, body string) (echo.Context, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(http.MethodPatch, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, contentType)
+		req.Header.Set("If-Match", `"3"`)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		c.Set("user_id", uint(1))
+		return c, rec
+	}
+
+	t.Run("Book", func(t *testing.T) {
+		c, rec := newCtx("/books/1", "application/merge-patch+json", `{"title":"New","description":null}`)
+		p := domain.MergePatch{"title": json.RawMessage(`"New"`), "description": json.RawMessage(`null`)}
+		ms.On("PatchBook", uint(1), int64(3), p).Return(&domain.Book{ID: 1, Title: "New", Content: "Text", Version: 4}, nil).Once()
+		assert.NoError(t, h.PatchBook(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"content":"Text"`)
+		assert.Regexp(t, `^"4-[0-9a-f]{16}"$`, rec.Header().Get("ETag"))
+
+		c, rec = newCtx("/books/1", "application/merge-patch+json", `{"rating_avg":5}`)
+		ms.On("PatchBook", uint(1), int64(3), mock.Anything).Return(nil, &service.PatchError{Field: "rating_avg", Reason: "field cannot be changed"}).Once()
+		assert.NoError(t, h.PatchBook(c))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "rating_avg")
+
+		c, rec = newCtx("/books/1", echo.MIMEApplicationJSON, `{}`)
+		ms.On("PatchBook", uint(1), int64(3), domain.MergePatch{}).Return(nil, gorm.ErrRecordNotFound).Once()
+		assert.NoError(t, h.PatchBook(c))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+
+		c, rec = newCtx("/books/1", "application/merge-patch+json", `{"title":"Old"}`)
+		ms.On("PatchBook", uint(1), int64(3), mock.Anything).Return(nil, domain.ErrVersionMismatch).Once()
+		assert.NoError(t, h.PatchBook(c))
+		assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	})
+
+	t.Run("IfMatch", func(t *testing.T) {
+		cases := []struct {
+			header string
+			code   int
+		}{
+			{"", http.StatusPreconditionRequired},
+			{`W/"3"`, http.StatusPreconditionFailed},
+			{`"x"`, http.StatusPreconditionFailed},
+			{`"1", "2"`, http.StatusPreconditionFailed},
+			{`"-3"`, http.StatusPreconditionFailed},
+		}
+		for _, tc := range cases {
+			c, rec := newCtx("/authors/1", "application/merge-patch+json", `{"bio":"New"}`)
+			c.Request().Header.Set("If-Match", tc.header)
+			assert.NoError(t, h.PatchAuthor(c))
+			assert.Equal(t, tc.code, rec.Code, tc.header)
+		}
+
+		req := httptest.NewRequest(http.MethodDelete, "/books/1", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		assert.NoError(t, h.DeleteBook(c))
+		assert.Equal(t, http.StatusPreconditionRequired, rec.Code)
+
+		// Тег из GET с хэшем ответа: сверяется только версия
+		c, rec = newCtx("/authors/1", "application/merge-patch+json", `{"bio":"New"}`)
+		c.Request().Header.Set("If-Match", `"3-0123456789abcdef"`)
+		ms.On("PatchAuthor", uint(1), int64(3), mock.Anything).Return(&domain.Author{ID: 1, Version: 4}, nil).Once()
+		assert.NoError(t, h.PatchAuthor(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("IfNoneMatch", func(t *testing.T) {
+		tag := etag(3, &domain.Book{ID: 1, Version: 3})
+		get := func(header string, b *domain.Book) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/books/1", nil)
+			req.Header.Set("If-None-Match", header)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues("1")
+			ms.On("GetBook", uint(1)).Return(b, nil).Once()
+			assert.NoError(t, h.GetBook(c))
+			return rec
+		}
+		for _, tc := range []struct {
+			header string
+			code   int
+		}{
+			{"", http.StatusOK},
+			{`"3"`, http.StatusOK},
+			{"W/" + tag, http.StatusNotModified},
+			{`"1", ` + tag, http.StatusNotModified},
+		} {
+			rec := get(tc.header, &domain.Book{ID: 1, Version: 3})
+			assert.Equal(t, tc.code, rec.Code, tc.header)
+			assert.Equal(t, tag, rec.Header().Get("ETag"))
+		}
+
+		// Новая оценка не меняет версию, но меняет ответ: кэш клиента устарел
+		rec := get(tag, &domain.Book{ID: 1, Version: 3, RatingCount: 1, RatingAvg: 5})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotEqual(t, tag, rec.Header().Get("ETag"))
+		assert.Regexp(t, `^"3-`, rec.Header().Get("ETag"))
+	})
+
+	t.Run("BadBody", func(t *testing.T) {
+		c, rec := newCtx("/books/1", echo.MIMETextPlain, `{"title":"New"}`)
+		assert.NoError(t, h.PatchBook(c))
+		assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+
+		for _, body := range []string{`["title"]`, `null`, `{`} {
+			c, rec = newCtx("/authors/1", "application/merge-patch+json", body)
+			assert.NoError(t, h.PatchAuthor(c))
+			assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+		}
+	})
+
+	t.Run("Author", func(t *testing.T) {
+		c, rec := newCtx("/authors/1", "application/merge-patch+json; charset=utf-8", `{"bio":"New"}`)
+		ms.On("PatchAuthor", uint(1), int64(3), domain.MergePatch{"bio": json.RawMessage(`"New"`)}).Return(&domain.Author{ID: 1, Bio: "New"}, nil).Once()
+		assert.NoError(t, h.PatchAuthor(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Profile", func(t *testing.T) {
+		c, rec := newCtx("/me", "application/merge-patch+json", `{"email":"taken@b.c"}`)
+		ms.On("PatchProfile", uint(1), mock.Anything).Return(nil, service.ErrEmailTaken).Once()
+		assert.NoError(t, h.PatchProfile(c))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+
+		c, rec = newCtx("/me", "application/merge-patch+json", `{"name":"B"}`)
+		ms.On("PatchProfile", uint(1), domain.MergePatch{"name": json.RawMessage(`"B"`)}).Return(&domain.User{ID: 1, Name: "B", Email: "a@b.c"}, nil).Once()
+		assert.NoError(t, h.PatchProfile(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"email":"a@b.c"`)
+	})
+	ms.AssertExpectations(t)
 }
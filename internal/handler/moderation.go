@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"E-book-service/internal/domain"
+	"E-book-service/internal/service"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type ReportRequest struct {
+	Reason string `json:"reason"`
+}
+
+type ModerationRequest struct {
+	Reason string `json:"reason"`
+}
+
+func moderationError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidReportReason), errors.Is(err, service.ErrOwnReview):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, service.ErrAlreadyReported), errors.Is(err, service.ErrNotPending):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Review not found"})
+	default:
+		return listError(c, err)
+	}
+}
+
+// @Summary Пожаловаться на отзыв
+// @Description Набрав порог жалоб, отзыв скрывается до решения модератора.
+// @Tags Reviews
+// @Security ApiKeyAuth
+// @Accept json
+// @Param id path int true "ID отзыва"
+// @Param body body ReportRequest true "Причина жалобы"
+// @Success 204 "No Content"
+// @Router /reviews/{id}/report [post]
+func (h *Handler) ReportReview(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	var r ReportRequest
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+	if err := h.svc.ReportReview(c.Request().Context(), getUID(c), id, r.Reason); err != nil {
+		return moderationError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Summary Очередь модерации
+// @Description Скрытые отзывы с жалобами, по умолчанию старые первыми.
+// @Tags Moderation
+// @Security ApiKeyAuth
+// @Produce json
+// @Param limit query int false "Размер страницы (по умолчанию 20, максимум 100)"
+// @Param cursor query string false "Курсор из next_cursor предыдущей страницы"
+// @Param sort query string false "id или rating; -id — новые первыми"
+// @Success 200 {object} domain.Page[domain.Review]
+// @Router /moderation/reviews [get]
+func (h *Handler) ModerationQueue(c echo.Context) error {
+	lq, err := parseListQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	page, err := h.svc.GetModerationQueue(c.Request().Context(), lq)
+	if err != nil {
+		return listError(c, err)
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// @Summary Одобрить отзыв
+// @Tags Moderation
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID отзыва"
+// @Param body body ModerationRequest false "Комментарий для журнала"
+// @Success 200 {object} domain.Review
+// @Router /moderation/reviews/{id}/approve [post]
+func (h *Handler) ApproveReview(c echo.Context) error {
+	return h.moderate(c, domain.ModerationApprove)
+}
+
+// @Summary Отклонить отзыв
+// @Tags Moderation
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID отзыва"
+// @Param body body ModerationRequest false "Комментарий для журнала"
+// @Success 200 {object} domain.Review
+// @Router /moderation/reviews/{id}/reject [post]
+func (h *Handler) RejectReview(c echo.Context) error {
+	return h.moderate(c, domain.ModerationReject)
+}
+
+func (h *Handler) moderate(c echo.Context, action string) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	var r ModerationRequest
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+	re, err := h.svc.ModerateReview(c.Request().Context(), getUID(c), id, action, r.Reason)
+	if err != nil {
+		return moderationError(c, err)
+	}
+	return c.JSON(http.StatusOK, re)
+}
+
+// @Summary Журнал модерации отзыва
+// @Tags Moderation
+// @Security ApiKeyAuth
+// @Produce json
+// @Param id path int true "ID отзыва"
+// @Success 200 {array} domain.ModerationAction
+// @Router /moderation/reviews/{id}/actions [get]
+func (h *Handler) ModerationActions(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	actions, err := h.svc.GetModerationActions(c.Request().Context(), id)
+	if err != nil {
+		return moderationError(c, err)
+	}
+	return c.JSON(http.StatusOK, actions)
+}
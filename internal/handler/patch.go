@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"E-book-service/internal/domain"
+	"E-book-service/internal/service"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const mimeMergePatch = "application/merge-patch+json"
+
+var errPatchNotObject = errors.New("merge patch must be a JSON object")
+
+// bindMergePatch читает тело PATCH. Принимается application/merge-patch+json
+// и, для простых клиентов, application/json.
+func bindMergePatch(c echo.Context) (domain.MergePatch, int, error) {
+	mt, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if mt != mimeMergePatch && mt != echo.MIMEApplicationJSON {
+		return nil, http.StatusUnsupportedMediaType, errors.New("use Content-Type " + mimeMergePatch)
+	}
+	var p domain.MergePatch
+	if err := json.NewDecoder(c.Request().Body).Decode(&p); err != nil || p == nil {
+		return nil, http.StatusBadRequest, errPatchNotObject
+	}
+	return p, 0, nil
+}
+
+// updateError отвечает на ошибку PUT или PATCH книги, автора или профиля.
+func updateError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidPatch):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, domain.ErrVersionMismatch):
+		return c.JSON(http.StatusPreconditionFailed, map[string]string{"error": err.Error()})
+	case errors.Is(err, service.ErrEmailTaken):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Not found"})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
+
+// @Summary Частично обновить профиль
+// @Description JSON Merge Patch (RFC 7386): можно менять name и email, null сбрасывает поле. Отдаёт сохранённый профиль.
+// @Tags Profile
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param patch body object true "Изменяемые поля"
+// @Success 200 {object} domain.User
+// @Router /me [patch]
+func (h *Handler) PatchProfile(c echo.Context) error {
+	p, code, err := bindMergePatch(c)
+	if err != nil {
+		return c.JSON(code, map[string]string{"error": err.Error()})
+	}
+	u, err := h.svc.PatchProfile(c.Request().Context(), getUID(c), p)
+	if err != nil {
+		return updateError(c, err)
+	}
+	return c.JSON(http.StatusOK, u)
+}
+
+// @Summary Частично обновить книгу
+// @Description JSON Merge Patch (RFC 7386): title, description, content, language, isbn, author_id. Отдаёт сохранённую книгу.
+// @Description Нужен If-Match с ETag из GET: без него — 428, если книгу успели изменить — 412.
+// @Tags Books
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID книги"
+// @Param If-Match header string true "ETag книги"
+// @Param patch body object true "Изменяемые поля"
+// @Success 200 {object} domain.Book
+// @Router /books/{id} [patch]
+func (h *Handler) PatchBook(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	version, code, err := ifMatch(c)
+	if err != nil {
+		return c.JSON(code, map[string]string{"error": err.Error()})
+	}
+	p, code, err := bindMergePatch(c)
+	if err != nil {
+		return c.JSON(code, map[string]string{"error": err.Error()})
+	}
+	b, err := h.svc.PatchBook(c.Request().Context(), id, version, p)
+	if err != nil {
+		return updateError(c, err)
+	}
+	c.Response().Header().Set(headerETag, etag(b.Version, b))
+	return c.JSON(http.StatusOK, b)
+}
+
+// @Summary Частично обновить автора
+// @Description JSON Merge Patch (RFC 7386): name и bio. Отдаёт сохранённого автора.
+// @Description Нужен If-Match с ETag из GET: без него — 428, если автора успели изменить — 412.
+// @Tags Authors
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "ID автора"
+// @Param If-Match header string true "ETag автора"
+// @Param patch body object true "Изменяемые поля"
+// @Success 200 {object} domain.Author
+// @Router /authors/{id} [patch]
+func (h *Handler) PatchAuthor(c echo.Context) error {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ID format"})
+	}
+	version, code, err := ifMatch(c)
+	if err != nil {
+		return c.JSON(code, map[string]string{"error": err.Error()})
+	}
+	p, code, err := bindMergePatch(c)
+	if err != nil {
+		return c.JSON(code, map[string]string{"error": err.Error()})
+	}
+	a, err := h.svc.PatchAuthor(c.Request().Context(), id, version, p)
+	if err != nil {
+		return updateError(c, err)
+	}
+	c.Response().Header().Set(headerETag, etag(a.Version, a))
+	return c.JSON(http.StatusOK, a)
+}
@@ -2,11 +2,16 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"strings"
 	"time"
 
+	"E-book-service/internal/domain"
+	"E-book-service/internal/repository"
+
 	"github.com/go-redis/redis/v8"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
@@ -15,7 +20,7 @@ import (
 func RateLimiter(rdb *redis.Client) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			ctx := context.Background()
+			ctx := c.Request().Context()
 
 			ip := c.RealIP()
 			key := fmt.Sprintf("rate_limit:%s", ip)
@@ -40,7 +45,9 @@ func RateLimiter(rdb *redis.Client) echo.MiddlewareFunc {
 	}
 }
 
-func JWTMiddleware(secret string) echo.MiddlewareFunc {
+// JWTMiddleware проверяет подпись и срок токена, а затем сверяется с denylist,
+// чтобы не пропускать токены, отозванные через logout.
+func JWTMiddleware(secret string, denylist repository.Denylist) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			authHeader := c.Request().Header.Get("Authorization")
@@ -66,14 +73,124 @@ func JWTMiddleware(secret string) echo.MiddlewareFunc {
 				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid or expired token"})
 			}
 
-			if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-				c.Set("user_id", uint(claims["id"].(float64)))
-				c.Set("user_email", claims["email"])
-			} else {
+			claims, ok := token.Claims.(jwt.MapClaims)
+			if !ok {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid token claims"})
+			}
+			id, ok := claims["id"].(float64)
+			if !ok {
 				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid token claims"})
 			}
+			jti, _ := claims["jti"].(string)
+			// iat читаем сами: jwt обрезает NumericDate до секунд, а сервис
+			// пишет iat с миллисекундами.
+			var issuedAt, expiresAt time.Time
+			if iat, ok := claims["iat"].(float64); ok {
+				issuedAt = time.UnixMilli(int64(math.Round(iat * 1e3)))
+			}
+			if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+				expiresAt = exp.Time
+			}
+
+			revoked, err := denylist.IsRevoked(c.Request().Context(), jti, uint(id), issuedAt)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Redis error"})
+			}
+			if revoked {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Token has been revoked"})
+			}
+
+			c.Set("user_id", uint(id))
+			c.Set("user_email", claims["email"])
+			// Токены, выпущенные до появления ролей, считаем токенами читателя.
+			role, _ := claims["role"].(string)
+			if role == "" {
+				role = domain.RoleReader
+			}
+			c.Set("user_role", role)
+			c.Set("token_id", jti)
+			c.Set("token_exp", expiresAt)
 
 			return next(c)
 		}
 	}
 }
+
+// RequireRole пропускает запрос дальше, только если роль из JWT входит в список roles.
+// Должен стоять после JWTMiddleware.
+func RequireRole(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			role, _ := c.Get("user_role").(string)
+			for _, r := range roles {
+				if role == r {
+					return next(c)
+				}
+			}
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Insufficient permissions"})
+		}
+	}
+}
+
+// Timeout ограничивает время обработки запроса: контекст запроса получает
+// дедлайн d, и запросы к Postgres и Redis, начатые после него, отменяются.
+// Если дедлайн истёк до того, как обработчик начал ответ, клиент получает 504
+// вместо ответа обработчика. d <= 0 отключает ограничение.
+func Timeout(d time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if d <= 0 {
+			return next
+		}
+		return func(c echo.Context) error {
+			ctx, cancel := context.WithTimeout(c.Request().Context(), d)
+			defer cancel()
+			c.SetRequest(c.Request().WithContext(ctx))
+			res := c.Response()
+			tw := &timeoutWriter{ResponseWriter: res.Writer, ctx: ctx}
+			res.Writer = tw
+			err := next(c)
+			if tw.timedOut {
+				res.Status = http.StatusGatewayTimeout // для логов: клиент получил 504
+			}
+			return err
+		}
+	}
+}
+
+// timeoutWriter подменяет ответ на 504, если к моменту записи заголовков
+// дедлайн запроса уже истёк: обработчик в этом случае обычно отвечает
+// ошибкой отменённого запроса к базе.
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	timedOut bool
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	if errors.Is(w.ctx.Err(), context.DeadlineExceeded) {
+		w.timedOut = true
+		w.Header().Del("Content-Length")
+		w.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+		w.ResponseWriter.WriteHeader(http.StatusGatewayTimeout)
+		_, _ = w.ResponseWriter.Write([]byte(`{"error":"Request timed out"}` + "\n"))
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	if w.timedOut {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timeoutWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.timedOut {
+		f.Flush()
+	}
+}
+
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
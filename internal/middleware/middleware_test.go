@@ -1,11 +1,14 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"E-book-service/internal/repository"
+
 	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis/v8"
 	"github.com/golang-jwt/jwt/v5"
@@ -72,9 +75,12 @@ func TestRateLimiter_TTLIsSet(t *testing.T) {
 	assert.True(t, ttl > 0 && ttl <= time.Minute)
 }
 
-func setupEchoWithJWT(secret string) *echo.Echo {
+func setupEchoWithJWT(t *testing.T, secret string) *echo.Echo {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
 	e := echo.New()
-	e.Use(JWTMiddleware(secret))
+	e.Use(JWTMiddleware(secret, repository.NewDenylist(rdb)))
 	e.GET("/", func(c echo.Context) error {
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"user_id":    c.Get("user_id"),
@@ -96,7 +102,7 @@ func generateToken(secret string) string {
 
 func TestJWTMiddleware_ValidToken(t *testing.T) {
 	secret := "secret"
-	e := setupEchoWithJWT(secret)
+	e := setupEchoWithJWT(t, secret)
 
 	token := generateToken(secret)
 
@@ -111,7 +117,7 @@ func TestJWTMiddleware_ValidToken(t *testing.T) {
 }
 
 func TestJWTMiddleware_MissingHeader(t *testing.T) {
-	e := setupEchoWithJWT("secret")
+	e := setupEchoWithJWT(t, "secret")
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	rec := httptest.NewRecorder()
@@ -122,7 +128,7 @@ func TestJWTMiddleware_MissingHeader(t *testing.T) {
 }
 
 func TestJWTMiddleware_InvalidFormat(t *testing.T) {
-	e := setupEchoWithJWT("secret")
+	e := setupEchoWithJWT(t, "secret")
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	req.Header.Set("Authorization", "BadFormat")
@@ -134,7 +140,7 @@ func TestJWTMiddleware_InvalidFormat(t *testing.T) {
 }
 
 func TestJWTMiddleware_InvalidSignature(t *testing.T) {
-	e := setupEchoWithJWT("secret")
+	e := setupEchoWithJWT(t, "secret")
 
 	token := generateToken("wrong-secret")
 
@@ -157,7 +163,7 @@ func TestJWTMiddleware_ExpiredToken(t *testing.T) {
 	})
 	s, _ := token.SignedString([]byte(secret))
 
-	e := setupEchoWithJWT(secret)
+	e := setupEchoWithJWT(t, secret)
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	req.Header.Set("Authorization", "Bearer "+s)
@@ -176,7 +182,7 @@ func TestJWTMiddleware_WrongSigningMethod(t *testing.T) {
 	})
 	s, _ := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
 
-	e := setupEchoWithJWT(secret)
+	e := setupEchoWithJWT(t, secret)
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	req.Header.Set("Authorization", "Bearer "+s)
@@ -207,3 +213,128 @@ func TestRateLimiter_RedisError(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, rec.Code)
 	assert.Contains(t, rec.Body.String(), "Redis error")
 }
+
+func TestRequireRole(t *testing.T) {
+	secret := "secret"
+	e := setupEchoWithJWT(t, secret)
+	e.POST("/books", func(c echo.Context) error {
+		return c.NoContent(http.StatusCreated)
+	}, RequireRole("editor", "admin"))
+
+	sign := func(claims jwt.MapClaims) string {
+		s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		return s
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	cases := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"editor", sign(jwt.MapClaims{"id": 1, "role": "editor", "exp": exp}), http.StatusCreated},
+		{"admin", sign(jwt.MapClaims{"id": 1, "role": "admin", "exp": exp}), http.StatusCreated},
+		{"reader", sign(jwt.MapClaims{"id": 1, "role": "reader", "exp": exp}), http.StatusForbidden},
+		{"legacy token without role", sign(jwt.MapClaims{"id": 1, "exp": exp}), http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/books", nil)
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, tc.code, rec.Code)
+		})
+	}
+}
+
+func TestJWTMiddleware_Denylist(t *testing.T) {
+	secret := "secret"
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	denylist := repository.NewDenylist(rdb)
+
+	e := echo.New()
+	e.Use(JWTMiddleware(secret, denylist))
+	e.GET("/", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]interface{}{"jti": c.Get("token_id")})
+	})
+
+	issued := time.Now().Add(-time.Minute)
+	sign := func(jti string) string {
+		s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"id":  7,
+			"jti": jti,
+			"iat": issued.Unix(),
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}).SignedString([]byte(secret))
+		return s
+	}
+	call := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, call(sign("a")))
+
+	assert.NoError(t, denylist.RevokeToken(context.Background(), "a", time.Hour))
+	assert.Equal(t, http.StatusUnauthorized, call(sign("a")))
+	assert.Equal(t, http.StatusOK, call(sign("b")))
+
+	logout := time.Now()
+	assert.NoError(t, denylist.RevokeUser(context.Background(), 7, logout, time.Hour))
+	assert.Equal(t, http.StatusUnauthorized, call(sign("b")))
+
+	// Токен, выпущенный после выхода в ту же секунду, действует: iat с миллисекундами
+	fresh, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":  7,
+		"jti": "d",
+		"iat": float64(logout.UnixMilli()+1) / 1e3,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(secret))
+	assert.Equal(t, http.StatusOK, call(fresh))
+
+	mr.Close()
+	assert.Equal(t, http.StatusInternalServerError, call(sign("c")))
+}
+
+func TestTimeout(t *testing.T) {
+	e := echo.New()
+	e.Use(Timeout(20 * time.Millisecond))
+	e.GET("/slow", func(c echo.Context) error {
+		// Как запрос к базе: ждёт, пока контекст не отменят, и отвечает ошибкой.
+		<-c.Request().Context().Done()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": c.Request().Context().Err().Error()})
+	})
+	e.GET("/fast", func(c echo.Context) error {
+		_, ok := c.Request().Context().Deadline()
+		assert.True(t, ok)
+		return c.String(http.StatusOK, "ok")
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	assert.JSONEq(t, `{"error":"Request timed out"}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok", rec.Body.String())
+}
+
+func TestTimeout_Disabled(t *testing.T) {
+	e := echo.New()
+	e.Use(Timeout(0))
+	e.GET("/", func(c echo.Context) error {
+		_, ok := c.Request().Context().Deadline()
+		assert.False(t, ok)
+		return c.NoContent(http.StatusNoContent)
+	})
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}
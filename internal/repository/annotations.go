@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"E-book-service/internal/domain"
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Выражение совпадает с индексом idx_annotations_text_trgm, иначе поиск его не использует.
+const annotationTextExpr = "(annotations.selected_text || ' ' || annotations.note)"
+
+var annotationSorts = sortFields{
+	"id":       "annotations.id",
+	"created":  "annotations.created_at",
+	"position": "(annotations.start_chapter, annotations.start_offset)",
+}
+
+func (r *postgresRepository) CreateAnnotation(ctx context.Context, a *domain.Annotation) error {
+	return r.db.WithContext(ctx).Omit("Book").Create(a).Error
+}
+
+// GetAnnotations ищет аннотации пользователя. Внутри одной книги по умолчанию
+// они идут по тексту, в общем списке — от новых к старым.
+func (r *postgresRepository) GetAnnotations(ctx context.Context, uID uint, f domain.AnnotationFilter) (domain.Page[domain.Annotation], error) {
+	fallback := "-created"
+	if f.BookID != 0 {
+		fallback = "position"
+	}
+	order, err := orderClause(f.Sort, annotationSorts, "annotations.id", fallback)
+	if err != nil {
+		return domain.Page[domain.Annotation]{}, err
+	}
+	q := r.db.WithContext(ctx).Model(&domain.Annotation{}).Where("annotations.user_id = ?", uID)
+	if f.BookID != 0 {
+		q = q.Where("annotations.book_id = ?", f.BookID)
+	}
+	if f.Kind != "" {
+		q = q.Where("annotations.kind = ?", f.Kind)
+	}
+	if f.Query != "" {
+		q = q.Where(annotationTextExpr+" ILIKE ?", likeContains(f.Query))
+	}
+	return paginate[domain.Annotation](q, f.ListQuery, order)
+}
+
+func (r *postgresRepository) GetAnnotation(ctx context.Context, id uint) (*domain.Annotation, error) {
+	var a domain.Annotation
+	return &a, r.db.WithContext(ctx).First(&a, id).Error
+}
+
+func (r *postgresRepository) UpdateAnnotation(ctx context.Context, a *domain.Annotation) error {
+	return r.db.WithContext(ctx).Omit("Book").Save(a).Error
+}
+
+func (r *postgresRepository) DeleteAnnotation(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Delete(&domain.Annotation{}, id).Error
+}
+
+// ExportAnnotations отдаёт все аннотации пользователя (или одной книги, если bookID
+// не 0) вместе с книгой и автором, сгруппированные по книгам и по порядку в тексте.
+func (r *postgresRepository) ExportAnnotations(ctx context.Context, uID, bookID uint) ([]domain.Annotation, error) {
+	q := r.db.WithContext(ctx).Where("user_id = ?", uID)
+	if bookID != 0 {
+		q = q.Where("book_id = ?", bookID)
+	}
+	var a []domain.Annotation
+	return a, q.Preload("Book", func(db *gorm.DB) *gorm.DB {
+		return db.Unscoped().Select("id", "title", "author_id")
+	}).Preload("Book.Author", unscoped).
+		Order("book_id, start_chapter, start_offset, id").Find(&a).Error
+}
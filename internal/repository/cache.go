@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Cache — короткоживущий кэш в Redis. Значения хранятся в JSON.
+type Cache interface {
+	// Get возвращает false, если ключа нет или он истёк.
+	Get(ctx context.Context, key string, dst interface{}) (bool, error)
+	Set(ctx context.Context, key string, val interface{}, ttl time.Duration) error
+}
+
+type redisCache struct {
+	rdb *redis.Client
+}
+
+func NewCache(rdb *redis.Client) Cache {
+	return &redisCache{rdb: rdb}
+}
+
+func (c *redisCache) Get(ctx context.Context, key string, dst interface{}) (bool, error) {
+	raw, err := c.rdb.Get(ctx, "cache:"+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(raw, dst)
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, val interface{}, ttl time.Duration) error {
+	raw, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return c.rdb.Set(ctx, "cache:"+key, raw, ttl).Err()
+}
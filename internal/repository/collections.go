@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"E-book-service/internal/domain"
+	"context"
+
+	"gorm.io/gorm"
+)
+
+func (r *postgresRepository) CreateCollection(ctx context.Context, c *domain.Collection) error {
+	return r.db.WithContext(ctx).Omit("Books").Create(c).Error
+}
+
+// GetCollections возвращает подборки пользователя без книг. Если onlyPublic,
+// то только публичные — так их видят другие пользователи.
+func (r *postgresRepository) GetCollections(ctx context.Context, uID uint, onlyPublic bool) ([]domain.Collection, error) {
+	q := r.db.WithContext(ctx).Where("user_id = ?", uID)
+	if onlyPublic {
+		q = q.Where("visibility = ?", domain.VisibilityPublic)
+	}
+	var c []domain.Collection
+	return c, q.Order("name, id").Find(&c).Error
+}
+
+func (r *postgresRepository) GetCollection(ctx context.Context, id uint) (*domain.Collection, error) {
+	var c domain.Collection
+	return &c, r.withCollectionBooks(ctx).First(&c, id).Error
+}
+
+func (r *postgresRepository) GetCollectionBySlug(ctx context.Context, slug string) (*domain.Collection, error) {
+	var c domain.Collection
+	return &c, r.withCollectionBooks(ctx).Where("slug = ?", slug).First(&c).Error
+}
+
+func (r *postgresRepository) withCollectionBooks(ctx context.Context) *gorm.DB {
+	return r.db.WithContext(ctx).Preload("Books", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).Preload("Books.Book.Author")
+}
+
+func (r *postgresRepository) UpdateCollection(ctx context.Context, c *domain.Collection) error {
+	return r.db.WithContext(ctx).Omit("Books").Save(c).Error
+}
+
+func (r *postgresRepository) DeleteCollection(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("collection_id = ?", id).Delete(&domain.CollectionBook{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&domain.Collection{}, id).Error
+	})
+}
+
+func (r *postgresRepository) AddCollectionBook(ctx context.Context, cb *domain.CollectionBook) error {
+	return r.db.WithContext(ctx).Omit("Book").Create(cb).Error
+}
+
+func (r *postgresRepository) RemoveCollectionBook(ctx context.Context, collectionID, bookID uint) error {
+	res := r.db.WithContext(ctx).Where("collection_id = ? AND book_id = ?", collectionID, bookID).Delete(&domain.CollectionBook{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ReorderCollection выставляет позиции по порядку bookIDs, начиная с 1.
+func (r *postgresRepository) ReorderCollection(ctx context.Context, collectionID uint, bookIDs []uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for i, bID := range bookIDs {
+			err := tx.Model(&domain.CollectionBook{}).
+				Where("collection_id = ? AND book_id = ?", collectionID, bID).
+				Update("position", i+1).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"E-book-service/internal/domain"
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func (r *postgresRepository) CreateReviewComment(ctx context.Context, c *domain.ReviewComment) error {
+	return r.db.WithContext(ctx).Create(c).Error
+}
+
+// GetReviewComment не находит удалённые комментарии.
+func (r *postgresRepository) GetReviewComment(ctx context.Context, id uint) (*domain.ReviewComment, error) {
+	var c domain.ReviewComment
+	return &c, r.db.WithContext(ctx).First(&c, id).Error
+}
+
+// GetReviewComments возвращает все комментарии к отзыву, включая удалённые,
+// в порядке создания: ветку из них собирает сервис.
+func (r *postgresRepository) GetReviewComments(ctx context.Context, reviewID uint) ([]domain.ReviewComment, error) {
+	var c []domain.ReviewComment
+	return c, r.db.WithContext(ctx).Unscoped().Where("review_id = ?", reviewID).Order("id").Find(&c).Error
+}
+
+func (r *postgresRepository) UpdateReviewComment(ctx context.Context, c *domain.ReviewComment) error {
+	return r.db.WithContext(ctx).Save(c).Error
+}
+
+// DeleteReviewComment удаляет комментарий мягко: ответы на него остаются в ветке.
+func (r *postgresRepository) DeleteReviewComment(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Delete(&domain.ReviewComment{}, id).Error
+}
+
+func (r *postgresRepository) CreateNotification(ctx context.Context, n *domain.Notification) error {
+	return r.db.WithContext(ctx).Create(n).Error
+}
+
+var notificationSorts = sortFields{"id": "notifications.id"}
+
+// GetNotifications — уведомления пользователя, новые первыми.
+func (r *postgresRepository) GetNotifications(ctx context.Context, uID uint, unreadOnly bool, lq domain.ListQuery) (domain.Page[domain.Notification], error) {
+	order, err := orderClause(lq.Sort, notificationSorts, "notifications.id", "-id")
+	if err != nil {
+		return domain.Page[domain.Notification]{}, err
+	}
+	q := r.db.WithContext(ctx).Model(&domain.Notification{}).Where("notifications.user_id = ?", uID)
+	if unreadOnly {
+		q = q.Where("notifications.read_at IS NULL")
+	}
+	return paginate[domain.Notification](q, lq, order)
+}
+
+// MarkNotificationRead отмечает уведомление прочитанным; время первого прочтения не меняется.
+func (r *postgresRepository) MarkNotificationRead(ctx context.Context, id, uID uint) error {
+	res := r.db.WithContext(ctx).Model(&domain.Notification{}).
+		Where("id = ? AND user_id = ?", id, uID).
+		Update("read_at", gorm.Expr("COALESCE(read_at, ?)", time.Now()))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Denylist хранит в Redis отозванные access-токены. Записи живут не дольше
+// самих токенов, поэтому отдельная чистка не нужна.
+type Denylist interface {
+	// RevokeToken отзывает один токен по его jti.
+	RevokeToken(ctx context.Context, jti string, ttl time.Duration) error
+	// RevokeUser отзывает все токены пользователя, выпущенные не позже at
+	// с точностью до миллисекунды.
+	RevokeUser(ctx context.Context, uID uint, at time.Time, ttl time.Duration) error
+	IsRevoked(ctx context.Context, jti string, uID uint, issuedAt time.Time) (bool, error)
+}
+
+type redisDenylist struct {
+	rdb *redis.Client
+}
+
+func NewDenylist(rdb *redis.Client) Denylist {
+	return &redisDenylist{rdb: rdb}
+}
+
+func jtiKey(jti string) string { return fmt.Sprintf("denylist:jti:%s", jti) }
+func userKey(uID uint) string  { return fmt.Sprintf("denylist:user:%d", uID) }
+
+func (d *redisDenylist) RevokeToken(ctx context.Context, jti string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil // токен и так уже истёк
+	}
+	return d.rdb.Set(ctx, jtiKey(jti), 1, ttl).Err()
+}
+
+func (d *redisDenylist) RevokeUser(ctx context.Context, uID uint, at time.Time, ttl time.Duration) error {
+	return d.rdb.Set(ctx, userKey(uID), at.UnixMilli(), ttl).Err()
+}
+
+func (d *redisDenylist) IsRevoked(ctx context.Context, jti string, uID uint, issuedAt time.Time) (bool, error) {
+	vals, err := d.rdb.MGet(ctx, jtiKey(jti), userKey(uID)).Result()
+	if err != nil {
+		return false, err
+	}
+	if jti != "" && vals[0] != nil {
+		return true, nil
+	}
+	if s, ok := vals[1].(string); ok {
+		before, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return false, err
+		}
+		return issuedAt.UnixMilli() <= before, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,291 @@
+package repository
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Миграции лежат в migrations/ парами NNNN_name.up.sql и NNNN_name.down.sql
+// и вшиваются в бинарник. Номера идут подряд с 1; применённые версии
+// записываются в schema_migrations.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey — ключ advisory-блокировки: пока одна реплика мигрирует,
+// остальные ждут её, а не применяют те же миграции параллельно.
+const migrationLockKey = 7316354020
+
+const createMigrationsTableSQL = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version bigint PRIMARY KEY,
+	name text NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`
+
+var (
+	ErrSchemaBehind   = errors.New("database schema is behind")
+	ErrUnknownVersion = errors.New("unknown migration version")
+)
+
+var migrationNameRe = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus — миграция и время её применения; AppliedAt пуст, если она ещё не применена.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+type schemaMigration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string { return "schema_migrations" }
+
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// NewMigrator готовит миграции, вшитые в бинарник.
+func NewMigrator(db *gorm.DB) (*Migrator, error) {
+	sub, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return newMigrator(db, sub)
+}
+
+func newMigrator(db *gorm.DB, fsys fs.FS) (*Migrator, error) {
+	ms, err := loadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: ms}, nil
+}
+
+// loadMigrations читает пары up/down из корня fsys. У каждой версии должны
+// быть оба файла, а версии — идти подряд с 1.
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		m := migrationNameRe.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			return nil, fmt.Errorf("migrations: unexpected file %s", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		mg := byVersion[version]
+		if mg == nil {
+			mg = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mg
+		}
+		if mg.Name != m[2] {
+			return nil, fmt.Errorf("migrations: version %d has two names: %s and %s", version, mg.Name, m[2])
+		}
+		if m[3] == "up" {
+			mg.Up = string(body)
+		} else {
+			mg.Down = string(body)
+		}
+	}
+
+	ms := make([]Migration, 0, len(byVersion))
+	for _, mg := range byVersion {
+		if mg.Up == "" || mg.Down == "" {
+			return nil, fmt.Errorf("migrations: version %d needs both up and down files", mg.Version)
+		}
+		ms = append(ms, *mg)
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	for i, mg := range ms {
+		if mg.Version != i+1 {
+			return nil, fmt.Errorf("migrations: version %d is missing", i+1)
+		}
+	}
+	return ms, nil
+}
+
+// Latest — версия, которую ожидает этот бинарник.
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Up применяет все недостающие миграции и возвращает их число.
+// Схему новее бинарника Up не трогает.
+func (m *Migrator) Up() (int, error) {
+	n := 0
+	err := m.locked(func(conn *gorm.DB) error {
+		current, err := currentVersion(conn)
+		if err != nil || current >= m.Latest() {
+			return err
+		}
+		n, err = m.migrate(conn, current, m.Latest())
+		return err
+	})
+	return n, err
+}
+
+// Down откатывает последнюю применённую миграцию.
+func (m *Migrator) Down() (int, error) {
+	n := 0
+	err := m.locked(func(conn *gorm.DB) error {
+		current, err := currentVersion(conn)
+		if err != nil || current == 0 {
+			return err
+		}
+		n, err = m.migrate(conn, current, current-1)
+		return err
+	})
+	return n, err
+}
+
+// To применяет или откатывает миграции, пока схема не окажется на версии target.
+// Возвращает число выполненных шагов.
+func (m *Migrator) To(target int) (int, error) {
+	if target < 0 || target > m.Latest() {
+		return 0, fmt.Errorf("%w: %d (latest is %d)", ErrUnknownVersion, target, m.Latest())
+	}
+	n := 0
+	err := m.locked(func(conn *gorm.DB) error {
+		current, err := currentVersion(conn)
+		if err != nil {
+			return err
+		}
+		n, err = m.migrate(conn, current, target)
+		return err
+	})
+	return n, err
+}
+
+// Status перечисляет миграции бинарника и отмечает применённые.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]MigrationStatus, len(m.migrations))
+	for i, mg := range m.migrations {
+		list[i] = MigrationStatus{Version: mg.Version, Name: mg.Name}
+		if sm, ok := applied[mg.Version]; ok {
+			at := sm.AppliedAt
+			list[i].AppliedAt = &at
+		}
+	}
+	return list, nil
+}
+
+// Check возвращает ErrSchemaBehind, если в базе применены не все миграции бинарника.
+// Схема новее бинарника допустима: так бывает при откате релиза.
+func (m *Migrator) Check() error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	current := 0
+	for v := range applied {
+		if v > current {
+			current = v
+		}
+	}
+	if current < m.Latest() {
+		return fmt.Errorf("%w: at version %d, need %d; run `migrate up`", ErrSchemaBehind, current, m.Latest())
+	}
+	return nil
+}
+
+func (m *Migrator) applied() (map[int]schemaMigration, error) {
+	applied := map[int]schemaMigration{}
+	if !m.db.Migrator().HasTable(&schemaMigration{}) {
+		return applied, nil
+	}
+	var rows []schemaMigration
+	if err := m.db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// locked выполняет fn на одном соединении под advisory-блокировкой.
+// Блокировка сессионная, поэтому соединение нельзя отдавать в пул до её снятия.
+func (m *Migrator) locked(fn func(conn *gorm.DB) error) error {
+	return m.db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("SELECT pg_advisory_lock(?)", migrationLockKey).Error; err != nil {
+			return err
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", migrationLockKey)
+		if err := conn.Exec(createMigrationsTableSQL).Error; err != nil {
+			return err
+		}
+		return fn(conn)
+	})
+}
+
+func currentVersion(conn *gorm.DB) (int, error) {
+	var v int
+	err := conn.Raw("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&v).Error
+	return v, err
+}
+
+// migrate проходит от версии from к версии to по одной миграции;
+// каждая выполняется в своей транзакции вместе с записью в schema_migrations.
+func (m *Migrator) migrate(conn *gorm.DB, from, to int) (int, error) {
+	n := 0
+	for v := from; v < to; v++ {
+		mg := m.migrations[v]
+		err := conn.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(mg.Up).Error; err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{Version: mg.Version, Name: mg.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return n, fmt.Errorf("migration %d_%s up: %w", mg.Version, mg.Name, err)
+		}
+		n++
+	}
+	for v := from; v > to; v-- {
+		if v > m.Latest() {
+			return n, fmt.Errorf("%w: database is at %d, this binary knows up to %d", ErrUnknownVersion, v, m.Latest())
+		}
+		mg := m.migrations[v-1]
+		err := conn.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(mg.Down).Error; err != nil {
+				return err
+			}
+			return tx.Where("version = ?", mg.Version).Delete(&schemaMigration{}).Error
+		})
+		if err != nil {
+			return n, fmt.Errorf("migration %d_%s down: %w", mg.Version, mg.Name, err)
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS
+	reading_events, device_positions, shelves,
+	notifications, review_comments, moderation_actions, review_votes, review_reports, reviews,
+	annotations, collection_books, collections,
+	book_files, chapters, books, authors,
+	refresh_tokens, users;
@@ -0,0 +1,281 @@
+-- Базовая схема: то, что раньше создавали AutoMigrate и schemaExtras.
+-- Всё через IF NOT EXISTS, поэтому на базе, обновлённой прежним Migrate,
+-- миграция ничего не меняет и только записывает версию. Таблицы, которые
+-- могли остаться от более ранних версий, дополняются недостающими колонками
+-- через ADD COLUMN IF NOT EXISTS. Данные здесь не трогаются: чистка и
+-- ограничения, которым она нужна, — в 0004_data_fixups.
+
+CREATE TABLE IF NOT EXISTS users (
+	id bigserial PRIMARY KEY,
+	email text NOT NULL CONSTRAINT uni_users_email UNIQUE,
+	password text,
+	name text,
+	role varchar(16) NOT NULL DEFAULT 'reader',
+	created_at timestamptz,
+	updated_at timestamptz,
+	deleted_at timestamptz
+);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role varchar(16) NOT NULL DEFAULT 'reader';
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	family_id varchar(64) NOT NULL,
+	token_hash char(64) NOT NULL,
+	expires_at timestamptz,
+	revoked_at timestamptz,
+	created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+
+CREATE TABLE IF NOT EXISTS authors (
+	id bigserial PRIMARY KEY,
+	name text NOT NULL,
+	bio text
+);
+
+CREATE TABLE IF NOT EXISTS books (
+	id bigserial PRIMARY KEY,
+	title text NOT NULL,
+	description text,
+	content text,
+	language varchar(16),
+	isbn varchar(13),
+	cover_key text,
+	author_id bigint CONSTRAINT fk_books_author REFERENCES authors (id),
+	rating_avg numeric NOT NULL DEFAULT 0,
+	rating_count bigint NOT NULL DEFAULT 0,
+	rating_1 bigint NOT NULL DEFAULT 0,
+	rating_2 bigint NOT NULL DEFAULT 0,
+	rating_3 bigint NOT NULL DEFAULT 0,
+	rating_4 bigint NOT NULL DEFAULT 0,
+	rating_5 bigint NOT NULL DEFAULT 0
+);
+ALTER TABLE books
+	ADD COLUMN IF NOT EXISTS language varchar(16),
+	ADD COLUMN IF NOT EXISTS isbn varchar(13),
+	ADD COLUMN IF NOT EXISTS cover_key text,
+	ADD COLUMN IF NOT EXISTS rating_avg numeric NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS rating_count bigint NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS rating_1 bigint NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS rating_2 bigint NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS rating_3 bigint NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS rating_4 bigint NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS rating_5 bigint NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_books_isbn ON books (isbn);
+
+CREATE TABLE IF NOT EXISTS chapters (
+	id bigserial PRIMARY KEY,
+	book_id bigint NOT NULL,
+	ordinal bigint NOT NULL,
+	title text,
+	body text,
+	word_count bigint
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_chapters_book_ordinal ON chapters (book_id, ordinal);
+
+CREATE TABLE IF NOT EXISTS book_files (
+	id bigserial PRIMARY KEY,
+	book_id bigint NOT NULL,
+	format varchar(8) NOT NULL,
+	file_name text,
+	size bigint,
+	checksum char(64) NOT NULL,
+	storage_key text NOT NULL,
+	created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_book_files_book_id ON book_files (book_id);
+
+CREATE TABLE IF NOT EXISTS collections (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	name text NOT NULL,
+	description text,
+	visibility varchar(16) NOT NULL DEFAULT 'private',
+	slug varchar(32) NOT NULL,
+	created_at timestamptz,
+	updated_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_collections_user_id ON collections (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_collections_slug ON collections (slug);
+
+CREATE TABLE IF NOT EXISTS collection_books (
+	collection_id bigint CONSTRAINT fk_collections_books REFERENCES collections (id),
+	book_id bigint CONSTRAINT fk_collection_books_book REFERENCES books (id),
+	position bigint NOT NULL,
+	added_at timestamptz,
+	PRIMARY KEY (collection_id, book_id)
+);
+
+CREATE TABLE IF NOT EXISTS annotations (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	book_id bigint NOT NULL CONSTRAINT fk_annotations_book REFERENCES books (id),
+	kind varchar(16) NOT NULL,
+	start_chapter bigint NOT NULL DEFAULT 0,
+	start_offset bigint NOT NULL DEFAULT 0,
+	end_chapter bigint NOT NULL DEFAULT 0,
+	end_offset bigint NOT NULL DEFAULT 0,
+	selected_text text,
+	note text,
+	color varchar(16),
+	created_at timestamptz,
+	updated_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_annotations_user_book ON annotations (user_id, book_id);
+
+CREATE TABLE IF NOT EXISTS reviews (
+	id bigserial PRIMARY KEY,
+	book_id bigint NOT NULL CONSTRAINT fk_books_reviews REFERENCES books (id),
+	user_id bigint NOT NULL,
+	rating bigint NOT NULL CONSTRAINT chk_reviews_rating CHECK (rating BETWEEN 1 AND 5),
+	comment text,
+	edited boolean NOT NULL DEFAULT false,
+	status varchar(16) NOT NULL DEFAULT 'published'
+		CONSTRAINT chk_reviews_status CHECK (status IN ('published', 'pending', 'rejected')),
+	moderated_at timestamptz,
+	created_at timestamptz,
+	updated_at timestamptz,
+	helpful_count bigint NOT NULL DEFAULT 0,
+	not_helpful_count bigint NOT NULL DEFAULT 0
+);
+ALTER TABLE reviews
+	ADD COLUMN IF NOT EXISTS edited boolean NOT NULL DEFAULT false,
+	ADD COLUMN IF NOT EXISTS status varchar(16) NOT NULL DEFAULT 'published',
+	ADD COLUMN IF NOT EXISTS moderated_at timestamptz,
+	ADD COLUMN IF NOT EXISTS created_at timestamptz,
+	ADD COLUMN IF NOT EXISTS updated_at timestamptz,
+	ADD COLUMN IF NOT EXISTS helpful_count bigint NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS not_helpful_count bigint NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_reviews_status ON reviews (status);
+
+CREATE TABLE IF NOT EXISTS review_reports (
+	id bigserial PRIMARY KEY,
+	review_id bigint NOT NULL CONSTRAINT fk_reviews_reports REFERENCES reviews (id),
+	user_id bigint NOT NULL,
+	reason text NOT NULL,
+	created_at timestamptz
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_review_reports_review_user ON review_reports (review_id, user_id);
+
+CREATE TABLE IF NOT EXISTS review_votes (
+	review_id bigint,
+	user_id bigint,
+	helpful boolean NOT NULL,
+	created_at timestamptz,
+	updated_at timestamptz,
+	PRIMARY KEY (review_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS moderation_actions (
+	id bigserial PRIMARY KEY,
+	review_id bigint NOT NULL,
+	moderator_id bigint,
+	action varchar(16) NOT NULL,
+	from_status varchar(16) NOT NULL,
+	to_status varchar(16) NOT NULL,
+	reason text,
+	created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_moderation_actions_review_id ON moderation_actions (review_id);
+
+CREATE TABLE IF NOT EXISTS review_comments (
+	id bigserial PRIMARY KEY,
+	review_id bigint NOT NULL,
+	user_id bigint NOT NULL,
+	parent_id bigint,
+	depth bigint NOT NULL DEFAULT 0,
+	body text NOT NULL,
+	edited_at timestamptz,
+	created_at timestamptz,
+	updated_at timestamptz,
+	deleted_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_review_comments_review_id ON review_comments (review_id);
+CREATE INDEX IF NOT EXISTS idx_review_comments_parent_id ON review_comments (parent_id);
+CREATE INDEX IF NOT EXISTS idx_review_comments_deleted_at ON review_comments (deleted_at);
+
+CREATE TABLE IF NOT EXISTS notifications (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	kind varchar(32) NOT NULL,
+	actor_id bigint,
+	review_id bigint,
+	comment_id bigint,
+	read_at timestamptz,
+	created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications (user_id);
+
+CREATE TABLE IF NOT EXISTS shelves (
+	user_id bigint,
+	book_id bigint CONSTRAINT fk_shelves_book REFERENCES books (id),
+	status text CONSTRAINT chk_shelves_status
+		CHECK (status IN ('want_to_read', 'reading', 'paused', 'completed', 'abandoned')),
+	progress_chapter bigint NOT NULL DEFAULT 0,
+	progress_offset bigint NOT NULL DEFAULT 0,
+	progress_percent numeric NOT NULL DEFAULT 0,
+	started_at timestamptz,
+	finished_at timestamptz,
+	updated_at timestamptz,
+	PRIMARY KEY (user_id, book_id)
+);
+ALTER TABLE shelves
+	ADD COLUMN IF NOT EXISTS progress_chapter bigint NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS progress_offset bigint NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS progress_percent numeric NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS started_at timestamptz,
+	ADD COLUMN IF NOT EXISTS finished_at timestamptz;
+
+CREATE TABLE IF NOT EXISTS device_positions (
+	user_id bigint,
+	book_id bigint,
+	device_id varchar(64),
+	progress_chapter bigint NOT NULL DEFAULT 0,
+	progress_offset bigint NOT NULL DEFAULT 0,
+	progress_percent numeric NOT NULL DEFAULT 0,
+	client_time timestamptz,
+	updated_at timestamptz,
+	PRIMARY KEY (user_id, book_id, device_id),
+	CONSTRAINT fk_shelves_devices FOREIGN KEY (user_id, book_id) REFERENCES shelves (user_id, book_id)
+);
+
+CREATE TABLE IF NOT EXISTS reading_events (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	book_id bigint NOT NULL,
+	device_id varchar(64) NOT NULL,
+	progress_chapter bigint NOT NULL DEFAULT 0,
+	progress_offset bigint NOT NULL DEFAULT 0,
+	progress_percent numeric NOT NULL DEFAULT 0,
+	client_time timestamptz,
+	jump_back boolean,
+	applied boolean,
+	created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_reading_events_user_book ON reading_events (user_id, book_id);
+
+-- Полнотекстовый поиск: генерируемые tsvector-колонки и GIN-индексы.
+ALTER TABLE books ADD COLUMN IF NOT EXISTS search_ru tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('russian', coalesce(title, '')), 'A') ||
+	setweight(to_tsvector('russian', coalesce(description, '')), 'B') ||
+	setweight(to_tsvector('russian', coalesce(content, '')), 'C')
+) STORED;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS search_en tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+	setweight(to_tsvector('english', coalesce(description, '')), 'B') ||
+	setweight(to_tsvector('english', coalesce(content, '')), 'C')
+) STORED;
+CREATE INDEX IF NOT EXISTS idx_books_search_ru ON books USING GIN (search_ru);
+CREATE INDEX IF NOT EXISTS idx_books_search_en ON books USING GIN (search_en);
+CREATE INDEX IF NOT EXISTS idx_authors_name_search_ru ON authors USING GIN (to_tsvector('russian', name));
+CREATE INDEX IF NOT EXISTS idx_authors_name_search_en ON authors USING GIN (to_tsvector('english', name));
+
+-- Триграммы для автодополнения и поиска по аннотациям.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS idx_books_title_trgm ON books USING GIN (lower(title) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_authors_name_trgm ON authors USING GIN (lower(name) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_annotations_text_trgm ON annotations USING GIN ((selected_text || ' ' || note) gin_trgm_ops);
@@ -0,0 +1,30 @@
+-- Мягко удалённые книги и авторы после отката снова видны.
+ALTER TABLE collection_books DROP CONSTRAINT fk_collection_books_book, ADD CONSTRAINT fk_collection_books_book
+	FOREIGN KEY (book_id) REFERENCES books (id);
+ALTER TABLE annotations DROP CONSTRAINT fk_annotations_book, ADD CONSTRAINT fk_annotations_book
+	FOREIGN KEY (book_id) REFERENCES books (id);
+ALTER TABLE shelves DROP CONSTRAINT fk_shelves_book, ADD CONSTRAINT fk_shelves_book
+	FOREIGN KEY (book_id) REFERENCES books (id);
+ALTER TABLE reviews DROP CONSTRAINT fk_books_reviews, ADD CONSTRAINT fk_books_reviews
+	FOREIGN KEY (book_id) REFERENCES books (id);
+ALTER TABLE books DROP CONSTRAINT IF EXISTS fk_authors_books, DROP CONSTRAINT IF EXISTS fk_books_author,
+	ADD CONSTRAINT fk_books_author FOREIGN KEY (author_id) REFERENCES authors (id);
+ALTER TABLE reading_events DROP CONSTRAINT fk_reading_events_book;
+
+ALTER TABLE device_positions DROP CONSTRAINT fk_shelves_devices, ADD CONSTRAINT fk_shelves_devices
+	FOREIGN KEY (user_id, book_id) REFERENCES shelves (user_id, book_id);
+ALTER TABLE collection_books DROP CONSTRAINT fk_collections_books, ADD CONSTRAINT fk_collections_books
+	FOREIGN KEY (collection_id) REFERENCES collections (id);
+ALTER TABLE review_reports DROP CONSTRAINT fk_reviews_reports, ADD CONSTRAINT fk_reviews_reports
+	FOREIGN KEY (review_id) REFERENCES reviews (id);
+ALTER TABLE review_comments DROP CONSTRAINT fk_review_comments_parent;
+ALTER TABLE review_comments DROP CONSTRAINT fk_review_comments_review;
+ALTER TABLE moderation_actions DROP CONSTRAINT fk_moderation_actions_review;
+ALTER TABLE review_votes DROP CONSTRAINT fk_review_votes_review;
+ALTER TABLE book_files DROP CONSTRAINT fk_book_files_book;
+ALTER TABLE chapters DROP CONSTRAINT fk_chapters_book;
+
+DROP INDEX IF EXISTS idx_books_deleted_at;
+DROP INDEX IF EXISTS idx_authors_deleted_at;
+ALTER TABLE books DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE authors DROP COLUMN IF EXISTS deleted_at;
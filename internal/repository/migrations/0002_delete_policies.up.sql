@@ -0,0 +1,52 @@
+-- Книги и авторы удаляются мягко: полки, отзывы и аннотации читателей
+-- продолжают ссылаться на существующую строку.
+ALTER TABLE authors ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+CREATE INDEX IF NOT EXISTS idx_authors_deleted_at ON authors (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_books_deleted_at ON books (deleted_at);
+
+-- Строки, осиротевшие после удалений без ограничений: без этого
+-- внешние ключи ниже не создать.
+DELETE FROM chapters WHERE book_id NOT IN (SELECT id FROM books);
+DELETE FROM book_files WHERE book_id NOT IN (SELECT id FROM books);
+DELETE FROM reading_events WHERE book_id NOT IN (SELECT id FROM books);
+DELETE FROM review_votes WHERE review_id NOT IN (SELECT id FROM reviews);
+DELETE FROM moderation_actions WHERE review_id NOT IN (SELECT id FROM reviews);
+DELETE FROM review_comments WHERE review_id NOT IN (SELECT id FROM reviews);
+
+-- Данные самой книги и отзыва удаляются вместе с ними.
+ALTER TABLE chapters ADD CONSTRAINT fk_chapters_book
+	FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE;
+ALTER TABLE book_files ADD CONSTRAINT fk_book_files_book
+	FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE;
+ALTER TABLE review_votes ADD CONSTRAINT fk_review_votes_review
+	FOREIGN KEY (review_id) REFERENCES reviews (id) ON DELETE CASCADE;
+ALTER TABLE moderation_actions ADD CONSTRAINT fk_moderation_actions_review
+	FOREIGN KEY (review_id) REFERENCES reviews (id) ON DELETE CASCADE;
+ALTER TABLE review_comments ADD CONSTRAINT fk_review_comments_review
+	FOREIGN KEY (review_id) REFERENCES reviews (id) ON DELETE CASCADE;
+ALTER TABLE review_comments ADD CONSTRAINT fk_review_comments_parent
+	FOREIGN KEY (parent_id) REFERENCES review_comments (id) ON DELETE CASCADE;
+ALTER TABLE review_reports DROP CONSTRAINT fk_reviews_reports, ADD CONSTRAINT fk_reviews_reports
+	FOREIGN KEY (review_id) REFERENCES reviews (id) ON DELETE CASCADE;
+ALTER TABLE collection_books DROP CONSTRAINT fk_collections_books, ADD CONSTRAINT fk_collections_books
+	FOREIGN KEY (collection_id) REFERENCES collections (id) ON DELETE CASCADE;
+ALTER TABLE device_positions DROP CONSTRAINT fk_shelves_devices, ADD CONSTRAINT fk_shelves_devices
+	FOREIGN KEY (user_id, book_id) REFERENCES shelves (user_id, book_id) ON DELETE CASCADE;
+
+-- Данные читателей и книги автора удаление не трогает: сервис решает, что с ними
+-- делать, по политике удаления, а физическое удаление книги или автора с такими
+-- записями база отклонит.
+ALTER TABLE reading_events ADD CONSTRAINT fk_reading_events_book
+	FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE RESTRICT;
+-- AutoMigrate называл ключ книги на автора по связи Author.Books: fk_authors_books.
+ALTER TABLE books DROP CONSTRAINT IF EXISTS fk_authors_books, DROP CONSTRAINT IF EXISTS fk_books_author,
+	ADD CONSTRAINT fk_books_author FOREIGN KEY (author_id) REFERENCES authors (id) ON DELETE RESTRICT;
+ALTER TABLE reviews DROP CONSTRAINT fk_books_reviews, ADD CONSTRAINT fk_books_reviews
+	FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE RESTRICT;
+ALTER TABLE shelves DROP CONSTRAINT fk_shelves_book, ADD CONSTRAINT fk_shelves_book
+	FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE RESTRICT;
+ALTER TABLE annotations DROP CONSTRAINT fk_annotations_book, ADD CONSTRAINT fk_annotations_book
+	FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE RESTRICT;
+ALTER TABLE collection_books DROP CONSTRAINT fk_collection_books_book, ADD CONSTRAINT fk_collection_books_book
+	FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE RESTRICT;
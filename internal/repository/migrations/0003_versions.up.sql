@@ -0,0 +1,4 @@
+-- Версия книги и автора для оптимистичной блокировки: растёт при каждом
+-- изменении и отдаётся клиенту в ETag.
+ALTER TABLE books ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 1;
+ALTER TABLE authors ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 1;
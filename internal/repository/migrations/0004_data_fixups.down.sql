@@ -0,0 +1,2 @@
+-- Удалённые дубли и исправленные данные не восстановить. Индекс и ограничения
+-- остаются, как и на базе, где их создала 0001.
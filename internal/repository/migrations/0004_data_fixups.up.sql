@@ -0,0 +1,39 @@
+-- Чистка данных, оставшихся с версий до ограничений: на базе, где
+-- ограничения уже были, каждый запрос ничего не находит.
+
+-- Из повторных отзывов остаётся самый поздний, оценки вне 1..5 прижимаются к границам.
+DELETE FROM reviews a USING reviews b
+WHERE a.book_id = b.book_id AND a.user_id = b.user_id AND a.id < b.id;
+UPDATE reviews SET rating = LEAST(GREATEST(rating, 1), 5) WHERE rating < 1 OR rating > 5;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reviews_book_user ON reviews (book_id, user_id);
+
+-- Статусы с опечатками, сохранённые до появления перечня, восстанавливаем по прогрессу.
+UPDATE shelves SET status = CASE
+	WHEN progress_percent >= 100 THEN 'completed'
+	WHEN progress_percent > 0 THEN 'reading'
+	ELSE 'want_to_read' END
+WHERE status IS NULL OR status NOT IN ('want_to_read', 'reading', 'paused', 'completed', 'abandoned');
+
+-- На новой базе ограничения создаёт 0001, на старой их могло не быть.
+DO $$ BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = 'reviews'::regclass AND conname = 'chk_reviews_rating') THEN
+		ALTER TABLE reviews ADD CONSTRAINT chk_reviews_rating CHECK (rating BETWEEN 1 AND 5);
+	END IF;
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = 'reviews'::regclass AND conname = 'chk_reviews_status') THEN
+		ALTER TABLE reviews ADD CONSTRAINT chk_reviews_status
+			CHECK (status IN ('published', 'pending', 'rejected'));
+	END IF;
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = 'shelves'::regclass AND conname = 'chk_shelves_status') THEN
+		ALTER TABLE shelves ADD CONSTRAINT chk_shelves_status
+			CHECK (status IN ('want_to_read', 'reading', 'paused', 'completed', 'abandoned'));
+	END IF;
+END $$;
+
+-- Сводку оценок считаем заново: на базе, где колонки добавила 0001, в них нули,
+-- а отзывы могли поменяться после чистки выше.
+UPDATE books SET (rating_count, rating_avg, rating_1, rating_2, rating_3, rating_4, rating_5) = (
+	SELECT COUNT(*), COALESCE(ROUND(AVG(rating), 2), 0),
+		COUNT(*) FILTER (WHERE rating = 1), COUNT(*) FILTER (WHERE rating = 2),
+		COUNT(*) FILTER (WHERE rating = 3), COUNT(*) FILTER (WHERE rating = 4),
+		COUNT(*) FILTER (WHERE rating = 5)
+	FROM reviews WHERE reviews.book_id = books.id AND reviews.status = 'published');
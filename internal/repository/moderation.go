@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"E-book-service/internal/domain"
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CreateReviewReport сохраняет жалобу и возвращает, сколько жалоб на отзыв подано
+// после since (после последнего решения модератора); nil — за всё время.
+func (r *postgresRepository) CreateReviewReport(ctx context.Context, rep *domain.ReviewReport, since *time.Time) (int64, error) {
+	var n int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(rep).Error; err != nil {
+			return err
+		}
+		q := tx.Model(&domain.ReviewReport{}).Where("review_id = ?", rep.ReviewID)
+		if since != nil {
+			q = q.Where("created_at > ?", *since)
+		}
+		return q.Count(&n).Error
+	})
+	return n, err
+}
+
+// GetModerationQueue — отзывы на модерации вместе с жалобами, по умолчанию старые первыми.
+func (r *postgresRepository) GetModerationQueue(ctx context.Context, lq domain.ListQuery) (domain.Page[domain.Review], error) {
+	order, err := orderClause(lq.Sort, reviewSorts, "reviews.id", "id")
+	if err != nil {
+		return domain.Page[domain.Review]{}, err
+	}
+	q := r.db.WithContext(ctx).Model(&domain.Review{}).Where("reviews.status = ?", domain.ReviewPending)
+	return paginate[domain.Review](q, lq, order, "Reports")
+}
+
+// ModerateReview меняет статус отзыва и пишет действие в журнал в одной
+// транзакции; сводка оценок книги пересчитывается там же. Статус меняется,
+// только если он всё ещё action.FromStatus, иначе — ErrReviewStatusChanged:
+// из двух одновременных решений применяется одно.
+func (r *postgresRepository) ModerateReview(ctx context.Context, re *domain.Review, action *domain.ModerationAction) error {
+	return r.withBookRating(ctx, re.BookID, func(tx *gorm.DB) error {
+		res := tx.Model(re).Where("status = ?", action.FromStatus).Select("status", "moderated_at").Updates(map[string]interface{}{
+			"status":       re.Status,
+			"moderated_at": re.ModeratedAt,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return domain.ErrReviewStatusChanged
+		}
+		return tx.Create(action).Error
+	})
+}
+
+func (r *postgresRepository) CreateModerationAction(ctx context.Context, a *domain.ModerationAction) error {
+	return r.db.WithContext(ctx).Create(a).Error
+}
+
+func (r *postgresRepository) GetModerationActions(ctx context.Context, reviewID uint) ([]domain.ModerationAction, error) {
+	var a []domain.ModerationAction
+	return a, r.db.WithContext(ctx).Where("review_id = ?", reviewID).Order("id").Find(&a).Error
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"E-book-service/internal/domain"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// sortFields сопоставляет имя поля из API с SQL-выражением для ORDER BY.
+type sortFields map[string]string
+
+// orderClause строит ORDER BY по полю из запроса. Вторым ключом всегда идёт id,
+// чтобы порядок был стабильным и страницы не пересекались.
+func orderClause(sort string, fields sortFields, idColumn, fallback string) (string, error) {
+	if sort == "" {
+		sort = fallback
+	}
+	dir := "ASC"
+	if strings.HasPrefix(sort, "-") {
+		dir = "DESC"
+		sort = sort[1:]
+	}
+	expr, ok := fields[sort]
+	if !ok {
+		return "", domain.ErrInvalidSort
+	}
+	if expr == idColumn {
+		return expr + " " + dir, nil
+	}
+	return expr + " " + dir + ", " + idColumn + " " + dir, nil
+}
+
+// paginate считает общее число строк запроса q и выбирает одну страницу.
+// Предзагрузка связей применяется только к выборке, а не к COUNT.
+func paginate[T any](q *gorm.DB, lq domain.ListQuery, order string, preload ...string) (domain.Page[T], error) {
+	page := domain.Page[T]{Items: []T{}}
+	offset, err := domain.DecodeCursor(lq.Cursor)
+	if err != nil {
+		return page, err
+	}
+	if err := q.Session(&gorm.Session{}).Count(&page.Total).Error; err != nil {
+		return page, err
+	}
+
+	limit := lq.PageSize()
+	find := q.Session(&gorm.Session{}).Order(order).Limit(limit + 1).Offset(offset)
+	for _, p := range preload {
+		find = find.Preload(p)
+	}
+	if err := find.Find(&page.Items).Error; err != nil {
+		return page, err
+	}
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.NextCursor = domain.EncodeCursor(offset + limit)
+	}
+	return page, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// likePrefix экранирует спецсимволы LIKE, чтобы "100%" искалось буквально.
+func likePrefix(s string) string {
+	return likeEscaper.Replace(s) + "%"
+}
+
+// likeContains — то же для поиска подстроки.
+func likeContains(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"context"
+	"gorm.io/gorm"
+)
+
+// ratingColumns пересчитываются только здесь; CreateBook и UpdateBook их не пишут.
+var ratingColumns = []string{"rating_avg", "rating_count", "rating_1", "rating_2", "rating_3", "rating_4", "rating_5"}
+
+// refreshRatingsSQL пересчитывает сводку оценок по опубликованным отзывам.
+// Агрегат без GROUP BY всегда даёт строку, поэтому книга без отзывов получает нули.
+const refreshRatingsSQL = `UPDATE books SET (rating_count, rating_avg, rating_1, rating_2, rating_3, rating_4, rating_5) = (
+	SELECT COUNT(*), COALESCE(ROUND(AVG(rating), 2), 0),
+		COUNT(*) FILTER (WHERE rating = 1), COUNT(*) FILTER (WHERE rating = 2),
+		COUNT(*) FILTER (WHERE rating = 3), COUNT(*) FILTER (WHERE rating = 4),
+		COUNT(*) FILTER (WHERE rating = 5)
+	FROM reviews WHERE reviews.book_id = books.id AND reviews.status = 'published')`
+
+// withBookRating выполняет fn и пересчитывает сводку оценок книги в одной транзакции.
+// Строка книги блокируется заранее: параллельные изменения отзывов одной книги
+// идут по очереди, и каждый пересчёт видит отзывы, закоммиченные до него.
+func (r *postgresRepository) withBookRating(ctx context.Context, bookID uint, fn func(tx *gorm.DB) error) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT id FROM books WHERE id = ? FOR UPDATE", bookID).Error; err != nil {
+			return err
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.Exec(refreshRatingsSQL+" WHERE books.id = ?", bookID).Error
+	})
+}
+
+// RecomputeRatings пересчитывает сводку оценок всех книг и возвращает число книг.
+func (r *postgresRepository) RecomputeRatings(ctx context.Context) (int64, error) {
+	res := r.db.WithContext(ctx).Exec(refreshRatingsSQL)
+	return res.RowsAffected, res.Error
+}
@@ -2,40 +2,121 @@ package repository
 
 import (
 	"E-book-service/internal/domain"
+	"context"
+	"errors"
+	"time"
+
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Repository interface {
+	// WithTx выполняет fn в одной транзакции: всё, что сделано через tx,
+	// фиксируется вместе или откатывается, если fn вернула ошибку.
+	WithTx(ctx context.Context, fn func(tx Repository) error) error
+
 	// Users
-	CreateUser(u *domain.User) error
-	GetUserByEmail(email string) (*domain.User, error)
-	GetUserByID(id uint) (*domain.User, error)
-	UpdateUser(u *domain.User) error
+	CreateUser(ctx context.Context, u *domain.User) error
+	GetUserByEmail(ctx context.Context, email string) (*domain.User, error)
+	GetUserByID(ctx context.Context, id uint) (*domain.User, error)
+	UpdateUser(ctx context.Context, u *domain.User) error
+	UpdateUserRole(ctx context.Context, id uint, role string) error
+
+	// Refresh tokens
+	CreateRefreshToken(ctx context.Context, t *domain.RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, hash string) (*domain.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, oldID uint, next *domain.RefreshToken) error
+	RevokeTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, uID uint) error
 
 	// Books
-	CreateBook(b *domain.Book) error
-	GetBooks() ([]domain.Book, error)
-	GetBookByID(id uint) (*domain.Book, error)
-	UpdateBook(b *domain.Book) error
-	DeleteBook(id uint) error
-	GetBooksByAuthor(aID uint) ([]domain.Book, error)
+	CreateBook(ctx context.Context, b *domain.Book) error
+	GetBooks(ctx context.Context, f domain.BookFilter) (domain.Page[domain.Book], error)
+	GetBookByID(ctx context.Context, id uint) (*domain.Book, error)
+	UpdateBook(ctx context.Context, b *domain.Book) error
+	DeleteBook(ctx context.Context, id uint, version int64) error
+	DeleteBooks(ctx context.Context, ids []uint) error
+	GetBookDependents(ctx context.Context, id uint) (domain.Dependents, error)
+	SearchBooks(ctx context.Context, q domain.SearchQuery) (domain.Page[domain.SearchHit], error)
+	Autocomplete(ctx context.Context, variants []string, limit int) (domain.Autocomplete, error)
+
+	// Book files
+	ReplaceChapters(ctx context.Context, bookID uint, chapters []domain.Chapter) error
+	GetChapters(ctx context.Context, bookID uint) ([]domain.Chapter, error)
+	GetChapter(ctx context.Context, bookID uint, ordinal int) (*domain.Chapter, error)
+	GetBooksWithoutChapters(ctx context.Context, afterID uint, limit int) ([]domain.Book, error)
+	CreateBookFile(ctx context.Context, f *domain.BookFile) error
+	GetBookFiles(ctx context.Context, bookID uint) ([]domain.BookFile, error)
+	GetBookFile(ctx context.Context, bookID, id uint) (*domain.BookFile, error)
 
 	// Authors
-	CreateAuthor(a *domain.Author) error
-	GetAuthors() ([]domain.Author, error)
-	GetAuthorByID(id uint) (*domain.Author, error)
-	UpdateAuthor(a *domain.Author) error
-	DeleteAuthor(id uint) error
+	CreateAuthor(ctx context.Context, a *domain.Author) error
+	GetAuthors(ctx context.Context, f domain.AuthorFilter) (domain.Page[domain.Author], error)
+	GetAuthorByID(ctx context.Context, id uint) (*domain.Author, error)
+	GetAuthorByName(ctx context.Context, name string) (*domain.Author, error)
+	UpdateAuthor(ctx context.Context, a *domain.Author) error
+	DeleteAuthor(ctx context.Context, id uint, version int64) error
+	GetAuthorDependents(ctx context.Context, id uint) (domain.Dependents, error)
+	ReassignBooks(ctx context.Context, fromAuthorID, toAuthorID uint) error
 
 	// Reviews
-	CreateReview(re *domain.Review) error
-	GetReviewsByBook(bookID uint) ([]domain.Review, error)
-	DeleteReview(id, uID uint) error
+	CreateReview(ctx context.Context, re *domain.Review) error
+	GetReviewsByBook(ctx context.Context, bookID uint, q domain.ListQuery) (domain.Page[domain.Review], error)
+	RecomputeRatings(ctx context.Context) (int64, error)
+	SaveReviewVote(ctx context.Context, v *domain.ReviewVote) error
+	DeleteReviewVote(ctx context.Context, reviewID, uID uint) error
+	GetReview(ctx context.Context, id uint) (*domain.Review, error)
+	UpdateReview(ctx context.Context, re *domain.Review) error
+	DeleteReview(ctx context.Context, id, uID uint) error
+
+	// Review comments
+	CreateReviewComment(ctx context.Context, c *domain.ReviewComment) error
+	GetReviewComment(ctx context.Context, id uint) (*domain.ReviewComment, error)
+	GetReviewComments(ctx context.Context, reviewID uint) ([]domain.ReviewComment, error)
+	UpdateReviewComment(ctx context.Context, c *domain.ReviewComment) error
+	DeleteReviewComment(ctx context.Context, id uint) error
+
+	// Notifications
+	CreateNotification(ctx context.Context, n *domain.Notification) error
+	GetNotifications(ctx context.Context, uID uint, unreadOnly bool, q domain.ListQuery) (domain.Page[domain.Notification], error)
+	MarkNotificationRead(ctx context.Context, id, uID uint) error
+
+	// Moderation
+	CreateReviewReport(ctx context.Context, rep *domain.ReviewReport, since *time.Time) (int64, error)
+	GetModerationQueue(ctx context.Context, q domain.ListQuery) (domain.Page[domain.Review], error)
+	ModerateReview(ctx context.Context, re *domain.Review, action *domain.ModerationAction) error
+	CreateModerationAction(ctx context.Context, a *domain.ModerationAction) error
+	GetModerationActions(ctx context.Context, reviewID uint) ([]domain.ModerationAction, error)
+
+	// Collections
+	CreateCollection(ctx context.Context, c *domain.Collection) error
+	GetCollections(ctx context.Context, uID uint, onlyPublic bool) ([]domain.Collection, error)
+	GetCollection(ctx context.Context, id uint) (*domain.Collection, error)
+	GetCollectionBySlug(ctx context.Context, slug string) (*domain.Collection, error)
+	UpdateCollection(ctx context.Context, c *domain.Collection) error
+	DeleteCollection(ctx context.Context, id uint) error
+	AddCollectionBook(ctx context.Context, cb *domain.CollectionBook) error
+	RemoveCollectionBook(ctx context.Context, collectionID, bookID uint) error
+	ReorderCollection(ctx context.Context, collectionID uint, bookIDs []uint) error
+
+	// Annotations
+	CreateAnnotation(ctx context.Context, a *domain.Annotation) error
+	GetAnnotations(ctx context.Context, uID uint, f domain.AnnotationFilter) (domain.Page[domain.Annotation], error)
+	GetAnnotation(ctx context.Context, id uint) (*domain.Annotation, error)
+	UpdateAnnotation(ctx context.Context, a *domain.Annotation) error
+	DeleteAnnotation(ctx context.Context, id uint) error
+	ExportAnnotations(ctx context.Context, uID, bookID uint) ([]domain.Annotation, error)
 
 	// Shelf
-	AddToShelf(s *domain.Shelf) error
-	GetShelf(uID uint) ([]domain.Shelf, error)
-	RemoveFromShelf(uID, bID uint) error
+	AddToShelf(ctx context.Context, s *domain.Shelf) error
+	GetShelf(ctx context.Context, uID uint) ([]domain.Shelf, error)
+	GetShelfEntry(ctx context.Context, uID, bID uint) (*domain.Shelf, error)
+	LockShelfEntry(ctx context.Context, uID, bID uint) (*domain.Shelf, error)
+	GetDevicePosition(ctx context.Context, uID, bID uint, deviceID string) (*domain.DevicePosition, error)
+	GetDevicePositions(ctx context.Context, uID, bID uint) ([]domain.DevicePosition, error)
+	SaveDevicePosition(ctx context.Context, p *domain.DevicePosition) error
+	CreateReadingEvent(ctx context.Context, e *domain.ReadingEvent) error
+	RemoveFromShelf(ctx context.Context, uID, bID uint) error
 }
 
 type postgresRepository struct {
@@ -46,61 +127,413 @@ func NewRepository(db *gorm.DB) Repository {
 	return &postgresRepository{db: db}
 }
 
-func (r *postgresRepository) CreateUser(u *domain.User) error { return r.db.Create(u).Error }
-func (r *postgresRepository) GetUserByEmail(email string) (*domain.User, error) {
+// WithTx открывает транзакцию; внутри уже открытой транзакции gorm ставит точку сохранения.
+func (r *postgresRepository) WithTx(ctx context.Context, fn func(tx Repository) error) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&postgresRepository{db: tx})
+	})
+}
+
+func (r *postgresRepository) CreateUser(ctx context.Context, u *domain.User) error {
+	return r.db.WithContext(ctx).Create(u).Error
+}
+func (r *postgresRepository) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
 	var u domain.User
-	return &u, r.db.Where("email = ?", email).First(&u).Error
+	return &u, r.db.WithContext(ctx).Where("email = ?", email).First(&u).Error
 }
-func (r *postgresRepository) GetUserByID(id uint) (*domain.User, error) {
+func (r *postgresRepository) GetUserByID(ctx context.Context, id uint) (*domain.User, error) {
 	var u domain.User
-	return &u, r.db.First(&u, id).Error
+	return &u, r.db.WithContext(ctx).First(&u, id).Error
 }
-func (r *postgresRepository) UpdateUser(u *domain.User) error { return r.db.Save(u).Error }
 
-func (r *postgresRepository) CreateBook(b *domain.Book) error { return r.db.Create(b).Error }
-func (r *postgresRepository) GetBooks() ([]domain.Book, error) {
-	var b []domain.Book
-	return b, r.db.Preload("Author").Find(&b).Error
+// UpdateUser сохраняет только имя и email: роль меняется только через
+// UpdateUserRole, пароль и прочие поля так тоже не меняются.
+func (r *postgresRepository) UpdateUser(ctx context.Context, u *domain.User) error {
+	return r.db.WithContext(ctx).Model(u).Select("name", "email").Updates(u).Error
+}
+func (r *postgresRepository) UpdateUserRole(ctx context.Context, id uint, role string) error {
+	res := r.db.WithContext(ctx).Model(&domain.User{}).Where("id = ?", id).Update("role", role)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *postgresRepository) CreateRefreshToken(ctx context.Context, t *domain.RefreshToken) error {
+	return r.db.WithContext(ctx).Create(t).Error
+}
+func (r *postgresRepository) GetRefreshTokenByHash(ctx context.Context, hash string) (*domain.RefreshToken, error) {
+	var t domain.RefreshToken
+	return &t, r.db.WithContext(ctx).Where("token_hash = ?", hash).First(&t).Error
+}
+
+// RotateRefreshToken отзывает старый токен и сохраняет следующий в одной транзакции.
+// Если старый токен уже отозван (например, параллельным запросом), возвращает
+// gorm.ErrRecordNotFound и ничего не создаёт.
+func (r *postgresRepository) RotateRefreshToken(ctx context.Context, oldID uint, next *domain.RefreshToken) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&domain.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", oldID).
+			Update("revoked_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Create(next).Error
+	})
+}
+func (r *postgresRepository) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	return r.db.WithContext(ctx).Model(&domain.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+func (r *postgresRepository) RevokeUserRefreshTokens(ctx context.Context, uID uint) error {
+	return r.db.WithContext(ctx).Model(&domain.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", uID).
+		Update("revoked_at", time.Now()).Error
 }
-func (r *postgresRepository) GetBookByID(id uint) (*domain.Book, error) {
+
+func (r *postgresRepository) CreateBook(ctx context.Context, b *domain.Book) error {
+	return r.db.WithContext(ctx).Omit(ratingColumns...).Create(b).Error
+}
+
+var bookSorts = sortFields{
+	"id":           "books.id",
+	"title":        "books.title",
+	"rating":       "books.rating_avg",
+	"rating_count": "books.rating_count",
+}
+
+func (r *postgresRepository) GetBooks(ctx context.Context, f domain.BookFilter) (domain.Page[domain.Book], error) {
+	order, err := orderClause(f.Sort, bookSorts, "books.id", "id")
+	if err != nil {
+		return domain.Page[domain.Book]{}, err
+	}
+	// Текст книги в списке не нужен, а весит больше всех остальных полей:
+	// он отдаётся только по GET /books/{id} и по главам.
+	q := r.db.WithContext(ctx).Model(&domain.Book{}).Omit("content")
+	if f.AuthorID != 0 {
+		q = q.Where("books.author_id = ?", f.AuthorID)
+	}
+	if f.TitlePrefix != "" {
+		q = q.Where("books.title ILIKE ?", likePrefix(f.TitlePrefix))
+	}
+	if f.MinRating > 0 {
+		q = q.Where("books.rating_avg >= ?", f.MinRating)
+	}
+	return paginate[domain.Book](q, f.ListQuery, order, "Author")
+}
+func (r *postgresRepository) GetBookByID(ctx context.Context, id uint) (*domain.Book, error) {
 	var b domain.Book
-	return &b, r.db.Preload("Author").First(&b, id).Error
+	return &b, r.db.WithContext(ctx).Preload("Author").First(&b, id).Error
+}
+
+// updateVersioned меняет строку, только если её версия всё ещё *version, и
+// увеличивает версию. Иначе — domain.ErrVersionMismatch.
+func updateVersioned(db *gorm.DB, model interface{}, id uint, version *int64, fields map[string]interface{}) error {
+	fields["version"] = gorm.Expr("version + 1")
+	res := db.Model(model).Where("id = ? AND version = ?", id, *version).Updates(fields)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrVersionMismatch
+	}
+	*version++
+	return nil
+}
+
+// UpdateBook сохраняет поля книги версии b.Version. Сводку оценок
+// и удалённые книги не трогает.
+func (r *postgresRepository) UpdateBook(ctx context.Context, b *domain.Book) error {
+	return updateVersioned(r.db.WithContext(ctx), &domain.Book{}, b.ID, &b.Version, map[string]interface{}{
+		"title":       b.Title,
+		"description": b.Description,
+		"content":     b.Content,
+		"language":    b.Language,
+		"isbn":        b.ISBN,
+		"cover_key":   b.CoverKey,
+		"author_id":   b.AuthorID,
+	})
+}
+
+// deleteVersioned мягко удаляет строку версии version; 0 — любой версии.
+// Если строку успели изменить или удалить, возвращает ErrVersionMismatch.
+func deleteVersioned(db *gorm.DB, model interface{}, id uint, version int64) error {
+	if version != 0 {
+		db = db.Where("version = ?", version)
+	}
+	res := db.Delete(model, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrVersionMismatch
+	}
+	return nil
+}
+
+// DeleteBook мягко удаляет книгу версии version и убирает её из подборок.
+func (r *postgresRepository) DeleteBook(ctx context.Context, id uint, version int64) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := deleteVersioned(tx, &domain.Book{}, id, version); err != nil {
+			return err
+		}
+		return tx.Where("book_id = ?", id).Delete(&domain.CollectionBook{}).Error
+	})
+}
+
+// DeleteBooks мягко удаляет книги и убирает их из подборок. Полки, отзывы
+// и аннотации читателей остаются и продолжают ссылаться на книгу.
+func (r *postgresRepository) DeleteBooks(ctx context.Context, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("book_id IN ?", ids).Delete(&domain.CollectionBook{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&domain.Book{}, ids).Error
+	})
+}
+
+// GetBookDependents считает записи читателей, которые ссылаются на книгу.
+func (r *postgresRepository) GetBookDependents(ctx context.Context, id uint) (domain.Dependents, error) {
+	var d domain.Dependents
+	db := r.db.WithContext(ctx)
+	counts := []struct {
+		model interface{}
+		dst   *int64
+	}{
+		{&domain.Review{}, &d.Reviews},
+		{&domain.Shelf{}, &d.ShelfEntries},
+		{&domain.CollectionBook{}, &d.Collections},
+		{&domain.Annotation{}, &d.Annotations},
+	}
+	for _, c := range counts {
+		if err := db.Model(c.model).Where("book_id = ?", id).Count(c.dst).Error; err != nil {
+			return d, err
+		}
+	}
+	return d, nil
+}
+
+// ReplaceChapters атомарно заменяет главы книги новым набором.
+func (r *postgresRepository) ReplaceChapters(ctx context.Context, bookID uint, chapters []domain.Chapter) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("book_id = ?", bookID).Delete(&domain.Chapter{}).Error; err != nil {
+			return err
+		}
+		if len(chapters) == 0 {
+			return nil
+		}
+		return tx.Create(&chapters).Error
+	})
 }
-func (r *postgresRepository) UpdateBook(b *domain.Book) error { return r.db.Save(b).Error }
-func (r *postgresRepository) DeleteBook(id uint) error        { return r.db.Delete(&domain.Book{}, id).Error }
-func (r *postgresRepository) GetBooksByAuthor(aID uint) ([]domain.Book, error) {
+
+// liveBookChapters ограничивает главы книгами, которые не удалены.
+const liveBookChapters = "JOIN books ON books.id = chapters.book_id AND books.deleted_at IS NULL"
+
+// GetChapters возвращает оглавление: главы без текста.
+func (r *postgresRepository) GetChapters(ctx context.Context, bookID uint) ([]domain.Chapter, error) {
+	var c []domain.Chapter
+	return c, r.db.WithContext(ctx).Omit("body").Joins(liveBookChapters).
+		Where("chapters.book_id = ?", bookID).Order("chapters.ordinal").Find(&c).Error
+}
+func (r *postgresRepository) GetChapter(ctx context.Context, bookID uint, ordinal int) (*domain.Chapter, error) {
+	var c domain.Chapter
+	return &c, r.db.WithContext(ctx).Joins(liveBookChapters).
+		Where("chapters.book_id = ? AND chapters.ordinal = ?", bookID, ordinal).First(&c).Error
+}
+
+// nonBlankContent совпадает с текстом, в котором есть хоть один непробельный
+// символ. Класс перечисляет те же символы, что unicode.IsSpace (по нему
+// strings.TrimSpace в splitChapters решает, что текст пуст): [[:space:]]
+// зависит от локали базы и может не включать NBSP и другие пробелы Unicode.
+const nonBlankContent = `[^\t\n\v\f\r \u0085\u00a0\u1680\u2000-\u200a\u2028\u2029\u202f\u205f\u3000]`
+
+// GetBooksWithoutChapters находит книги с текстом, который ещё не разбит на главы,
+// по возрастанию id начиная с книги после afterID.
+func (r *postgresRepository) GetBooksWithoutChapters(ctx context.Context, afterID uint, limit int) ([]domain.Book, error) {
 	var b []domain.Book
-	return b, r.db.Where("author_id = ?", aID).Find(&b).Error
+	return b, r.db.WithContext(ctx).
+		Where("books.id > ?", afterID).
+		Where("books.content ~ ?", nonBlankContent).
+		Where("NOT EXISTS (SELECT 1 FROM chapters WHERE chapters.book_id = books.id)").
+		Order("books.id").Limit(limit).Find(&b).Error
 }
 
-func (r *postgresRepository) CreateAuthor(a *domain.Author) error { return r.db.Create(a).Error }
-func (r *postgresRepository) GetAuthors() ([]domain.Author, error) {
-	var a []domain.Author
-	return a, r.db.Find(&a).Error
+func (r *postgresRepository) CreateBookFile(ctx context.Context, f *domain.BookFile) error {
+	return r.db.WithContext(ctx).Create(f).Error
 }
-func (r *postgresRepository) GetAuthorByID(id uint) (*domain.Author, error) {
+func (r *postgresRepository) GetBookFiles(ctx context.Context, bookID uint) ([]domain.BookFile, error) {
+	var f []domain.BookFile
+	return f, r.db.WithContext(ctx).Where("book_id = ?", bookID).Order("id").Find(&f).Error
+}
+func (r *postgresRepository) GetBookFile(ctx context.Context, bookID, id uint) (*domain.BookFile, error) {
+	var f domain.BookFile
+	return &f, r.db.WithContext(ctx).Where("book_id = ?", bookID).First(&f, id).Error
+}
+
+func (r *postgresRepository) CreateAuthor(ctx context.Context, a *domain.Author) error {
+	return r.db.WithContext(ctx).Create(a).Error
+}
+
+var authorSorts = sortFields{"id": "authors.id", "name": "authors.name"}
+
+func (r *postgresRepository) GetAuthors(ctx context.Context, f domain.AuthorFilter) (domain.Page[domain.Author], error) {
+	order, err := orderClause(f.Sort, authorSorts, "authors.id", "id")
+	if err != nil {
+		return domain.Page[domain.Author]{}, err
+	}
+	q := r.db.WithContext(ctx).Model(&domain.Author{})
+	if f.NamePrefix != "" {
+		q = q.Where("authors.name ILIKE ?", likePrefix(f.NamePrefix))
+	}
+	return paginate[domain.Author](q, f.ListQuery, order)
+}
+func (r *postgresRepository) GetAuthorByID(ctx context.Context, id uint) (*domain.Author, error) {
 	var a domain.Author
-	return &a, r.db.First(&a, id).Error
+	return &a, r.db.WithContext(ctx).First(&a, id).Error
+}
+func (r *postgresRepository) GetAuthorByName(ctx context.Context, name string) (*domain.Author, error) {
+	var a domain.Author
+	return &a, r.db.WithContext(ctx).Where("lower(name) = lower(?)", name).Order("id").First(&a).Error
+}
+func (r *postgresRepository) UpdateAuthor(ctx context.Context, a *domain.Author) error {
+	return updateVersioned(r.db.WithContext(ctx), &domain.Author{}, a.ID, &a.Version, map[string]interface{}{
+		"name": a.Name,
+		"bio":  a.Bio,
+	})
 }
-func (r *postgresRepository) UpdateAuthor(a *domain.Author) error { return r.db.Save(a).Error }
-func (r *postgresRepository) DeleteAuthor(id uint) error {
-	return r.db.Delete(&domain.Author{}, id).Error
+
+// DeleteAuthor мягко удаляет автора версии version.
+func (r *postgresRepository) DeleteAuthor(ctx context.Context, id uint, version int64) error {
+	return deleteVersioned(r.db.WithContext(ctx), &domain.Author{}, id, version)
 }
 
-func (r *postgresRepository) CreateReview(re *domain.Review) error { return r.db.Create(re).Error }
-func (r *postgresRepository) GetReviewsByBook(bookID uint) ([]domain.Review, error) {
-	var re []domain.Review
-	return re, r.db.Where("book_id = ?", bookID).Find(&re).Error
+// GetAuthorDependents перечисляет неудалённые книги автора.
+func (r *postgresRepository) GetAuthorDependents(ctx context.Context, id uint) (domain.Dependents, error) {
+	var d domain.Dependents
+	return d, r.db.WithContext(ctx).Model(&domain.Book{}).Select("id", "title").
+		Where("author_id = ?", id).Order("id").Find(&d.Books).Error
 }
-func (r *postgresRepository) DeleteReview(id, uID uint) error {
-	return r.db.Where("id = ? AND user_id = ?", id, uID).Delete(&domain.Review{}).Error
+
+// ReassignBooks передаёт книги другому автору. Книги меняются, поэтому их версии растут.
+func (r *postgresRepository) ReassignBooks(ctx context.Context, fromAuthorID, toAuthorID uint) error {
+	return r.db.WithContext(ctx).Model(&domain.Book{}).Where("author_id = ?", fromAuthorID).
+		Updates(map[string]interface{}{"author_id": toAuthorID, "version": gorm.Expr("version + 1")}).Error
 }
 
-func (r *postgresRepository) AddToShelf(s *domain.Shelf) error { return r.db.Save(s).Error }
-func (r *postgresRepository) GetShelf(uID uint) ([]domain.Shelf, error) {
+func (r *postgresRepository) CreateReview(ctx context.Context, re *domain.Review) error {
+	return r.withBookRating(ctx, re.BookID, func(tx *gorm.DB) error { return tx.Omit(voteColumns...).Create(re).Error })
+}
+
+var reviewSorts = sortFields{
+	"id":      "reviews.id",
+	"rating":  "reviews.rating",
+	"created": "reviews.created_at",
+	"helpful": "(reviews.helpful_count - reviews.not_helpful_count)",
+}
+
+// reviewSortAliases — именованные порядки из API отзывов. Обычная запись
+// вида "-rating" тоже работает.
+var reviewSortAliases = map[string]string{
+	"helpful":     "-helpful",
+	"newest":      "-created",
+	"rating_desc": "-rating",
+	"rating_asc":  "rating",
+}
+
+// GetReviewsByBook отдаёт опубликованные отзывы, по умолчанию самые полезные первыми:
+// по разнице голосов «полезно» и «бесполезно».
+func (r *postgresRepository) GetReviewsByBook(ctx context.Context, bookID uint, lq domain.ListQuery) (domain.Page[domain.Review], error) {
+	sort := lq.Sort
+	if alias, ok := reviewSortAliases[sort]; ok {
+		sort = alias
+	}
+	order, err := orderClause(sort, reviewSorts, "reviews.id", "-helpful")
+	if err != nil {
+		return domain.Page[domain.Review]{}, err
+	}
+	q := r.db.WithContext(ctx).Model(&domain.Review{}).
+		Where("reviews.book_id = ? AND reviews.status = ?", bookID, domain.ReviewPublished)
+	return paginate[domain.Review](q, lq, order)
+}
+func (r *postgresRepository) GetReview(ctx context.Context, id uint) (*domain.Review, error) {
+	var re domain.Review
+	return &re, r.db.WithContext(ctx).First(&re, id).Error
+}
+
+// UpdateReview сохраняет правку отзыва. Счётчики голосов и статус модерации
+// не пишутся: правка, прочитавшая отзыв раньше, не должна откатить голоса
+// или решение модератора.
+func (r *postgresRepository) UpdateReview(ctx context.Context, re *domain.Review) error {
+	return r.withBookRating(ctx, re.BookID, func(tx *gorm.DB) error {
+		return tx.Omit(append([]string{"status", "moderated_at"}, voteColumns...)...).Save(re).Error
+	})
+}
+
+// DeleteReview удаляет отзыв пользователя; чужой или уже удалённый отзыв — не ошибка.
+func (r *postgresRepository) DeleteReview(ctx context.Context, id, uID uint) error {
+	var re domain.Review
+	err := r.db.WithContext(ctx).Select("id", "book_id").Where("id = ? AND user_id = ?", id, uID).First(&re).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return r.withBookRating(ctx, re.BookID, func(tx *gorm.DB) error {
+		return tx.Where("id = ? AND user_id = ?", id, uID).Delete(&domain.Review{}).Error
+	})
+}
+
+func (r *postgresRepository) AddToShelf(ctx context.Context, s *domain.Shelf) error {
+	return r.db.WithContext(ctx).Save(s).Error
+}
+
+// unscoped подгружает и мягко удалённые строки: книга, убранная из каталога,
+// остаётся видна на полке и в экспорте аннотаций.
+func unscoped(db *gorm.DB) *gorm.DB { return db.Unscoped() }
+
+func (r *postgresRepository) GetShelf(ctx context.Context, uID uint) ([]domain.Shelf, error) {
 	var s []domain.Shelf
-	return s, r.db.Preload("Book.Author").Where("user_id = ?", uID).Find(&s).Error
+	return s, r.db.WithContext(ctx).Preload("Book", unscoped).Preload("Book.Author", unscoped).Preload("Devices", func(db *gorm.DB) *gorm.DB {
+		return db.Order("updated_at DESC")
+	}).Where("user_id = ?", uID).Find(&s).Error
+}
+func (r *postgresRepository) GetShelfEntry(ctx context.Context, uID, bID uint) (*domain.Shelf, error) {
+	var s domain.Shelf
+	return &s, r.db.WithContext(ctx).Where("user_id = ? AND book_id = ?", uID, bID).First(&s).Error
+}
+
+// LockShelfEntry читает запись полки с SELECT ... FOR UPDATE: до конца транзакции
+// её не изменит никто другой. Имеет смысл только внутри WithTx.
+func (r *postgresRepository) LockShelfEntry(ctx context.Context, uID, bID uint) (*domain.Shelf, error) {
+	var s domain.Shelf
+	return &s, r.db.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND book_id = ?", uID, bID).First(&s).Error
+}
+func (r *postgresRepository) GetDevicePosition(ctx context.Context, uID, bID uint, deviceID string) (*domain.DevicePosition, error) {
+	var p domain.DevicePosition
+	return &p, r.db.WithContext(ctx).Where("user_id = ? AND book_id = ? AND device_id = ?", uID, bID, deviceID).First(&p).Error
+}
+func (r *postgresRepository) GetDevicePositions(ctx context.Context, uID, bID uint) ([]domain.DevicePosition, error) {
+	var p []domain.DevicePosition
+	return p, r.db.WithContext(ctx).Where("user_id = ? AND book_id = ?", uID, bID).Order("updated_at DESC").Find(&p).Error
+}
+func (r *postgresRepository) SaveDevicePosition(ctx context.Context, p *domain.DevicePosition) error {
+	return r.db.WithContext(ctx).Save(p).Error
+}
+func (r *postgresRepository) CreateReadingEvent(ctx context.Context, e *domain.ReadingEvent) error {
+	return r.db.WithContext(ctx).Create(e).Error
 }
-func (r *postgresRepository) RemoveFromShelf(uID, bID uint) error {
-	return r.db.Where("user_id = ? AND book_id = ?", uID, bID).Delete(&domain.Shelf{}).Error
+func (r *postgresRepository) RemoveFromShelf(ctx context.Context, uID, bID uint) error {
+	return r.db.WithContext(ctx).Where("user_id = ? AND book_id = ?", uID, bID).Delete(&domain.Shelf{}).Error
 }
@@ -2,11 +2,19 @@ package repository
 
 import (
 	"E-book-service/internal/domain"
+	"context"
+	"errors"
+	"fmt"
+	"os"
 	"regexp"
 	"testing"
+	"testing/fstest"
 	"time"
+	"unicode"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"gorm.io/driver/postgres"
@@ -41,6 +49,7 @@ func TestRepoSuite(t *testing.T) {
 // --- USERS ---
 
 func (s *RepoTestSuite) TestUsers() {
+	ctx := context.Background()
 	user := &domain.User{Email: "test@test.com", Name: "Name"}
 	user.ID = 1
 
@@ -49,33 +58,150 @@ func (s *RepoTestSuite) TestUsers() {
 	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "users"`)).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 	s.mock.ExpectCommit()
-	assert.NoError(s.T(), s.repo.CreateUser(user))
+	assert.NoError(s.T(), s.repo.CreateUser(ctx, user))
 
 	// GetUserByEmail
 	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "users" WHERE email = $1`)).
 		WithArgs("test@test.com", 1).
 		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(1, "test@test.com"))
-	_, err := s.repo.GetUserByEmail("test@test.com")
+	_, err := s.repo.GetUserByEmail(ctx, "test@test.com")
 	assert.NoError(s.T(), err)
 
 	// GetUserByID
 	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "users" WHERE "users"."id" = $1`)).
 		WithArgs(1, 1).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-	_, err = s.repo.GetUserByID(1)
+	_, err = s.repo.GetUserByID(ctx, 1)
 	assert.NoError(s.T(), err)
 
-	// UpdateUser
+	// UpdateUser: только имя и email
 	s.mock.ExpectBegin()
-	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET`)).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "email"=$1,"name"=$2,"updated_at"=$3 WHERE "users"."deleted_at" IS NULL AND "id" = $4`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 	s.mock.ExpectCommit()
-	err = s.repo.UpdateUser(user)
+	err = s.repo.UpdateUser(ctx, user)
 	assert.NoError(s.T(), err)
+
+	// UpdateUserRole
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "role"=$1`)).
+		WithArgs("editor", sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	assert.NoError(s.T(), s.repo.UpdateUserRole(ctx, 1, "editor"))
+
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "users" SET "role"=$1`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectCommit()
+	assert.ErrorIs(s.T(), s.repo.UpdateUserRole(ctx, 2, "admin"), gorm.ErrRecordNotFound)
+}
+
+// --- REFRESH TOKENS ---
+
+func (s *RepoTestSuite) TestRefreshTokens() {
+	ctx := context.Background()
+	next := &domain.RefreshToken{UserID: 1, FamilyID: "fam", TokenHash: "h2", ExpiresAt: time.Now().Add(time.Hour)}
+
+	// GetRefreshTokenByHash
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "refresh_tokens" WHERE token_hash = $1`)).
+		WithArgs("h1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "family_id"}).AddRow(1, "fam"))
+	_, err := s.repo.GetRefreshTokenByHash(ctx, "h1")
+	assert.NoError(s.T(), err)
+
+	// RotateRefreshToken
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "refresh_tokens" SET "revoked_at"=$1 WHERE id = $2 AND revoked_at IS NULL`)).
+		WithArgs(sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "refresh_tokens"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	s.mock.ExpectCommit()
+	assert.NoError(s.T(), s.repo.RotateRefreshToken(ctx, 1, next))
+
+	// RotateRefreshToken: токен уже отозван
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "refresh_tokens" SET "revoked_at"=$1`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectRollback()
+	assert.ErrorIs(s.T(), s.repo.RotateRefreshToken(ctx, 1, next), gorm.ErrRecordNotFound)
+
+	// RevokeTokenFamily
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "refresh_tokens" SET "revoked_at"=$1 WHERE family_id = $2 AND revoked_at IS NULL`)).
+		WithArgs(sqlmock.AnyArg(), "fam").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	s.mock.ExpectCommit()
+	assert.NoError(s.T(), s.repo.RevokeTokenFamily(ctx, "fam"))
+
+	// RevokeUserRefreshTokens
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "refresh_tokens" SET "revoked_at"=$1 WHERE user_id = $2 AND revoked_at IS NULL`)).
+		WithArgs(sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	s.mock.ExpectCommit()
+	assert.NoError(s.T(), s.repo.RevokeUserRefreshTokens(ctx, 1))
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	c := NewCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+
+	var got []string
+	ok, err := c.Get(ctx, "k", &got)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, c.Set(ctx, "k", []string{"a", "b"}, time.Minute))
+	ok, err = c.Get(ctx, "k", &got)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a", "b"}, got)
+
+	mr.FastForward(2 * time.Minute)
+	ok, _ = c.Get(ctx, "k", &got)
+	assert.False(t, ok)
+}
+
+func TestDenylist(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	dl := NewDenylist(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	issued := time.Now().Add(-time.Minute)
+
+	revoked, err := dl.IsRevoked(ctx, "jti-1", 1, issued)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	assert.NoError(t, dl.RevokeToken(ctx, "jti-1", time.Minute))
+	assert.True(t, mr.TTL("denylist:jti:jti-1") > 0)
+	revoked, _ = dl.IsRevoked(ctx, "jti-1", 1, issued)
+	assert.True(t, revoked)
+
+	// Истёкший токен в denylist не попадает
+	assert.NoError(t, dl.RevokeToken(ctx, "jti-old", -time.Second))
+	assert.False(t, mr.Exists("denylist:jti:jti-old"))
+
+	logout := time.Now()
+	assert.NoError(t, dl.RevokeUser(ctx, 1, logout, time.Minute))
+	revoked, _ = dl.IsRevoked(ctx, "jti-2", 1, issued)
+	assert.True(t, revoked)
+	revoked, _ = dl.IsRevoked(ctx, "jti-2", 1, logout)
+	assert.True(t, revoked)
+	revoked, _ = dl.IsRevoked(ctx, "jti-4", 1, logout.Add(time.Millisecond))
+	assert.False(t, revoked, "вход сразу после выхода, в ту же секунду, даёт рабочий токен")
+	revoked, _ = dl.IsRevoked(ctx, "jti-3", 1, time.Now().Add(time.Minute))
+	assert.False(t, revoked, "токены, выпущенные после выхода, остаются действительными")
+	revoked, _ = dl.IsRevoked(ctx, "jti-2", 2, issued)
+	assert.False(t, revoked)
 }
 
 // --- BOOKS ---
 
 func (s *RepoTestSuite) TestBooks() {
+	ctx := context.Background()
 	book := &domain.Book{Title: "Title", AuthorID: 1}
 	book.ID = 1
 
@@ -83,15 +209,54 @@ func (s *RepoTestSuite) TestBooks() {
 	s.mock.ExpectBegin()
 	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "books"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 	s.mock.ExpectCommit()
-	err := s.repo.CreateBook(book)
+	err := s.repo.CreateBook(ctx, book)
 	assert.NoError(s.T(), err)
 
-	// GetBooks
-	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "books"`)).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "author_id"}).AddRow(1, 1))
+	// GetBooks: без текста книги
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "books"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT "books"."id","books"."title","books"."description","books"."language","books"."isbn","books"."cover_key","books"."author_id","books"."rating_avg","books"."rating_count","books"."rating_1","books"."rating_2","books"."rating_3","books"."rating_4","books"."rating_5","books"."version","books"."deleted_at" FROM "books" WHERE "books"."deleted_at" IS NULL ORDER BY books.id ASC LIMIT $1`)).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "author_id"}).AddRow(1, 1).AddRow(2, 1).AddRow(3, 1))
 	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "authors" WHERE "authors"."id" = $1`)).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-	_, err = s.repo.GetBooks()
+	page, err := s.repo.GetBooks(ctx, domain.BookFilter{ListQuery: domain.ListQuery{Limit: 2}})
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), page.Items, 2)
+	assert.Equal(s.T(), int64(3), page.Total)
+	assert.Equal(s.T(), domain.EncodeCursor(2), page.NextCursor)
+
+	// GetBooks: фильтры, сортировка и вторая страница
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "books" WHERE books.author_id = $1 AND books.title ILIKE $2 AND books.rating_avg >= $3`)).
+		WithArgs(1, `50\%%`, 4.0).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`ORDER BY books.title DESC, books.id DESC LIMIT $4 OFFSET $5`)).
+		WithArgs(1, `50\%%`, 4.0, 3, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "author_id"}).AddRow(3, 1))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "authors"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	page, err = s.repo.GetBooks(ctx, domain.BookFilter{
+		ListQuery:   domain.ListQuery{Limit: 2, Cursor: domain.EncodeCursor(2), Sort: "-title"},
+		AuthorID:    1,
+		TitlePrefix: "50%",
+		MinRating:   4,
+	})
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), page.Items, 1)
+	assert.Empty(s.T(), page.NextCursor)
+
+	// GetBooks: неизвестное поле сортировки и битый курсор
+	_, err = s.repo.GetBooks(ctx, domain.BookFilter{ListQuery: domain.ListQuery{Sort: "price"}})
+	assert.ErrorIs(s.T(), err, domain.ErrInvalidSort)
+	_, err = s.repo.GetBooks(ctx, domain.BookFilter{ListQuery: domain.ListQuery{Cursor: "!!"}})
+	assert.ErrorIs(s.T(), err, domain.ErrInvalidCursor)
+
+	// GetBooks: по числу оценок
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "books"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT "books"."id","books"."title","books"."description","books"."language","books"."isbn","books"."cover_key","books"."author_id","books"."rating_avg","books"."rating_count","books"."rating_1","books"."rating_2","books"."rating_3","books"."rating_4","books"."rating_5","books"."version","books"."deleted_at" FROM "books" WHERE "books"."deleted_at" IS NULL ORDER BY books.rating_count DESC, books.id DESC`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	_, err = s.repo.GetBooks(ctx, domain.BookFilter{ListQuery: domain.ListQuery{Sort: "-rating_count"}})
 	assert.NoError(s.T(), err)
 
 	// GetBookByID
@@ -99,36 +264,281 @@ func (s *RepoTestSuite) TestBooks() {
 		WillReturnRows(sqlmock.NewRows([]string{"id", "author_id"}).AddRow(1, 1))
 	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "authors" WHERE "authors"."id" = $1`)).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-	_, err = s.repo.GetBookByID(1)
+	_, err = s.repo.GetBookByID(ctx, 1)
 	assert.NoError(s.T(), err)
 
-	// UpdateBook
+	// UpdateBook: сводку оценок из запроса не сохраняем, версия растёт
 	s.mock.ExpectBegin()
-	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "books" SET`)).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "books" SET "author_id"=$1,"content"=$2,"cover_key"=$3,"description"=$4,"isbn"=$5,"language"=$6,"title"=$7,"version"=version + 1 WHERE (id = $8 AND version = $9) AND "books"."deleted_at" IS NULL`)).
+		WithArgs(book.AuthorID, book.Content, "", "", "", "", book.Title, book.ID, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
 	s.mock.ExpectCommit()
-	err = s.repo.UpdateBook(book)
+	book.RatingAvg, book.Version = 5, 1
+	err = s.repo.UpdateBook(ctx, book)
 	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(2), book.Version)
 
-	// DeleteBook
+	// UpdateBook: книгу успели изменить
 	s.mock.ExpectBegin()
-	s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "books" WHERE "books"."id" = $1`)).
-		WithArgs(1).
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "books" SET`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectCommit()
+	book.Version = 1
+	err = s.repo.UpdateBook(ctx, book)
+	assert.ErrorIs(s.T(), err, domain.ErrVersionMismatch)
+	assert.Equal(s.T(), int64(1), book.Version)
+
+	// GetBookDependents
+	for _, table := range []string{"reviews", "shelves", "collection_books", "annotations"} {
+		s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "` + table + `" WHERE book_id = $1`)).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	}
+	deps, err := s.repo.GetBookDependents(ctx, 1)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), domain.Dependents{Reviews: 2, ShelfEntries: 2, Collections: 2, Annotations: 2}, deps)
+
+	// DeleteBooks: мягкое удаление, книга уходит из подборок
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "collection_books" WHERE book_id IN ($1,$2)`)).
+		WithArgs(1, 2).
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "books" SET "deleted_at"=$1 WHERE "books"."id" IN ($2,$3) AND "books"."deleted_at" IS NULL`)).
+		WithArgs(sqlmock.AnyArg(), 1, 2).
+		WillReturnResult(sqlmock.NewResult(0, 2))
 	s.mock.ExpectCommit()
-	err = s.repo.DeleteBook(1)
+	err = s.repo.DeleteBooks(ctx, []uint{1, 2})
 	assert.NoError(s.T(), err)
+	assert.NoError(s.T(), s.repo.DeleteBooks(ctx, nil))
 
-	// GetBooksByAuthor
-	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "books" WHERE author_id = $1`)).
+	// DeleteBook: удаляется только книга той версии, которую видел клиент
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "books" SET "deleted_at"=$1 WHERE version = $2 AND "books"."id" = $3 AND "books"."deleted_at" IS NULL`)).
+		WithArgs(sqlmock.AnyArg(), 2, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "collection_books" WHERE book_id = $1`)).
 		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectCommit()
+	assert.NoError(s.T(), s.repo.DeleteBook(ctx, 1, 2))
+
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "books" SET "deleted_at"=$1 WHERE version = $2`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectRollback()
+	assert.ErrorIs(s.T(), s.repo.DeleteBook(ctx, 1, 1), domain.ErrVersionMismatch)
+}
+
+func (s *RepoTestSuite) TestChapters() {
+	ctx := context.Background()
+	// ReplaceChapters
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "chapters" WHERE book_id = $1`)).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "chapters"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	s.mock.ExpectCommit()
+	err := s.repo.ReplaceChapters(ctx, 1, []domain.Chapter{{BookID: 1, Ordinal: 1}, {BookID: 1, Ordinal: 2}})
+	assert.NoError(s.T(), err)
+
+	// ReplaceChapters: пустой набор только удаляет старые главы
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "chapters"`)).WillReturnResult(sqlmock.NewResult(0, 2))
+	s.mock.ExpectCommit()
+	assert.NoError(s.T(), s.repo.ReplaceChapters(ctx, 1, nil))
+
+	// GetChapters: оглавление без текста глав
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT "chapters"."id","chapters"."book_id","chapters"."ordinal","chapters"."title","chapters"."word_count" FROM "chapters" JOIN books ON books.id = chapters.book_id AND books.deleted_at IS NULL WHERE chapters.book_id = $1 ORDER BY chapters.ordinal`)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ordinal"}).AddRow(1, 1).AddRow(2, 2))
+	chapters, err := s.repo.GetChapters(ctx, 1)
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), chapters, 2)
+
+	// GetChapter: главы удалённой книги не отдаются
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT "chapters"."id","chapters"."book_id","chapters"."ordinal","chapters"."title","chapters"."body","chapters"."word_count" FROM "chapters" JOIN books ON books.id = chapters.book_id AND books.deleted_at IS NULL WHERE chapters.book_id = $1 AND chapters.ordinal = $2`)).
+		WithArgs(1, 2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ordinal", "body"}).AddRow(2, 2, "text"))
+	ch, err := s.repo.GetChapter(ctx, 1, 2)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "text", ch.Body)
+
+	// GetBooksWithoutChapters
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "books" WHERE books.id > $1 AND books.content ~ $2 AND NOT EXISTS (SELECT 1 FROM chapters WHERE chapters.book_id = books.id) AND "books"."deleted_at" IS NULL ORDER BY books.id LIMIT $3`)).
+		WithArgs(2, nonBlankContent, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "content"}).AddRow(3, "# A"))
+	books, err := s.repo.GetBooksWithoutChapters(ctx, 2, 100)
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), books, 1)
+}
+
+func (s *RepoTestSuite) TestAnnotations() {
+	ctx := context.Background()
+	// GetAnnotations: поиск подстроки с экранированием, по умолчанию — новые сверху
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "annotations" WHERE annotations.user_id = $1 AND annotations.kind = $2 AND (annotations.selected_text || ' ' || annotations.note) ILIKE $3`)).
+		WithArgs(1, domain.AnnotationNote, `%50\%%`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`ORDER BY annotations.created_at DESC, annotations.id DESC LIMIT $4`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "kind"}).AddRow(3, domain.AnnotationNote))
+	page, err := s.repo.GetAnnotations(ctx, 1, domain.AnnotationFilter{Kind: domain.AnnotationNote, Query: "50%"})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(1), page.Total)
+
+	// Внутри книги — по порядку текста
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "annotations" WHERE annotations.user_id = $1 AND annotations.book_id = $2`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`ORDER BY (annotations.start_chapter, annotations.start_offset) ASC, annotations.id ASC`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	_, err = s.repo.GetAnnotations(ctx, 1, domain.AnnotationFilter{BookID: 10})
+	assert.NoError(s.T(), err)
+
+	// ExportAnnotations: книга без текста, с автором
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "annotations" WHERE user_id = $1 AND book_id = $2 ORDER BY book_id, start_chapter, start_offset, id`)).
+		WithArgs(1, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "book_id"}).AddRow(3, 10))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id","title","author_id" FROM "books" WHERE "books"."id" = $1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author_id"}).AddRow(10, "Анна Каренина", 1))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "authors" WHERE "authors"."id" = $1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "Лев Толстой"))
+	list, err := s.repo.ExportAnnotations(ctx, 1, 10)
+	assert.NoError(s.T(), err)
+	if assert.Len(s.T(), list, 1) {
+		assert.Equal(s.T(), "Лев Толстой", list[0].Book.Author.Name)
+	}
+}
+
+func (s *RepoTestSuite) TestCollections() {
+	ctx := context.Background()
+	// CreateCollection
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "collections"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	s.mock.ExpectCommit()
+	assert.NoError(s.T(), s.repo.CreateCollection(ctx, &domain.Collection{UserID: 1, Name: "A", Slug: "s"}))
+
+	// GetCollections: чужие — только публичные
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "collections" WHERE user_id = $1 AND visibility = $2 ORDER BY name, id`)).
+		WithArgs(1, domain.VisibilityPublic).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-	_, err = s.repo.GetBooksByAuthor(1)
+	list, err := s.repo.GetCollections(ctx, 1, true)
 	assert.NoError(s.T(), err)
+	assert.Len(s.T(), list, 1)
+
+	// GetCollectionBySlug: книги по порядку
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "collections" WHERE slug = $1`)).
+		WithArgs("s", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "slug"}).AddRow(1, "s"))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "collection_books" WHERE "collection_books"."collection_id" = $1 ORDER BY position`)).
+		WillReturnRows(sqlmock.NewRows([]string{"collection_id", "book_id", "position"}).AddRow(1, 2, 1))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "books" WHERE "books"."id" = $1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "author_id"}).AddRow(2, 1))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "authors" WHERE "authors"."id" = $1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	col, err := s.repo.GetCollectionBySlug(ctx, "s")
+	assert.NoError(s.T(), err)
+	if assert.Len(s.T(), col.Books, 1) {
+		assert.Equal(s.T(), uint(2), col.Books[0].Book.ID)
+	}
+
+	// DeleteCollection
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "collection_books" WHERE collection_id = $1`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "collections" WHERE "collections"."id" = $1`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	assert.NoError(s.T(), s.repo.DeleteCollection(ctx, 1))
+
+	// RemoveCollectionBook: книги нет в подборке
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "collection_books" WHERE collection_id = $1 AND book_id = $2`)).
+		WithArgs(1, 9).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectCommit()
+	assert.ErrorIs(s.T(), s.repo.RemoveCollectionBook(ctx, 1, 9), gorm.ErrRecordNotFound)
+
+	// ReorderCollection
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "collection_books" SET "position"=$1 WHERE collection_id = $2 AND book_id = $3`)).
+		WithArgs(1, 1, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "collection_books" SET "position"=$1 WHERE collection_id = $2 AND book_id = $3`)).
+		WithArgs(2, 1, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	assert.NoError(s.T(), s.repo.ReorderCollection(ctx, 1, []uint{3, 2}))
+}
+
+func (s *RepoTestSuite) TestBookFiles() {
+	ctx := context.Background()
+	f := &domain.BookFile{BookID: 1, Format: domain.FormatPDF, StorageKey: "books/1/x.pdf"}
+
+	// CreateBookFile
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "book_files"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	s.mock.ExpectCommit()
+	assert.NoError(s.T(), s.repo.CreateBookFile(ctx, f))
+
+	// GetBookFiles
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "book_files" WHERE book_id = $1 ORDER BY id`)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "book_id"}).AddRow(1, 1).AddRow(2, 1))
+	files, err := s.repo.GetBookFiles(ctx, 1)
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), files, 2)
+
+	// GetBookFile: файл чужой книги не находится
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "book_files" WHERE book_id = $1 AND "book_files"."id" = $2`)).
+		WithArgs(2, 1, 1).
+		WillReturnError(gorm.ErrRecordNotFound)
+	_, err = s.repo.GetBookFile(ctx, 2, 1)
+	assert.ErrorIs(s.T(), err, gorm.ErrRecordNotFound)
+}
+
+// --- SEARCH ---
+
+func (s *RepoTestSuite) TestSearchBooks() {
+	ctx := context.Background()
+	s.mock.ExpectQuery(regexp.QuoteMeta(`WITH query AS (SELECT websearch_to_tsquery('russian', $1) AS q)
+SELECT count(*)`)).
+		WithArgs("мир").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`b.search_ru @@ query.q OR to_tsvector('russian', a.name) @@ query.q`)).
+		WithArgs("мир", domain.DefaultPageSize+1, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id", "title", "author_id", "author_name", "rank", "snippet"}).
+			AddRow(1, "Война и мир", 1, "Толстой", 0.9, "<mark>мир</mark>"))
+	page, err := s.repo.SearchBooks(ctx, domain.SearchQuery{Text: "мир", Lang: domain.SearchLangRU})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(1), page.Total)
+	assert.Equal(s.T(), "Толстой", page.Items[0].AuthorName)
+
+	s.mock.ExpectQuery(regexp.QuoteMeta(`websearch_to_tsquery('english', $1)`)).
+		WithArgs("war").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`b.search_en @@ query.q`)).
+		WithArgs("war", domain.DefaultPageSize+1, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"book_id"}))
+	page, err = s.repo.SearchBooks(ctx, domain.SearchQuery{Text: "war", Lang: domain.SearchLangEN})
+	assert.NoError(s.T(), err)
+	assert.Empty(s.T(), page.Items)
+
+	_, err = s.repo.SearchBooks(ctx, domain.SearchQuery{Text: "x", Lang: "de"})
+	assert.Error(s.T(), err)
+}
+
+func (s *RepoTestSuite) TestAutocomplete() {
+	ctx := context.Background()
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title AS label, GREATEST(word_similarity($1, lower(title)), word_similarity($2, lower(title))) AS score FROM books WHERE deleted_at IS NULL AND ($3 <% lower(title) OR $4 <% lower(title)) ORDER BY score DESC, id LIMIT $5`)).
+		WithArgs("tolstoy", "толстой", "tolstoy", "толстой", 5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "label", "score"}))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name AS label`)).
+		WithArgs("tolstoy", "толстой", "tolstoy", "толстой", 5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "label", "score"}).AddRow(1, "Лев Толстой", 0.83))
+	res, err := s.repo.Autocomplete(ctx, []string{"tolstoy", "толстой"}, 5)
+	assert.NoError(s.T(), err)
+	assert.Empty(s.T(), res.Books)
+	assert.Equal(s.T(), "Лев Толстой", res.Authors[0].Label)
 }
 
 // --- AUTHORS ---
 
 func (s *RepoTestSuite) TestAuthors() {
+	ctx := context.Background()
 	author := &domain.Author{Name: "Name"}
 	author.ID = 1
 
@@ -136,77 +546,336 @@ func (s *RepoTestSuite) TestAuthors() {
 	s.mock.ExpectBegin()
 	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "authors"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 	s.mock.ExpectCommit()
-	err := s.repo.CreateAuthor(author)
+	err := s.repo.CreateAuthor(ctx, author)
 	assert.NoError(s.T(), err)
 
 	// GetAuthors
-	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "authors"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-	_, err = s.repo.GetAuthors()
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "authors" WHERE authors.name ILIKE $1`)).
+		WithArgs("Толс%").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "authors" WHERE authors.name ILIKE $1 AND "authors"."deleted_at" IS NULL ORDER BY authors.name ASC, authors.id ASC LIMIT $2`)).
+		WithArgs("Толс%", domain.DefaultPageSize+1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	_, err = s.repo.GetAuthors(ctx, domain.AuthorFilter{ListQuery: domain.ListQuery{Sort: "name"}, NamePrefix: "Толс"})
 	assert.NoError(s.T(), err)
 
 	// GetAuthorByID
 	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "authors" WHERE "authors"."id" = $1`)).
 		WithArgs(1, 1).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-	_, err = s.repo.GetAuthorByID(1)
+	_, err = s.repo.GetAuthorByID(ctx, 1)
+	assert.NoError(s.T(), err)
+
+	// GetAuthorByName: без учёта регистра, самый старый из тёзок
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "authors" WHERE lower(name) = lower($1) AND "authors"."deleted_at" IS NULL ORDER BY id,"authors"."id" LIMIT $2`)).
+		WithArgs("Лев Толстой", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "лев толстой"))
+	a, err := s.repo.GetAuthorByName(ctx, "Лев Толстой")
 	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), uint(1), a.ID)
 
 	// UpdateAuthor
 	s.mock.ExpectBegin()
 	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "authors" SET`)).WillReturnResult(sqlmock.NewResult(1, 1))
 	s.mock.ExpectCommit()
-	err = s.repo.UpdateAuthor(author)
+	err = s.repo.UpdateAuthor(ctx, author)
 	assert.NoError(s.T(), err)
 
-	// DeleteAuthor
-	s.mock.ExpectBegin()
-	s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "authors" WHERE "authors"."id" = $1`)).
+	// GetAuthorDependents: только неудалённые книги
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id","title" FROM "books" WHERE author_id = $1 AND "books"."deleted_at" IS NULL ORDER BY id`)).
 		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(3, "Война и мир"))
+	deps, err := s.repo.GetAuthorDependents(ctx, 1)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []domain.BookRef{{ID: 3, Title: "Война и мир"}}, deps.Books)
+
+	// ReassignBooks
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "books" SET "author_id"=$1,"version"=version + 1 WHERE author_id = $2 AND "books"."deleted_at" IS NULL`)).
+		WithArgs(2, 1).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 	s.mock.ExpectCommit()
-	err = s.repo.DeleteAuthor(1)
+	err = s.repo.ReassignBooks(ctx, 1, 2)
 	assert.NoError(s.T(), err)
+
+	// DeleteAuthor: мягкое удаление
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "authors" SET "deleted_at"=$1 WHERE "authors"."id" = $2 AND "authors"."deleted_at" IS NULL`)).
+		WithArgs(sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	err = s.repo.DeleteAuthor(ctx, 1, 0)
+	assert.NoError(s.T(), err)
+
+	// DeleteAuthor: автора успели изменить
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "authors" SET "deleted_at"=$1 WHERE version = $2 AND "authors"."id" = $3 AND "authors"."deleted_at" IS NULL`)).
+		WithArgs(sqlmock.AnyArg(), 3, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectCommit()
+	err = s.repo.DeleteAuthor(ctx, 1, 3)
+	assert.ErrorIs(s.T(), err, domain.ErrVersionMismatch)
 }
 
 // --- REVIEWS ---
 
 func (s *RepoTestSuite) TestReviews() {
-	review := &domain.Review{Comment: "C", BookID: 1, UserID: 1}
+	ctx := context.Background()
+	review := &domain.Review{Comment: "C", BookID: 1, UserID: 1, HelpfulCount: 100, NotHelpfulCount: 3}
 
-	// CreateReview
+	// CreateReview: книга блокируется, затем сводка оценок пересчитывается в той же транзакции;
+	// счётчики голосов из запроса не пишутся
 	s.mock.ExpectBegin()
-	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "reviews"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	s.mock.ExpectExec(regexp.QuoteMeta(`SELECT id FROM books WHERE id = $1 FOR UPDATE`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "reviews" ("book_id","user_id","rating","comment","edited","status","moderated_at","created_at","updated_at") VALUES`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE books SET (rating_count, rating_avg, rating_1, rating_2, rating_3, rating_4, rating_5) = (`)).
+		WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
 	s.mock.ExpectCommit()
-	err := s.repo.CreateReview(review)
+	err := s.repo.CreateReview(ctx, review)
 	assert.NoError(s.T(), err)
 
-	// GetReviewsByBook
-	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "reviews" WHERE book_id = $1`)).
-		WithArgs(1).
+	// GetReviewsByBook: только опубликованные
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "reviews" WHERE reviews.book_id = $1 AND reviews.status = $2`)).
+		WithArgs(1, domain.ReviewPublished).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "reviews" WHERE reviews.book_id = $1 AND reviews.status = $2 ORDER BY reviews.rating DESC, reviews.id DESC LIMIT $3`)).
+		WithArgs(1, domain.ReviewPublished, domain.MaxPageSize+1).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-	_, err = s.repo.GetReviewsByBook(1)
+	_, err = s.repo.GetReviewsByBook(ctx, 1, domain.ListQuery{Limit: 500, Sort: "-rating"})
 	assert.NoError(s.T(), err)
 
+	// GetReviewsByBook: по умолчанию — самые полезные, newest — новые первыми
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "reviews"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`ORDER BY (reviews.helpful_count - reviews.not_helpful_count) DESC, reviews.id DESC`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	_, err = s.repo.GetReviewsByBook(ctx, 1, domain.ListQuery{})
+	assert.NoError(s.T(), err)
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "reviews"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`ORDER BY reviews.created_at DESC, reviews.id DESC`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	_, err = s.repo.GetReviewsByBook(ctx, 1, domain.ListQuery{Sort: "newest"})
+	assert.NoError(s.T(), err)
+	_, err = s.repo.GetReviewsByBook(ctx, 1, domain.ListQuery{Sort: "oldest"})
+	assert.ErrorIs(s.T(), err, domain.ErrInvalidSort)
+
+	// SaveReviewVote: upsert и пересчёт счётчиков под блокировкой отзыва
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`SELECT id FROM reviews WHERE id = $1 FOR UPDATE`)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "review_votes" ("review_id","user_id","helpful","created_at","updated_at") VALUES ($1,$2,$3,$4,$5) ON CONFLICT ("review_id","user_id") DO UPDATE SET "helpful"="excluded"."helpful","updated_at"="excluded"."updated_at"`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE reviews SET (helpful_count, not_helpful_count) = (`)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	assert.NoError(s.T(), s.repo.SaveReviewVote(ctx, &domain.ReviewVote{ReviewID: 7, UserID: 2, Helpful: true}))
+
+	// DeleteReviewVote
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`FOR UPDATE`)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "review_votes" WHERE review_id = $1 AND user_id = $2`)).
+		WithArgs(7, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE reviews SET (helpful_count`)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	assert.NoError(s.T(), s.repo.DeleteReviewVote(ctx, 7, 2))
+
+	// GetReview / UpdateReview
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "reviews" WHERE "reviews"."id" = $1 ORDER BY "reviews"."id" LIMIT $2`)).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "rating"}).AddRow(1, 3))
+	got, err := s.repo.GetReview(ctx, 1)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 3, got.Rating)
+
+	got.Edited = true
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`FOR UPDATE`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "reviews" SET "book_id"=$1,"user_id"=$2,"rating"=$3,"comment"=$4,"edited"=$5,"created_at"=$6,"updated_at"=$7 WHERE "id" = $8`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE books SET`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	assert.NoError(s.T(), s.repo.UpdateReview(ctx, got))
+
 	// DeleteReview
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id","book_id" FROM "reviews" WHERE id = $1 AND user_id = $2`)).
+		WithArgs(1, 1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "book_id"}).AddRow(1, 5))
 	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`FOR UPDATE`)).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
 	s.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "reviews" WHERE id = $1 AND user_id = $2`)).
 		WithArgs(1, 1).
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE books SET`)).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	err = s.repo.DeleteReview(ctx, 1, 1)
+	assert.NoError(s.T(), err)
+
+	// DeleteReview: чужого отзыва нет — ничего не делаем
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id","book_id" FROM "reviews"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "book_id"}))
+	assert.NoError(s.T(), s.repo.DeleteReview(ctx, 1, 2))
+
+	// RecomputeRatings
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE books SET (rating_count, rating_avg`)).WillReturnResult(sqlmock.NewResult(0, 4))
+	n, err := s.repo.RecomputeRatings(ctx)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(4), n)
+}
+
+func (s *RepoTestSuite) TestModeration() {
+	ctx := context.Background()
+	// CreateReviewReport: считаются только жалобы после решения модератора
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "review_reports"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "review_reports" WHERE review_id = $1 AND created_at > $2`)).
+		WithArgs(7, since).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	s.mock.ExpectCommit()
+	n, err := s.repo.CreateReviewReport(ctx, &domain.ReviewReport{ReviewID: 7, UserID: 2, Reason: "Spam"}, &since)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(2), n)
+
+	// GetModerationQueue: отзывы на модерации вместе с жалобами
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "reviews" WHERE reviews.status = $1`)).
+		WithArgs(domain.ReviewPending).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "reviews" WHERE reviews.status = $1 ORDER BY reviews.id ASC`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(7, domain.ReviewPending))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "review_reports" WHERE "review_reports"."review_id" = $1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "review_id"}).AddRow(1, 7).AddRow(2, 7))
+	page, err := s.repo.GetModerationQueue(ctx, domain.ListQuery{})
+	assert.NoError(s.T(), err)
+	if assert.Len(s.T(), page.Items, 1) {
+		assert.Len(s.T(), page.Items[0].Reports, 2)
+	}
+
+	// ModerateReview: статус, журнал и пересчёт оценок в одной транзакции
+	re := &domain.Review{ID: 7, BookID: 1, Status: domain.ReviewPublished, ModeratedAt: &since}
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`FOR UPDATE`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "reviews" SET "moderated_at"=$1,"status"=$2,"updated_at"=$3 WHERE status = $4 AND "id" = $5`)).
+		WithArgs(since, domain.ReviewPublished, sqlmock.AnyArg(), domain.ReviewPending, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "moderation_actions"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE books SET`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
 	s.mock.ExpectCommit()
-	err = s.repo.DeleteReview(1, 1)
+	approve := &domain.ModerationAction{ReviewID: 7, Action: domain.ModerationApprove, FromStatus: domain.ReviewPending}
+	assert.NoError(s.T(), s.repo.ModerateReview(ctx, re, approve))
+
+	// ModerateReview: решение уже принял другой модератор, журнал не пишется
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`FOR UPDATE`)).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "reviews" SET`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectRollback()
+	assert.ErrorIs(s.T(), s.repo.ModerateReview(ctx, re, approve), domain.ErrReviewStatusChanged)
+
+	// GetModerationActions
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "moderation_actions" WHERE review_id = $1 ORDER BY id`)).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "action"}).AddRow(1, domain.ModerationFlag))
+	actions, err := s.repo.GetModerationActions(ctx, 7)
 	assert.NoError(s.T(), err)
+	assert.Len(s.T(), actions, 1)
+}
+
+func (s *RepoTestSuite) TestComments() {
+	ctx := context.Background()
+	// GetReviewComments: вместе с удалёнными, в порядке создания
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "review_comments" WHERE review_id = $1 ORDER BY id`)).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "review_id", "deleted_at"}).AddRow(1, 7, time.Now()).AddRow(2, 7, nil))
+	list, err := s.repo.GetReviewComments(ctx, 7)
+	assert.NoError(s.T(), err)
+	if assert.Len(s.T(), list, 2) {
+		assert.True(s.T(), list[0].DeletedAt.Valid)
+	}
+
+	// GetReviewComment не видит удалённые
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "review_comments" WHERE "review_comments"."id" = $1 AND "review_comments"."deleted_at" IS NULL`)).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	_, err = s.repo.GetReviewComment(ctx, 1)
+	assert.ErrorIs(s.T(), err, gorm.ErrRecordNotFound)
+
+	// DeleteReviewComment — мягкое удаление
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "review_comments" SET "deleted_at"=$1 WHERE "review_comments"."id" = $2`)).
+		WithArgs(sqlmock.AnyArg(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	assert.NoError(s.T(), s.repo.DeleteReviewComment(ctx, 2))
+}
+
+func (s *RepoTestSuite) TestNotifications() {
+	ctx := context.Background()
+	// GetNotifications: только непрочитанные, новые первыми
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "notifications" WHERE notifications.user_id = $1 AND notifications.read_at IS NULL`)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "notifications" WHERE notifications.user_id = $1 AND notifications.read_at IS NULL ORDER BY notifications.id DESC`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "kind"}).AddRow(3, domain.NotificationCommentReply))
+	page, err := s.repo.GetNotifications(ctx, 1, true, domain.ListQuery{})
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), page.Items, 1)
+
+	// MarkNotificationRead: чужое уведомление не найдено
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "notifications" SET "read_at"=COALESCE(read_at, $1) WHERE id = $2 AND user_id = $3`)).
+		WithArgs(sqlmock.AnyArg(), 3, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	assert.NoError(s.T(), s.repo.MarkNotificationRead(ctx, 3, 1))
+
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "notifications" SET`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectCommit()
+	assert.ErrorIs(s.T(), s.repo.MarkNotificationRead(ctx, 3, 2), gorm.ErrRecordNotFound)
+}
+
+func (s *RepoTestSuite) TestWithTx() {
+	ctx := context.Background()
+
+	// Успех: обе записи в одной транзакции
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "authors"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "books"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	s.mock.ExpectCommit()
+	err := s.repo.WithTx(ctx, func(tx Repository) error {
+		a := &domain.Author{Name: "New"}
+		if err := tx.CreateAuthor(ctx, a); err != nil {
+			return err
+		}
+		return tx.CreateBook(ctx, &domain.Book{Title: "T", AuthorID: a.ID})
+	})
+	assert.NoError(s.T(), err)
+
+	// Ошибка внутри fn откатывает всё
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "authors"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	s.mock.ExpectRollback()
+	failed := errors.New("book is invalid")
+	err = s.repo.WithTx(ctx, func(tx Repository) error {
+		if err := tx.CreateAuthor(ctx, &domain.Author{Name: "Other"}); err != nil {
+			return err
+		}
+		return failed
+	})
+	assert.ErrorIs(s.T(), err, failed)
+	assert.NoError(s.T(), s.mock.ExpectationsWereMet())
 }
 
 // --- SHELF ---
 
 func (s *RepoTestSuite) TestShelf() {
+	ctx := context.Background()
 	shelf := &domain.Shelf{UserID: 1, BookID: 1, Status: "reading", UpdatedAt: time.Now()}
 
 	// AddToShelf (Save)
 	s.mock.ExpectBegin()
 	s.mock.ExpectExec(regexp.QuoteMeta(`UPDATE "shelves" SET`)).WillReturnResult(sqlmock.NewResult(1, 1))
 	s.mock.ExpectCommit()
-	err := s.repo.AddToShelf(shelf)
+	err := s.repo.AddToShelf(ctx, shelf)
 	assert.NoError(s.T(), err)
 
 	// GetShelf
@@ -217,7 +886,41 @@ func (s *RepoTestSuite) TestShelf() {
 		WillReturnRows(sqlmock.NewRows([]string{"id", "author_id"}).AddRow(1, 1))
 	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "authors" WHERE "authors"."id" = $1`)).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-	_, err = s.repo.GetShelf(1)
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "device_positions" WHERE ("device_positions"."user_id","device_positions"."book_id") IN (($1,$2)) ORDER BY updated_at DESC`)).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "book_id", "device_id"}).AddRow(1, 1, "phone"))
+	shelf2, err := s.repo.GetShelf(ctx, 1)
+	assert.NoError(s.T(), err)
+	if assert.Len(s.T(), shelf2, 1) {
+		assert.Equal(s.T(), "phone", shelf2[0].Devices[0].DeviceID)
+	}
+
+	// GetDevicePosition
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "device_positions" WHERE user_id = $1 AND book_id = $2 AND device_id = $3`)).
+		WithArgs(1, 1, "phone", 1).
+		WillReturnError(gorm.ErrRecordNotFound)
+	_, err = s.repo.GetDevicePosition(ctx, 1, 1, "phone")
+	assert.ErrorIs(s.T(), err, gorm.ErrRecordNotFound)
+
+	// CreateReadingEvent
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "reading_events"`)).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	s.mock.ExpectCommit()
+	assert.NoError(s.T(), s.repo.CreateReadingEvent(ctx, &domain.ReadingEvent{UserID: 1, BookID: 1, DeviceID: "phone"}))
+
+	// GetShelfEntry
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "shelves" WHERE user_id = $1 AND book_id = $2`)).
+		WithArgs(1, 1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "book_id", "status", "progress_chapter", "progress_percent"}).AddRow(1, 1, "reading", 3, 42.5))
+	entry, err := s.repo.GetShelfEntry(ctx, 1, 1)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), domain.ReadingPosition{Chapter: 3, Percent: 42.5}, entry.Progress)
+
+	// LockShelfEntry
+	s.mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "shelves" WHERE user_id = $1 AND book_id = $2 ORDER BY "shelves"."user_id" LIMIT $3 FOR UPDATE`)).
+		WithArgs(1, 1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "book_id", "status"}).AddRow(1, 1, "reading"))
+	_, err = s.repo.LockShelfEntry(ctx, 1, 1)
 	assert.NoError(s.T(), err)
 
 	// RemoveFromShelf
@@ -226,6 +929,216 @@ func (s *RepoTestSuite) TestShelf() {
 		WithArgs(1, 1).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 	s.mock.ExpectCommit()
-	err = s.repo.RemoveFromShelf(1, 1)
+	err = s.repo.RemoveFromShelf(ctx, 1, 1)
 	assert.NoError(s.T(), err)
 }
+
+// --- MIGRATIONS ---
+
+// Класс в nonBlankContent должен совпадать с unicode.IsSpace, иначе книга
+// из одних пробелов попадёт в выборку, а глав из неё не выйдет.
+func TestNonBlankContentMatchesIsSpace(t *testing.T) {
+	re := regexp.MustCompile(regexp.MustCompile(`\\u([0-9a-f]{4})`).ReplaceAllString(nonBlankContent, `\x{$1}`))
+	for r := rune(0); r <= unicode.MaxRune; r++ {
+		if r >= 0xd800 && r <= 0xdfff {
+			continue
+		}
+		if re.MatchString(string(r)) == unicode.IsSpace(r) {
+			t.Fatalf("U+%04X: IsSpace=%v", r, unicode.IsSpace(r))
+		}
+	}
+	assert.False(t, re.MatchString(" \u00a0\t\u3000"))
+	assert.True(t, re.MatchString("\u00a0x"))
+}
+
+func TestLoadMigrations(t *testing.T) {
+	ms, err := loadMigrations(fstest.MapFS{
+		"0002_add_tags.up.sql":   {Data: []byte("CREATE TABLE tags (id bigserial)")},
+		"0002_add_tags.down.sql": {Data: []byte("DROP TABLE tags")},
+		"0001_init.up.sql":       {Data: []byte("CREATE TABLE a (id bigserial)")},
+		"0001_init.down.sql":     {Data: []byte("DROP TABLE a")},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, ms, 2) {
+		assert.Equal(t, Migration{Version: 1, Name: "init", Up: "CREATE TABLE a (id bigserial)", Down: "DROP TABLE a"}, ms[0])
+		assert.Equal(t, "add_tags", ms[1].Name)
+	}
+
+	_, err = loadMigrations(fstest.MapFS{"0001_init.up.sql": {Data: []byte("SELECT 1")}})
+	assert.ErrorContains(t, err, "needs both up and down")
+
+	_, err = loadMigrations(fstest.MapFS{
+		"0002_b.up.sql":   {Data: []byte("SELECT 1")},
+		"0002_b.down.sql": {Data: []byte("SELECT 1")},
+	})
+	assert.ErrorContains(t, err, "version 1 is missing")
+
+	_, err = loadMigrations(fstest.MapFS{"notes.txt": {Data: []byte("")}})
+	assert.ErrorContains(t, err, "unexpected file")
+
+	// Вшитые миграции должны загружаться.
+	m, err := NewMigrator(nil)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, m.Latest(), 1)
+}
+
+func TestMigrator(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{})
+	assert.NoError(t, err)
+	m, err := newMigrator(db, fstest.MapFS{
+		"0001_init.up.sql":     {Data: []byte("CREATE TABLE a (id bigserial)")},
+		"0001_init.down.sql":   {Data: []byte("DROP TABLE a")},
+		"0002_more.up.sql":     {Data: []byte("CREATE TABLE b (id bigserial)")},
+		"0002_more.down.sql":   {Data: []byte("DROP TABLE b")},
+		"0003_latest.up.sql":   {Data: []byte("CREATE TABLE c (id bigserial)")},
+		"0003_latest.down.sql": {Data: []byte("DROP TABLE c")},
+	})
+	assert.NoError(t, err)
+
+	expectLocked := func(current int) {
+		mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_lock($1)`)).WithArgs(migrationLockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS schema_migrations`)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`)).
+			WillReturnRows(sqlmock.NewRows([]string{"v"}).AddRow(current))
+	}
+	expectUnlock := func() {
+		mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_unlock($1)`)).WithArgs(migrationLockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	// Up: с версии 1 применяются 2 и 3, каждая в своей транзакции
+	expectLocked(1)
+	for _, table := range []string{"b", "c"} {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE ` + table)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "schema_migrations"`)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	expectUnlock()
+	n, err := m.Up()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// Down откатывает одну последнюю миграцию
+	expectLocked(3)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DROP TABLE c`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "schema_migrations" WHERE version = $1`)).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlock()
+	n, err = m.Down()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// To: ошибка откатывает транзакцию миграции, блокировка снимается
+	expectLocked(0)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE a`)).WillReturnError(errors.New("syntax error"))
+	mock.ExpectRollback()
+	expectUnlock()
+	n, err = m.To(2)
+	assert.ErrorContains(t, err, "migration 1_init up: syntax error")
+	assert.Equal(t, 0, n)
+
+	_, err = m.To(4)
+	assert.ErrorIs(t, err, ErrUnknownVersion)
+
+	// Check: схема отстаёт
+	mock.ExpectQuery(regexp.QuoteMeta(`information_schema.tables`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "schema_migrations" ORDER BY version`)).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "name", "applied_at"}).AddRow(1, "init", time.Now()).AddRow(2, "more", time.Now()))
+	assert.ErrorIs(t, m.Check(), ErrSchemaBehind)
+
+	// Status на пустой базе: всё ещё не применено
+	mock.ExpectQuery(regexp.QuoteMeta(`information_schema.tables`)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	list, err := m.Status()
+	assert.NoError(t, err)
+	if assert.Len(t, list, 3) {
+		assert.Nil(t, list[0].AppliedAt)
+		assert.Equal(t, "latest", list[2].Name)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestMigrationsOnAutoMigratedSchema прогоняет вшитые миграции по базе, которую
+// до версионных миграций создавал AutoMigrate. Нужен живой Postgres, например
+// из docker-compose: TEST_DATABASE_DSN="host=localhost user=admin password=... dbname=ebooks port=5432 sslmode=disable".
+// Тест работает в своей схеме и удаляет её за собой.
+func TestMigrationsOnAutoMigratedSchema(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN не задан")
+	}
+	admin, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	schema := fmt.Sprintf("migrations_test_%d", time.Now().UnixNano())
+	if !assert.NoError(t, admin.Exec("CREATE SCHEMA "+schema).Error) {
+		return
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+	db, err := gorm.Open(postgres.Open(dsn+" search_path="+schema+",public"), &gorm.Config{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	// Схема, которую оставлял прежний Migrate, и данные с версий до
+	// уникального индекса на отзывы и проверки оценки.
+	assert.NoError(t, db.AutoMigrate(
+		&domain.User{}, &domain.RefreshToken{},
+		&domain.Author{}, &domain.Book{}, &domain.Chapter{}, &domain.BookFile{},
+		&domain.Collection{}, &domain.CollectionBook{}, &domain.Annotation{},
+		&domain.Review{}, &domain.ReviewReport{}, &domain.ReviewVote{}, &domain.ModerationAction{},
+		&domain.ReviewComment{}, &domain.Notification{},
+		&domain.Shelf{}, &domain.DevicePosition{}, &domain.ReadingEvent{},
+	))
+	assert.NoError(t, db.Exec("DROP INDEX idx_reviews_book_user").Error)
+	author := domain.Author{Name: "Автор"}
+	assert.NoError(t, db.Create(&author).Error)
+	book := domain.Book{Title: "Книга", AuthorID: author.ID}
+	assert.NoError(t, db.Create(&book).Error)
+	for _, r := range []domain.Review{
+		{BookID: book.ID, UserID: 1, Rating: 2},
+		{BookID: book.ID, UserID: 1, Rating: 4},
+		{BookID: book.ID, UserID: 2, Rating: 9},
+	} {
+		assert.NoError(t, db.Create(&r).Error)
+	}
+
+	m, err := NewMigrator(db)
+	assert.NoError(t, err)
+	n, err := m.Up()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, m.Latest(), n)
+
+	var fks []string
+	assert.NoError(t, db.Raw(`SELECT conname FROM pg_constraint WHERE conrelid = 'books'::regclass AND contype = 'f'`).Scan(&fks).Error)
+	assert.Equal(t, []string{"fk_books_author"}, fks, "ключ AutoMigrate fk_authors_books заменён")
+
+	var reviews []domain.Review
+	assert.NoError(t, db.Order("user_id").Find(&reviews).Error)
+	if assert.Len(t, reviews, 2) {
+		assert.Equal(t, 4, reviews[0].Rating, "из повторных отзывов остаётся поздний")
+		assert.Equal(t, 5, reviews[1].Rating)
+	}
+	assert.Error(t, db.Create(&domain.Review{BookID: book.ID, UserID: 2, Rating: 3}).Error)
+	var got domain.Book
+	assert.NoError(t, db.First(&got, book.ID).Error)
+	assert.Equal(t, 2, got.RatingCount)
+	assert.Equal(t, 4.5, got.RatingAvg)
+
+	// Откат до пустой базы и подъём заново проверяют down-файлы и чистую установку.
+	_, err = m.To(0)
+	assert.NoError(t, err)
+	_, err = m.Up()
+	assert.NoError(t, err)
+}
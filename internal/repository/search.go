@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"E-book-service/internal/domain"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// searchConfig связывает язык из API с конфигурацией PostgreSQL
+// и генерируемой tsvector-колонкой books (см. migrations/0001_baseline.up.sql).
+type searchConfig struct {
+	regconfig string
+	column    string
+}
+
+var searchConfigs = map[string]searchConfig{
+	domain.SearchLangRU: {regconfig: "russian", column: "search_ru"},
+	domain.SearchLangEN: {regconfig: "english", column: "search_en"},
+}
+
+// headlineTextLimit ограничивает объём текста, который ts_headline разбирает
+// ради сниппета: на целом романе это заметно дорого.
+const headlineTextLimit = 100000
+
+// Условие поиска должно совпадать с выражениями GIN-индексов, иначе индекс не используется.
+// Удалённые книги в выдачу не попадают.
+const searchWhere = `b.deleted_at IS NULL AND (b.%[2]s @@ query.q OR to_tsvector('%[1]s', a.name) @@ query.q)`
+
+const searchCountSQL = `
+WITH query AS (SELECT websearch_to_tsquery('%[1]s', ?) AS q)
+SELECT count(*)
+FROM books b LEFT JOIN authors a ON a.id = b.author_id, query
+WHERE ` + searchWhere
+
+// Сниппеты считаются только для строк текущей страницы.
+const searchSQL = `
+WITH query AS (SELECT websearch_to_tsquery('%[1]s', ?) AS q),
+hits AS (
+	SELECT b.id, ts_rank(b.%[2]s || setweight(to_tsvector('%[1]s', coalesce(a.name, '')), 'A'), query.q) AS rank
+	FROM books b LEFT JOIN authors a ON a.id = b.author_id, query
+	WHERE ` + searchWhere + `
+	ORDER BY rank DESC, b.id
+	LIMIT ? OFFSET ?
+)
+SELECT b.id AS book_id, b.title, b.author_id, coalesce(a.name, '') AS author_name, hits.rank,
+	ts_headline('%[1]s', coalesce(b.description, '') || E'\n' || left(coalesce(b.content, ''), %[3]d), query.q,
+		'MaxFragments=2, MinWords=5, MaxWords=25, StartSel=<mark>, StopSel=</mark>') AS snippet
+FROM hits JOIN books b ON b.id = hits.id LEFT JOIN authors a ON a.id = b.author_id, query
+ORDER BY hits.rank DESC, b.id`
+
+func (r *postgresRepository) SearchBooks(ctx context.Context, sq domain.SearchQuery) (domain.Page[domain.SearchHit], error) {
+	page := domain.Page[domain.SearchHit]{Items: []domain.SearchHit{}}
+	cfg, ok := searchConfigs[sq.Lang]
+	if !ok {
+		return page, fmt.Errorf("unsupported search language %q", sq.Lang)
+	}
+	offset, err := domain.DecodeCursor(sq.Cursor)
+	if err != nil {
+		return page, err
+	}
+
+	countSQL := fmt.Sprintf(searchCountSQL, cfg.regconfig, cfg.column)
+	if err := r.db.WithContext(ctx).Raw(countSQL, sq.Text).Scan(&page.Total).Error; err != nil {
+		return page, err
+	}
+
+	limit := sq.PageSize()
+	hitsSQL := fmt.Sprintf(searchSQL, cfg.regconfig, cfg.column, headlineTextLimit)
+	if err := r.db.WithContext(ctx).Raw(hitsSQL, sq.Text, limit+1, offset).Scan(&page.Items).Error; err != nil {
+		return page, err
+	}
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.NextCursor = domain.EncodeCursor(offset + limit)
+	}
+	return page, nil
+}
+
+// trigramSQL выбирает строки, похожие хотя бы на один из вариантов запроса.
+// Условие "? <% lower(col)" использует GIN-индекс gin_trgm_ops по lower(col).
+func trigramSQL(table, column string, variants int) string {
+	scores := make([]string, variants)
+	conds := make([]string, variants)
+	for i := range scores {
+		scores[i] = fmt.Sprintf("word_similarity(?, lower(%s))", column)
+		conds[i] = fmt.Sprintf("? <%% lower(%s)", column)
+	}
+	return fmt.Sprintf("SELECT id, %s AS label, GREATEST(%s) AS score FROM %s WHERE deleted_at IS NULL AND (%s) ORDER BY score DESC, id LIMIT ?",
+		column, strings.Join(scores, ", "), table, strings.Join(conds, " OR "))
+}
+
+func (r *postgresRepository) Autocomplete(ctx context.Context, variants []string, limit int) (domain.Autocomplete, error) {
+	res := domain.Autocomplete{Books: []domain.Suggestion{}, Authors: []domain.Suggestion{}}
+	args := make([]interface{}, 0, 2*len(variants)+1)
+	for _, v := range variants {
+		args = append(args, v)
+	}
+	for _, v := range variants {
+		args = append(args, v)
+	}
+	args = append(args, limit)
+
+	if err := r.db.WithContext(ctx).Raw(trigramSQL("books", "title", len(variants)), args...).Scan(&res.Books).Error; err != nil {
+		return res, err
+	}
+	if err := r.db.WithContext(ctx).Raw(trigramSQL("authors", "name", len(variants)), args...).Scan(&res.Authors).Error; err != nil {
+		return res, err
+	}
+	return res, nil
+}
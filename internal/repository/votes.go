@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"E-book-service/internal/domain"
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// voteColumns пересчитываются только здесь; CreateReview и UpdateReview их не пишут.
+var voteColumns = []string{"helpful_count", "not_helpful_count"}
+
+const refreshVotesSQL = `UPDATE reviews SET (helpful_count, not_helpful_count) = (
+	SELECT COUNT(*) FILTER (WHERE helpful), COUNT(*) FILTER (WHERE NOT helpful)
+	FROM review_votes WHERE review_votes.review_id = reviews.id)
+WHERE reviews.id = ?`
+
+// withReviewVotes выполняет fn и пересчитывает счётчики голосов отзыва в одной
+// транзакции. Строка отзыва блокируется заранее, как книга в withBookRating.
+func (r *postgresRepository) withReviewVotes(ctx context.Context, reviewID uint, fn func(tx *gorm.DB) error) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT id FROM reviews WHERE id = ? FOR UPDATE", reviewID).Error; err != nil {
+			return err
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.Exec(refreshVotesSQL, reviewID).Error
+	})
+}
+
+// SaveReviewVote записывает голос; повторный голос пользователя заменяет прежний.
+func (r *postgresRepository) SaveReviewVote(ctx context.Context, v *domain.ReviewVote) error {
+	return r.withReviewVotes(ctx, v.ReviewID, func(tx *gorm.DB) error {
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "review_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"helpful", "updated_at"}),
+		}).Create(v).Error
+	})
+}
+
+func (r *postgresRepository) DeleteReviewVote(ctx context.Context, reviewID, uID uint) error {
+	return r.withReviewVotes(ctx, reviewID, func(tx *gorm.DB) error {
+		return tx.Where("review_id = ? AND user_id = ?", reviewID, uID).Delete(&domain.ReviewVote{}).Error
+	})
+}
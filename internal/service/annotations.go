@@ -0,0 +1,223 @@
+package service
+
+import (
+	"E-book-service/internal/domain"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+const (
+	maxAnnotationTextLen = 10000
+
+	ExportMarkdown = "md"
+	ExportJSON     = "json"
+)
+
+var (
+	ErrInvalidAnnotationKind = errors.New("kind must be bookmark, highlight or note")
+	ErrInvalidAnchor         = errors.New("anchor chapter and offset must be non-negative and end must not precede start")
+	ErrEmptyAnnotation       = errors.New("highlight needs selected text and note needs a note body")
+	ErrAnnotationTooLong     = errors.New("selected text and note must be at most 10000 characters")
+	ErrInvalidColor          = errors.New("color must be one of yellow, green, blue, pink, purple, orange or #rrggbb")
+	ErrInvalidExportFormat   = errors.New("export format must be md or json")
+)
+
+var (
+	annotationColors = map[string]bool{
+		"yellow": true, "green": true, "blue": true, "pink": true, "purple": true, "orange": true,
+	}
+	hexColorRe = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+)
+
+// validateAnnotation проверяет и нормализует аннотацию перед сохранением.
+// У закладки нет диапазона, поэтому End приравнивается к Start.
+func validateAnnotation(a *domain.Annotation) error {
+	if !domain.ValidAnnotationKind(a.Kind) {
+		return ErrInvalidAnnotationKind
+	}
+	if a.Start.Chapter < 0 || a.Start.Offset < 0 {
+		return ErrInvalidAnchor
+	}
+	if a.Kind == domain.AnnotationBookmark {
+		a.End = a.Start
+	}
+	if a.End.Before(a.Start) {
+		return ErrInvalidAnchor
+	}
+	a.Note = strings.TrimSpace(a.Note)
+	if a.Kind == domain.AnnotationHighlight && strings.TrimSpace(a.SelectedText) == "" ||
+		a.Kind == domain.AnnotationNote && a.Note == "" {
+		return ErrEmptyAnnotation
+	}
+	if utf8.RuneCountInString(a.SelectedText) > maxAnnotationTextLen || utf8.RuneCountInString(a.Note) > maxAnnotationTextLen {
+		return ErrAnnotationTooLong
+	}
+	a.Color = strings.ToLower(strings.TrimSpace(a.Color))
+	if a.Color != "" && !annotationColors[a.Color] && !hexColorRe.MatchString(a.Color) {
+		return ErrInvalidColor
+	}
+	return nil
+}
+
+func (s *service) CreateAnnotation(ctx context.Context, uID, bookID uint, a *domain.Annotation) error {
+	if err := validateAnnotation(a); err != nil {
+		return err
+	}
+	if _, err := s.repo.GetBookByID(ctx, bookID); err != nil {
+		return err
+	}
+	a.ID, a.UserID, a.BookID = 0, uID, bookID
+	return s.repo.CreateAnnotation(ctx, a)
+}
+
+// GetAnnotations ищет только среди аннотаций самого пользователя.
+func (s *service) GetAnnotations(ctx context.Context, uID uint, f domain.AnnotationFilter) (domain.Page[domain.Annotation], error) {
+	if f.Kind != "" && !domain.ValidAnnotationKind(f.Kind) {
+		return domain.Page[domain.Annotation]{}, ErrInvalidAnnotationKind
+	}
+	f.Query = strings.TrimSpace(f.Query)
+	return s.repo.GetAnnotations(ctx, uID, f)
+}
+
+// GetAnnotation отдаёт аннотацию только её автору; для остальных её как будто нет.
+func (s *service) GetAnnotation(ctx context.Context, uID, id uint) (*domain.Annotation, error) {
+	a, err := s.repo.GetAnnotation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if a.UserID != uID {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return a, nil
+}
+
+// UpdateAnnotation меняет всё, кроме книги; a заполняется сохранённой аннотацией.
+func (s *service) UpdateAnnotation(ctx context.Context, uID uint, a *domain.Annotation) error {
+	if err := validateAnnotation(a); err != nil {
+		return err
+	}
+	existing, err := s.GetAnnotation(ctx, uID, a.ID)
+	if err != nil {
+		return err
+	}
+	existing.Kind, existing.Start, existing.End = a.Kind, a.Start, a.End
+	existing.SelectedText, existing.Note, existing.Color = a.SelectedText, a.Note, a.Color
+	if err := s.repo.UpdateAnnotation(ctx, existing); err != nil {
+		return err
+	}
+	*a = *existing
+	return nil
+}
+
+func (s *service) DeleteAnnotation(ctx context.Context, uID, id uint) error {
+	if _, err := s.GetAnnotation(ctx, uID, id); err != nil {
+		return err
+	}
+	return s.repo.DeleteAnnotation(ctx, id)
+}
+
+// annotationExport — аннотация в экспорте: вместо идентификаторов подставлены
+// название книги и автор, чтобы файл был понятен вне сервиса.
+type annotationExport struct {
+	BookID       uint          `json:"book_id"`
+	BookTitle    string        `json:"book_title"`
+	Author       string        `json:"author,omitempty"`
+	Kind         string        `json:"kind"`
+	Start        domain.Anchor `json:"start"`
+	End          domain.Anchor `json:"end"`
+	SelectedText string        `json:"selected_text,omitempty"`
+	Note         string        `json:"note,omitempty"`
+	Color        string        `json:"color,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+}
+
+// ExportAnnotations выгружает аннотации одной книги (bookID != 0) или все
+// аннотации пользователя в Markdown либо JSON.
+func (s *service) ExportAnnotations(ctx context.Context, uID, bookID uint, format string) ([]byte, error) {
+	if format != ExportMarkdown && format != ExportJSON {
+		return nil, ErrInvalidExportFormat
+	}
+	list, err := s.repo.ExportAnnotations(ctx, uID, bookID)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 && bookID != 0 {
+		if _, err := s.repo.GetBookByID(ctx, bookID); err != nil {
+			return nil, err
+		}
+	}
+
+	items := make([]annotationExport, 0, len(list))
+	for _, a := range list {
+		e := annotationExport{
+			BookID: a.BookID, Kind: a.Kind, Start: a.Start, End: a.End,
+			SelectedText: a.SelectedText, Note: a.Note, Color: a.Color,
+			CreatedAt: a.CreatedAt, UpdatedAt: a.UpdatedAt,
+		}
+		if a.Book != nil {
+			e.BookTitle = a.Book.Title
+			if a.Book.Author != nil {
+				e.Author = a.Book.Author.Name
+			}
+		}
+		items = append(items, e)
+	}
+	if format == ExportJSON {
+		return json.MarshalIndent(map[string]interface{}{
+			"exported_at": time.Now().UTC(),
+			"annotations": items,
+		}, "", "  ")
+	}
+	return renderAnnotationsMarkdown(items), nil
+}
+
+var annotationKindTitles = map[string]string{
+	domain.AnnotationBookmark:  "Bookmark",
+	domain.AnnotationHighlight: "Highlight",
+	domain.AnnotationNote:      "Note",
+}
+
+// renderAnnotationsMarkdown ожидает аннотации, сгруппированные по книгам.
+func renderAnnotationsMarkdown(items []annotationExport) []byte {
+	var b bytes.Buffer
+	b.WriteString("# Annotations\n")
+	var book uint
+	for i, a := range items {
+		if i == 0 || a.BookID != book {
+			book = a.BookID
+			fmt.Fprintf(&b, "\n## %s\n", a.BookTitle)
+			if a.Author != "" {
+				fmt.Fprintf(&b, "\n_%s_\n", a.Author)
+			}
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "- **%s**, chapter %d", annotationKindTitles[a.Kind], a.Start.Chapter)
+		if a.Color != "" {
+			fmt.Fprintf(&b, " (%s)", a.Color)
+		}
+		b.WriteString("\n")
+		if a.SelectedText != "" {
+			b.WriteString("\n")
+			for _, line := range strings.Split(strings.TrimSpace(a.SelectedText), "\n") {
+				b.WriteString(strings.TrimRight("  > "+strings.TrimSpace(line), " ") + "\n")
+			}
+		}
+		if a.Note != "" {
+			b.WriteString("\n")
+			for _, line := range strings.Split(a.Note, "\n") {
+				b.WriteString(strings.TrimRight("  "+line, " ") + "\n")
+			}
+		}
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"E-book-service/internal/domain"
+	"context"
+	"regexp"
+	"strings"
+)
+
+// backfillBatch — сколько книг разбиваем на главы за один проход BackfillChapters.
+const backfillBatch = 100
+
+// headingRe находит строки-заголовки: Markdown "# ...", "Глава 5", "Chapter IV",
+// а также пролог и эпилог. Заголовок должен занимать всю строку.
+var headingRe = regexp.MustCompile(`(?m)^[ \t]*(?:#{1,6}[ \t]+(.+?)[ \t#]*|((?:Глава|ГЛАВА|Chapter|CHAPTER)[ \t]+(?:\d+|[IVXLCDM]+)(?:[ \t.:—–-].*?)?|Пролог|ПРОЛОГ|Эпилог|ЭПИЛОГ|Prologue|PROLOGUE|Epilogue|EPILOGUE)[ \t]*)\r?$`)
+
+// splitChapters режет текст книги на главы по заголовкам. Текст до первого
+// заголовка становится главой без названия. Если заголовков нет, вся книга —
+// одна глава. Для пустого текста глав нет.
+func splitChapters(bookID uint, content string) []domain.Chapter {
+	if strings.TrimSpace(content) == "" {
+		return nil
+	}
+	var chapters []domain.Chapter
+	add := func(title, body string) {
+		body = strings.TrimSpace(body)
+		if title == "" && body == "" {
+			return
+		}
+		chapters = append(chapters, domain.Chapter{
+			BookID:    bookID,
+			Ordinal:   len(chapters) + 1,
+			Title:     title,
+			Body:      body,
+			WordCount: len(strings.Fields(body)),
+		})
+	}
+
+	title, start := "", 0
+	for _, m := range headingRe.FindAllStringSubmatchIndex(content, -1) {
+		add(title, content[start:m[0]])
+		if m[2] >= 0 {
+			title = strings.TrimSpace(content[m[2]:m[3]])
+		} else {
+			title = strings.TrimSpace(content[m[4]:m[5]])
+		}
+		start = m[1]
+	}
+	add(title, content[start:])
+	return chapters
+}
+
+// BackfillChapters разбивает на главы книги, сохранённые до появления глав.
+// Книги идут по возрастанию id, поэтому книга, из текста которой не вышло
+// ни одной главы, повторно не выбирается. Возвращает число обработанных книг.
+func (s *service) BackfillChapters(ctx context.Context) (int, error) {
+	total, lastID := 0, uint(0)
+	for {
+		books, err := s.repo.GetBooksWithoutChapters(ctx, lastID, backfillBatch)
+		if err != nil {
+			return total, err
+		}
+		for _, b := range books {
+			if err := s.repo.ReplaceChapters(ctx, b.ID, splitChapters(b.ID, b.Content)); err != nil {
+				return total, err
+			}
+			lastID = b.ID
+			total++
+		}
+		if len(books) < backfillBatch {
+			return total, nil
+		}
+	}
+}
+
+func (s *service) GetChapters(ctx context.Context, bookID uint) ([]domain.Chapter, error) {
+	chapters, err := s.repo.GetChapters(ctx, bookID)
+	if err != nil {
+		return nil, err
+	}
+	if len(chapters) == 0 {
+		// Пустое оглавление и несуществующая книга должны различаться.
+		if _, err := s.repo.GetBookByID(ctx, bookID); err != nil {
+			return nil, err
+		}
+	}
+	return chapters, nil
+}
+
+func (s *service) GetChapter(ctx context.Context, bookID uint, ordinal int) (*domain.Chapter, error) {
+	return s.repo.GetChapter(ctx, bookID, ordinal)
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"E-book-service/internal/domain"
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+const maxCollectionNameLen = 200
+
+var (
+	ErrInvalidCollectionName = errors.New("collection name is required and must be at most 200 characters")
+	ErrInvalidVisibility     = errors.New("visibility must be private, unlisted or public")
+	ErrBookInCollection      = errors.New("book is already in the collection")
+	ErrInvalidOrder          = errors.New("order must list every book of the collection exactly once")
+)
+
+// validateCollection нормализует имя и видимость перед сохранением.
+func validateCollection(c *domain.Collection) error {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" || utf8.RuneCountInString(c.Name) > maxCollectionNameLen {
+		return ErrInvalidCollectionName
+	}
+	if c.Visibility == "" {
+		c.Visibility = domain.VisibilityPrivate
+	}
+	if !domain.ValidVisibility(c.Visibility) {
+		return ErrInvalidVisibility
+	}
+	return nil
+}
+
+func (s *service) CreateCollection(ctx context.Context, uID uint, c *domain.Collection) error {
+	if err := validateCollection(c); err != nil {
+		return err
+	}
+	slug, err := randomToken(12)
+	if err != nil {
+		return err
+	}
+	c.ID, c.UserID, c.Slug, c.Books = 0, uID, slug, nil
+	return s.repo.CreateCollection(ctx, c)
+}
+
+// GetCollections возвращает все подборки текущего пользователя.
+func (s *service) GetCollections(ctx context.Context, uID uint) ([]domain.Collection, error) {
+	return s.repo.GetCollections(ctx, uID, false)
+}
+
+// GetUserCollections возвращает публичные подборки другого пользователя.
+func (s *service) GetUserCollections(ctx context.Context, uID uint) ([]domain.Collection, error) {
+	return s.repo.GetCollections(ctx, uID, true)
+}
+
+// GetCollection отдаёт подборку владельцу, а чужую — только если она публичная.
+// Для остальных подборка как будто не существует.
+func (s *service) GetCollection(ctx context.Context, uID, id uint) (*domain.Collection, error) {
+	c, err := s.repo.GetCollection(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if c.UserID != uID && c.Visibility != domain.VisibilityPublic {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return c, nil
+}
+
+// GetSharedCollection открывает подборку по ссылке: подходят публичные и скрытые из списков.
+func (s *service) GetSharedCollection(ctx context.Context, slug string) (*domain.Collection, error) {
+	c, err := s.repo.GetCollectionBySlug(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	if c.Visibility == domain.VisibilityPrivate {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return c, nil
+}
+
+// ownCollection загружает подборку, если она принадлежит пользователю.
+func (s *service) ownCollection(ctx context.Context, uID, id uint) (*domain.Collection, error) {
+	c, err := s.repo.GetCollection(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if c.UserID != uID {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return c, nil
+}
+
+// UpdateCollection меняет имя, описание и видимость; c заполняется сохранённой подборкой.
+func (s *service) UpdateCollection(ctx context.Context, uID uint, c *domain.Collection) error {
+	if err := validateCollection(c); err != nil {
+		return err
+	}
+	existing, err := s.ownCollection(ctx, uID, c.ID)
+	if err != nil {
+		return err
+	}
+	existing.Name, existing.Description, existing.Visibility = c.Name, c.Description, c.Visibility
+	if err := s.repo.UpdateCollection(ctx, existing); err != nil {
+		return err
+	}
+	*c = *existing
+	return nil
+}
+
+func (s *service) DeleteCollection(ctx context.Context, uID, id uint) error {
+	if _, err := s.ownCollection(ctx, uID, id); err != nil {
+		return err
+	}
+	return s.repo.DeleteCollection(ctx, id)
+}
+
+// AddBookToCollection добавляет книгу в конец подборки.
+func (s *service) AddBookToCollection(ctx context.Context, uID, id, bookID uint) error {
+	c, err := s.ownCollection(ctx, uID, id)
+	if err != nil {
+		return err
+	}
+	last := 0
+	for _, cb := range c.Books {
+		if cb.BookID == bookID {
+			return ErrBookInCollection
+		}
+		if cb.Position > last {
+			last = cb.Position
+		}
+	}
+	if _, err := s.repo.GetBookByID(ctx, bookID); err != nil {
+		return err
+	}
+	return s.repo.AddCollectionBook(ctx, &domain.CollectionBook{
+		CollectionID: id,
+		BookID:       bookID,
+		Position:     last + 1,
+		AddedAt:      time.Now(),
+	})
+}
+
+func (s *service) RemoveBookFromCollection(ctx context.Context, uID, id, bookID uint) error {
+	if _, err := s.ownCollection(ctx, uID, id); err != nil {
+		return err
+	}
+	return s.repo.RemoveCollectionBook(ctx, id, bookID)
+}
+
+// ReorderCollection задаёт новый порядок книг. bookIDs должен содержать
+// каждую книгу подборки ровно один раз.
+func (s *service) ReorderCollection(ctx context.Context, uID, id uint, bookIDs []uint) error {
+	c, err := s.ownCollection(ctx, uID, id)
+	if err != nil {
+		return err
+	}
+	if len(bookIDs) != len(c.Books) {
+		return ErrInvalidOrder
+	}
+	members := make(map[uint]bool, len(c.Books))
+	for _, cb := range c.Books {
+		members[cb.BookID] = true
+	}
+	for _, bID := range bookIDs {
+		if !members[bID] {
+			return ErrInvalidOrder
+		}
+		delete(members, bID)
+	}
+	return s.repo.ReorderCollection(ctx, id, bookIDs)
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"E-book-service/internal/domain"
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+const (
+	maxCommentLen      = 5000
+	maxCommentDepth    = 8 // глубже отвечать нельзя
+	defaultThreadDepth = 3 // сколько уровней отдаём, если depth не задан
+	commentEditWindow  = 15 * time.Minute
+)
+
+var (
+	ErrInvalidComment   = errors.New("comment body is required and must be at most 5000 characters")
+	ErrInvalidParent    = errors.New("parent comment belongs to another review")
+	ErrThreadTooDeep    = errors.New("thread is too deep to reply")
+	ErrEditWindowClosed = errors.New("comment can only be edited within 15 minutes")
+)
+
+func validateComment(c *domain.ReviewComment) error {
+	c.Body = strings.TrimSpace(c.Body)
+	if c.Body == "" || utf8.RuneCountInString(c.Body) > maxCommentLen {
+		return ErrInvalidComment
+	}
+	return nil
+}
+
+// publishedReview загружает отзыв, под которым можно читать и писать комментарии.
+func (s *service) publishedReview(ctx context.Context, id uint) (*domain.Review, error) {
+	re, err := s.repo.GetReview(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if re.Status != domain.ReviewPublished {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return re, nil
+}
+
+// GetCommentThread собирает дерево комментариев к отзыву. Если задан parentID,
+// возвращаются ответы на этот комментарий. depth ограничивает число уровней;
+// у обрезанных веток выставлен HasMoreReplies. Удалённые комментарии остаются
+// в ветке без текста, пока на них есть ответы.
+func (s *service) GetCommentThread(ctx context.Context, reviewID uint, parentID *uint, depth int) ([]*domain.ReviewComment, error) {
+	if depth <= 0 {
+		depth = defaultThreadDepth
+	}
+	if depth > maxCommentDepth+1 {
+		depth = maxCommentDepth + 1
+	}
+	if _, err := s.publishedReview(ctx, reviewID); err != nil {
+		return nil, err
+	}
+	all, err := s.repo.GetReviewComments(ctx, reviewID)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]*domain.ReviewComment, len(all))
+	roots := []*domain.ReviewComment{}
+	for i := range all {
+		c := &all[i]
+		if c.DeletedAt.Valid {
+			c.Deleted, c.Body = true, ""
+		}
+		byID[c.ID] = c
+	}
+	for i := range all {
+		c := &all[i]
+		if c.ParentID == nil {
+			roots = append(roots, c)
+		} else if p := byID[*c.ParentID]; p != nil {
+			p.Replies = append(p.Replies, c)
+		}
+	}
+	if parentID != nil {
+		p := byID[*parentID]
+		if p == nil {
+			return nil, gorm.ErrRecordNotFound
+		}
+		roots = p.Replies
+	}
+	roots = pruneDeleted(roots)
+	limitDepth(roots, depth)
+	if roots == nil {
+		roots = []*domain.ReviewComment{}
+	}
+	return roots, nil
+}
+
+// pruneDeleted убирает удалённые комментарии, на которые не осталось ответов.
+func pruneDeleted(list []*domain.ReviewComment) []*domain.ReviewComment {
+	var out []*domain.ReviewComment
+	for _, c := range list {
+		c.Replies = pruneDeleted(c.Replies)
+		if c.Deleted && len(c.Replies) == 0 {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func limitDepth(list []*domain.ReviewComment, depth int) {
+	for _, c := range list {
+		if depth <= 1 {
+			c.HasMoreReplies = len(c.Replies) > 0
+			c.Replies = nil
+			continue
+		}
+		limitDepth(c.Replies, depth-1)
+	}
+}
+
+// AddComment публикует комментарий к отзыву или ответ на другой комментарий
+// и уведомляет автора отзыва и автора родительского комментария.
+func (s *service) AddComment(ctx context.Context, uID, reviewID uint, c *domain.ReviewComment) error {
+	if err := validateComment(c); err != nil {
+		return err
+	}
+	re, err := s.publishedReview(ctx, reviewID)
+	if err != nil {
+		return err
+	}
+	c.ID, c.ReviewID, c.UserID, c.Depth, c.EditedAt = 0, reviewID, uID, 0, nil
+
+	var parent *domain.ReviewComment
+	if c.ParentID != nil {
+		if parent, err = s.repo.GetReviewComment(ctx, *c.ParentID); err != nil {
+			return err
+		}
+		if parent.ReviewID != reviewID {
+			return ErrInvalidParent
+		}
+		if parent.Depth >= maxCommentDepth {
+			return ErrThreadTooDeep
+		}
+		c.Depth = parent.Depth + 1
+	}
+
+	// Каждый получает одно уведомление: ответ на свой комментарий важнее,
+	// чем новый комментарий под своим отзывом.
+	notify := map[uint]string{}
+	if re.UserID != uID {
+		notify[re.UserID] = domain.NotificationReviewComment
+	}
+	if parent != nil && parent.UserID != uID {
+		notify[parent.UserID] = domain.NotificationCommentReply
+	}
+	return s.inTx(ctx, func(tx *service) error {
+		if err := tx.repo.CreateReviewComment(ctx, c); err != nil {
+			return err
+		}
+		for userID, kind := range notify {
+			err := tx.repo.CreateNotification(ctx, &domain.Notification{
+				UserID:    userID,
+				Kind:      kind,
+				ActorID:   uID,
+				ReviewID:  reviewID,
+				CommentID: c.ID,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ownComment загружает неудалённый комментарий, если он принадлежит пользователю.
+func (s *service) ownComment(ctx context.Context, uID, id uint) (*domain.ReviewComment, error) {
+	c, err := s.repo.GetReviewComment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if c.UserID != uID {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return c, nil
+}
+
+// EditComment меняет текст своего комментария в течение окна редактирования;
+// c заполняется сохранённым комментарием.
+func (s *service) EditComment(ctx context.Context, uID uint, c *domain.ReviewComment) error {
+	if err := validateComment(c); err != nil {
+		return err
+	}
+	existing, err := s.ownComment(ctx, uID, c.ID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if now.Sub(existing.CreatedAt) > commentEditWindow {
+		return ErrEditWindowClosed
+	}
+	existing.Body, existing.EditedAt = c.Body, &now
+	if err := s.repo.UpdateReviewComment(ctx, existing); err != nil {
+		return err
+	}
+	*c = *existing
+	return nil
+}
+
+func (s *service) DeleteComment(ctx context.Context, uID, id uint) error {
+	if _, err := s.ownComment(ctx, uID, id); err != nil {
+		return err
+	}
+	return s.repo.DeleteReviewComment(ctx, id)
+}
+
+func (s *service) GetNotifications(ctx context.Context, uID uint, unreadOnly bool, q domain.ListQuery) (domain.Page[domain.Notification], error) {
+	return s.repo.GetNotifications(ctx, uID, unreadOnly, q)
+}
+
+func (s *service) MarkNotificationRead(ctx context.Context, uID, id uint) error {
+	return s.repo.MarkNotificationRead(ctx, id, uID)
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"E-book-service/internal/domain"
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidDeletePolicy = errors.New("policy must be restrict or cascade, or reassign for authors")
+	ErrInvalidReassign     = errors.New("reassign_to must be another existing author")
+)
+
+// DependentsError — политика restrict не дала удалить объект: на него ссылаются другие записи.
+type DependentsError struct {
+	Dependents domain.Dependents
+}
+
+func (e *DependentsError) Error() string {
+	return "there are records depending on it, delete with policy cascade or reassign"
+}
+
+// deletePolicy проверяет политику; пустая означает restrict.
+func deletePolicy(policy string, reassignAllowed bool) (string, error) {
+	switch policy {
+	case "":
+		return domain.DeleteRestrict, nil
+	case domain.DeleteRestrict, domain.DeleteCascade:
+		return policy, nil
+	case domain.DeleteReassign:
+		if reassignAllowed {
+			return policy, nil
+		}
+	}
+	return "", ErrInvalidDeletePolicy
+}
+
+// DeleteBook мягко удаляет книгу: она пропадает из каталога и подборок, а полки,
+// отзывы и аннотации читателей остаются. При restrict удаление отклоняется,
+// если такие записи есть. Версия сверяется ещё раз в самом удалении: правка,
+// успевшая между проверкой и удалением, даёт ErrVersionMismatch.
+func (s *service) DeleteBook(ctx context.Context, id uint, opts domain.DeleteOptions) error {
+	policy, err := deletePolicy(opts.Policy, false)
+	if err != nil {
+		return err
+	}
+	b, err := s.repo.GetBookByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := matchVersion(opts.Version, b.Version); err != nil {
+		return err
+	}
+	return s.inTx(ctx, func(tx *service) error {
+		if policy == domain.DeleteRestrict {
+			deps, err := tx.repo.GetBookDependents(ctx, id)
+			if err != nil {
+				return err
+			}
+			if !deps.Empty() {
+				return &DependentsError{Dependents: deps}
+			}
+		}
+		return tx.repo.DeleteBook(ctx, id, opts.Version)
+	})
+}
+
+// DeleteAuthor мягко удаляет автора. Его книги при restrict не дают удалить
+// автора, при cascade удаляются вместе с ним, при reassign переходят к автору
+// opts.ReassignTo. Версия автора, как и у книги, сверяется в самом удалении.
+func (s *service) DeleteAuthor(ctx context.Context, id uint, opts domain.DeleteOptions) error {
+	policy, err := deletePolicy(opts.Policy, true)
+	if err != nil {
+		return err
+	}
+	a, err := s.repo.GetAuthorByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := matchVersion(opts.Version, a.Version); err != nil {
+		return err
+	}
+	if policy == domain.DeleteReassign {
+		if opts.ReassignTo == 0 || opts.ReassignTo == id {
+			return ErrInvalidReassign
+		}
+		if _, err := s.repo.GetAuthorByID(ctx, opts.ReassignTo); errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidReassign
+		} else if err != nil {
+			return err
+		}
+	}
+	return s.inTx(ctx, func(tx *service) error {
+		deps, err := tx.repo.GetAuthorDependents(ctx, id)
+		if err != nil {
+			return err
+		}
+		if len(deps.Books) > 0 {
+			switch policy {
+			case domain.DeleteRestrict:
+				return &DependentsError{Dependents: deps}
+			case domain.DeleteCascade:
+				ids := make([]uint, len(deps.Books))
+				for i, b := range deps.Books {
+					ids[i] = b.ID
+				}
+				err = tx.repo.DeleteBooks(ctx, ids)
+			case domain.DeleteReassign:
+				err = tx.repo.ReassignBooks(ctx, id, opts.ReassignTo)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return tx.repo.DeleteAuthor(ctx, id, opts.Version)
+	})
+}
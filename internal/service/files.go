@@ -0,0 +1,268 @@
+package service
+
+import (
+	"E-book-service/internal/domain"
+	"E-book-service/internal/epub"
+	"E-book-service/internal/storage"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNoFileStore       = errors.New("file storage is not configured")
+	ErrUnsupportedFormat = errors.New("unsupported file format, expected EPUB, PDF or FB2")
+	ErrInvalidEPUB       = errors.New("file looks like EPUB but its package cannot be read")
+)
+
+// UploadResult — ответ на загрузку файла. Metadata заполняется только для EPUB.
+type UploadResult struct {
+	File     *domain.BookFile `json:"file"`
+	Metadata *MetadataReport  `json:"metadata,omitempty"`
+}
+
+// MetadataReport перечисляет поля книги, заполненные из файла, и поля,
+// где значение в файле расходится с уже сохранённым. Такие поля не перезаписываются.
+type MetadataReport struct {
+	Applied   []string           `json:"applied"`
+	Conflicts []MetadataConflict `json:"conflicts"`
+}
+
+type MetadataConflict struct {
+	Field     string `json:"field"`
+	Current   string `json:"current"`
+	Suggested string `json:"suggested"`
+}
+
+var coverExtensions = map[string]string{
+	"image/jpeg":    ".jpg",
+	"image/png":     ".png",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/svg+xml": ".svg",
+}
+
+// sniffLen — сколько байт читаем из начала файла, чтобы определить формат.
+const sniffLen = 1024
+
+// detectFormat определяет формат по содержимому, а не по расширению имени.
+func detectFormat(head []byte) (string, bool) {
+	switch {
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return domain.FormatPDF, true
+	// EPUB — zip-архив, первой записью в котором обязан быть несжатый файл
+	// "mimetype" с содержимым application/epub+zip.
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")) && len(head) >= 58 &&
+		bytes.Equal(head[30:58], []byte("mimetypeapplication/epub+zip")):
+		return domain.FormatEPUB, true
+	case bytes.Contains(head, []byte("<FictionBook")):
+		return domain.FormatFB2, true
+	}
+	return "", false
+}
+
+func (s *service) UploadBookFile(ctx context.Context, bookID uint, name string, r io.Reader) (*UploadResult, error) {
+	if s.files == nil {
+		return nil, ErrNoFileStore
+	}
+	if _, err := s.repo.GetBookByID(ctx, bookID); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, err
+	}
+	format, ok := detectFormat(head)
+	if !ok {
+		return nil, ErrUnsupportedFormat
+	}
+
+	suffix, err := randomToken(12)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("books/%d/%s.%s", bookID, suffix, format)
+	hash := sha256.New()
+	size, err := s.files.Put(key, io.TeeReader(br, hash))
+	if err != nil {
+		return nil, err
+	}
+
+	var meta *epub.Metadata
+	if format == domain.FormatEPUB {
+		if meta, err = s.readEPUB(ctx, key, size); err != nil {
+			_ = s.files.Delete(key)
+			return nil, err
+		}
+	}
+
+	f := &domain.BookFile{
+		BookID:     bookID,
+		Format:     format,
+		FileName:   name,
+		Size:       size,
+		Checksum:   hex.EncodeToString(hash.Sum(nil)),
+		StorageKey: key,
+	}
+	// Запись о файле, новый автор и метаданные книги сохраняются вместе;
+	// при ошибке удаляем и уже записанные файлы: саму книгу и обложку.
+	res := &UploadResult{File: f}
+	stored := []string{key}
+	err = s.inTx(ctx, func(tx *service) error {
+		if err := tx.repo.CreateBookFile(ctx, f); err != nil {
+			return err
+		}
+		if meta == nil {
+			return nil
+		}
+		// Пока файл загружался, книгу могли изменить, а UpdateBook сверяет
+		// версию, поэтому книгу перечитываем уже в транзакции.
+		book, err := tx.repo.GetBookByID(ctx, bookID)
+		if err != nil {
+			return err
+		}
+		res.Metadata, err = tx.applyMetadata(ctx, book, meta, &stored)
+		return err
+	})
+	if err != nil {
+		for _, k := range stored {
+			_ = s.files.Delete(k)
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *service) readEPUB(ctx context.Context, key string, size int64) (*epub.Metadata, error) {
+	content, err := s.files.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	meta, err := epub.Parse(content, size)
+	if errors.Is(err, epub.ErrInvalid) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEPUB, err)
+	}
+	return meta, err
+}
+
+// applyMetadata заполняет пустые поля книги из метаданных EPUB. Непустые
+// поля не трогаем: расхождения возвращаются куратору в отчёте. Ключи
+// записанных в хранилище файлов добавляются в stored.
+func (s *service) applyMetadata(ctx context.Context, book *domain.Book, m *epub.Metadata, stored *[]string) (*MetadataReport, error) {
+	report := &MetadataReport{Applied: []string{}, Conflicts: []MetadataConflict{}}
+	merge := func(field string, current *string, suggested string) {
+		switch {
+		case suggested == "" || strings.EqualFold(strings.TrimSpace(*current), suggested):
+		case strings.TrimSpace(*current) == "":
+			*current = suggested
+			report.Applied = append(report.Applied, field)
+		default:
+			report.Conflicts = append(report.Conflicts, MetadataConflict{Field: field, Current: *current, Suggested: suggested})
+		}
+	}
+	merge("title", &book.Title, m.Title)
+	merge("description", &book.Description, m.Description)
+	merge("language", &book.Language, m.Language)
+	merge("isbn", &book.ISBN, m.ISBN)
+
+	if m.Author != "" {
+		switch {
+		case book.AuthorID == 0:
+			author, err := s.repo.GetAuthorByName(ctx, m.Author)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				author = &domain.Author{Name: m.Author}
+				err = s.repo.CreateAuthor(ctx, author)
+			}
+			if err != nil {
+				return nil, err
+			}
+			book.AuthorID, book.Author = author.ID, author
+			report.Applied = append(report.Applied, "author")
+		case book.Author != nil && !strings.EqualFold(book.Author.Name, m.Author):
+			report.Conflicts = append(report.Conflicts, MetadataConflict{Field: "author", Current: book.Author.Name, Suggested: m.Author})
+		}
+	}
+
+	if m.Cover != nil {
+		ext, ok := coverExtensions[m.Cover.MediaType]
+		switch {
+		case !ok:
+		case book.CoverKey == "":
+			// Ключ случайный, как у файлов книги: параллельные загрузки пишут
+			// разные объекты, и откат одной не удалит обложку, сохранённую другой.
+			// Существующую обложку загрузка не заменяет, так что после коммита
+			// удалять нечего.
+			suffix, err := randomToken(12)
+			if err != nil {
+				return nil, err
+			}
+			key := fmt.Sprintf("books/%d/cover-%s%s", book.ID, suffix, ext)
+			*stored = append(*stored, key)
+			if _, err := s.files.Put(key, bytes.NewReader(m.Cover.Data)); err != nil {
+				return nil, err
+			}
+			book.CoverKey = key
+			report.Applied = append(report.Applied, "cover")
+		default:
+			report.Conflicts = append(report.Conflicts, MetadataConflict{Field: "cover", Current: path.Base(book.CoverKey), Suggested: m.Cover.Name})
+		}
+	}
+
+	if len(report.Applied) > 0 {
+		if err := s.repo.UpdateBook(ctx, book); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+func (s *service) GetBookFiles(ctx context.Context, bookID uint) ([]domain.BookFile, error) {
+	return s.repo.GetBookFiles(ctx, bookID)
+}
+
+// OpenBookFile возвращает описание файла и открытое содержимое. Закрыть его должен вызывающий.
+func (s *service) OpenBookFile(ctx context.Context, bookID, id uint) (*domain.BookFile, storage.File, error) {
+	if s.files == nil {
+		return nil, nil, ErrNoFileStore
+	}
+	f, err := s.repo.GetBookFile(ctx, bookID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := s.files.Open(f.StorageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, content, nil
+}
+
+// OpenBookCover открывает обложку книги. Если обложки нет, возвращает gorm.ErrRecordNotFound.
+func (s *service) OpenBookCover(ctx context.Context, bookID uint) (*domain.Book, storage.File, error) {
+	if s.files == nil {
+		return nil, nil, ErrNoFileStore
+	}
+	book, err := s.repo.GetBookByID(ctx, bookID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if book.CoverKey == "" {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+	content, err := s.files.Open(book.CoverKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return book, content, nil
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"E-book-service/internal/domain"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultReportThreshold = 3
+	maxReportReasonLen     = 1000
+)
+
+var (
+	ErrInvalidReportReason     = errors.New("report reason is required and must be at most 1000 characters")
+	ErrAlreadyReported         = errors.New("you have already reported this review")
+	ErrOwnReview               = errors.New("you cannot report your own review")
+	ErrNotPending              = errors.New("review is not waiting for moderation")
+	ErrInvalidModerationAction = errors.New("moderation action must be approve or reject")
+)
+
+// ModerationConfig задаёт, когда отзыв автоматически уходит на модерацию.
+type ModerationConfig struct {
+	ReportThreshold int      // столько жалоб скрывают опубликованный отзыв
+	Blocklist       []string // слова, с которыми отзыв сразу уходит на модерацию
+}
+
+// WithModeration настраивает порог жалоб и список запрещённых слов.
+// Без него порог — 3 жалобы, список пуст.
+func WithModeration(cfg ModerationConfig) Option {
+	return func(s *service) {
+		if cfg.ReportThreshold > 0 {
+			s.reportThreshold = cfg.ReportThreshold
+		}
+		s.blocklist = make(map[string]bool, len(cfg.Blocklist))
+		for _, w := range cfg.Blocklist {
+			if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+				s.blocklist[w] = true
+			}
+		}
+	}
+}
+
+// blockedWord возвращает первое слово текста из списка запрещённых или "".
+// Сравниваются целые слова без учёта регистра.
+func (s *service) blockedWord(text string) string {
+	if len(s.blocklist) == 0 {
+		return ""
+	}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		if s.blocklist[w] {
+			return w
+		}
+	}
+	return ""
+}
+
+// ReportReview сохраняет жалобу на опубликованный отзыв. Набрав порог жалоб
+// после последнего решения модератора, отзыв уходит на модерацию.
+func (s *service) ReportReview(ctx context.Context, uID, reviewID uint, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if reason == "" || utf8.RuneCountInString(reason) > maxReportReasonLen {
+		return ErrInvalidReportReason
+	}
+	re, err := s.repo.GetReview(ctx, reviewID)
+	if err != nil {
+		return err
+	}
+	if re.Status != domain.ReviewPublished {
+		return gorm.ErrRecordNotFound
+	}
+	if re.UserID == uID {
+		return ErrOwnReview
+	}
+	// Жалоба и скрытие отзыва по порогу фиксируются вместе.
+	err = s.inTx(ctx, func(tx *service) error {
+		n, err := tx.repo.CreateReviewReport(ctx, &domain.ReviewReport{ReviewID: reviewID, UserID: uID, Reason: reason}, re.ModeratedAt)
+		if err != nil || n < int64(s.reportThreshold) {
+			return err
+		}
+		re.Status = domain.ReviewPending
+		err = tx.repo.ModerateReview(ctx, re, &domain.ModerationAction{
+			ReviewID:   re.ID,
+			Action:     domain.ModerationFlag,
+			FromStatus: domain.ReviewPublished,
+			ToStatus:   domain.ReviewPending,
+			Reason:     fmt.Sprintf("%d reports", n),
+		})
+		if errors.Is(err, domain.ErrReviewStatusChanged) {
+			// Отзыв уже скрыт параллельной жалобой или решением модератора; жалоба сохраняется.
+			return nil
+		}
+		return err
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrAlreadyReported
+	}
+	return err
+}
+
+func (s *service) GetModerationQueue(ctx context.Context, q domain.ListQuery) (domain.Page[domain.Review], error) {
+	return s.repo.GetModerationQueue(ctx, q)
+}
+
+// ModerateReview публикует (approve) или отклоняет (reject) отзыв из очереди
+// и записывает решение в журнал.
+func (s *service) ModerateReview(ctx context.Context, moderatorID, reviewID uint, action, reason string) (*domain.Review, error) {
+	var to string
+	switch action {
+	case domain.ModerationApprove:
+		to = domain.ReviewPublished
+	case domain.ModerationReject:
+		to = domain.ReviewRejected
+	default:
+		return nil, ErrInvalidModerationAction
+	}
+	re, err := s.repo.GetReview(ctx, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	if re.Status != domain.ReviewPending {
+		return nil, ErrNotPending
+	}
+	now := time.Now()
+	re.Status, re.ModeratedAt = to, &now
+	err = s.repo.ModerateReview(ctx, re, &domain.ModerationAction{
+		ReviewID:    re.ID,
+		ModeratorID: &moderatorID,
+		Action:      action,
+		FromStatus:  domain.ReviewPending,
+		ToStatus:    to,
+		Reason:      strings.TrimSpace(reason),
+	})
+	if errors.Is(err, domain.ErrReviewStatusChanged) {
+		// Другой модератор успел принять решение раньше.
+		return nil, ErrNotPending
+	}
+	if err != nil {
+		return nil, err
+	}
+	return re, nil
+}
+
+// GetModerationActions возвращает журнал модерации отзыва, старые записи первыми.
+func (s *service) GetModerationActions(ctx context.Context, reviewID uint) ([]domain.ModerationAction, error) {
+	if _, err := s.repo.GetReview(ctx, reviewID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetModerationActions(ctx, reviewID)
+}
+
+// flagBlocked отправляет на модерацию отзыв с запрещённым словом. Вызывается
+// до сохранения; запись в журнал делает logFlag после него, когда известен ID.
+func (s *service) flagBlocked(re *domain.Review) string {
+	word := s.blockedWord(re.Comment)
+	if word != "" && re.Status == domain.ReviewPublished {
+		re.Status = domain.ReviewPending
+		return word
+	}
+	return ""
+}
+
+func (s *service) logFlag(ctx context.Context, re *domain.Review, word string) error {
+	return s.repo.CreateModerationAction(ctx, flagAction(re, word))
+}
+
+func flagAction(re *domain.Review, word string) *domain.ModerationAction {
+	return &domain.ModerationAction{
+		ReviewID:   re.ID,
+		Action:     domain.ModerationFlag,
+		FromStatus: domain.ReviewPublished,
+		ToStatus:   domain.ReviewPending,
+		Reason:     "blocklisted word: " + word,
+	}
+}
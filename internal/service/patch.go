@@ -0,0 +1,165 @@
+package service
+
+import (
+	"E-book-service/internal/domain"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidPatch = errors.New("invalid merge patch")
+	ErrEmailTaken   = errors.New("email is already in use")
+)
+
+// PatchError — поле патча, которое нельзя применить.
+type PatchError struct {
+	Field  string
+	Reason string
+}
+
+func (e *PatchError) Error() string { return fmt.Sprintf("%s: %s", e.Field, e.Reason) }
+func (e *PatchError) Unwrap() error { return ErrInvalidPatch }
+
+// patchFields — поля, которые разрешено менять через PATCH: имя в JSON → указатель на поле.
+type patchFields map[string]interface{}
+
+// applyPatch переносит значения из патча в разрешённые поля. null сбрасывает
+// поле в нулевое значение; поле вне списка или значение не того типа — ошибка.
+func applyPatch(p domain.MergePatch, fields patchFields) error {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		dst, ok := fields[name]
+		if !ok {
+			return &PatchError{Field: name, Reason: "field cannot be changed"}
+		}
+		raw := p[name]
+		if string(raw) == "null" {
+			v := reflect.ValueOf(dst).Elem()
+			v.Set(reflect.Zero(v.Type()))
+			continue
+		}
+		if err := json.Unmarshal(raw, dst); err != nil {
+			return &PatchError{Field: name, Reason: "invalid value"}
+		}
+	}
+	return nil
+}
+
+// requireText отклоняет патч, который оставил обязательное поле пустым.
+func requireText(field string, v *string) error {
+	*v = strings.TrimSpace(*v)
+	if *v == "" {
+		return &PatchError{Field: field, Reason: "must not be empty"}
+	}
+	return nil
+}
+
+// PatchBook применяет merge patch к книге версии version и возвращает её в
+// сохранённом виде. Главы пересобираются, только если изменился текст.
+func (s *service) PatchBook(ctx context.Context, id uint, version int64, p domain.MergePatch) (*domain.Book, error) {
+	err := s.inTx(ctx, func(tx *service) error {
+		b, err := tx.repo.GetBookByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := matchVersion(version, b.Version); err != nil {
+			return err
+		}
+		content, authorID := b.Content, b.AuthorID
+		err = applyPatch(p, patchFields{
+			"title":       &b.Title,
+			"description": &b.Description,
+			"content":     &b.Content,
+			"language":    &b.Language,
+			"isbn":        &b.ISBN,
+			"author_id":   &b.AuthorID,
+		})
+		if err != nil {
+			return err
+		}
+		if err := requireText("title", &b.Title); err != nil {
+			return err
+		}
+		if b.AuthorID != authorID {
+			if _, err := tx.repo.GetAuthorByID(ctx, b.AuthorID); errors.Is(err, gorm.ErrRecordNotFound) {
+				return &PatchError{Field: "author_id", Reason: "author not found"}
+			} else if err != nil {
+				return err
+			}
+		}
+		if err := tx.repo.UpdateBook(ctx, b); err != nil {
+			return err
+		}
+		if b.Content == content {
+			return nil
+		}
+		return tx.repo.ReplaceChapters(ctx, b.ID, splitChapters(b.ID, b.Content))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetBookByID(ctx, id)
+}
+
+// PatchAuthor применяет merge patch к автору версии version и возвращает его в сохранённом виде.
+func (s *service) PatchAuthor(ctx context.Context, id uint, version int64, p domain.MergePatch) (*domain.Author, error) {
+	a, err := s.repo.GetAuthorByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := matchVersion(version, a.Version); err != nil {
+		return nil, err
+	}
+	if err := applyPatch(p, patchFields{"name": &a.Name, "bio": &a.Bio}); err != nil {
+		return nil, err
+	}
+	if err := requireText("name", &a.Name); err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdateAuthor(ctx, a); err != nil {
+		return nil, err
+	}
+	return s.repo.GetAuthorByID(ctx, id)
+}
+
+// PatchProfile меняет имя и email пользователя. Роль и пароль так не меняются.
+func (s *service) PatchProfile(ctx context.Context, uID uint, p domain.MergePatch) (*domain.User, error) {
+	u, err := s.repo.GetUserByID(ctx, uID)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyPatch(p, patchFields{"name": &u.Name, "email": &u.Email}); err != nil {
+		return nil, err
+	}
+	if err := s.saveProfile(ctx, u); err != nil {
+		return nil, err
+	}
+	return s.repo.GetUserByID(ctx, uID)
+}
+
+// saveProfile проверяет email и сохраняет имя и email пользователя.
+func (s *service) saveProfile(ctx context.Context, u *domain.User) error {
+	if err := requireText("email", &u.Email); err != nil {
+		return err
+	}
+	if !strings.Contains(u.Email, "@") {
+		return &PatchError{Field: "email", Reason: "must be an email address"}
+	}
+	if err := s.repo.UpdateUser(ctx, u); errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrEmailTaken
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
@@ -3,91 +3,693 @@ package service
 import (
 	"E-book-service/internal/domain"
 	"E-book-service/internal/repository"
+	"E-book-service/internal/storage"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 type ServiceInterface interface {
-	Register(email, pass, name string) error
-	Login(email, pass string) (string, error)
-	GetProfile(id uint) (*domain.User, error)
-	UpdateProfile(u *domain.User) error
-	CreateBook(b *domain.Book) error
-	GetAllBooks() ([]domain.Book, error)
-	GetBook(id uint) (*domain.Book, error)
-	UpdateBook(b *domain.Book) error
-	DeleteBook(id uint) error
-	GetBooksByAuthor(aID uint) ([]domain.Book, error)
-	CreateAuthor(a *domain.Author) error
-	GetAllAuthors() ([]domain.Author, error)
-	GetAuthor(id uint) (*domain.Author, error)
-	UpdateAuthor(a *domain.Author) error
-	DeleteAuthor(id uint) error
-	AddReview(re *domain.Review) error
-	GetReviews(bID uint) ([]domain.Review, error)
-	DeleteReview(id, uID uint) error
-	SetShelfStatus(uID, bID uint, status string) error
-	GetShelf(uID uint) ([]domain.Shelf, error)
-	RemoveFromShelf(uID, bID uint) error
+	Register(ctx context.Context, email, pass, name string) error
+	Login(ctx context.Context, email, pass string) (*TokenPair, error)
+	Refresh(ctx context.Context, refreshToken string) (*TokenPair, error)
+	Logout(ctx context.Context, uID uint, jti string, exp time.Time, refreshToken string) error
+	LogoutAll(ctx context.Context, uID uint) error
+	GetProfile(ctx context.Context, id uint) (*domain.User, error)
+	UpdateProfile(ctx context.Context, u *domain.User) error
+	PatchProfile(ctx context.Context, uID uint, p domain.MergePatch) (*domain.User, error)
+	SetUserRole(ctx context.Context, actorID, id uint, role string) error
+	CreateBook(ctx context.Context, b *domain.Book) error
+	GetAllBooks(ctx context.Context, f domain.BookFilter) (domain.Page[domain.Book], error)
+	GetBook(ctx context.Context, id uint) (*domain.Book, error)
+	UpdateBook(ctx context.Context, b *domain.Book) error
+	PatchBook(ctx context.Context, id uint, version int64, p domain.MergePatch) (*domain.Book, error)
+	DeleteBook(ctx context.Context, id uint, opts domain.DeleteOptions) error
+	GetBooksByAuthor(ctx context.Context, aID uint, f domain.BookFilter) (domain.Page[domain.Book], error)
+	SearchBooks(ctx context.Context, q domain.SearchQuery) (domain.Page[domain.SearchHit], error)
+	Autocomplete(ctx context.Context, q string, limit int) (domain.Autocomplete, error)
+	GetChapters(ctx context.Context, bookID uint) ([]domain.Chapter, error)
+	GetChapter(ctx context.Context, bookID uint, ordinal int) (*domain.Chapter, error)
+	BackfillChapters(ctx context.Context) (int, error)
+	UploadBookFile(ctx context.Context, bookID uint, name string, r io.Reader) (*UploadResult, error)
+	GetBookFiles(ctx context.Context, bookID uint) ([]domain.BookFile, error)
+	OpenBookFile(ctx context.Context, bookID, id uint) (*domain.BookFile, storage.File, error)
+	OpenBookCover(ctx context.Context, bookID uint) (*domain.Book, storage.File, error)
+	CreateAuthor(ctx context.Context, a *domain.Author) error
+	GetAllAuthors(ctx context.Context, f domain.AuthorFilter) (domain.Page[domain.Author], error)
+	GetAuthor(ctx context.Context, id uint) (*domain.Author, error)
+	UpdateAuthor(ctx context.Context, a *domain.Author) error
+	PatchAuthor(ctx context.Context, id uint, version int64, p domain.MergePatch) (*domain.Author, error)
+	DeleteAuthor(ctx context.Context, id uint, opts domain.DeleteOptions) error
+	AddReview(ctx context.Context, re *domain.Review) error
+	GetReviews(ctx context.Context, bID uint, q domain.ListQuery) (domain.Page[domain.Review], error)
+	UpdateReview(ctx context.Context, uID uint, re *domain.Review) error
+	RecomputeRatings(ctx context.Context) (int64, error)
+	VoteReview(ctx context.Context, uID, reviewID uint, helpful bool) (*domain.Review, error)
+	UnvoteReview(ctx context.Context, uID, reviewID uint) (*domain.Review, error)
+	ReportReview(ctx context.Context, uID, reviewID uint, reason string) error
+	GetModerationQueue(ctx context.Context, q domain.ListQuery) (domain.Page[domain.Review], error)
+	ModerateReview(ctx context.Context, moderatorID, reviewID uint, action, reason string) (*domain.Review, error)
+	GetModerationActions(ctx context.Context, reviewID uint) ([]domain.ModerationAction, error)
+	GetCommentThread(ctx context.Context, reviewID uint, parentID *uint, depth int) ([]*domain.ReviewComment, error)
+	AddComment(ctx context.Context, uID, reviewID uint, c *domain.ReviewComment) error
+	EditComment(ctx context.Context, uID uint, c *domain.ReviewComment) error
+	DeleteComment(ctx context.Context, uID, id uint) error
+	GetNotifications(ctx context.Context, uID uint, unreadOnly bool, q domain.ListQuery) (domain.Page[domain.Notification], error)
+	MarkNotificationRead(ctx context.Context, uID, id uint) error
+	DeleteReview(ctx context.Context, id, uID uint) error
+	CreateCollection(ctx context.Context, uID uint, c *domain.Collection) error
+	GetCollections(ctx context.Context, uID uint) ([]domain.Collection, error)
+	GetUserCollections(ctx context.Context, uID uint) ([]domain.Collection, error)
+	GetCollection(ctx context.Context, uID, id uint) (*domain.Collection, error)
+	GetSharedCollection(ctx context.Context, slug string) (*domain.Collection, error)
+	UpdateCollection(ctx context.Context, uID uint, c *domain.Collection) error
+	DeleteCollection(ctx context.Context, uID, id uint) error
+	AddBookToCollection(ctx context.Context, uID, id, bookID uint) error
+	RemoveBookFromCollection(ctx context.Context, uID, id, bookID uint) error
+	ReorderCollection(ctx context.Context, uID, id uint, bookIDs []uint) error
+	CreateAnnotation(ctx context.Context, uID, bookID uint, a *domain.Annotation) error
+	GetAnnotations(ctx context.Context, uID uint, f domain.AnnotationFilter) (domain.Page[domain.Annotation], error)
+	GetAnnotation(ctx context.Context, uID, id uint) (*domain.Annotation, error)
+	UpdateAnnotation(ctx context.Context, uID uint, a *domain.Annotation) error
+	DeleteAnnotation(ctx context.Context, uID, id uint) error
+	ExportAnnotations(ctx context.Context, uID, bookID uint, format string) ([]byte, error)
+	SetShelfStatus(ctx context.Context, uID, bID uint, status string) error
+	GetShelf(ctx context.Context, uID uint) ([]domain.Shelf, error)
+	UpdateProgress(ctx context.Context, uID, bID uint, pos domain.ReadingPosition) (*domain.Shelf, error)
+	SyncPosition(ctx context.Context, uID, bID uint, u domain.PositionUpdate) (*SyncResult, error)
+	RemoveFromShelf(ctx context.Context, uID, bID uint) error
+}
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+
+	autocompleteTTL     = time.Minute
+	autocompleteDefault = 5
+	autocompleteMax     = 20
+	autocompleteMinLen  = 2
+)
+
+var (
+	ErrInvalidRole         = errors.New("invalid role")
+	ErrSelfRoleChange      = errors.New("cannot change your own role")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected, please log in again")
+	ErrNoDenylist          = errors.New("token revocation is not configured")
+	ErrEmptySearchQuery    = errors.New("search query is empty")
+	ErrUnsupportedLanguage = errors.New("unsupported search language, use ru or en")
+	ErrInvalidProgress     = errors.New("invalid reading position: chapter and offset must be non-negative, percent within 0..100")
+	ErrInvalidShelfStatus  = errors.New("unknown shelf status")
+	ErrInvalidTransition   = errors.New("shelf status cannot be changed this way")
+	ErrInvalidRating       = errors.New("rating must be between 1 and 5")
+	ErrReviewExists        = errors.New("you have already reviewed this book, edit your review instead")
+	ErrOwnReviewVote       = errors.New("you cannot vote for your own review")
+)
+
+// StatusError сообщает клиенту, какие статусы допустимы в текущем состоянии.
+type StatusError struct {
+	Err     error
+	Allowed []string
+}
+
+func (e *StatusError) Error() string { return e.Err.Error() }
+func (e *StatusError) Unwrap() error { return e.Err }
+
+// TokenPair отдаётся клиенту при логине и при обновлении токенов.
+// Access-токен лежит под ключом "token", как и раньше.
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
 }
 
 type service struct {
-	repo   repository.Repository // Используем интерфейс!
-	jwtKey string
+	repo     repository.Repository // Используем интерфейс!
+	denylist repository.Denylist
+	cache    repository.Cache
+	files    storage.FileStore
+	jwtKey   string
+
+	reportThreshold int
+	blocklist       map[string]bool
 }
 
-func NewService(r repository.Repository, key string) ServiceInterface {
-	return &service{repo: r, jwtKey: key}
+// Option подключает к сервису необязательные зависимости.
+type Option func(*service)
+
+// WithDenylist включает отзыв access-токенов (logout).
+func WithDenylist(d repository.Denylist) Option {
+	return func(s *service) { s.denylist = d }
+}
+
+// WithCache включает кэширование автодополнения.
+func WithCache(c repository.Cache) Option {
+	return func(s *service) { s.cache = c }
+}
+
+// WithFileStore включает загрузку и скачивание файлов книг.
+func WithFileStore(fs storage.FileStore) Option {
+	return func(s *service) { s.files = fs }
+}
+
+func NewService(r repository.Repository, key string, opts ...Option) ServiceInterface {
+	s := &service{repo: r, jwtKey: key, reportThreshold: defaultReportThreshold}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
-func (s *service) Register(email, pass, name string) error {
+// inTx выполняет fn в транзакции репозитория. Внутри fn сервис tx работает
+// через транзакцию, поэтому вспомогательные методы можно вызывать как обычно.
+func (s *service) inTx(ctx context.Context, fn func(tx *service) error) error {
+	return s.repo.WithTx(ctx, func(repo repository.Repository) error {
+		tx := *s
+		tx.repo = repo
+		return fn(&tx)
+	})
+}
+
+func (s *service) Register(ctx context.Context, email, pass, name string) error {
 	hash, _ := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
-	return s.repo.CreateUser(&domain.User{Email: email, Password: string(hash), Name: name})
+	return s.repo.CreateUser(ctx, &domain.User{Email: email, Password: string(hash), Name: name})
 }
 
-func (s *service) Login(email, pass string) (string, error) {
-	u, err := s.repo.GetUserByEmail(email)
+func (s *service) Login(ctx context.Context, email, pass string) (*TokenPair, error) {
+	u, err := s.repo.GetUserByEmail(ctx, email)
 	if err != nil || bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(pass)) != nil {
-		return "", errors.New("invalid credentials")
+		return nil, errors.New("invalid credentials")
+	}
+	family, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	return s.issueTokens(ctx, u, family, func(rt *domain.RefreshToken) error { return s.repo.CreateRefreshToken(ctx, rt) })
+}
+
+// Refresh обменивает refresh-токен на новую пару. Старый токен при этом отзывается.
+// Повторное предъявление уже отозванного токена означает, что он утёк,
+// поэтому отзывается всё семейство, и пользователю придётся войти заново.
+func (s *service) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	rt, err := s.repo.GetRefreshTokenByHash(ctx, hashToken(refreshToken))
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if rt.RevokedAt != nil {
+		if err := s.repo.RevokeTokenFamily(ctx, rt.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	if time.Now().After(rt.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+	u, err := s.repo.GetUserByID(ctx, rt.UserID)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	pair, err := s.issueTokens(ctx, u, rt.FamilyID, func(next *domain.RefreshToken) error {
+		return s.repo.RotateRefreshToken(ctx, rt.ID, next)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Токен успели ротировать параллельно: считаем это повторным использованием.
+		if err := s.repo.RevokeTokenFamily(ctx, rt.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	return pair, err
+}
+
+// Logout отзывает текущий access-токен и, если клиент его прислал,
+// всё семейство refresh-токена этого устройства.
+func (s *service) Logout(ctx context.Context, uID uint, jti string, exp time.Time, refreshToken string) error {
+	if s.denylist == nil {
+		return ErrNoDenylist
+	}
+	if jti != "" {
+		if err := s.denylist.RevokeToken(ctx, jti, time.Until(exp)); err != nil {
+			return err
+		}
+	}
+	if refreshToken == "" {
+		return nil
+	}
+	rt, err := s.repo.GetRefreshTokenByHash(ctx, hashToken(refreshToken))
+	if err != nil || rt.UserID != uID {
+		return nil // чужой или неизвестный токен просто игнорируем
 	}
+	return s.repo.RevokeTokenFamily(ctx, rt.FamilyID)
+}
+
+// LogoutAll завершает сессии пользователя на всех устройствах.
+func (s *service) LogoutAll(ctx context.Context, uID uint) error {
+	if s.denylist == nil {
+		return ErrNoDenylist
+	}
+	if err := s.denylist.RevokeUser(ctx, uID, time.Now(), accessTokenTTL); err != nil {
+		return err
+	}
+	return s.repo.RevokeUserRefreshTokens(ctx, uID)
+}
+
+func (s *service) issueTokens(ctx context.Context, u *domain.User, family string, store func(*domain.RefreshToken) error) (*TokenPair, error) {
+	access, err := s.signAccessToken(u)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	rt := &domain.RefreshToken{
+		UserID:    u.ID,
+		FamilyID:  family,
+		TokenHash: hashToken(raw),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}
+	if err := store(rt); err != nil {
+		return nil, err
+	}
+	return &TokenPair{AccessToken: access, RefreshToken: raw, ExpiresIn: int64(accessTokenTTL.Seconds())}, nil
+}
+
+func (s *service) signAccessToken(u *domain.User) (string, error) {
+	role := u.Role
+	if role == "" {
+		role = domain.RoleReader
+	}
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":  u.ID,
-		"exp": time.Now().Add(time.Hour * 72).Unix(),
+		"id":   u.ID,
+		"role": role,
+		"jti":  jti,
+		// iat с миллисекундами: токен, выпущенный сразу после "выйти везде",
+		// должен оказаться позже момента выхода, даже в ту же секунду.
+		"iat": float64(now.UnixMilli()) / 1e3,
+		"exp": now.Add(accessTokenTTL).Unix(),
 	})
 	return token.SignedString([]byte(s.jwtKey))
 }
 
-func (s *service) GetProfile(id uint) (*domain.User, error) { return s.repo.GetUserByID(id) }
-func (s *service) UpdateProfile(u *domain.User) error       { return s.repo.UpdateUser(u) }
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *service) GetProfile(ctx context.Context, id uint) (*domain.User, error) {
+	return s.repo.GetUserByID(ctx, id)
+}
+
+// UpdateProfile заменяет имя и email пользователя u.ID. Остальные поля из
+// запроса не сохраняются; u заполняется сохранённым профилем.
+func (s *service) UpdateProfile(ctx context.Context, u *domain.User) error {
+	cur, err := s.repo.GetUserByID(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	cur.Name, cur.Email = u.Name, u.Email
+	if err := s.saveProfile(ctx, cur); err != nil {
+		return err
+	}
+	*u = *cur
+	return nil
+}
+
+// SetUserRole повышает или понижает пользователя. Менять собственную роль нельзя,
+// чтобы администратор случайно не лишил себя доступа. Роль зашита в access-токен,
+// поэтому все сессии пользователя завершаются: со старыми правами он не доработает
+// до истечения токена.
+func (s *service) SetUserRole(ctx context.Context, actorID, id uint, role string) error {
+	if !domain.ValidRole(role) {
+		return ErrInvalidRole
+	}
+	if actorID == id {
+		return ErrSelfRoleChange
+	}
+	err := s.inTx(ctx, func(tx *service) error {
+		if err := tx.repo.UpdateUserRole(ctx, id, role); err != nil {
+			return err
+		}
+		return tx.repo.RevokeUserRefreshTokens(ctx, id)
+	})
+	if err != nil || s.denylist == nil {
+		return err
+	}
+	return s.denylist.RevokeUser(ctx, id, time.Now(), accessTokenTTL)
+}
 
 // BOOKS
-func (s *service) CreateBook(b *domain.Book) error       { return s.repo.CreateBook(b) }
-func (s *service) GetAllBooks() ([]domain.Book, error)   { return s.repo.GetBooks() }
-func (s *service) GetBook(id uint) (*domain.Book, error) { return s.repo.GetBookByID(id) }
-func (s *service) UpdateBook(b *domain.Book) error       { return s.repo.UpdateBook(b) }
-func (s *service) DeleteBook(id uint) error              { return s.repo.DeleteBook(id) }
-func (s *service) GetBooksByAuthor(aID uint) ([]domain.Book, error) {
-	return s.repo.GetBooksByAuthor(aID)
+// CreateBook сохраняет книгу (и нового автора, если он передан вместе с ней)
+// и разбивает её на главы в одной транзакции.
+func (s *service) CreateBook(ctx context.Context, b *domain.Book) error {
+	b.Version = 0
+	return s.inTx(ctx, func(tx *service) error {
+		if err := tx.repo.CreateBook(ctx, b); err != nil {
+			return err
+		}
+		return tx.repo.ReplaceChapters(ctx, b.ID, splitChapters(b.ID, b.Content))
+	})
+}
+func (s *service) GetAllBooks(ctx context.Context, f domain.BookFilter) (domain.Page[domain.Book], error) {
+	return s.repo.GetBooks(ctx, f)
+}
+func (s *service) GetBook(ctx context.Context, id uint) (*domain.Book, error) {
+	return s.repo.GetBookByID(ctx, id)
+}
+
+// matchVersion сверяет версию из If-Match с текущей; 0 соответствует "If-Match: *".
+func matchVersion(expected, current int64) error {
+	if expected != 0 && expected != current {
+		return domain.ErrVersionMismatch
+	}
+	return nil
+}
+
+// UpdateBook заменяет поля книги. b.Version — версия, которую видел клиент.
+// Обложка задаётся только загрузкой файла, поэтому сохраняется как была.
+func (s *service) UpdateBook(ctx context.Context, b *domain.Book) error {
+	return s.inTx(ctx, func(tx *service) error {
+		cur, err := tx.repo.GetBookByID(ctx, b.ID)
+		if err != nil {
+			return err
+		}
+		if err := matchVersion(b.Version, cur.Version); err != nil {
+			return err
+		}
+		b.Version, b.CoverKey = cur.Version, cur.CoverKey
+		if err := tx.repo.UpdateBook(ctx, b); err != nil {
+			return err
+		}
+		return tx.repo.ReplaceChapters(ctx, b.ID, splitChapters(b.ID, b.Content))
+	})
+}
+func (s *service) GetBooksByAuthor(ctx context.Context, aID uint, f domain.BookFilter) (domain.Page[domain.Book], error) {
+	f.AuthorID = aID
+	return s.repo.GetBooks(ctx, f)
+}
+
+// SearchBooks ищет по названию, описанию, тексту книги и имени автора.
+// По умолчанию используется русская конфигурация: большая часть каталога на русском.
+func (s *service) SearchBooks(ctx context.Context, q domain.SearchQuery) (domain.Page[domain.SearchHit], error) {
+	q.Text = strings.TrimSpace(q.Text)
+	if q.Text == "" {
+		return domain.Page[domain.SearchHit]{}, ErrEmptySearchQuery
+	}
+	switch q.Lang {
+	case "":
+		q.Lang = domain.SearchLangRU
+	case domain.SearchLangRU, domain.SearchLangEN:
+	default:
+		return domain.Page[domain.SearchHit]{}, ErrUnsupportedLanguage
+	}
+	return s.repo.SearchBooks(ctx, q)
+}
+
+// Autocomplete подсказывает книги и авторов по триграммной похожести.
+// Запрос проверяется и как есть, и в транслитерации, поэтому "Tolstoy" находит "Толстой".
+// Ответ кэшируется на минуту; ошибки Redis не мешают ответить из базы.
+func (s *service) Autocomplete(ctx context.Context, q string, limit int) (domain.Autocomplete, error) {
+	q = normalizeQuery(q)
+	if limit <= 0 {
+		limit = autocompleteDefault
+	}
+	if limit > autocompleteMax {
+		limit = autocompleteMax
+	}
+	if utf8.RuneCountInString(q) < autocompleteMinLen {
+		return domain.Autocomplete{Books: []domain.Suggestion{}, Authors: []domain.Suggestion{}}, nil
+	}
+
+	key := fmt.Sprintf("autocomplete:%d:%s", limit, q)
+	var res domain.Autocomplete
+	if s.cache != nil {
+		if ok, err := s.cache.Get(ctx, key, &res); err == nil && ok {
+			return res, nil
+		}
+	}
+	res, err := s.repo.Autocomplete(ctx, queryVariants(q), limit)
+	if err != nil {
+		return res, err
+	}
+	if s.cache != nil {
+		_ = s.cache.Set(ctx, key, res, autocompleteTTL)
+	}
+	return res, nil
 }
 
 // AUTHORS
-func (s *service) CreateAuthor(a *domain.Author) error       { return s.repo.CreateAuthor(a) }
-func (s *service) GetAllAuthors() ([]domain.Author, error)   { return s.repo.GetAuthors() }
-func (s *service) GetAuthor(id uint) (*domain.Author, error) { return s.repo.GetAuthorByID(id) }
-func (s *service) UpdateAuthor(a *domain.Author) error       { return s.repo.UpdateAuthor(a) }
-func (s *service) DeleteAuthor(id uint) error                { return s.repo.DeleteAuthor(id) }
+func (s *service) CreateAuthor(ctx context.Context, a *domain.Author) error {
+	a.Version = 0
+	return s.repo.CreateAuthor(ctx, a)
+}
+func (s *service) GetAllAuthors(ctx context.Context, f domain.AuthorFilter) (domain.Page[domain.Author], error) {
+	return s.repo.GetAuthors(ctx, f)
+}
+func (s *service) GetAuthor(ctx context.Context, id uint) (*domain.Author, error) {
+	return s.repo.GetAuthorByID(ctx, id)
+}
+
+// UpdateAuthor заменяет имя и биографию автора версии a.Version.
+func (s *service) UpdateAuthor(ctx context.Context, a *domain.Author) error {
+	cur, err := s.repo.GetAuthorByID(ctx, a.ID)
+	if err != nil {
+		return err
+	}
+	if err := matchVersion(a.Version, cur.Version); err != nil {
+		return err
+	}
+	a.Version = cur.Version
+	return s.repo.UpdateAuthor(ctx, a)
+}
 
 // REVIEWS
-func (s *service) AddReview(re *domain.Review) error            { return s.repo.CreateReview(re) }
-func (s *service) GetReviews(bID uint) ([]domain.Review, error) { return s.repo.GetReviewsByBook(bID) }
-func (s *service) DeleteReview(id, uID uint) error              { return s.repo.DeleteReview(id, uID) }
+// AddReview сохраняет отзыв. Второй отзыв того же пользователя на книгу
+// отклоняется уникальным индексом и возвращает ErrReviewExists.
+// Отзыв с запрещённым словом сохраняется, но ждёт модерации.
+func (s *service) AddReview(ctx context.Context, re *domain.Review) error {
+	if re.Rating < domain.MinRating || re.Rating > domain.MaxRating {
+		return ErrInvalidRating
+	}
+	if _, err := s.repo.GetBookByID(ctx, re.BookID); err != nil {
+		return err
+	}
+	re.ID, re.Edited, re.Status, re.ModeratedAt, re.Reports = 0, false, domain.ReviewPublished, nil, nil
+	re.HelpfulCount, re.NotHelpfulCount = 0, 0 // голоса считаются только по review_votes
+	word := s.flagBlocked(re)
+	err := s.inTx(ctx, func(tx *service) error {
+		if err := tx.repo.CreateReview(ctx, re); err != nil || word == "" {
+			return err
+		}
+		return tx.logFlag(ctx, re, word)
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrReviewExists
+	}
+	return err
+}
+
+// UpdateReview меняет оценку и текст своего отзыва и помечает его отредактированным;
+// re заполняется сохранённым отзывом. Чужой отзыв для пользователя не существует.
+func (s *service) UpdateReview(ctx context.Context, uID uint, re *domain.Review) error {
+	if re.Rating < domain.MinRating || re.Rating > domain.MaxRating {
+		return ErrInvalidRating
+	}
+	existing, err := s.repo.GetReview(ctx, re.ID)
+	if err != nil {
+		return err
+	}
+	if existing.UserID != uID {
+		return gorm.ErrRecordNotFound
+	}
+	existing.Rating, existing.Comment, existing.Edited = re.Rating, re.Comment, true
+	word := s.flagBlocked(existing)
+	err = s.inTx(ctx, func(tx *service) error {
+		if err := tx.repo.UpdateReview(ctx, existing); err != nil || word == "" {
+			return err
+		}
+		// Статус правка не пишет: на модерацию отзыв уходит условным переходом
+		// из published, как по жалобам.
+		err := tx.repo.ModerateReview(ctx, existing, flagAction(existing, word))
+		if !errors.Is(err, domain.ErrReviewStatusChanged) {
+			return err
+		}
+		// Отзыв уже снят с публикации параллельно; правка сохраняется, статус — текущий.
+		cur, err := tx.repo.GetReview(ctx, existing.ID)
+		if err != nil {
+			return err
+		}
+		existing.Status, existing.ModeratedAt = cur.Status, cur.ModeratedAt
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	*re = *existing
+	return nil
+}
+func (s *service) GetReviews(ctx context.Context, bID uint, q domain.ListQuery) (domain.Page[domain.Review], error) {
+	return s.repo.GetReviewsByBook(ctx, bID, q)
+}
+func (s *service) DeleteReview(ctx context.Context, id, uID uint) error {
+	return s.repo.DeleteReview(ctx, id, uID)
+}
+
+// votableReview загружает опубликованный чужой отзыв; скрытые отзывы для голосования не существуют.
+func (s *service) votableReview(ctx context.Context, uID, reviewID uint) error {
+	re, err := s.repo.GetReview(ctx, reviewID)
+	if err != nil {
+		return err
+	}
+	if re.Status != domain.ReviewPublished {
+		return gorm.ErrRecordNotFound
+	}
+	if re.UserID == uID {
+		return ErrOwnReviewVote
+	}
+	return nil
+}
+
+// VoteReview отмечает отзыв полезным или бесполезным и возвращает его
+// с пересчитанными счётчиками. Повторный голос заменяет прежний.
+func (s *service) VoteReview(ctx context.Context, uID, reviewID uint, helpful bool) (*domain.Review, error) {
+	if err := s.votableReview(ctx, uID, reviewID); err != nil {
+		return nil, err
+	}
+	if err := s.repo.SaveReviewVote(ctx, &domain.ReviewVote{ReviewID: reviewID, UserID: uID, Helpful: helpful}); err != nil {
+		return nil, err
+	}
+	return s.repo.GetReview(ctx, reviewID)
+}
+
+// UnvoteReview снимает голос пользователя; если голоса не было, ничего не меняется.
+func (s *service) UnvoteReview(ctx context.Context, uID, reviewID uint) (*domain.Review, error) {
+	if err := s.votableReview(ctx, uID, reviewID); err != nil {
+		return nil, err
+	}
+	if err := s.repo.DeleteReviewVote(ctx, reviewID, uID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetReview(ctx, reviewID)
+}
+
+// RecomputeRatings пересчитывает сводку оценок всех книг с нуля,
+// например после ручной правки отзывов в базе.
+func (s *service) RecomputeRatings(ctx context.Context) (int64, error) {
+	return s.repo.RecomputeRatings(ctx)
+}
 
 // SHELF
-func (s *service) SetShelfStatus(uID, bID uint, status string) error {
-	return s.repo.AddToShelf(&domain.Shelf{UserID: uID, BookID: bID, Status: status, UpdatedAt: time.Now()})
+// shelfEntry возвращает запись полки или новую, ещё не сохранённую, если книги на полке нет.
+// Для новой записи проверяет, что книга существует.
+func (s *service) shelfEntry(ctx context.Context, uID, bID uint) (*domain.Shelf, error) {
+	entry, err := s.repo.GetShelfEntry(ctx, uID, bID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if _, err := s.repo.GetBookByID(ctx, bID); err != nil {
+			return nil, err
+		}
+		return &domain.Shelf{UserID: uID, BookID: bID}, nil
+	}
+	return entry, err
+}
+
+// SetShelfStatus меняет только статус, сохранённая позиция чтения не сбрасывается.
+// Неизвестный статус и недопустимый переход возвращают *StatusError.
+func (s *service) SetShelfStatus(ctx context.Context, uID, bID uint, status string) error {
+	if !domain.ValidShelfStatus(status) {
+		return &StatusError{Err: ErrInvalidShelfStatus, Allowed: domain.ShelfStatuses}
+	}
+	entry, err := s.shelfEntry(ctx, uID, bID)
+	if err != nil {
+		return err
+	}
+	if !domain.CanMoveShelfStatus(entry.Status, status) {
+		return &StatusError{Err: ErrInvalidTransition, Allowed: domain.ShelfTransitions[entry.Status]}
+	}
+	moveShelfStatus(entry, status, time.Now())
+	return s.repo.AddToShelf(ctx, entry)
+}
+
+// UpdateProgress сохраняет позицию чтения. Книга, которой ещё нет на полке
+// или которая отложена, переходит в reading; на 100% статус становится completed.
+func (s *service) UpdateProgress(ctx context.Context, uID, bID uint, pos domain.ReadingPosition) (*domain.Shelf, error) {
+	if !validPosition(pos) {
+		return nil, ErrInvalidProgress
+	}
+	entry, err := s.shelfEntry(ctx, uID, bID)
+	if err != nil {
+		return nil, err
+	}
+	setProgress(entry, pos)
+	if err := s.repo.AddToShelf(ctx, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func validPosition(pos domain.ReadingPosition) bool {
+	return pos.Chapter >= 0 && pos.Offset >= 0 && pos.Percent >= 0 && pos.Percent <= 100
+}
+
+func setProgress(entry *domain.Shelf, pos domain.ReadingPosition) {
+	now := time.Now()
+	entry.Progress = pos
+	switch {
+	case pos.Percent >= 100:
+		moveShelfStatus(entry, domain.ShelfStatusCompleted, now)
+	case entry.Status != domain.ShelfStatusReading && entry.Status != domain.ShelfStatusCompleted:
+		// Перечитывание завершённой книги статус не меняет: его ставят явно.
+		moveShelfStatus(entry, domain.ShelfStatusReading, now)
+	}
+	entry.UpdatedAt = now
+}
+
+// moveShelfStatus меняет статус и отмечает начало и конец чтения. Возврат из
+// паузы начало не сдвигает, а новое прочтение сбрасывает дату окончания.
+func moveShelfStatus(entry *domain.Shelf, status string, now time.Time) {
+	if entry.Status == status {
+		return
+	}
+	switch status {
+	case domain.ShelfStatusReading:
+		if entry.Status != domain.ShelfStatusPaused || entry.StartedAt == nil {
+			entry.StartedAt = &now
+		}
+		entry.FinishedAt = nil
+	case domain.ShelfStatusCompleted:
+		entry.FinishedAt = &now
+	}
+	entry.Status = status
+	entry.UpdatedAt = now
+}
+func (s *service) GetShelf(ctx context.Context, uID uint) ([]domain.Shelf, error) {
+	return s.repo.GetShelf(ctx, uID)
+}
+func (s *service) RemoveFromShelf(ctx context.Context, uID, bID uint) error {
+	return s.repo.RemoveFromShelf(ctx, uID, bID)
 }
-func (s *service) GetShelf(uID uint) ([]domain.Shelf, error) { return s.repo.GetShelf(uID) }
-func (s *service) RemoveFromShelf(uID, bID uint) error       { return s.repo.RemoveFromShelf(uID, bID) }
@@ -2,207 +2,2000 @@ package service
 
 import (
 	"E-book-service/internal/domain"
+	"E-book-service/internal/repository"
+	"E-book-service/internal/storage"
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"io"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 type MockRepository struct {
 	mock.Mock
 }
 
-func (m *MockRepository) CreateUser(u *domain.User) error { return m.Called(u).Error(0) }
-func (m *MockRepository) GetUserByEmail(email string) (*domain.User, error) {
+// WithTx выполняет fn на том же моке: откат проверяют тесты репозитория.
+func (m *MockRepository) WithTx(ctx context.Context, fn func(repository.Repository) error) error {
+	return fn(m)
+}
+
+func (m *MockRepository) CreateUser(ctx context.Context, u *domain.User) error {
+	return m.Called(u).Error(0)
+}
+func (m *MockRepository) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
 	args := m.Called(email)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.User), args.Error(1)
 }
-func (m *MockRepository) GetUserByID(id uint) (*domain.User, error) {
+func (m *MockRepository) GetUserByID(ctx context.Context, id uint) (*domain.User, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.User), args.Error(1)
 }
-func (m *MockRepository) UpdateUser(u *domain.User) error { return m.Called(u).Error(0) }
+func (m *MockRepository) UpdateUser(ctx context.Context, u *domain.User) error {
+	return m.Called(u).Error(0)
+}
+func (m *MockRepository) UpdateUserRole(ctx context.Context, id uint, role string) error {
+	return m.Called(id, role).Error(0)
+}
 
-func (m *MockRepository) CreateBook(b *domain.Book) error { return m.Called(b).Error(0) }
-func (m *MockRepository) GetBooks() ([]domain.Book, error) {
-	args := m.Called()
-	return args.Get(0).([]domain.Book), args.Error(1)
+func (m *MockRepository) CreateRefreshToken(ctx context.Context, t *domain.RefreshToken) error {
+	return m.Called(t).Error(0)
+}
+func (m *MockRepository) GetRefreshTokenByHash(ctx context.Context, hash string) (*domain.RefreshToken, error) {
+	args := m.Called(hash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.RefreshToken), args.Error(1)
+}
+func (m *MockRepository) RotateRefreshToken(ctx context.Context, oldID uint, next *domain.RefreshToken) error {
+	return m.Called(oldID, next).Error(0)
+}
+func (m *MockRepository) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	return m.Called(familyID).Error(0)
+}
+
+func (m *MockRepository) RevokeUserRefreshTokens(ctx context.Context, uID uint) error {
+	return m.Called(uID).Error(0)
+}
+
+func (m *MockRepository) CreateBook(ctx context.Context, b *domain.Book) error {
+	return m.Called(b).Error(0)
+}
+func (m *MockRepository) GetBooks(ctx context.Context, f domain.BookFilter) (domain.Page[domain.Book], error) {
+	args := m.Called(f)
+	return args.Get(0).(domain.Page[domain.Book]), args.Error(1)
 }
-func (m *MockRepository) GetBookByID(id uint) (*domain.Book, error) {
+func (m *MockRepository) GetBookByID(ctx context.Context, id uint) (*domain.Book, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.Book), args.Error(1)
 }
-func (m *MockRepository) UpdateBook(b *domain.Book) error { return m.Called(b).Error(0) }
-func (m *MockRepository) DeleteBook(id uint) error        { return m.Called(id).Error(0) }
-func (m *MockRepository) GetBooksByAuthor(aID uint) ([]domain.Book, error) {
-	args := m.Called(aID)
+func (m *MockRepository) SearchBooks(ctx context.Context, q domain.SearchQuery) (domain.Page[domain.SearchHit], error) {
+	args := m.Called(q)
+	return args.Get(0).(domain.Page[domain.SearchHit]), args.Error(1)
+}
+func (m *MockRepository) Autocomplete(ctx context.Context, variants []string, limit int) (domain.Autocomplete, error) {
+	args := m.Called(variants, limit)
+	return args.Get(0).(domain.Autocomplete), args.Error(1)
+}
+func (m *MockRepository) UpdateBook(ctx context.Context, b *domain.Book) error {
+	return m.Called(b).Error(0)
+}
+func (m *MockRepository) ReplaceChapters(ctx context.Context, bookID uint, chapters []domain.Chapter) error {
+	return m.Called(bookID, chapters).Error(0)
+}
+func (m *MockRepository) GetChapters(ctx context.Context, bookID uint) ([]domain.Chapter, error) {
+	args := m.Called(bookID)
+	return args.Get(0).([]domain.Chapter), args.Error(1)
+}
+func (m *MockRepository) GetChapter(ctx context.Context, bookID uint, ordinal int) (*domain.Chapter, error) {
+	args := m.Called(bookID, ordinal)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Chapter), args.Error(1)
+}
+func (m *MockRepository) GetBooksWithoutChapters(ctx context.Context, afterID uint, limit int) ([]domain.Book, error) {
+	args := m.Called(afterID, limit)
 	return args.Get(0).([]domain.Book), args.Error(1)
 }
+func (m *MockRepository) CreateBookFile(ctx context.Context, f *domain.BookFile) error {
+	return m.Called(f).Error(0)
+}
+func (m *MockRepository) GetBookFiles(ctx context.Context, bookID uint) ([]domain.BookFile, error) {
+	args := m.Called(bookID)
+	return args.Get(0).([]domain.BookFile), args.Error(1)
+}
+func (m *MockRepository) GetBookFile(ctx context.Context, bookID, id uint) (*domain.BookFile, error) {
+	args := m.Called(bookID, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.BookFile), args.Error(1)
+}
+func (m *MockRepository) DeleteBook(ctx context.Context, id uint, version int64) error {
+	return m.Called(id, version).Error(0)
+}
+func (m *MockRepository) DeleteBooks(ctx context.Context, ids []uint) error {
+	return m.Called(ids).Error(0)
+}
+func (m *MockRepository) GetBookDependents(ctx context.Context, id uint) (domain.Dependents, error) {
+	args := m.Called(id)
+	return args.Get(0).(domain.Dependents), args.Error(1)
+}
 
-func (m *MockRepository) CreateAuthor(a *domain.Author) error { return m.Called(a).Error(0) }
-func (m *MockRepository) GetAuthors() ([]domain.Author, error) {
-	args := m.Called()
-	return args.Get(0).([]domain.Author), args.Error(1)
+func (m *MockRepository) CreateAuthor(ctx context.Context, a *domain.Author) error {
+	return m.Called(a).Error(0)
 }
-func (m *MockRepository) GetAuthorByID(id uint) (*domain.Author, error) {
+func (m *MockRepository) GetAuthors(ctx context.Context, f domain.AuthorFilter) (domain.Page[domain.Author], error) {
+	args := m.Called(f)
+	return args.Get(0).(domain.Page[domain.Author]), args.Error(1)
+}
+func (m *MockRepository) GetAuthorByID(ctx context.Context, id uint) (*domain.Author, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.Author), args.Error(1)
 }
-func (m *MockRepository) UpdateAuthor(a *domain.Author) error { return m.Called(a).Error(0) }
-func (m *MockRepository) DeleteAuthor(id uint) error          { return m.Called(id).Error(0) }
+func (m *MockRepository) GetAuthorByName(ctx context.Context, name string) (*domain.Author, error) {
+	args := m.Called(name)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Author), args.Error(1)
+}
+func (m *MockRepository) UpdateAuthor(ctx context.Context, a *domain.Author) error {
+	return m.Called(a).Error(0)
+}
+func (m *MockRepository) DeleteAuthor(ctx context.Context, id uint, version int64) error {
+	return m.Called(id, version).Error(0)
+}
+func (m *MockRepository) GetAuthorDependents(ctx context.Context, id uint) (domain.Dependents, error) {
+	args := m.Called(id)
+	return args.Get(0).(domain.Dependents), args.Error(1)
+}
+func (m *MockRepository) ReassignBooks(ctx context.Context, fromAuthorID, toAuthorID uint) error {
+	return m.Called(fromAuthorID, toAuthorID).Error(0)
+}
 
-func (m *MockRepository) CreateReview(re *domain.Review) error { return m.Called(re).Error(0) }
-func (m *MockRepository) GetReviewsByBook(bID uint) ([]domain.Review, error) {
-	args := m.Called(bID)
-	return args.Get(0).([]domain.Review), args.Error(1)
+func (m *MockRepository) CreateReview(ctx context.Context, re *domain.Review) error {
+	return m.Called(re).Error(0)
+}
+func (m *MockRepository) GetReviewsByBook(ctx context.Context, bID uint, q domain.ListQuery) (domain.Page[domain.Review], error) {
+	args := m.Called(bID, q)
+	return args.Get(0).(domain.Page[domain.Review]), args.Error(1)
+}
+func (m *MockRepository) GetReview(ctx context.Context, id uint) (*domain.Review, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Review), args.Error(1)
+}
+func (m *MockRepository) UpdateReview(ctx context.Context, re *domain.Review) error {
+	return m.Called(re).Error(0)
+}
+func (m *MockRepository) DeleteReview(ctx context.Context, id, uID uint) error {
+	return m.Called(id, uID).Error(0)
+}
+func (m *MockRepository) RecomputeRatings(ctx context.Context) (int64, error) {
+	args := m.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
+func (m *MockRepository) SaveReviewVote(ctx context.Context, v *domain.ReviewVote) error {
+	return m.Called(v).Error(0)
+}
+func (m *MockRepository) DeleteReviewVote(ctx context.Context, reviewID, uID uint) error {
+	return m.Called(reviewID, uID).Error(0)
+}
+func (m *MockRepository) CreateReviewReport(ctx context.Context, rep *domain.ReviewReport, since *time.Time) (int64, error) {
+	args := m.Called(rep, since)
+	return args.Get(0).(int64), args.Error(1)
+}
+func (m *MockRepository) GetModerationQueue(ctx context.Context, q domain.ListQuery) (domain.Page[domain.Review], error) {
+	args := m.Called(q)
+	return args.Get(0).(domain.Page[domain.Review]), args.Error(1)
+}
+func (m *MockRepository) ModerateReview(ctx context.Context, re *domain.Review, action *domain.ModerationAction) error {
+	return m.Called(re, action).Error(0)
+}
+func (m *MockRepository) CreateModerationAction(ctx context.Context, a *domain.ModerationAction) error {
+	return m.Called(a).Error(0)
+}
+func (m *MockRepository) GetModerationActions(ctx context.Context, reviewID uint) ([]domain.ModerationAction, error) {
+	args := m.Called(reviewID)
+	return args.Get(0).([]domain.ModerationAction), args.Error(1)
+}
+func (m *MockRepository) CreateReviewComment(ctx context.Context, c *domain.ReviewComment) error {
+	return m.Called(c).Error(0)
+}
+func (m *MockRepository) GetReviewComment(ctx context.Context, id uint) (*domain.ReviewComment, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.ReviewComment), args.Error(1)
+}
+func (m *MockRepository) GetReviewComments(ctx context.Context, reviewID uint) ([]domain.ReviewComment, error) {
+	args := m.Called(reviewID)
+	return args.Get(0).([]domain.ReviewComment), args.Error(1)
+}
+func (m *MockRepository) UpdateReviewComment(ctx context.Context, c *domain.ReviewComment) error {
+	return m.Called(c).Error(0)
+}
+func (m *MockRepository) DeleteReviewComment(ctx context.Context, id uint) error {
+	return m.Called(id).Error(0)
+}
+func (m *MockRepository) CreateNotification(ctx context.Context, n *domain.Notification) error {
+	return m.Called(n).Error(0)
+}
+func (m *MockRepository) GetNotifications(ctx context.Context, uID uint, unreadOnly bool, q domain.ListQuery) (domain.Page[domain.Notification], error) {
+	args := m.Called(uID, unreadOnly, q)
+	return args.Get(0).(domain.Page[domain.Notification]), args.Error(1)
+}
+func (m *MockRepository) MarkNotificationRead(ctx context.Context, id, uID uint) error {
+	return m.Called(id, uID).Error(0)
 }
-func (m *MockRepository) DeleteReview(id, uID uint) error { return m.Called(id, uID).Error(0) }
 
-func (m *MockRepository) AddToShelf(s *domain.Shelf) error { return m.Called(s).Error(0) }
-func (m *MockRepository) GetShelf(uID uint) ([]domain.Shelf, error) {
+func (m *MockRepository) AddToShelf(ctx context.Context, s *domain.Shelf) error {
+	return m.Called(s).Error(0)
+}
+func (m *MockRepository) GetShelf(ctx context.Context, uID uint) ([]domain.Shelf, error) {
 	args := m.Called(uID)
 	return args.Get(0).([]domain.Shelf), args.Error(1)
 }
-func (m *MockRepository) RemoveFromShelf(uID, bID uint) error { return m.Called(uID, bID).Error(0) }
+func (m *MockRepository) CreateCollection(ctx context.Context, c *domain.Collection) error {
+	return m.Called(c).Error(0)
+}
+func (m *MockRepository) GetCollections(ctx context.Context, uID uint, onlyPublic bool) ([]domain.Collection, error) {
+	args := m.Called(uID, onlyPublic)
+	return args.Get(0).([]domain.Collection), args.Error(1)
+}
+func (m *MockRepository) GetCollection(ctx context.Context, id uint) (*domain.Collection, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Collection), args.Error(1)
+}
+func (m *MockRepository) GetCollectionBySlug(ctx context.Context, slug string) (*domain.Collection, error) {
+	args := m.Called(slug)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Collection), args.Error(1)
+}
+func (m *MockRepository) UpdateCollection(ctx context.Context, c *domain.Collection) error {
+	return m.Called(c).Error(0)
+}
+func (m *MockRepository) DeleteCollection(ctx context.Context, id uint) error {
+	return m.Called(id).Error(0)
+}
+func (m *MockRepository) AddCollectionBook(ctx context.Context, cb *domain.CollectionBook) error {
+	return m.Called(cb).Error(0)
+}
+func (m *MockRepository) RemoveCollectionBook(ctx context.Context, collectionID, bookID uint) error {
+	return m.Called(collectionID, bookID).Error(0)
+}
+func (m *MockRepository) ReorderCollection(ctx context.Context, collectionID uint, bookIDs []uint) error {
+	return m.Called(collectionID, bookIDs).Error(0)
+}
+func (m *MockRepository) CreateAnnotation(ctx context.Context, a *domain.Annotation) error {
+	return m.Called(a).Error(0)
+}
+func (m *MockRepository) GetAnnotations(ctx context.Context, uID uint, f domain.AnnotationFilter) (domain.Page[domain.Annotation], error) {
+	args := m.Called(uID, f)
+	return args.Get(0).(domain.Page[domain.Annotation]), args.Error(1)
+}
+func (m *MockRepository) GetAnnotation(ctx context.Context, id uint) (*domain.Annotation, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Annotation), args.Error(1)
+}
+func (m *MockRepository) UpdateAnnotation(ctx context.Context, a *domain.Annotation) error {
+	return m.Called(a).Error(0)
+}
+func (m *MockRepository) DeleteAnnotation(ctx context.Context, id uint) error {
+	return m.Called(id).Error(0)
+}
+func (m *MockRepository) ExportAnnotations(ctx context.Context, uID, bookID uint) ([]domain.Annotation, error) {
+	args := m.Called(uID, bookID)
+	return args.Get(0).([]domain.Annotation), args.Error(1)
+}
+func (m *MockRepository) GetShelfEntry(ctx context.Context, uID, bID uint) (*domain.Shelf, error) {
+	args := m.Called(uID, bID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Shelf), args.Error(1)
+}
+func (m *MockRepository) LockShelfEntry(ctx context.Context, uID, bID uint) (*domain.Shelf, error) {
+	args := m.Called(uID, bID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Shelf), args.Error(1)
+}
+func (m *MockRepository) GetDevicePosition(ctx context.Context, uID, bID uint, deviceID string) (*domain.DevicePosition, error) {
+	args := m.Called(uID, bID, deviceID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.DevicePosition), args.Error(1)
+}
+func (m *MockRepository) GetDevicePositions(ctx context.Context, uID, bID uint) ([]domain.DevicePosition, error) {
+	args := m.Called(uID, bID)
+	return args.Get(0).([]domain.DevicePosition), args.Error(1)
+}
+func (m *MockRepository) SaveDevicePosition(ctx context.Context, p *domain.DevicePosition) error {
+	return m.Called(p).Error(0)
+}
+func (m *MockRepository) CreateReadingEvent(ctx context.Context, e *domain.ReadingEvent) error {
+	return m.Called(e).Error(0)
+}
+func (m *MockRepository) RemoveFromShelf(ctx context.Context, uID, bID uint) error {
+	return m.Called(uID, bID).Error(0)
+}
+
+type MockDenylist struct {
+	mock.Mock
+}
+
+func (m *MockDenylist) RevokeToken(ctx context.Context, jti string, ttl time.Duration) error {
+	return m.Called(jti, ttl).Error(0)
+}
+func (m *MockDenylist) RevokeUser(ctx context.Context, uID uint, at time.Time, ttl time.Duration) error {
+	return m.Called(uID, at, ttl).Error(0)
+}
+func (m *MockDenylist) IsRevoked(ctx context.Context, jti string, uID uint, issuedAt time.Time) (bool, error) {
+	args := m.Called(jti, uID, issuedAt)
+	return args.Bool(0), args.Error(1)
+}
+
+type MockCache struct {
+	mock.Mock
+}
+
+func (m *MockCache) Get(ctx context.Context, key string, dst interface{}) (bool, error) {
+	args := m.Called(key, dst)
+	return args.Bool(0), args.Error(1)
+}
+func (m *MockCache) Set(ctx context.Context, key string, val interface{}, ttl time.Duration) error {
+	return m.Called(key, val, ttl).Error(0)
+}
 
 func TestAuthAndProfile(t *testing.T) {
+	ctx := context.Background()
 	mockRepo := new(MockRepository)
 	svc := NewService(mockRepo, "test-key")
 
 	t.Run("Register", func(t *testing.T) {
 		mockRepo.On("CreateUser", mock.Anything).Return(nil).Once()
-		err := svc.Register("test@mail.com", "pass", "User")
+		err := svc.Register(ctx, "test@mail.com", "pass", "User")
 		assert.NoError(t, err)
 	})
 
 	t.Run("Login_Success", func(t *testing.T) {
 		hash, _ := bcrypt.GenerateFromPassword([]byte("pass"), bcrypt.DefaultCost)
 		mockRepo.On("GetUserByEmail", "test@mail.com").Return(&domain.User{Email: "test@mail.com", Password: string(hash)}, nil).Once()
-		token, err := svc.Login("test@mail.com", "pass")
+		mockRepo.On("CreateRefreshToken", mock.Anything).Return(nil).Once()
+		tokens, err := svc.Login(ctx, "test@mail.com", "pass")
+		assert.NoError(t, err)
+		assert.NotEmpty(t, tokens.AccessToken)
+		assert.NotEmpty(t, tokens.RefreshToken)
+		assert.Equal(t, int64(accessTokenTTL.Seconds()), tokens.ExpiresIn)
+	})
+
+	t.Run("Login_RoleClaim", func(t *testing.T) {
+		hash, _ := bcrypt.GenerateFromPassword([]byte("pass"), bcrypt.DefaultCost)
+		mockRepo.On("GetUserByEmail", "editor@mail.com").Return(&domain.User{ID: 2, Email: "editor@mail.com", Password: string(hash), Role: domain.RoleEditor}, nil).Once()
+		mockRepo.On("CreateRefreshToken", mock.Anything).Return(nil).Once()
+		tokens, err := svc.Login(ctx, "editor@mail.com", "pass")
+		assert.NoError(t, err)
+
+		claims := jwt.MapClaims{}
+		_, err = jwt.ParseWithClaims(tokens.AccessToken, claims, func(*jwt.Token) (interface{}, error) { return []byte("test-key"), nil })
 		assert.NoError(t, err)
-		assert.NotEmpty(t, token)
+		assert.Equal(t, domain.RoleEditor, claims["role"])
 	})
 
 	t.Run("Login_Fail", func(t *testing.T) {
 		mockRepo.On("GetUserByEmail", "fail@mail.com").Return(nil, errors.New("not found")).Once()
-		_, err := svc.Login("fail@mail.com", "any")
+		_, err := svc.Login(ctx, "fail@mail.com", "any")
 		assert.Error(t, err)
 	})
 
 	t.Run("ProfileOperations", func(t *testing.T) {
 		user := &domain.User{Name: "Name"}
 		mockRepo.On("GetUserByID", uint(1)).Return(user, nil).Once()
-		res, _ := svc.GetProfile(1)
+		res, _ := svc.GetProfile(ctx, 1)
 		assert.Equal(t, "Name", res.Name)
 
-		mockRepo.On("UpdateUser", user).Return(nil).Once()
-		err := svc.UpdateProfile(user)
+		// PUT меняет только имя и email: пароль и роль из запроса не сохраняются
+		stored := &domain.User{ID: 1, Email: "a@b.c", Name: "Name", Role: domain.RoleReader, Password: "hash"}
+		mockRepo.On("GetUserByID", uint(1)).Return(stored, nil).Once()
+		mockRepo.On("UpdateUser", &domain.User{ID: 1, Email: "new@b.c", Name: "New", Role: domain.RoleReader, Password: "hash"}).Return(nil).Once()
+		req := &domain.User{ID: 1, Email: " new@b.c ", Name: "New", Role: domain.RoleAdmin}
+		err := svc.UpdateProfile(ctx, req)
 		assert.NoError(t, err)
+		assert.Equal(t, "hash", req.Password)
+		assert.Equal(t, domain.RoleReader, req.Role)
+
+		// без email профиль не сохраняется
+		mockRepo.On("GetUserByID", uint(1)).Return(&domain.User{ID: 1, Email: "a@b.c"}, nil).Once()
+		err = svc.UpdateProfile(ctx, &domain.User{ID: 1, Name: "New"})
+		assert.ErrorIs(t, err, ErrInvalidPatch)
+	})
+}
+
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	user := &domain.User{ID: 1, Role: domain.RoleReader}
+
+	t.Run("Rotate", func(t *testing.T) {
+		mockRepo := new(MockRepository)
+		svc := NewService(mockRepo, "key")
+		rt := &domain.RefreshToken{ID: 5, UserID: 1, FamilyID: "fam", ExpiresAt: time.Now().Add(time.Hour)}
+		mockRepo.On("GetRefreshTokenByHash", hashToken("raw")).Return(rt, nil).Once()
+		mockRepo.On("GetUserByID", uint(1)).Return(user, nil).Once()
+		mockRepo.On("RotateRefreshToken", uint(5), mock.MatchedBy(func(next *domain.RefreshToken) bool {
+			return next.FamilyID == "fam" && next.UserID == 1 && next.TokenHash != hashToken("raw")
+		})).Return(nil).Once()
+
+		tokens, err := svc.Refresh(ctx, "raw")
+		assert.NoError(t, err)
+		assert.NotEqual(t, "raw", tokens.RefreshToken)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("ReuseRevokesFamily", func(t *testing.T) {
+		mockRepo := new(MockRepository)
+		svc := NewService(mockRepo, "key")
+		revoked := time.Now().Add(-time.Minute)
+		rt := &domain.RefreshToken{ID: 5, UserID: 1, FamilyID: "fam", ExpiresAt: time.Now().Add(time.Hour), RevokedAt: &revoked}
+		mockRepo.On("GetRefreshTokenByHash", hashToken("stolen")).Return(rt, nil).Once()
+		mockRepo.On("RevokeTokenFamily", "fam").Return(nil).Once()
+
+		_, err := svc.Refresh(ctx, "stolen")
+		assert.ErrorIs(t, err, ErrRefreshTokenReused)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("ConcurrentRotationRevokesFamily", func(t *testing.T) {
+		mockRepo := new(MockRepository)
+		svc := NewService(mockRepo, "key")
+		rt := &domain.RefreshToken{ID: 5, UserID: 1, FamilyID: "fam", ExpiresAt: time.Now().Add(time.Hour)}
+		mockRepo.On("GetRefreshTokenByHash", hashToken("raw")).Return(rt, nil).Once()
+		mockRepo.On("GetUserByID", uint(1)).Return(user, nil).Once()
+		mockRepo.On("RotateRefreshToken", uint(5), mock.Anything).Return(gorm.ErrRecordNotFound).Once()
+		mockRepo.On("RevokeTokenFamily", "fam").Return(nil).Once()
+
+		_, err := svc.Refresh(ctx, "raw")
+		assert.ErrorIs(t, err, ErrRefreshTokenReused)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		mockRepo := new(MockRepository)
+		svc := NewService(mockRepo, "key")
+		rt := &domain.RefreshToken{ID: 5, UserID: 1, FamilyID: "fam", ExpiresAt: time.Now().Add(-time.Hour)}
+		mockRepo.On("GetRefreshTokenByHash", hashToken("old")).Return(rt, nil).Once()
+
+		_, err := svc.Refresh(ctx, "old")
+		assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		mockRepo := new(MockRepository)
+		svc := NewService(mockRepo, "key")
+		mockRepo.On("GetRefreshTokenByHash", hashToken("nope")).Return(nil, gorm.ErrRecordNotFound).Once()
+
+		_, err := svc.Refresh(ctx, "nope")
+		assert.ErrorIs(t, err, ErrInvalidRefreshToken)
 	})
 }
 
+func TestLogout(t *testing.T) {
+	ctx := context.Background()
+	t.Run("CurrentDevice", func(t *testing.T) {
+		mockRepo, dl := new(MockRepository), new(MockDenylist)
+		svc := NewService(mockRepo, "key", WithDenylist(dl))
+		exp := time.Now().Add(10 * time.Minute)
+		dl.On("RevokeToken", "jti", mock.MatchedBy(func(ttl time.Duration) bool {
+			return ttl > 9*time.Minute && ttl <= 10*time.Minute
+		})).Return(nil).Once()
+		mockRepo.On("GetRefreshTokenByHash", hashToken("raw")).Return(&domain.RefreshToken{UserID: 1, FamilyID: "fam"}, nil).Once()
+		mockRepo.On("RevokeTokenFamily", "fam").Return(nil).Once()
+
+		assert.NoError(t, svc.Logout(ctx, 1, "jti", exp, "raw"))
+		dl.AssertExpectations(t)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("ForeignRefreshTokenIgnored", func(t *testing.T) {
+		mockRepo, dl := new(MockRepository), new(MockDenylist)
+		svc := NewService(mockRepo, "key", WithDenylist(dl))
+		dl.On("RevokeToken", "jti", mock.Anything).Return(nil).Once()
+		mockRepo.On("GetRefreshTokenByHash", hashToken("raw")).Return(&domain.RefreshToken{UserID: 2, FamilyID: "fam"}, nil).Once()
+
+		assert.NoError(t, svc.Logout(ctx, 1, "jti", time.Now().Add(time.Minute), "raw"))
+		mockRepo.AssertNotCalled(t, "RevokeTokenFamily", mock.Anything)
+	})
+
+	t.Run("AllDevices", func(t *testing.T) {
+		mockRepo, dl := new(MockRepository), new(MockDenylist)
+		svc := NewService(mockRepo, "key", WithDenylist(dl))
+		dl.On("RevokeUser", uint(1), mock.Anything, accessTokenTTL).Return(nil).Once()
+		mockRepo.On("RevokeUserRefreshTokens", uint(1)).Return(nil).Once()
+
+		assert.NoError(t, svc.LogoutAll(ctx, 1))
+		dl.AssertExpectations(t)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("NoDenylist", func(t *testing.T) {
+		svc := NewService(new(MockRepository), "key")
+		assert.ErrorIs(t, svc.Logout(ctx, 1, "jti", time.Now(), ""), ErrNoDenylist)
+		assert.ErrorIs(t, svc.LogoutAll(ctx, 1), ErrNoDenylist)
+	})
+}
+
+func TestSetUserRole(t *testing.T) {
+	ctx := context.Background()
+	mockRepo, dl := new(MockRepository), new(MockDenylist)
+	svc := NewService(mockRepo, "key", WithDenylist(dl))
+
+	// Смена роли завершает все сессии: старые права остались в выданных токенах
+	mockRepo.On("UpdateUserRole", uint(2), domain.RoleReader).Return(nil).Once()
+	mockRepo.On("RevokeUserRefreshTokens", uint(2)).Return(nil).Once()
+	dl.On("RevokeUser", uint(2), mock.Anything, accessTokenTTL).Return(nil).Once()
+	assert.NoError(t, svc.SetUserRole(ctx, 1, 2, domain.RoleReader))
+
+	mockRepo.On("UpdateUserRole", uint(3), domain.RoleModerator).Return(gorm.ErrRecordNotFound).Once()
+	assert.ErrorIs(t, svc.SetUserRole(ctx, 1, 3, domain.RoleModerator), gorm.ErrRecordNotFound)
+
+	assert.ErrorIs(t, svc.SetUserRole(ctx, 1, 2, "superuser"), ErrInvalidRole)
+	assert.ErrorIs(t, svc.SetUserRole(ctx, 1, 1, domain.RoleReader), ErrSelfRoleChange)
+	mockRepo.AssertExpectations(t)
+	dl.AssertExpectations(t)
+
+	// Без denylist отзываются только refresh-токены
+	mockRepo = new(MockRepository)
+	svc = NewService(mockRepo, "key")
+	mockRepo.On("UpdateUserRole", uint(2), domain.RoleEditor).Return(nil).Once()
+	mockRepo.On("RevokeUserRefreshTokens", uint(2)).Return(nil).Once()
+	assert.NoError(t, svc.SetUserRole(ctx, 1, 2, domain.RoleEditor))
+	mockRepo.AssertExpectations(t)
+}
+
 func TestBooks(t *testing.T) {
+	ctx := context.Background()
 	mockRepo := new(MockRepository)
 	svc := NewService(mockRepo, "key")
 	book := &domain.Book{Title: "Title"}
 
 	t.Run("CreateAndList", func(t *testing.T) {
 		mockRepo.On("CreateBook", book).Return(nil).Once()
-		assert.NoError(t, svc.CreateBook(book))
+		mockRepo.On("ReplaceChapters", book.ID, []domain.Chapter(nil)).Return(nil).Once()
+		assert.NoError(t, svc.CreateBook(ctx, book))
 
-		mockRepo.On("GetBooks").Return([]domain.Book{*book}, nil).Once()
-		res, _ := svc.GetAllBooks()
-		assert.Len(t, res, 1)
+		mockRepo.On("GetBooks", domain.BookFilter{}).Return(domain.Page[domain.Book]{Items: []domain.Book{*book}, Total: 1}, nil).Once()
+		res, _ := svc.GetAllBooks(ctx, domain.BookFilter{})
+		assert.Len(t, res.Items, 1)
 	})
 
 	t.Run("GetUpdateDelete", func(t *testing.T) {
 		mockRepo.On("GetBookByID", uint(1)).Return(book, nil).Once()
-		_, err := svc.GetBook(1)
+		_, err := svc.GetBook(ctx, 1)
+		assert.NoError(t, err)
+
+		// Версия совпадает с If-Match; обложку PUT не стирает
+		mockRepo.On("GetBookByID", book.ID).Return(&domain.Book{ID: book.ID, Version: 3, CoverKey: "cover.jpg"}, nil).Once()
+		mockRepo.On("UpdateBook", book).Return(nil).Once()
+		mockRepo.On("ReplaceChapters", book.ID, []domain.Chapter(nil)).Return(nil).Once()
+		book.Version = 3
+		err = svc.UpdateBook(ctx, book)
+		assert.NoError(t, err)
+		assert.Equal(t, "cover.jpg", book.CoverKey)
+
+		mockRepo.On("GetBookByID", book.ID).Return(&domain.Book{ID: book.ID, Version: 4}, nil).Once()
+		err = svc.UpdateBook(ctx, &domain.Book{ID: book.ID, Version: 3})
+		assert.ErrorIs(t, err, domain.ErrVersionMismatch)
+
+		mockRepo.On("GetBookByID", uint(1)).Return(book, nil).Once()
+		mockRepo.On("GetBookDependents", uint(1)).Return(domain.Dependents{}, nil).Once()
+		mockRepo.On("DeleteBook", uint(1), int64(0)).Return(nil).Once()
+		err = svc.DeleteBook(ctx, 1, domain.DeleteOptions{})
+		assert.NoError(t, err)
+
+		// Фильтр по автору из пути имеет приоритет над параметром запроса
+		mockRepo.On("GetBooks", domain.BookFilter{AuthorID: 1, TitlePrefix: "A"}).Return(domain.Page[domain.Book]{}, nil).Once()
+		_, err = svc.GetBooksByAuthor(ctx, 1, domain.BookFilter{AuthorID: 9, TitlePrefix: "A"})
+		assert.NoError(t, err)
+	})
+}
+
+func TestSplitChapters(t *testing.T) {
+	content := "Предисловие автора.\n\n# Начало\nПервая глава.\n\nГлава 2. Дорога\nВторая глава, длиннее первой.\nCHAPTER IV\nFour\n## Эпилог ##\n"
+	chapters := splitChapters(7, content)
+	if assert.Len(t, chapters, 5) {
+		assert.Equal(t, domain.Chapter{BookID: 7, Ordinal: 1, Body: "Предисловие автора.", WordCount: 2}, chapters[0])
+		assert.Equal(t, "Начало", chapters[1].Title)
+		assert.Equal(t, "Первая глава.", chapters[1].Body)
+		assert.Equal(t, "Глава 2. Дорога", chapters[2].Title)
+		assert.Equal(t, 4, chapters[2].WordCount)
+		assert.Equal(t, "CHAPTER IV", chapters[3].Title)
+		assert.Equal(t, domain.Chapter{BookID: 7, Ordinal: 5, Title: "Эпилог"}, chapters[4])
+	}
+
+	// Без заголовков вся книга — одна глава; слово "Глава" внутри строки заголовком не считается
+	chapters = splitChapters(1, "Глава семьи вернулась.\nКонец.")
+	assert.Len(t, chapters, 1)
+	assert.Empty(t, chapters[0].Title)
+	assert.Nil(t, splitChapters(1, " \n\t"))
+}
+
+func TestChapters(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepository)
+	svc := NewService(mockRepo, "key")
+
+	t.Run("Backfill", func(t *testing.T) {
+		// Из текста из одних пробелов Unicode глав не выходит: такие книги
+		// остаются без глав, и следующий проход должен начаться после них
+		batch := make([]domain.Book, backfillBatch)
+		for i := range batch {
+			batch[i] = domain.Book{ID: uint(i + 1), Content: "\u00a0\u2003"}
+		}
+		mockRepo.On("GetBooksWithoutChapters", uint(0), backfillBatch).Return(batch, nil).Once()
+		mockRepo.On("ReplaceChapters", mock.Anything, []domain.Chapter(nil)).Return(nil).Times(backfillBatch)
+		mockRepo.On("GetBooksWithoutChapters", uint(backfillBatch), backfillBatch).Return([]domain.Book{{ID: 500, Content: "# A\nB"}}, nil).Once()
+		mockRepo.On("ReplaceChapters", uint(500), []domain.Chapter{{BookID: 500, Ordinal: 1, Title: "A", Body: "B", WordCount: 1}}).Return(nil).Once()
+		n, err := svc.BackfillChapters(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, backfillBatch+1, n)
+	})
+
+	t.Run("TOC", func(t *testing.T) {
+		mockRepo.On("GetChapters", uint(1)).Return([]domain.Chapter{{Ordinal: 1}}, nil).Once()
+		chapters, err := svc.GetChapters(ctx, 1)
+		assert.NoError(t, err)
+		assert.Len(t, chapters, 1)
+
+		// Пустое оглавление у несуществующей книги — это 404, а не []
+		mockRepo.On("GetChapters", uint(2)).Return([]domain.Chapter{}, nil).Once()
+		mockRepo.On("GetBookByID", uint(2)).Return(nil, gorm.ErrRecordNotFound).Once()
+		_, err = svc.GetChapters(ctx, 2)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+	mockRepo.AssertExpectations(t)
+}
+
+func TestDetectFormat(t *testing.T) {
+	epub := append([]byte("PK\x03\x04"), make([]byte, 26)...)
+	epub = append(epub, "mimetypeapplication/epub+zip"...)
+	cases := map[string][]byte{
+		domain.FormatPDF:  []byte("%PDF-1.7\n"),
+		domain.FormatEPUB: epub,
+		domain.FormatFB2:  []byte(`<?xml version="1.0"?><FictionBook xmlns="http://www.gribuse.info/xml/fictionbook/2.0">`),
+	}
+	for want, head := range cases {
+		got, ok := detectFormat(head)
+		assert.True(t, ok, want)
+		assert.Equal(t, want, got)
+	}
+	_, ok := detectFormat([]byte("PK\x03\x04 plain zip"))
+	assert.False(t, ok)
+}
+
+// testEPUB собирает минимальный EPUB: несжатый mimetype первым, container.xml, OPF и обложку.
+func testEPUB(t *testing.T, author string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	mt, err := w.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	assert.NoError(t, err)
+	mt.Write([]byte("application/epub+zip"))
+	files := map[string]string{
+		"META-INF/container.xml": `<container><rootfiles><rootfile full-path="content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`,
+		"content.opf": `<package xmlns="http://www.idpf.org/2007/opf"><metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:title>Война и мир</dc:title><dc:creator>` + author + `</dc:creator><dc:language>ru</dc:language>
+<dc:identifier>urn:isbn:978-0-306-40615-7</dc:identifier></metadata>
+<manifest><item id="c" href="cover.png" media-type="image/png" properties="cover-image"/></manifest></package>`,
+		"cover.png": "PNG",
+	}
+	for name, body := range files {
+		f, err := w.Create(name)
+		assert.NoError(t, err)
+		f.Write([]byte(body))
+	}
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestBookFiles(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepository)
+	store, err := storage.NewLocalStore(t.TempDir())
+	assert.NoError(t, err)
+	svc := NewService(mockRepo, "key", WithFileStore(store))
+	pdf := "%PDF-1.4 body"
+
+	t.Run("Upload", func(t *testing.T) {
+		mockRepo.On("GetBookByID", uint(1)).Return(&domain.Book{ID: 1}, nil).Once()
+		mockRepo.On("CreateBookFile", mock.AnythingOfType("*domain.BookFile")).Return(nil).Once()
+		res, err := svc.UploadBookFile(ctx, 1, "book.pdf", strings.NewReader(pdf))
+		assert.NoError(t, err)
+		assert.Nil(t, res.Metadata)
+		f := res.File
+		assert.Equal(t, domain.FormatPDF, f.Format)
+		assert.Equal(t, int64(len(pdf)), f.Size)
+		sum := sha256.Sum256([]byte(pdf))
+		assert.Equal(t, hex.EncodeToString(sum[:]), f.Checksum)
+
+		mockRepo.On("GetBookFile", uint(1), uint(5)).Return(f, nil).Once()
+		_, content, err := svc.OpenBookFile(ctx, 1, 5)
 		assert.NoError(t, err)
+		data, _ := io.ReadAll(content)
+		content.Close()
+		assert.Equal(t, pdf, string(data))
+	})
 
+	t.Run("EPUBMetadata", func(t *testing.T) {
+		book := &domain.Book{ID: 2, Title: "Война", Author: &domain.Author{ID: 7, Name: "Лев Толстой"}, AuthorID: 7}
+		mockRepo.On("GetBookByID", uint(2)).Return(&domain.Book{ID: 2}, nil).Once()
+		mockRepo.On("GetBookByID", uint(2)).Return(book, nil).Once()
+		mockRepo.On("CreateBookFile", mock.AnythingOfType("*domain.BookFile")).Return(nil).Once()
 		mockRepo.On("UpdateBook", book).Return(nil).Once()
-		err = svc.UpdateBook(book)
+		res, err := svc.UploadBookFile(ctx, 2, "war.epub", bytes.NewReader(testEPUB(t, "Лев  Толстой")))
+		assert.NoError(t, err)
+		assert.Equal(t, domain.FormatEPUB, res.File.Format)
+		assert.ElementsMatch(t, []string{"language", "isbn", "cover"}, res.Metadata.Applied)
+		assert.Equal(t, []MetadataConflict{{Field: "title", Current: "Война", Suggested: "Война и мир"}}, res.Metadata.Conflicts)
+		assert.Equal(t, "Война", book.Title)
+		assert.Equal(t, "9780306406157", book.ISBN)
+		assert.Regexp(t, `^books/2/cover-[\w-]+\.png$`, book.CoverKey)
+
+		mockRepo.On("GetBookByID", uint(2)).Return(book, nil).Once()
+		_, cover, err := svc.OpenBookCover(ctx, 2)
 		assert.NoError(t, err)
+		cover.Close()
+	})
 
-		mockRepo.On("DeleteBook", uint(1)).Return(nil).Once()
-		err = svc.DeleteBook(1)
+	t.Run("EPUBCreatesAuthor", func(t *testing.T) {
+		book := &domain.Book{ID: 3, Title: "Война и мир", Language: "ru", ISBN: "9780306406157", CoverKey: "books/3/cover.jpg"}
+		mockRepo.On("GetBookByID", uint(3)).Return(book, nil).Twice()
+		mockRepo.On("CreateBookFile", mock.AnythingOfType("*domain.BookFile")).Return(nil).Once()
+		mockRepo.On("GetAuthorByName", "Лев Толстой").Return(nil, gorm.ErrRecordNotFound).Once()
+		mockRepo.On("CreateAuthor", &domain.Author{Name: "Лев Толстой"}).
+			Run(func(args mock.Arguments) { args.Get(0).(*domain.Author).ID = 9 }).Return(nil).Once()
+		mockRepo.On("UpdateBook", book).Return(nil).Once()
+		res, err := svc.UploadBookFile(ctx, 3, "war.epub", bytes.NewReader(testEPUB(t, "Лев Толстой")))
 		assert.NoError(t, err)
+		assert.Equal(t, []string{"author"}, res.Metadata.Applied)
+		assert.Equal(t, "cover", res.Metadata.Conflicts[0].Field)
+		assert.Equal(t, uint(9), book.AuthorID)
+	})
+
+	t.Run("EPUBUpdateErrorRemovesCover", func(t *testing.T) {
+		var key, coverKey string
+		mockRepo.On("GetBookByID", uint(4)).Return(&domain.Book{ID: 4}, nil).Once()
+		mockRepo.On("GetBookByID", uint(4)).Return(&domain.Book{ID: 4, Title: "Война и мир", AuthorID: 7, Version: 2}, nil).Once()
+		mockRepo.On("CreateBookFile", mock.AnythingOfType("*domain.BookFile")).
+			Run(func(args mock.Arguments) { key = args.Get(0).(*domain.BookFile).StorageKey }).Return(nil).Once()
+		mockRepo.On("UpdateBook", mock.AnythingOfType("*domain.Book")).
+			Run(func(args mock.Arguments) { coverKey = args.Get(0).(*domain.Book).CoverKey }).Return(domain.ErrVersionMismatch).Once()
+		_, err := svc.UploadBookFile(ctx, 4, "war.epub", bytes.NewReader(testEPUB(t, "Лев Толстой")))
+		assert.ErrorIs(t, err, domain.ErrVersionMismatch)
+		assert.NotEmpty(t, coverKey)
+		for _, k := range []string{key, coverKey} {
+			_, err = store.Open(k)
+			assert.ErrorIs(t, err, os.ErrNotExist, k)
+		}
+	})
 
-		mockRepo.On("GetBooksByAuthor", uint(1)).Return([]domain.Book{}, nil).Once()
-		_, err = svc.GetBooksByAuthor(1)
+	t.Run("ConcurrentUploadKeepsWinnerCover", func(t *testing.T) {
+		// Обе загрузки видят книгу без обложки; вторая проигрывает по версии
+		// и при откате не должна задеть обложку первой.
+		covers := []string{}
+		saveCover := func(args mock.Arguments) { covers = append(covers, args.Get(0).(*domain.Book).CoverKey) }
+		for i := 0; i < 4; i++ {
+			mockRepo.On("GetBookByID", uint(5)).Return(&domain.Book{ID: 5, Title: "Война и мир", AuthorID: 7, Version: 1}, nil).Once()
+		}
+		mockRepo.On("CreateBookFile", mock.AnythingOfType("*domain.BookFile")).Return(nil).Twice()
+		mockRepo.On("UpdateBook", mock.AnythingOfType("*domain.Book")).Run(saveCover).Return(nil).Once()
+		mockRepo.On("UpdateBook", mock.AnythingOfType("*domain.Book")).Run(saveCover).Return(domain.ErrVersionMismatch).Once()
+		_, err := svc.UploadBookFile(ctx, 5, "war.epub", bytes.NewReader(testEPUB(t, "Лев Толстой")))
 		assert.NoError(t, err)
+		_, err = svc.UploadBookFile(ctx, 5, "war.epub", bytes.NewReader(testEPUB(t, "Лев Толстой")))
+		assert.ErrorIs(t, err, domain.ErrVersionMismatch)
+		if assert.Len(t, covers, 2) {
+			assert.NotEqual(t, covers[0], covers[1])
+			winner, err := store.Open(covers[0])
+			if assert.NoError(t, err) {
+				winner.Close()
+			}
+			_, err = store.Open(covers[1])
+			assert.ErrorIs(t, err, os.ErrNotExist)
+		}
+	})
+
+	t.Run("InvalidEPUB", func(t *testing.T) {
+		mockRepo.On("GetBookByID", uint(1)).Return(&domain.Book{ID: 1}, nil).Once()
+		head := testEPUB(t, "")[:80]
+		_, err := svc.UploadBookFile(ctx, 1, "broken.epub", bytes.NewReader(head))
+		assert.ErrorIs(t, err, ErrInvalidEPUB)
+	})
+
+	t.Run("UnsupportedFormat", func(t *testing.T) {
+		mockRepo.On("GetBookByID", uint(1)).Return(&domain.Book{ID: 1}, nil).Once()
+		_, err := svc.UploadBookFile(ctx, 1, "notes.txt", strings.NewReader("plain text"))
+		assert.ErrorIs(t, err, ErrUnsupportedFormat)
+	})
+
+	t.Run("DBErrorRemovesFile", func(t *testing.T) {
+		var key string
+		mockRepo.On("GetBookByID", uint(1)).Return(&domain.Book{ID: 1}, nil).Once()
+		mockRepo.On("CreateBookFile", mock.AnythingOfType("*domain.BookFile")).
+			Run(func(args mock.Arguments) { key = args.Get(0).(*domain.BookFile).StorageKey }).
+			Return(errors.New("db down")).Once()
+		_, err := svc.UploadBookFile(ctx, 1, "book.pdf", strings.NewReader(pdf))
+		assert.Error(t, err)
+		_, err = store.Open(key)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("NoFileStore", func(t *testing.T) {
+		svc := NewService(new(MockRepository), "key")
+		_, err := svc.UploadBookFile(ctx, 1, "book.pdf", strings.NewReader(pdf))
+		assert.ErrorIs(t, err, ErrNoFileStore)
+	})
+	mockRepo.AssertExpectations(t)
+}
+
+func TestSearchBooks(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepository)
+	svc := NewService(mockRepo, "key")
+
+	mockRepo.On("SearchBooks", domain.SearchQuery{Text: "война и мир", Lang: domain.SearchLangRU}).
+		Return(domain.Page[domain.SearchHit]{Items: []domain.SearchHit{{BookID: 1}}, Total: 1}, nil).Once()
+	res, err := svc.SearchBooks(ctx, domain.SearchQuery{Text: "  война и мир "})
+	assert.NoError(t, err)
+	assert.Len(t, res.Items, 1)
+
+	mockRepo.On("SearchBooks", domain.SearchQuery{Text: "war", Lang: domain.SearchLangEN}).
+		Return(domain.Page[domain.SearchHit]{}, nil).Once()
+	_, err = svc.SearchBooks(ctx, domain.SearchQuery{Text: "war", Lang: "en"})
+	assert.NoError(t, err)
+
+	_, err = svc.SearchBooks(ctx, domain.SearchQuery{Text: "   "})
+	assert.ErrorIs(t, err, ErrEmptySearchQuery)
+	_, err = svc.SearchBooks(ctx, domain.SearchQuery{Text: "krieg", Lang: "de"})
+	assert.ErrorIs(t, err, ErrUnsupportedLanguage)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestQueryVariants(t *testing.T) {
+	assert.Equal(t, "лев толстой", normalizeQuery("  Лев   ТОЛСТОЙ "))
+	assert.Equal(t, []string{"tolstoy", "толстой"}, queryVariants("tolstoy"))
+	assert.Equal(t, []string{"пушкин", "pushkin"}, queryVariants("пушкин"))
+	// Латинская "t" внутри кириллического слова
+	assert.Contains(t, queryVariants("tолстой"), "толстой")
+	assert.Equal(t, "щедрин", toCyrillic("shchedrin"))
+	assert.Equal(t, "chekhov", toLatin("чехов"))
+}
+
+func TestAutocomplete(t *testing.T) {
+	ctx := context.Background()
+	res := domain.Autocomplete{Authors: []domain.Suggestion{{ID: 1, Label: "Лев Толстой", Score: 0.8}}}
+
+	t.Run("MissThenStore", func(t *testing.T) {
+		mockRepo, cache := new(MockRepository), new(MockCache)
+		svc := NewService(mockRepo, "key", WithCache(cache))
+		cache.On("Get", "autocomplete:5:tolstoy", mock.Anything).Return(false, nil).Once()
+		mockRepo.On("Autocomplete", []string{"tolstoy", "толстой"}, 5).Return(res, nil).Once()
+		cache.On("Set", "autocomplete:5:tolstoy", res, autocompleteTTL).Return(nil).Once()
+
+		got, err := svc.Autocomplete(ctx, " Tolstoy ", 0)
+		assert.NoError(t, err)
+		assert.Equal(t, res, got)
+		cache.AssertExpectations(t)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("Hit", func(t *testing.T) {
+		mockRepo, cache := new(MockRepository), new(MockCache)
+		svc := NewService(mockRepo, "key", WithCache(cache))
+		cache.On("Get", "autocomplete:20:толс", mock.Anything).Return(true, nil).Once()
+
+		_, err := svc.Autocomplete(ctx, "толс", 100)
+		assert.NoError(t, err)
+		mockRepo.AssertNotCalled(t, "Autocomplete", mock.Anything, mock.Anything)
+	})
+
+	t.Run("CacheDown", func(t *testing.T) {
+		mockRepo, cache := new(MockRepository), new(MockCache)
+		svc := NewService(mockRepo, "key", WithCache(cache))
+		cache.On("Get", mock.Anything, mock.Anything).Return(false, errors.New("redis down")).Once()
+		cache.On("Set", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("redis down")).Once()
+		mockRepo.On("Autocomplete", mock.Anything, 5).Return(res, nil).Once()
+
+		_, err := svc.Autocomplete(ctx, "толс", 0)
+		assert.NoError(t, err)
+	})
+
+	t.Run("TooShort", func(t *testing.T) {
+		svc := NewService(new(MockRepository), "key")
+		got, err := svc.Autocomplete(ctx, "т", 0)
+		assert.NoError(t, err)
+		assert.Empty(t, got.Books)
 	})
 }
 
 func TestAuthors(t *testing.T) {
+	ctx := context.Background()
 	mockRepo := new(MockRepository)
 	svc := NewService(mockRepo, "key")
 	author := &domain.Author{Name: "Author"}
 
 	mockRepo.On("CreateAuthor", author).Return(nil).Once()
-	err := svc.CreateAuthor(author)
+	err := svc.CreateAuthor(ctx, author)
 	assert.NoError(t, err)
 
-	mockRepo.On("GetAuthors").Return([]domain.Author{*author}, nil).Once()
-	_, err = svc.GetAllAuthors()
+	mockRepo.On("GetAuthors", domain.AuthorFilter{}).Return(domain.Page[domain.Author]{Items: []domain.Author{*author}}, nil).Once()
+	_, err = svc.GetAllAuthors(ctx, domain.AuthorFilter{})
 	assert.NoError(t, err)
 
 	mockRepo.On("GetAuthorByID", uint(1)).Return(author, nil).Once()
-	_, err = svc.GetAuthor(1)
+	_, err = svc.GetAuthor(ctx, 1)
 	assert.NoError(t, err)
 
+	// If-Match: * — подходит любая версия
+	mockRepo.On("GetAuthorByID", author.ID).Return(&domain.Author{ID: author.ID, Version: 2}, nil).Once()
 	mockRepo.On("UpdateAuthor", author).Return(nil).Once()
-	err = svc.UpdateAuthor(author)
+	err = svc.UpdateAuthor(ctx, author)
 	assert.NoError(t, err)
+	assert.Equal(t, int64(2), author.Version)
 
-	mockRepo.On("DeleteAuthor", uint(1)).Return(nil).Once()
-	err = svc.DeleteAuthor(1)
+	mockRepo.On("GetAuthorByID", author.ID).Return(&domain.Author{ID: author.ID, Version: 3}, nil).Once()
+	err = svc.UpdateAuthor(ctx, author)
+	assert.ErrorIs(t, err, domain.ErrVersionMismatch)
+
+	mockRepo.On("GetAuthorByID", uint(1)).Return(author, nil).Once()
+	mockRepo.On("GetAuthorDependents", uint(1)).Return(domain.Dependents{}, nil).Once()
+	mockRepo.On("DeleteAuthor", uint(1), int64(0)).Return(nil).Once()
+	err = svc.DeleteAuthor(ctx, 1, domain.DeleteOptions{})
 	assert.NoError(t, err)
 }
 
+func TestPatch(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepository)
+	svc := NewService(mockRepo, "key")
+
+	t.Run("Book", func(t *testing.T) {
+		stored := func() *domain.Book {
+			return &domain.Book{ID: 1, Title: "Old", Content: "Text", AuthorID: 1, Author: &domain.Author{ID: 1}}
+		}
+
+		// Только новое название: текст и автор остаются, главы не пересобираются
+		mockRepo.On("GetBookByID", uint(1)).Return(stored(), nil).Once()
+		mockRepo.On("UpdateBook", &domain.Book{ID: 1, Title: "New", Content: "Text", AuthorID: 1, Author: &domain.Author{ID: 1}}).Return(nil).Once()
+		mockRepo.On("GetBookByID", uint(1)).Return(&domain.Book{ID: 1, Title: "New"}, nil).Once()
+		b, err := svc.PatchBook(ctx, 1, 0, domain.MergePatch{"title": json.RawMessage(`" New "`)})
+		assert.NoError(t, err)
+		assert.Equal(t, "New", b.Title)
+
+		// null сбрасывает поле, новый текст пересобирает главы
+		mockRepo.On("GetBookByID", uint(1)).Return(stored(), nil).Once()
+		mockRepo.On("GetAuthorByID", uint(2)).Return(&domain.Author{ID: 2}, nil).Once()
+		mockRepo.On("UpdateBook", &domain.Book{ID: 1, Title: "Old", Content: "Glava", AuthorID: 2, Author: &domain.Author{ID: 1}}).Return(nil).Once()
+		mockRepo.On("ReplaceChapters", uint(1), splitChapters(1, "Glava")).Return(nil).Once()
+		mockRepo.On("GetBookByID", uint(1)).Return(&domain.Book{ID: 1}, nil).Once()
+		_, err = svc.PatchBook(ctx, 1, 0, domain.MergePatch{
+			"description": json.RawMessage(`null`),
+			"content":     json.RawMessage(`"Glava"`),
+			"author_id":   json.RawMessage(`2`),
+		})
+		assert.NoError(t, err)
+
+		for name, p := range map[string]domain.MergePatch{
+			"rating_avg": {"rating_avg": json.RawMessage(`5`)},
+			"title":      {"title": json.RawMessage(`null`)},
+			"author_id":  {"author_id": json.RawMessage(`"two"`)},
+		} {
+			mockRepo.On("GetBookByID", uint(1)).Return(stored(), nil).Once()
+			_, err = svc.PatchBook(ctx, 1, 0, p)
+			var patchErr *PatchError
+			if assert.ErrorAs(t, err, &patchErr, name) {
+				assert.Equal(t, name, patchErr.Field)
+			}
+			assert.ErrorIs(t, err, ErrInvalidPatch)
+		}
+
+		mockRepo.On("GetBookByID", uint(1)).Return(stored(), nil).Once()
+		_, err = svc.PatchBook(ctx, 1, 5, domain.MergePatch{"title": json.RawMessage(`"New"`)})
+		assert.ErrorIs(t, err, domain.ErrVersionMismatch)
+
+		mockRepo.On("GetBookByID", uint(1)).Return(stored(), nil).Once()
+		mockRepo.On("GetAuthorByID", uint(9)).Return(nil, gorm.ErrRecordNotFound).Once()
+		_, err = svc.PatchBook(ctx, 1, 0, domain.MergePatch{"author_id": json.RawMessage(`9`)})
+		assert.ErrorIs(t, err, ErrInvalidPatch)
+	})
+
+	t.Run("Author", func(t *testing.T) {
+		mockRepo.On("GetAuthorByID", uint(1)).Return(&domain.Author{ID: 1, Name: "Name", Bio: "Bio"}, nil).Once()
+		mockRepo.On("UpdateAuthor", &domain.Author{ID: 1, Name: "Name", Bio: "New"}).Return(nil).Once()
+		mockRepo.On("GetAuthorByID", uint(1)).Return(&domain.Author{ID: 1, Name: "Name", Bio: "New"}, nil).Once()
+		a, err := svc.PatchAuthor(ctx, 1, 0, domain.MergePatch{"bio": json.RawMessage(`"New"`)})
+		assert.NoError(t, err)
+		assert.Equal(t, "New", a.Bio)
+
+		mockRepo.On("GetAuthorByID", uint(1)).Return(&domain.Author{ID: 1, Name: "Name"}, nil).Once()
+		_, err = svc.PatchAuthor(ctx, 1, 0, domain.MergePatch{"name": json.RawMessage(`""`)})
+		assert.ErrorIs(t, err, ErrInvalidPatch)
+	})
+
+	t.Run("Profile", func(t *testing.T) {
+		user := func() *domain.User {
+			return &domain.User{ID: 1, Email: "a@b.c", Name: "A", Role: domain.RoleReader, Password: "hash"}
+		}
+
+		mockRepo.On("GetUserByID", uint(1)).Return(user(), nil).Once()
+		mockRepo.On("UpdateUser", &domain.User{ID: 1, Email: "a@b.c", Name: "B", Role: domain.RoleReader, Password: "hash"}).Return(nil).Once()
+		mockRepo.On("GetUserByID", uint(1)).Return(user(), nil).Once()
+		_, err := svc.PatchProfile(ctx, 1, domain.MergePatch{"name": json.RawMessage(`"B"`)})
+		assert.NoError(t, err)
+
+		for _, p := range []domain.MergePatch{
+			{"email": json.RawMessage(`null`)},
+			{"email": json.RawMessage(`"nobody"`)},
+			{"role": json.RawMessage(`"admin"`)},
+			{"password": json.RawMessage(`"secret"`)},
+		} {
+			mockRepo.On("GetUserByID", uint(1)).Return(user(), nil).Once()
+			_, err = svc.PatchProfile(ctx, 1, p)
+			assert.ErrorIs(t, err, ErrInvalidPatch)
+		}
+
+		mockRepo.On("GetUserByID", uint(1)).Return(user(), nil).Once()
+		mockRepo.On("UpdateUser", mock.Anything).Return(gorm.ErrDuplicatedKey).Once()
+		_, err = svc.PatchProfile(ctx, 1, domain.MergePatch{"email": json.RawMessage(`"taken@b.c"`)})
+		assert.ErrorIs(t, err, ErrEmailTaken)
+	})
+	mockRepo.AssertExpectations(t)
+}
+
+func TestDeletePolicies(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepository)
+	svc := NewService(mockRepo, "key")
+	book := &domain.Book{ID: 1, Title: "Book"}
+	author := &domain.Author{ID: 1, Name: "Author"}
+
+	t.Run("Book", func(t *testing.T) {
+		err := svc.DeleteBook(ctx, 1, domain.DeleteOptions{Policy: domain.DeleteReassign})
+		assert.ErrorIs(t, err, ErrInvalidDeletePolicy)
+
+		mockRepo.On("GetBookByID", uint(9)).Return(nil, gorm.ErrRecordNotFound).Once()
+		err = svc.DeleteBook(ctx, 9, domain.DeleteOptions{})
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+		// restrict: на книгу ссылаются полки и отзывы
+		deps := domain.Dependents{Reviews: 2, ShelfEntries: 5}
+		mockRepo.On("GetBookByID", uint(1)).Return(book, nil).Once()
+		mockRepo.On("GetBookDependents", uint(1)).Return(deps, nil).Once()
+		err = svc.DeleteBook(ctx, 1, domain.DeleteOptions{Policy: domain.DeleteRestrict})
+		var depsErr *DependentsError
+		if assert.ErrorAs(t, err, &depsErr) {
+			assert.Equal(t, deps, depsErr.Dependents)
+		}
+
+		mockRepo.On("GetBookByID", uint(1)).Return(&domain.Book{ID: 1, Version: 2}, nil).Once()
+		err = svc.DeleteBook(ctx, 1, domain.DeleteOptions{Policy: domain.DeleteCascade, Version: 1})
+		assert.ErrorIs(t, err, domain.ErrVersionMismatch)
+
+		// cascade: зависимости не проверяются
+		mockRepo.On("GetBookByID", uint(1)).Return(book, nil).Once()
+		mockRepo.On("DeleteBook", uint(1), int64(0)).Return(nil).Once()
+		err = svc.DeleteBook(ctx, 1, domain.DeleteOptions{Policy: domain.DeleteCascade})
+		assert.NoError(t, err)
+
+		// книгу изменили после проверки версии, но до удаления
+		mockRepo.On("GetBookByID", uint(1)).Return(&domain.Book{ID: 1, Version: 2}, nil).Once()
+		mockRepo.On("DeleteBook", uint(1), int64(2)).Return(domain.ErrVersionMismatch).Once()
+		err = svc.DeleteBook(ctx, 1, domain.DeleteOptions{Policy: domain.DeleteCascade, Version: 2})
+		assert.ErrorIs(t, err, domain.ErrVersionMismatch)
+	})
+
+	t.Run("Author", func(t *testing.T) {
+		books := domain.Dependents{Books: []domain.BookRef{{ID: 3, Title: "A"}, {ID: 4, Title: "B"}}}
+
+		err := svc.DeleteAuthor(ctx, 1, domain.DeleteOptions{Policy: "purge"})
+		assert.ErrorIs(t, err, ErrInvalidDeletePolicy)
+
+		mockRepo.On("GetAuthorByID", uint(1)).Return(author, nil).Once()
+		mockRepo.On("GetAuthorDependents", uint(1)).Return(books, nil).Once()
+		err = svc.DeleteAuthor(ctx, 1, domain.DeleteOptions{})
+		var depsErr *DependentsError
+		if assert.ErrorAs(t, err, &depsErr) {
+			assert.Equal(t, books.Books, depsErr.Dependents.Books)
+		}
+
+		mockRepo.On("GetAuthorByID", uint(1)).Return(author, nil).Once()
+		mockRepo.On("GetAuthorDependents", uint(1)).Return(books, nil).Once()
+		mockRepo.On("DeleteBooks", []uint{3, 4}).Return(nil).Once()
+		mockRepo.On("DeleteAuthor", uint(1), int64(0)).Return(nil).Once()
+		err = svc.DeleteAuthor(ctx, 1, domain.DeleteOptions{Policy: domain.DeleteCascade})
+		assert.NoError(t, err)
+
+		// reassign: на себя, без цели и на несуществующего автора нельзя
+		mockRepo.On("GetAuthorByID", uint(1)).Return(author, nil).Times(3)
+		err = svc.DeleteAuthor(ctx, 1, domain.DeleteOptions{Policy: domain.DeleteReassign, ReassignTo: 1})
+		assert.ErrorIs(t, err, ErrInvalidReassign)
+		err = svc.DeleteAuthor(ctx, 1, domain.DeleteOptions{Policy: domain.DeleteReassign})
+		assert.ErrorIs(t, err, ErrInvalidReassign)
+		mockRepo.On("GetAuthorByID", uint(9)).Return(nil, gorm.ErrRecordNotFound).Once()
+		err = svc.DeleteAuthor(ctx, 1, domain.DeleteOptions{Policy: domain.DeleteReassign, ReassignTo: 9})
+		assert.ErrorIs(t, err, ErrInvalidReassign)
+
+		mockRepo.On("GetAuthorByID", uint(1)).Return(author, nil).Once()
+		mockRepo.On("GetAuthorByID", uint(2)).Return(&domain.Author{ID: 2}, nil).Once()
+		mockRepo.On("GetAuthorDependents", uint(1)).Return(books, nil).Once()
+		mockRepo.On("ReassignBooks", uint(1), uint(2)).Return(nil).Once()
+		mockRepo.On("DeleteAuthor", uint(1), int64(0)).Return(nil).Once()
+		err = svc.DeleteAuthor(ctx, 1, domain.DeleteOptions{Policy: domain.DeleteReassign, ReassignTo: 2})
+		assert.NoError(t, err)
+
+		mockRepo.On("GetAuthorByID", uint(1)).Return(&domain.Author{ID: 1, Version: 5}, nil).Once()
+		mockRepo.On("GetAuthorDependents", uint(1)).Return(domain.Dependents{}, nil).Once()
+		mockRepo.On("DeleteAuthor", uint(1), int64(5)).Return(domain.ErrVersionMismatch).Once()
+		err = svc.DeleteAuthor(ctx, 1, domain.DeleteOptions{Version: 5})
+		assert.ErrorIs(t, err, domain.ErrVersionMismatch)
+	})
+	mockRepo.AssertExpectations(t)
+}
+
 func TestReviewsAndShelf(t *testing.T) {
+	ctx := context.Background()
 	mockRepo := new(MockRepository)
 	svc := NewService(mockRepo, "key")
 
 	t.Run("Reviews", func(t *testing.T) {
-		rev := &domain.Review{Comment: "Good"}
+		rev := &domain.Review{BookID: 1, UserID: 1, Rating: 4, Comment: "Good"}
+		mockRepo.On("GetBookByID", uint(1)).Return(&domain.Book{ID: 1}, nil).Once()
 		mockRepo.On("CreateReview", rev).Return(nil).Once()
-		err := svc.AddReview(rev)
+		err := svc.AddReview(ctx, rev)
 		assert.NoError(t, err)
 
-		mockRepo.On("GetReviewsByBook", uint(1)).Return([]domain.Review{}, nil).Once()
-		_, err = svc.GetReviews(1)
+		for _, rating := range []int{0, -5, 6, 1000} {
+			assert.ErrorIs(t, svc.AddReview(ctx, &domain.Review{BookID: 1, Rating: rating}), ErrInvalidRating)
+		}
+
+		// Второй отзыв на ту же книгу упирается в уникальный индекс
+		mockRepo.On("GetBookByID", uint(1)).Return(&domain.Book{ID: 1}, nil).Once()
+		mockRepo.On("CreateReview", mock.Anything).Return(gorm.ErrDuplicatedKey).Once()
+		assert.ErrorIs(t, svc.AddReview(ctx, &domain.Review{BookID: 1, UserID: 1, Rating: 5}), ErrReviewExists)
+
+		mockRepo.On("GetBookByID", uint(2)).Return(nil, gorm.ErrRecordNotFound).Once()
+		assert.ErrorIs(t, svc.AddReview(ctx, &domain.Review{BookID: 2, Rating: 5}), gorm.ErrRecordNotFound)
+
+		// Счётчики голосов из запроса отбрасываются: новый отзыв начинает с нуля
+		mockRepo.On("GetBookByID", uint(1)).Return(&domain.Book{ID: 1}, nil).Once()
+		mockRepo.On("CreateReview", mock.MatchedBy(func(re *domain.Review) bool {
+			return re.HelpfulCount == 0 && re.NotHelpfulCount == 0
+		})).Return(nil).Once()
+		fake := &domain.Review{BookID: 1, UserID: 3, Rating: 5, HelpfulCount: 1000, NotHelpfulCount: 7}
+		assert.NoError(t, svc.AddReview(ctx, fake))
+		assert.Zero(t, fake.HelpfulCount)
+
+		mockRepo.On("GetReviewsByBook", uint(1), domain.ListQuery{Limit: 10}).Return(domain.Page[domain.Review]{}, nil).Once()
+		_, err = svc.GetReviews(ctx, 1, domain.ListQuery{Limit: 10})
 		assert.NoError(t, err)
 
 		mockRepo.On("DeleteReview", uint(1), uint(1)).Return(nil).Once()
-		err = svc.DeleteReview(1, 1)
+		err = svc.DeleteReview(ctx, 1, 1)
+		assert.NoError(t, err)
+	})
+
+	t.Run("RecomputeRatings", func(t *testing.T) {
+		mockRepo.On("RecomputeRatings").Return(int64(3), nil).Once()
+		n, err := svc.RecomputeRatings(ctx)
 		assert.NoError(t, err)
+		assert.Equal(t, int64(3), n)
+	})
+
+	t.Run("UpdateReview", func(t *testing.T) {
+		existing := &domain.Review{ID: 7, BookID: 1, UserID: 1, Rating: 2, Comment: "Meh"}
+		mockRepo.On("GetReview", uint(7)).Return(existing, nil).Once()
+		mockRepo.On("UpdateReview", existing).Return(nil).Once()
+		upd := &domain.Review{ID: 7, BookID: 9, UserID: 3, Rating: 4, Comment: "Better on reread"}
+		assert.NoError(t, svc.UpdateReview(ctx, 1, upd))
+		assert.True(t, upd.Edited)
+		assert.Equal(t, uint(1), upd.BookID)
+		assert.Equal(t, "Better on reread", existing.Comment)
+
+		mockRepo.On("GetReview", uint(7)).Return(&domain.Review{ID: 7, UserID: 2, Rating: 3}, nil).Once()
+		assert.ErrorIs(t, svc.UpdateReview(ctx, 1, &domain.Review{ID: 7, Rating: 3}), gorm.ErrRecordNotFound)
+		assert.ErrorIs(t, svc.UpdateReview(ctx, 1, &domain.Review{ID: 7, Rating: 9}), ErrInvalidRating)
 	})
 
 	t.Run("Shelf", func(t *testing.T) {
+		mockRepo.On("GetShelfEntry", uint(1), uint(1)).Return(nil, gorm.ErrRecordNotFound).Once()
+		mockRepo.On("GetBookByID", uint(1)).Return(&domain.Book{ID: 1}, nil).Once()
 		mockRepo.On("AddToShelf", mock.Anything).Return(nil).Once()
-		err := svc.SetShelfStatus(1, 1, "reading")
+		err := svc.SetShelfStatus(ctx, 1, 1, "reading")
 		assert.NoError(t, err)
 
 		mockRepo.On("GetShelf", uint(1)).Return([]domain.Shelf{}, nil).Once()
-		_, err = svc.GetShelf(1)
+		_, err = svc.GetShelf(ctx, 1)
 		assert.NoError(t, err)
 
 		mockRepo.On("RemoveFromShelf", uint(1), uint(1)).Return(nil).Once()
-		err = svc.RemoveFromShelf(1, 1)
+		err = svc.RemoveFromShelf(ctx, 1, 1)
+		assert.NoError(t, err)
+	})
+
+	t.Run("StatusKeepsProgress", func(t *testing.T) {
+		entry := &domain.Shelf{UserID: 1, BookID: 2, Status: "reading", Progress: domain.ReadingPosition{Chapter: 3, Percent: 40}}
+		mockRepo.On("GetShelfEntry", uint(1), uint(2)).Return(entry, nil).Once()
+		mockRepo.On("AddToShelf", entry).Return(nil).Once()
+		assert.NoError(t, svc.SetShelfStatus(ctx, 1, 2, "paused"))
+		assert.Equal(t, 3, entry.Progress.Chapter)
+	})
+
+	t.Run("StatusMachine", func(t *testing.T) {
+		var statusErr *StatusError
+		err := svc.SetShelfStatus(ctx, 1, 2, "readng")
+		assert.ErrorIs(t, err, ErrInvalidShelfStatus)
+		if assert.ErrorAs(t, err, &statusErr) {
+			assert.Equal(t, domain.ShelfStatuses, statusErr.Allowed)
+		}
+
+		entry := &domain.Shelf{UserID: 1, BookID: 2, Status: domain.ShelfStatusCompleted}
+		mockRepo.On("GetShelfEntry", uint(1), uint(2)).Return(entry, nil).Once()
+		err = svc.SetShelfStatus(ctx, 1, 2, domain.ShelfStatusPaused)
+		assert.ErrorIs(t, err, ErrInvalidTransition)
+		if assert.ErrorAs(t, err, &statusErr) {
+			assert.Equal(t, []string{domain.ShelfStatusReading}, statusErr.Allowed)
+		}
+
+		// Перечитывание: новая дата начала, дата окончания сбрасывается
+		finished := time.Now().Add(-time.Hour)
+		entry.FinishedAt = &finished
+		mockRepo.On("GetShelfEntry", uint(1), uint(2)).Return(entry, nil).Once()
+		mockRepo.On("AddToShelf", entry).Return(nil).Once()
+		assert.NoError(t, svc.SetShelfStatus(ctx, 1, 2, domain.ShelfStatusReading))
+		assert.NotNil(t, entry.StartedAt)
+		assert.Nil(t, entry.FinishedAt)
+		started := *entry.StartedAt
+
+		// Пауза и возврат к чтению не сдвигают дату начала
+		mockRepo.On("GetShelfEntry", uint(1), uint(2)).Return(entry, nil).Twice()
+		mockRepo.On("AddToShelf", entry).Return(nil).Twice()
+		assert.NoError(t, svc.SetShelfStatus(ctx, 1, 2, domain.ShelfStatusPaused))
+		assert.NoError(t, svc.SetShelfStatus(ctx, 1, 2, domain.ShelfStatusReading))
+		assert.Equal(t, started, *entry.StartedAt)
+
+		mockRepo.On("GetShelfEntry", uint(1), uint(2)).Return(entry, nil).Once()
+		mockRepo.On("AddToShelf", entry).Return(nil).Once()
+		assert.NoError(t, svc.SetShelfStatus(ctx, 1, 2, domain.ShelfStatusCompleted))
+		assert.NotNil(t, entry.FinishedAt)
+	})
+
+	t.Run("Progress", func(t *testing.T) {
+		// Книги нет на полке: добавляется со статусом reading
+		mockRepo.On("GetShelfEntry", uint(1), uint(3)).Return(nil, gorm.ErrRecordNotFound).Once()
+		mockRepo.On("GetBookByID", uint(3)).Return(&domain.Book{ID: 3}, nil).Once()
+		mockRepo.On("AddToShelf", mock.AnythingOfType("*domain.Shelf")).Return(nil).Once()
+		pos := domain.ReadingPosition{Chapter: 2, Offset: 150, Percent: 12.5}
+		entry, err := svc.UpdateProgress(ctx, 1, 3, pos)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.ShelfStatusReading, entry.Status)
+		assert.NotNil(t, entry.StartedAt)
+		assert.Equal(t, pos, entry.Progress)
+
+		// 100% — книга прочитана
+		mockRepo.On("GetShelfEntry", uint(1), uint(3)).Return(entry, nil).Once()
+		mockRepo.On("AddToShelf", entry).Return(nil).Once()
+		entry, err = svc.UpdateProgress(ctx, 1, 3, domain.ReadingPosition{Chapter: 9, Offset: 0, Percent: 100})
+		assert.NoError(t, err)
+		assert.Equal(t, domain.ShelfStatusCompleted, entry.Status)
+		assert.NotNil(t, entry.FinishedAt)
+
+		// Прогресс по отложенной книге возвращает её в чтение
+		paused := &domain.Shelf{UserID: 1, BookID: 4, Status: domain.ShelfStatusPaused}
+		mockRepo.On("GetShelfEntry", uint(1), uint(4)).Return(paused, nil).Once()
+		mockRepo.On("AddToShelf", paused).Return(nil).Once()
+		_, err = svc.UpdateProgress(ctx, 1, 4, pos)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.ShelfStatusReading, paused.Status)
+
+		for _, bad := range []domain.ReadingPosition{{Chapter: -1}, {Offset: -5}, {Percent: 100.5}} {
+			_, err = svc.UpdateProgress(ctx, 1, 3, bad)
+			assert.ErrorIs(t, err, ErrInvalidProgress)
+		}
+
+		mockRepo.On("GetShelfEntry", uint(1), uint(404)).Return(nil, gorm.ErrRecordNotFound).Once()
+		mockRepo.On("GetBookByID", uint(404)).Return(nil, gorm.ErrRecordNotFound).Once()
+		_, err = svc.UpdateProgress(ctx, 1, 404, pos)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+	mockRepo.AssertExpectations(t)
+}
+
+func TestSyncPosition(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC)
+	pos := func(ch, off int, pct float64) domain.ReadingPosition {
+		return domain.ReadingPosition{Chapter: ch, Offset: off, Percent: pct}
+	}
+	setup := func(shelf domain.ReadingPosition, device *domain.DevicePosition) (*MockRepository, ServiceInterface) {
+		mockRepo := new(MockRepository)
+		entry := &domain.Shelf{UserID: 1, BookID: 2, Status: domain.ShelfStatusReading, Progress: shelf}
+		mockRepo.On("LockShelfEntry", uint(1), uint(2)).Return(entry, nil).Once()
+		if device == nil {
+			mockRepo.On("GetDevicePosition", uint(1), uint(2), "phone").Return(nil, gorm.ErrRecordNotFound).Once()
+		} else {
+			mockRepo.On("GetDevicePosition", uint(1), uint(2), "phone").Return(device, nil).Once()
+		}
+		mockRepo.On("CreateReadingEvent", mock.AnythingOfType("*domain.ReadingEvent")).Return(nil).Once()
+		mockRepo.On("GetDevicePositions", uint(1), uint(2)).Return([]domain.DevicePosition{}, nil).Once()
+		return mockRepo, NewService(mockRepo, "key")
+	}
+
+	t.Run("FurtherWins", func(t *testing.T) {
+		mockRepo, svc := setup(pos(3, 100, 30), nil)
+		mockRepo.On("SaveDevicePosition", mock.AnythingOfType("*domain.DevicePosition")).Return(nil).Once()
+		mockRepo.On("AddToShelf", mock.AnythingOfType("*domain.Shelf")).Return(nil).Once()
+		res, err := svc.SyncPosition(ctx, 1, 2, domain.PositionUpdate{DeviceID: "phone", Position: pos(3, 500, 31), ClientTime: at})
+		assert.NoError(t, err)
+		assert.True(t, res.Applied)
+		assert.Equal(t, pos(3, 500, 31), res.Position)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("BehindIsRejected", func(t *testing.T) {
+		mockRepo, svc := setup(pos(7, 0, 70), nil)
+		mockRepo.On("SaveDevicePosition", mock.AnythingOfType("*domain.DevicePosition")).Return(nil).Once()
+		res, err := svc.SyncPosition(ctx, 1, 2, domain.PositionUpdate{DeviceID: "phone", Position: pos(3, 500, 31), ClientTime: at})
+		assert.NoError(t, err)
+		assert.False(t, res.Applied)
+		assert.Equal(t, pos(7, 0, 70), res.Position)
+		mockRepo.AssertNotCalled(t, "AddToShelf", mock.Anything)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("ExplicitJumpBack", func(t *testing.T) {
+		mockRepo, svc := setup(pos(7, 0, 70), nil)
+		mockRepo.On("SaveDevicePosition", mock.AnythingOfType("*domain.DevicePosition")).Return(nil).Once()
+		mockRepo.On("AddToShelf", mock.AnythingOfType("*domain.Shelf")).Return(nil).Once()
+		res, err := svc.SyncPosition(ctx, 1, 2, domain.PositionUpdate{DeviceID: "phone", Position: pos(1, 0, 1), ClientTime: at, JumpBack: true})
+		assert.NoError(t, err)
+		assert.True(t, res.Applied)
+		assert.Equal(t, pos(1, 0, 1), res.Position)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("StaleMessageIgnored", func(t *testing.T) {
+		device := &domain.DevicePosition{DeviceID: "phone", Position: pos(2, 0, 20), ClientTime: at}
+		mockRepo, svc := setup(pos(2, 0, 20), device)
+		res, err := svc.SyncPosition(ctx, 1, 2, domain.PositionUpdate{DeviceID: "phone", Position: pos(9, 0, 90), ClientTime: at.Add(-time.Minute)})
+		assert.NoError(t, err)
+		assert.False(t, res.Applied)
+		mockRepo.AssertNotCalled(t, "SaveDevicePosition", mock.Anything)
+		mockRepo.AssertNotCalled(t, "AddToShelf", mock.Anything)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("NotOnShelf", func(t *testing.T) {
+		mockRepo := new(MockRepository)
+		svc := NewService(mockRepo, "key")
+		var calls []string
+		mockRepo.On("LockShelfEntry", uint(1), uint(2)).Return(nil, gorm.ErrRecordNotFound).Once()
+		mockRepo.On("GetBookByID", uint(2)).Return(&domain.Book{ID: 2}, nil).Once()
+		mockRepo.On("GetDevicePosition", uint(1), uint(2), "phone").Return(nil, gorm.ErrRecordNotFound).Once()
+		mockRepo.On("AddToShelf", mock.MatchedBy(func(e *domain.Shelf) bool {
+			return e.UserID == 1 && e.BookID == 2 && e.Status == domain.ShelfStatusReading
+		})).Run(func(mock.Arguments) { calls = append(calls, "shelf") }).Return(nil).Once()
+		mockRepo.On("SaveDevicePosition", mock.AnythingOfType("*domain.DevicePosition")).
+			Run(func(mock.Arguments) { calls = append(calls, "device") }).Return(nil).Once()
+		mockRepo.On("CreateReadingEvent", mock.AnythingOfType("*domain.ReadingEvent")).Return(nil).Once()
+		mockRepo.On("GetDevicePositions", uint(1), uint(2)).Return([]domain.DevicePosition{}, nil).Once()
+
+		res, err := svc.SyncPosition(ctx, 1, 2, domain.PositionUpdate{DeviceID: "phone", Position: pos(1, 200, 5), ClientTime: at})
+		assert.NoError(t, err)
+		assert.True(t, res.Applied)
+		assert.Equal(t, domain.ShelfStatusReading, res.Status)
+		// Сначала полка, потом устройство: device_positions ссылается на shelves.
+		assert.Equal(t, []string{"shelf", "device"}, calls)
+		mockRepo.AssertExpectations(t)
+
+		mockRepo.On("LockShelfEntry", uint(1), uint(9)).Return(nil, gorm.ErrRecordNotFound).Once()
+		mockRepo.On("GetBookByID", uint(9)).Return(nil, gorm.ErrRecordNotFound).Once()
+		_, err = svc.SyncPosition(ctx, 1, 9, domain.PositionUpdate{DeviceID: "phone", Position: pos(1, 0, 1), ClientTime: at})
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("ConcurrentFirstSync", func(t *testing.T) {
+		// Другое устройство успело добавить книгу на полку: вставка не проходит,
+		// и повтор сливает позицию с уже сохранённой
+		mockRepo := new(MockRepository)
+		svc := NewService(mockRepo, "key")
+		mockRepo.On("LockShelfEntry", uint(1), uint(2)).Return(nil, gorm.ErrRecordNotFound).Once()
+		mockRepo.On("GetBookByID", uint(2)).Return(&domain.Book{ID: 2}, nil).Once()
+		mockRepo.On("GetDevicePosition", uint(1), uint(2), "phone").Return(nil, gorm.ErrRecordNotFound).Twice()
+		mockRepo.On("AddToShelf", mock.AnythingOfType("*domain.Shelf")).Return(gorm.ErrDuplicatedKey).Once()
+		entry := &domain.Shelf{UserID: 1, BookID: 2, Status: domain.ShelfStatusReading, Progress: pos(5, 0, 50)}
+		mockRepo.On("LockShelfEntry", uint(1), uint(2)).Return(entry, nil).Once()
+		mockRepo.On("SaveDevicePosition", mock.AnythingOfType("*domain.DevicePosition")).Return(nil).Once()
+		mockRepo.On("CreateReadingEvent", mock.AnythingOfType("*domain.ReadingEvent")).Return(nil).Once()
+		mockRepo.On("GetDevicePositions", uint(1), uint(2)).Return([]domain.DevicePosition{}, nil).Once()
+
+		res, err := svc.SyncPosition(ctx, 1, 2, domain.PositionUpdate{DeviceID: "phone", Position: pos(3, 0, 30), ClientTime: at})
+		assert.NoError(t, err)
+		assert.False(t, res.Applied)
+		assert.Equal(t, pos(5, 0, 50), res.Position)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		svc := NewService(new(MockRepository), "key")
+		_, err := svc.SyncPosition(ctx, 1, 2, domain.PositionUpdate{Position: pos(1, 0, 1)})
+		assert.ErrorIs(t, err, ErrInvalidDevice)
+		_, err = svc.SyncPosition(ctx, 1, 2, domain.PositionUpdate{DeviceID: "phone", Position: pos(1, -1, 1)})
+		assert.ErrorIs(t, err, ErrInvalidProgress)
+	})
+}
+
+func TestCollections(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepository)
+	svc := NewService(mockRepo, "key")
+	col := func() *domain.Collection {
+		return &domain.Collection{ID: 5, UserID: 1, Name: "Sci-fi classics", Visibility: domain.VisibilityPrivate, Books: []domain.CollectionBook{
+			{CollectionID: 5, BookID: 10, Position: 1},
+			{CollectionID: 5, BookID: 11, Position: 2},
+		}}
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		c := &domain.Collection{Name: "  Summer 2026 ", UserID: 99}
+		mockRepo.On("CreateCollection", c).Return(nil).Once()
+		assert.NoError(t, svc.CreateCollection(ctx, 1, c))
+		assert.Equal(t, "Summer 2026", c.Name)
+		assert.Equal(t, uint(1), c.UserID)
+		assert.Equal(t, domain.VisibilityPrivate, c.Visibility)
+		assert.Len(t, c.Slug, 16)
+
+		assert.ErrorIs(t, svc.CreateCollection(ctx, 1, &domain.Collection{Name: " "}), ErrInvalidCollectionName)
+		assert.ErrorIs(t, svc.CreateCollection(ctx, 1, &domain.Collection{Name: "A", Visibility: "friends"}), ErrInvalidVisibility)
+	})
+
+	t.Run("Visibility", func(t *testing.T) {
+		// Чужая приватная подборка не видна ни по ID, ни по ссылке
+		mockRepo.On("GetCollection", uint(5)).Return(col(), nil).Once()
+		_, err := svc.GetCollection(ctx, 2, 5)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		mockRepo.On("GetCollectionBySlug", "abc").Return(col(), nil).Once()
+		_, err = svc.GetSharedCollection(ctx, "abc")
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+		unlisted := col()
+		unlisted.Visibility = domain.VisibilityUnlisted
+		mockRepo.On("GetCollectionBySlug", "abc").Return(unlisted, nil).Once()
+		_, err = svc.GetSharedCollection(ctx, "abc")
+		assert.NoError(t, err)
+		mockRepo.On("GetCollection", uint(5)).Return(unlisted, nil).Once()
+		_, err = svc.GetCollection(ctx, 2, 5)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+		mockRepo.On("GetCollections", uint(1), true).Return([]domain.Collection{}, nil).Once()
+		_, err = svc.GetUserCollections(ctx, 1)
+		assert.NoError(t, err)
+	})
+
+	t.Run("UpdateAndDelete", func(t *testing.T) {
+		existing := col()
+		mockRepo.On("GetCollection", uint(5)).Return(existing, nil).Once()
+		mockRepo.On("UpdateCollection", existing).Return(nil).Once()
+		upd := &domain.Collection{ID: 5, Name: "Renamed", Visibility: domain.VisibilityPublic, UserID: 2}
+		assert.NoError(t, svc.UpdateCollection(ctx, 1, upd))
+		assert.Equal(t, uint(1), upd.UserID)
+		assert.Equal(t, "Renamed", existing.Name)
+		assert.Len(t, upd.Books, 2)
+
+		mockRepo.On("GetCollection", uint(5)).Return(col(), nil).Once()
+		assert.ErrorIs(t, svc.DeleteCollection(ctx, 2, 5), gorm.ErrRecordNotFound)
+		mockRepo.On("GetCollection", uint(5)).Return(col(), nil).Once()
+		mockRepo.On("DeleteCollection", uint(5)).Return(nil).Once()
+		assert.NoError(t, svc.DeleteCollection(ctx, 1, 5))
+	})
+
+	t.Run("Books", func(t *testing.T) {
+		mockRepo.On("GetCollection", uint(5)).Return(col(), nil).Once()
+		mockRepo.On("GetBookByID", uint(12)).Return(&domain.Book{ID: 12}, nil).Once()
+		mockRepo.On("AddCollectionBook", mock.MatchedBy(func(cb *domain.CollectionBook) bool {
+			return cb.CollectionID == 5 && cb.BookID == 12 && cb.Position == 3
+		})).Return(nil).Once()
+		assert.NoError(t, svc.AddBookToCollection(ctx, 1, 5, 12))
+
+		mockRepo.On("GetCollection", uint(5)).Return(col(), nil).Once()
+		assert.ErrorIs(t, svc.AddBookToCollection(ctx, 1, 5, 10), ErrBookInCollection)
+
+		mockRepo.On("GetCollection", uint(5)).Return(col(), nil).Once()
+		mockRepo.On("RemoveCollectionBook", uint(5), uint(10)).Return(nil).Once()
+		assert.NoError(t, svc.RemoveBookFromCollection(ctx, 1, 5, 10))
+	})
+
+	t.Run("Reorder", func(t *testing.T) {
+		mockRepo.On("GetCollection", uint(5)).Return(col(), nil).Once()
+		mockRepo.On("ReorderCollection", uint(5), []uint{11, 10}).Return(nil).Once()
+		assert.NoError(t, svc.ReorderCollection(ctx, 1, 5, []uint{11, 10}))
+
+		for _, bad := range [][]uint{{11}, {11, 11}, {10, 12}} {
+			mockRepo.On("GetCollection", uint(5)).Return(col(), nil).Once()
+			assert.ErrorIs(t, svc.ReorderCollection(ctx, 1, 5, bad), ErrInvalidOrder)
+		}
+	})
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAnnotations(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepository)
+	svc := NewService(mockRepo, "key")
+	note := func() *domain.Annotation {
+		return &domain.Annotation{ID: 3, UserID: 1, BookID: 10, Kind: domain.AnnotationNote,
+			Start: domain.Anchor{Chapter: 2, Offset: 10}, End: domain.Anchor{Chapter: 2, Offset: 40},
+			SelectedText: "Все счастливые семьи", Note: "Первая фраза", Color: "yellow"}
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		a := &domain.Annotation{Kind: domain.AnnotationBookmark, UserID: 7, Start: domain.Anchor{Chapter: 3, Offset: 5}, Color: " #FFAA00 "}
+		mockRepo.On("GetBookByID", uint(10)).Return(&domain.Book{ID: 10}, nil).Once()
+		mockRepo.On("CreateAnnotation", a).Return(nil).Once()
+		assert.NoError(t, svc.CreateAnnotation(ctx, 1, 10, a))
+		assert.Equal(t, uint(1), a.UserID)
+		assert.Equal(t, uint(10), a.BookID)
+		assert.Equal(t, a.Start, a.End)
+		assert.Equal(t, "#ffaa00", a.Color)
+
+		mockRepo.On("GetBookByID", uint(11)).Return(nil, gorm.ErrRecordNotFound).Once()
+		assert.ErrorIs(t, svc.CreateAnnotation(ctx, 1, 11, &domain.Annotation{Kind: domain.AnnotationBookmark}), gorm.ErrRecordNotFound)
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		cases := []struct {
+			a   domain.Annotation
+			err error
+		}{
+			{domain.Annotation{Kind: "underline"}, ErrInvalidAnnotationKind},
+			{domain.Annotation{Kind: domain.AnnotationBookmark, Start: domain.Anchor{Offset: -1}}, ErrInvalidAnchor},
+			{domain.Annotation{Kind: domain.AnnotationHighlight, SelectedText: "x",
+				Start: domain.Anchor{Chapter: 2}, End: domain.Anchor{Chapter: 1, Offset: 99}}, ErrInvalidAnchor},
+			{domain.Annotation{Kind: domain.AnnotationHighlight, SelectedText: "  "}, ErrEmptyAnnotation},
+			{domain.Annotation{Kind: domain.AnnotationNote, SelectedText: "x", Note: " "}, ErrEmptyAnnotation},
+			{domain.Annotation{Kind: domain.AnnotationNote, Note: strings.Repeat("я", 10001)}, ErrAnnotationTooLong},
+			{domain.Annotation{Kind: domain.AnnotationHighlight, SelectedText: "x", Color: "red"}, ErrInvalidColor},
+		}
+		for _, tc := range cases {
+			a := tc.a
+			assert.ErrorIs(t, svc.CreateAnnotation(ctx, 1, 10, &a), tc.err)
+		}
+	})
+
+	t.Run("Ownership", func(t *testing.T) {
+		mockRepo.On("GetAnnotation", uint(3)).Return(note(), nil).Once()
+		_, err := svc.GetAnnotation(ctx, 2, 3)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+		mockRepo.On("GetAnnotation", uint(3)).Return(note(), nil).Once()
+		assert.ErrorIs(t, svc.DeleteAnnotation(ctx, 2, 3), gorm.ErrRecordNotFound)
+		mockRepo.On("GetAnnotation", uint(3)).Return(note(), nil).Once()
+		mockRepo.On("DeleteAnnotation", uint(3)).Return(nil).Once()
+		assert.NoError(t, svc.DeleteAnnotation(ctx, 1, 3))
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		existing := note()
+		mockRepo.On("GetAnnotation", uint(3)).Return(existing, nil).Once()
+		mockRepo.On("UpdateAnnotation", existing).Return(nil).Once()
+		upd := &domain.Annotation{ID: 3, BookID: 99, Kind: domain.AnnotationHighlight, SelectedText: "Все счастливые семьи",
+			Start: domain.Anchor{Chapter: 2, Offset: 10}, End: domain.Anchor{Chapter: 2, Offset: 30}, Color: "green"}
+		assert.NoError(t, svc.UpdateAnnotation(ctx, 1, upd))
+		assert.Equal(t, uint(10), upd.BookID)
+		assert.Equal(t, domain.AnnotationHighlight, existing.Kind)
+		assert.Equal(t, 30, existing.End.Offset)
+		assert.Empty(t, existing.Note)
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		f := domain.AnnotationFilter{Query: " семьи ", Kind: domain.AnnotationNote}
+		mockRepo.On("GetAnnotations", uint(1), domain.AnnotationFilter{Query: "семьи", Kind: domain.AnnotationNote}).
+			Return(domain.Page[domain.Annotation]{Items: []domain.Annotation{*note()}, Total: 1}, nil).Once()
+		page, err := svc.GetAnnotations(ctx, 1, f)
+		assert.NoError(t, err)
+		assert.Len(t, page.Items, 1)
+
+		_, err = svc.GetAnnotations(ctx, 1, domain.AnnotationFilter{Kind: "quote"})
+		assert.ErrorIs(t, err, ErrInvalidAnnotationKind)
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		book := &domain.Book{ID: 10, Title: "Анна Каренина", Author: &domain.Author{Name: "Лев Толстой"}}
+		a := note()
+		a.Book = book
+		b := &domain.Annotation{BookID: 10, Book: book, Kind: domain.AnnotationBookmark, Start: domain.Anchor{Chapter: 5}}
+		mockRepo.On("ExportAnnotations", uint(1), uint(0)).Return([]domain.Annotation{*a, *b}, nil).Twice()
+
+		md, err := svc.ExportAnnotations(ctx, 1, 0, ExportMarkdown)
+		assert.NoError(t, err)
+		assert.Equal(t, "# Annotations\n\n## Анна Каренина\n\n_Лев Толстой_\n\n"+
+			"- **Note**, chapter 2 (yellow)\n\n  > Все счастливые семьи\n\n  Первая фраза\n"+
+			"- **Bookmark**, chapter 5\n", string(md))
+
+		data, err := svc.ExportAnnotations(ctx, 1, 0, ExportJSON)
+		assert.NoError(t, err)
+		var out struct {
+			Annotations []map[string]interface{} `json:"annotations"`
+		}
+		assert.NoError(t, json.Unmarshal(data, &out))
+		assert.Len(t, out.Annotations, 2)
+		assert.Equal(t, "Анна Каренина", out.Annotations[0]["book_title"])
+		assert.Equal(t, "Лев Толстой", out.Annotations[0]["author"])
+
+		// Пустой экспорт по несуществующей книге — 404, а не пустой файл
+		mockRepo.On("ExportAnnotations", uint(1), uint(11)).Return([]domain.Annotation{}, nil).Once()
+		mockRepo.On("GetBookByID", uint(11)).Return(nil, gorm.ErrRecordNotFound).Once()
+		_, err = svc.ExportAnnotations(ctx, 1, 11, ExportJSON)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+		_, err = svc.ExportAnnotations(ctx, 1, 0, "pdf")
+		assert.ErrorIs(t, err, ErrInvalidExportFormat)
+	})
+	mockRepo.AssertExpectations(t)
+}
+
+func TestModeration(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepository)
+	svc := NewService(mockRepo, "key", WithModeration(ModerationConfig{ReportThreshold: 2, Blocklist: []string{" Spam ", "казино"}}))
+	published := func() *domain.Review {
+		return &domain.Review{ID: 7, BookID: 1, UserID: 1, Rating: 5, Status: domain.ReviewPublished}
+	}
+
+	t.Run("Blocklist", func(t *testing.T) {
+		mockRepo.On("GetBookByID", uint(1)).Return(&domain.Book{ID: 1}, nil).Once()
+		mockRepo.On("CreateReview", mock.MatchedBy(func(re *domain.Review) bool {
+			return re.Status == domain.ReviewPending
+		})).Run(func(args mock.Arguments) { args.Get(0).(*domain.Review).ID = 8 }).Return(nil).Once()
+		mockRepo.On("CreateModerationAction", &domain.ModerationAction{
+			ReviewID: 8, Action: domain.ModerationFlag, FromStatus: domain.ReviewPublished,
+			ToStatus: domain.ReviewPending, Reason: "blocklisted word: казино",
+		}).Return(nil).Once()
+		re := &domain.Review{BookID: 1, UserID: 2, Rating: 5, Comment: "Лучшее КАЗИНО онлайн!"}
+		assert.NoError(t, svc.AddReview(ctx, re))
+		assert.Equal(t, domain.ReviewPending, re.Status)
+
+		// Целые слова: "spammer" не совпадает со "spam"
+		mockRepo.On("GetBookByID", uint(1)).Return(&domain.Book{ID: 1}, nil).Once()
+		mockRepo.On("CreateReview", mock.MatchedBy(func(re *domain.Review) bool {
+			return re.Status == domain.ReviewPublished
+		})).Return(nil).Once()
+		assert.NoError(t, svc.AddReview(ctx, &domain.Review{BookID: 1, UserID: 3, Rating: 4, Comment: "The villain is a spammer"}))
+
+		// Правка с запрещённым словом тоже отправляет отзыв на модерацию
+		existing := published()
+		mockRepo.On("GetReview", uint(7)).Return(existing, nil).Once()
+		mockRepo.On("UpdateReview", existing).Return(nil).Once()
+		mockRepo.On("ModerateReview", existing, mock.MatchedBy(func(a *domain.ModerationAction) bool {
+			return a.FromStatus == domain.ReviewPublished && a.Reason == "blocklisted word: spam"
+		})).Return(nil).Once()
+		upd := &domain.Review{ID: 7, Rating: 5, Comment: "spam, spam, spam"}
+		assert.NoError(t, svc.UpdateReview(ctx, 1, upd))
+		assert.Equal(t, domain.ReviewPending, upd.Status)
+
+		// Модератор успел отклонить отзыв: правка сохраняется, решение не перетирается
+		existing = published()
+		mockRepo.On("GetReview", uint(7)).Return(existing, nil).Once()
+		mockRepo.On("UpdateReview", existing).Return(nil).Once()
+		mockRepo.On("ModerateReview", existing, mock.Anything).Return(domain.ErrReviewStatusChanged).Once()
+		mockRepo.On("GetReview", uint(7)).Return(&domain.Review{ID: 7, Status: domain.ReviewRejected}, nil).Once()
+		upd = &domain.Review{ID: 7, Rating: 5, Comment: "spam again"}
+		assert.NoError(t, svc.UpdateReview(ctx, 1, upd))
+		assert.Equal(t, domain.ReviewRejected, upd.Status)
+		assert.Equal(t, "spam again", upd.Comment)
+	})
+
+	t.Run("Report", func(t *testing.T) {
+		mockRepo.On("GetReview", uint(7)).Return(published(), nil).Once()
+		mockRepo.On("CreateReviewReport", &domain.ReviewReport{ReviewID: 7, UserID: 2, Reason: "Spoilers"}, (*time.Time)(nil)).
+			Return(int64(1), nil).Once()
+		assert.NoError(t, svc.ReportReview(ctx, 2, 7, "  Spoilers "))
+
+		// Вторая жалоба достигает порога — отзыв скрывается с записью в журнале
+		mockRepo.On("GetReview", uint(7)).Return(published(), nil).Once()
+		mockRepo.On("CreateReviewReport", mock.Anything, (*time.Time)(nil)).Return(int64(2), nil).Once()
+		mockRepo.On("ModerateReview", mock.MatchedBy(func(re *domain.Review) bool {
+			return re.Status == domain.ReviewPending
+		}), mock.MatchedBy(func(a *domain.ModerationAction) bool {
+			return a.Action == domain.ModerationFlag && a.ModeratorID == nil && a.Reason == "2 reports"
+		})).Return(nil).Once()
+		assert.NoError(t, svc.ReportReview(ctx, 3, 7, "Spam"))
+
+		// Отзыв скрыла параллельная жалоба — эта всё равно сохраняется
+		mockRepo.On("GetReview", uint(7)).Return(published(), nil).Once()
+		mockRepo.On("CreateReviewReport", mock.Anything, (*time.Time)(nil)).Return(int64(3), nil).Once()
+		mockRepo.On("ModerateReview", mock.Anything, mock.Anything).Return(domain.ErrReviewStatusChanged).Once()
+		assert.NoError(t, svc.ReportReview(ctx, 4, 7, "Spam"))
+
+		mockRepo.On("GetReview", uint(7)).Return(published(), nil).Once()
+		mockRepo.On("CreateReviewReport", mock.Anything, mock.Anything).Return(int64(0), gorm.ErrDuplicatedKey).Once()
+		assert.ErrorIs(t, svc.ReportReview(ctx, 2, 7, "Again"), ErrAlreadyReported)
+
+		mockRepo.On("GetReview", uint(7)).Return(published(), nil).Once()
+		assert.ErrorIs(t, svc.ReportReview(ctx, 1, 7, "Mine"), ErrOwnReview)
+		assert.ErrorIs(t, svc.ReportReview(ctx, 2, 7, " "), ErrInvalidReportReason)
+
+		hidden := published()
+		hidden.Status = domain.ReviewPending
+		mockRepo.On("GetReview", uint(7)).Return(hidden, nil).Once()
+		assert.ErrorIs(t, svc.ReportReview(ctx, 2, 7, "Spam"), gorm.ErrRecordNotFound)
+	})
+
+	t.Run("Decide", func(t *testing.T) {
+		pending := published()
+		pending.Status = domain.ReviewPending
+		mockRepo.On("GetReview", uint(7)).Return(pending, nil).Once()
+		mockRepo.On("ModerateReview", pending, mock.MatchedBy(func(a *domain.ModerationAction) bool {
+			return a.Action == domain.ModerationReject && *a.ModeratorID == 9 &&
+				a.FromStatus == domain.ReviewPending && a.ToStatus == domain.ReviewRejected && a.Reason == "Advertising"
+		})).Return(nil).Once()
+		re, err := svc.ModerateReview(ctx, 9, 7, domain.ModerationReject, " Advertising ")
+		assert.NoError(t, err)
+		assert.Equal(t, domain.ReviewRejected, re.Status)
+		assert.NotNil(t, re.ModeratedAt)
+
+		mockRepo.On("GetReview", uint(7)).Return(published(), nil).Once()
+		_, err = svc.ModerateReview(ctx, 9, 7, domain.ModerationApprove, "")
+		assert.ErrorIs(t, err, ErrNotPending)
+
+		// второй модератор прочитал отзыв ещё в очереди, но первый успел раньше
+		pending = published()
+		pending.Status = domain.ReviewPending
+		mockRepo.On("GetReview", uint(7)).Return(pending, nil).Once()
+		mockRepo.On("ModerateReview", pending, mock.Anything).Return(domain.ErrReviewStatusChanged).Once()
+		_, err = svc.ModerateReview(ctx, 9, 7, domain.ModerationApprove, "")
+		assert.ErrorIs(t, err, ErrNotPending)
+		_, err = svc.ModerateReview(ctx, 9, 7, "delete", "")
+		assert.ErrorIs(t, err, ErrInvalidModerationAction)
+
+		mockRepo.On("GetReview", uint(7)).Return(published(), nil).Once()
+		mockRepo.On("GetModerationActions", uint(7)).Return([]domain.ModerationAction{{ID: 1}}, nil).Once()
+		actions, err := svc.GetModerationActions(ctx, 7)
+		assert.NoError(t, err)
+		assert.Len(t, actions, 1)
+	})
+	mockRepo.AssertExpectations(t)
+}
+
+func TestReviewVotes(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepository)
+	svc := NewService(mockRepo, "key")
+	review := &domain.Review{ID: 7, UserID: 1, Status: domain.ReviewPublished}
+	voted := &domain.Review{ID: 7, UserID: 1, Status: domain.ReviewPublished, HelpfulCount: 1}
+
+	mockRepo.On("GetReview", uint(7)).Return(review, nil).Once()
+	mockRepo.On("SaveReviewVote", &domain.ReviewVote{ReviewID: 7, UserID: 2, Helpful: true}).Return(nil).Once()
+	mockRepo.On("GetReview", uint(7)).Return(voted, nil).Once()
+	re, err := svc.VoteReview(ctx, 2, 7, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, re.HelpfulCount)
+
+	mockRepo.On("GetReview", uint(7)).Return(review, nil).Once()
+	_, err = svc.VoteReview(ctx, 1, 7, true)
+	assert.ErrorIs(t, err, ErrOwnReviewVote)
+
+	hidden := &domain.Review{ID: 7, UserID: 1, Status: domain.ReviewPending}
+	mockRepo.On("GetReview", uint(7)).Return(hidden, nil).Once()
+	_, err = svc.VoteReview(ctx, 2, 7, false)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	mockRepo.On("GetReview", uint(7)).Return(review, nil).Twice()
+	mockRepo.On("DeleteReviewVote", uint(7), uint(2)).Return(nil).Once()
+	_, err = svc.UnvoteReview(ctx, 2, 7)
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestComments(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepository)
+	svc := NewService(mockRepo, "key")
+	review := &domain.Review{ID: 7, UserID: 1, Status: domain.ReviewPublished}
+	pid := func(id uint) *uint { return &id }
+
+	t.Run("Thread", func(t *testing.T) {
+		deleted := gorm.DeletedAt{Time: time.Now(), Valid: true}
+		mockRepo.On("GetReview", uint(7)).Return(review, nil).Times(3)
+		mockRepo.On("GetReviewComments", uint(7)).Return([]domain.ReviewComment{
+			{ID: 1, ReviewID: 7, Body: "root"},
+			{ID: 2, ReviewID: 7, ParentID: pid(1), Depth: 1, Body: "gone", DeletedAt: deleted},
+			{ID: 3, ReviewID: 7, ParentID: pid(2), Depth: 2, Body: "reply"},
+			{ID: 4, ReviewID: 7, Body: "deleted leaf", DeletedAt: deleted},
+			{ID: 5, ReviewID: 7, ParentID: pid(3), Depth: 3, Body: "deep"},
+		}, nil).Times(3)
+
+		thread, err := svc.GetCommentThread(ctx, 7, nil, 3)
+		assert.NoError(t, err)
+		if assert.Len(t, thread, 1) {
+			assert.Equal(t, uint(1), thread[0].ID)
+			gone := thread[0].Replies[0]
+			assert.True(t, gone.Deleted)
+			assert.Empty(t, gone.Body)
+			reply := gone.Replies[0]
+			assert.Equal(t, "reply", reply.Body)
+			assert.Nil(t, reply.Replies)
+			assert.True(t, reply.HasMoreReplies)
+		}
+
+		thread, err = svc.GetCommentThread(ctx, 7, pid(3), 0)
+		assert.NoError(t, err)
+		if assert.Len(t, thread, 1) {
+			assert.Equal(t, uint(5), thread[0].ID)
+		}
+
+		_, err = svc.GetCommentThread(ctx, 7, pid(99), 0)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("Thread_HiddenReview", func(t *testing.T) {
+		mockRepo.On("GetReview", uint(8)).Return(&domain.Review{ID: 8, Status: domain.ReviewPending}, nil).Once()
+		_, err := svc.GetCommentThread(ctx, 8, nil, 0)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("Add_NotifiesReviewAuthor", func(t *testing.T) {
+		mockRepo.On("GetReview", uint(7)).Return(review, nil).Once()
+		mockRepo.On("CreateReviewComment", mock.MatchedBy(func(c *domain.ReviewComment) bool {
+			return c.ReviewID == 7 && c.UserID == 2 && c.Depth == 0 && c.Body == "nice"
+		})).Run(func(args mock.Arguments) { args.Get(0).(*domain.ReviewComment).ID = 10 }).Return(nil).Once()
+		mockRepo.On("CreateNotification", &domain.Notification{
+			UserID: 1, Kind: domain.NotificationReviewComment, ActorID: 2, ReviewID: 7, CommentID: 10,
+		}).Return(nil).Once()
+		assert.NoError(t, svc.AddComment(ctx, 2, 7, &domain.ReviewComment{Body: " nice "}))
+	})
+
+	t.Run("Add_Reply", func(t *testing.T) {
+		mockRepo.On("GetReview", uint(7)).Return(review, nil).Once()
+		mockRepo.On("GetReviewComment", uint(10)).Return(&domain.ReviewComment{ID: 10, ReviewID: 7, UserID: 2, Depth: 0}, nil).Once()
+		mockRepo.On("CreateReviewComment", mock.MatchedBy(func(c *domain.ReviewComment) bool {
+			return c.Depth == 1 && *c.ParentID == 10
+		})).Run(func(args mock.Arguments) { args.Get(0).(*domain.ReviewComment).ID = 11 }).Return(nil).Once()
+		mockRepo.On("CreateNotification", &domain.Notification{
+			UserID: 2, Kind: domain.NotificationCommentReply, ActorID: 1, ReviewID: 7, CommentID: 11,
+		}).Return(nil).Once()
+		// Автор отзыва отвечает сам: себе уведомление не шлём.
+		assert.NoError(t, svc.AddComment(ctx, 1, 7, &domain.ReviewComment{Body: "thanks", ParentID: pid(10)}))
+	})
+
+	t.Run("Add_Invalid", func(t *testing.T) {
+		assert.ErrorIs(t, svc.AddComment(ctx, 2, 7, &domain.ReviewComment{Body: "  "}), ErrInvalidComment)
+
+		mockRepo.On("GetReview", uint(7)).Return(review, nil).Twice()
+		mockRepo.On("GetReviewComment", uint(20)).Return(&domain.ReviewComment{ID: 20, ReviewID: 9}, nil).Once()
+		assert.ErrorIs(t, svc.AddComment(ctx, 2, 7, &domain.ReviewComment{Body: "x", ParentID: pid(20)}), ErrInvalidParent)
+
+		mockRepo.On("GetReviewComment", uint(21)).Return(&domain.ReviewComment{ID: 21, ReviewID: 7, Depth: maxCommentDepth}, nil).Once()
+		assert.ErrorIs(t, svc.AddComment(ctx, 2, 7, &domain.ReviewComment{Body: "x", ParentID: pid(21)}), ErrThreadTooDeep)
+	})
+
+	t.Run("Edit", func(t *testing.T) {
+		mockRepo.On("GetReviewComment", uint(10)).Return(&domain.ReviewComment{ID: 10, UserID: 2, Body: "old", CreatedAt: time.Now().Add(-time.Minute)}, nil).Once()
+		mockRepo.On("UpdateReviewComment", mock.MatchedBy(func(c *domain.ReviewComment) bool {
+			return c.Body == "new" && c.EditedAt != nil
+		})).Return(nil).Once()
+		c := &domain.ReviewComment{ID: 10, Body: "new"}
+		assert.NoError(t, svc.EditComment(ctx, 2, c))
+		assert.NotNil(t, c.EditedAt)
+
+		mockRepo.On("GetReviewComment", uint(10)).Return(&domain.ReviewComment{ID: 10, UserID: 2, CreatedAt: time.Now().Add(-time.Hour)}, nil).Once()
+		assert.ErrorIs(t, svc.EditComment(ctx, 2, &domain.ReviewComment{ID: 10, Body: "late"}), ErrEditWindowClosed)
+
+		mockRepo.On("GetReviewComment", uint(10)).Return(&domain.ReviewComment{ID: 10, UserID: 2, CreatedAt: time.Now()}, nil).Once()
+		assert.ErrorIs(t, svc.EditComment(ctx, 3, &domain.ReviewComment{ID: 10, Body: "not mine"}), gorm.ErrRecordNotFound)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		mockRepo.On("GetReviewComment", uint(10)).Return(&domain.ReviewComment{ID: 10, UserID: 2}, nil).Twice()
+		mockRepo.On("DeleteReviewComment", uint(10)).Return(nil).Once()
+		assert.NoError(t, svc.DeleteComment(ctx, 2, 10))
+		assert.ErrorIs(t, svc.DeleteComment(ctx, 3, 10), gorm.ErrRecordNotFound)
+	})
+
+	t.Run("Notifications", func(t *testing.T) {
+		lq := domain.ListQuery{Limit: 20}
+		mockRepo.On("GetNotifications", uint(1), true, lq).Return(domain.Page[domain.Notification]{Total: 1}, nil).Once()
+		page, err := svc.GetNotifications(ctx, 1, true, lq)
 		assert.NoError(t, err)
+		assert.Equal(t, int64(1), page.Total)
+
+		mockRepo.On("MarkNotificationRead", uint(5), uint(1)).Return(nil).Once()
+		assert.NoError(t, svc.MarkNotificationRead(ctx, 1, 5))
+	})
+	mockRepo.AssertExpectations(t)
+}
+
+// txRepository считает транзакции, открытые сервисом.
+type txRepository struct {
+	*MockRepository
+	txs int
+}
+
+func (r *txRepository) WithTx(ctx context.Context, fn func(repository.Repository) error) error {
+	r.txs++
+	return fn(r)
+}
+
+func TestTransactions(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("CreateBook", func(t *testing.T) {
+		repo := &txRepository{MockRepository: new(MockRepository)}
+		svc := NewService(repo, "key")
+		b := &domain.Book{ID: 1, Title: "T", Content: "text", Author: &domain.Author{Name: "New"}}
+		repo.On("CreateBook", b).Return(nil).Once()
+		repo.On("ReplaceChapters", uint(1), mock.Anything).Return(errors.New("db down")).Once()
+		assert.EqualError(t, svc.CreateBook(ctx, b), "db down")
+		assert.Equal(t, 1, repo.txs)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("AddComment", func(t *testing.T) {
+		repo := &txRepository{MockRepository: new(MockRepository)}
+		svc := NewService(repo, "key")
+		repo.On("GetReview", uint(7)).Return(&domain.Review{ID: 7, UserID: 1, Status: domain.ReviewPublished}, nil).Once()
+		repo.On("CreateReviewComment", mock.Anything).Return(nil).Once()
+		repo.On("CreateNotification", mock.Anything).Return(errors.New("db down")).Once()
+		assert.EqualError(t, svc.AddComment(ctx, 2, 7, &domain.ReviewComment{Body: "hi"}), "db down")
+		assert.Equal(t, 1, repo.txs)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("ReportReview", func(t *testing.T) {
+		repo := &txRepository{MockRepository: new(MockRepository)}
+		svc := NewService(repo, "key", WithModeration(ModerationConfig{ReportThreshold: 1}))
+		repo.On("GetReview", uint(7)).Return(&domain.Review{ID: 7, BookID: 1, UserID: 1, Status: domain.ReviewPublished}, nil).Once()
+		repo.On("CreateReviewReport", mock.Anything, (*time.Time)(nil)).Return(int64(1), nil).Once()
+		repo.On("ModerateReview", mock.Anything, mock.Anything).Return(nil).Once()
+		assert.NoError(t, svc.ReportReview(ctx, 2, 7, "spam"))
+		assert.Equal(t, 1, repo.txs)
+		repo.AssertExpectations(t)
 	})
 }
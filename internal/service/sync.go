@@ -0,0 +1,124 @@
+package service
+
+import (
+	"E-book-service/internal/domain"
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxDeviceIDLen совпадает с размером колонки device_id.
+const maxDeviceIDLen = 64
+
+var ErrInvalidDevice = errors.New("device_id is required and must be at most 64 characters")
+
+// SyncResult — итог синхронизации. Position — общая позиция после слияния:
+// если присланная позиция не принята, клиент должен перейти на неё.
+type SyncResult struct {
+	Applied  bool                    `json:"applied"`
+	Status   string                  `json:"status"`
+	Position domain.ReadingPosition  `json:"position"`
+	Devices  []domain.DevicePosition `json:"devices"`
+}
+
+// SyncPosition сливает позицию с устройства с общей позицией на полке.
+// Побеждает самая дальняя позиция, если только клиент явно не попросил
+// вернуться назад. Сообщения, которые старше уже полученных от того же
+// устройства, считаются запоздавшими и на позицию не влияют.
+// Каждое сообщение попадает в историю ReadingEvent.
+func (s *service) SyncPosition(ctx context.Context, uID, bID uint, u domain.PositionUpdate) (*SyncResult, error) {
+	if u.DeviceID == "" || len(u.DeviceID) > maxDeviceIDLen {
+		return nil, ErrInvalidDevice
+	}
+	if !validPosition(u.Position) {
+		return nil, ErrInvalidProgress
+	}
+	now := time.Now()
+	if u.ClientTime.IsZero() {
+		u.ClientTime = now
+	}
+
+	// Позиция устройства, полка и история меняются вместе.
+	var res *SyncResult
+	sync := func() error {
+		return s.inTx(ctx, func(tx *service) (err error) {
+			res, err = tx.syncPosition(ctx, uID, bID, u, now)
+			return err
+		})
+	}
+	// Если записи на полке ещё нет, блокировать нечего: две первые синхронизации
+	// обе её вставляют, и вторая упирается в уникальный индекс. Тогда повторяем
+	// транзакцию: запись уже есть, её можно заблокировать и слить позиции.
+	err := sync()
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		err = sync()
+	}
+	return res, err
+}
+
+// syncPosition работает внутри транзакции. Запись полки блокируется до конца
+// транзакции, чтобы два устройства не сравнивали позицию с одним и тем же
+// старым прогрессом: иначе победила бы последняя запись, а не самая дальняя.
+func (s *service) syncPosition(ctx context.Context, uID, bID uint, u domain.PositionUpdate, now time.Time) (*SyncResult, error) {
+	entry, err := s.repo.LockShelfEntry(ctx, uID, bID)
+	isNew := errors.Is(err, gorm.ErrRecordNotFound)
+	if isNew {
+		if _, err = s.repo.GetBookByID(ctx, bID); err != nil {
+			return nil, err
+		}
+		entry = &domain.Shelf{UserID: uID, BookID: bID}
+	}
+	if err != nil {
+		return nil, err
+	}
+	device, err := s.repo.GetDevicePosition(ctx, uID, bID, u.DeviceID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		device, err = &domain.DevicePosition{UserID: uID, BookID: bID, DeviceID: u.DeviceID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stale := u.ClientTime.Before(device.ClientTime)
+	applied := !stale && (u.JumpBack || !u.Position.Before(entry.Progress))
+	// Позиция устройства ссылается на запись полки, поэтому полка пишется первой,
+	// даже если присланную позицию не приняли.
+	switch {
+	case applied:
+		setProgress(entry, u.Position)
+	case isNew:
+		moveShelfStatus(entry, domain.ShelfStatusReading, now)
+		entry.UpdatedAt = now
+	}
+	if applied || isNew {
+		if err := s.repo.AddToShelf(ctx, entry); err != nil {
+			return nil, err
+		}
+	}
+	if !stale {
+		device.Position, device.ClientTime, device.UpdatedAt = u.Position, u.ClientTime, now
+		if err := s.repo.SaveDevicePosition(ctx, device); err != nil {
+			return nil, err
+		}
+	}
+	err = s.repo.CreateReadingEvent(ctx, &domain.ReadingEvent{
+		UserID:     uID,
+		BookID:     bID,
+		DeviceID:   u.DeviceID,
+		Position:   u.Position,
+		ClientTime: u.ClientTime,
+		JumpBack:   u.JumpBack,
+		Applied:    applied,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &SyncResult{Applied: applied, Status: entry.Status, Position: entry.Progress}
+	if res.Devices, err = s.repo.GetDevicePositions(ctx, uID, bID); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+var cyrToLat = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya",
+}
+
+// latToCyrMulti проверяется раньше одиночных букв, длинные сочетания — первыми.
+var latToCyrMulti = []struct{ lat, cyr string }{
+	{"shch", "щ"}, {"sch", "щ"}, {"zh", "ж"}, {"kh", "х"}, {"ts", "ц"}, {"ch", "ч"},
+	{"sh", "ш"}, {"yu", "ю"}, {"ya", "я"}, {"yo", "ё"},
+}
+
+var latToCyr = map[rune]string{
+	'a': "а", 'b': "б", 'c': "к", 'd': "д", 'e': "е", 'f': "ф", 'g': "г", 'h': "х",
+	'i': "и", 'j': "й", 'k': "к", 'l': "л", 'm': "м", 'n': "н", 'o': "о", 'p': "п",
+	'q': "к", 'r': "р", 's': "с", 't': "т", 'u': "у", 'v': "в", 'w': "в", 'x': "кс",
+	'y': "й", 'z': "з",
+}
+
+// normalizeQuery приводит запрос к нижнему регистру и схлопывает пробелы.
+func normalizeQuery(q string) string {
+	return strings.Join(strings.Fields(strings.ToLower(q)), " ")
+}
+
+// toLatin транслитерирует кириллицу, остальные символы оставляет как есть.
+func toLatin(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if lat, ok := cyrToLat[r]; ok {
+			b.WriteString(lat)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// toCyrillic транслитерирует латиницу в кириллицу.
+func toCyrillic(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		matched := false
+		for _, m := range latToCyrMulti {
+			if strings.HasPrefix(s[i:], m.lat) {
+				b.WriteString(m.cyr)
+				i += len(m.lat)
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if cyr, ok := latToCyr[r]; ok {
+			b.WriteString(cyr)
+		} else {
+			b.WriteRune(r)
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// queryVariants возвращает запрос в исходном виде и в обеих транслитерациях.
+// Так "Tolstoy" находит "Толстой", а "Tолстой" с латинской T — тоже.
+func queryVariants(q string) []string {
+	variants := []string{q}
+	for _, v := range []string{toCyrillic(q), toLatin(q)} {
+		dup := false
+		for _, existing := range variants {
+			if existing == v {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			variants = append(variants, v)
+		}
+	}
+	return variants
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalStore(t *testing.T) {
+	root := t.TempDir()
+	s, err := NewLocalStore(root)
+	assert.NoError(t, err)
+
+	n, err := s.Put("books/1/a.pdf", strings.NewReader("%PDF-1.7 hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(14), n)
+	assert.FileExists(t, filepath.Join(root, "books", "1", "a.pdf"))
+
+	f, err := s.Open("books/1/a.pdf")
+	assert.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = f.ReadAt(buf, 9)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+	_, _ = f.Seek(0, io.SeekStart)
+	all, _ := io.ReadAll(f)
+	assert.Equal(t, "%PDF-1.7 hello", string(all))
+	assert.NoError(t, f.Close())
+
+	assert.NoError(t, s.Delete("books/1/a.pdf"))
+	assert.NoError(t, s.Delete("books/1/a.pdf"), "повторное удаление не ошибка")
+	_, err = s.Open("books/1/a.pdf")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// временные файлы не остаются
+	entries, _ := os.ReadDir(filepath.Join(root, "books", "1"))
+	assert.Empty(t, entries)
+}
+
+func TestLocalStore_InvalidKey(t *testing.T) {
+	s, _ := NewLocalStore(t.TempDir())
+	for _, key := range []string{"", "/", "../etc/passwd", "books/../../x"} {
+		_, err := s.Put(key, strings.NewReader("x"))
+		assert.ErrorIs(t, err, ErrInvalidKey, key)
+	}
+}